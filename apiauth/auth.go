@@ -0,0 +1,324 @@
+package apiauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Authentication and Authorization ---
+// The HTTP services sign with our keys, so every request must say who
+// sends it. A client is configured once under the "auth" key and may prove
+// its identity in any of four ways:
+//
+//   - a static API key in the X-API-Key header; only its SHA-256 is kept
+//     in the configuration,
+//   - an HMAC-SHA256 signature over the request (see hmac.go), which also
+//     protects the body, is accepted once and expires after a few minutes,
+//   - a TLS client certificate issued by one of the service's
+//     servers.<name>.tls.client_ca_files (see apiserver),
+//   - a JWT bearer token signed by a key of the local JWKS (see jwt.go).
+//
+// Each client then has rules for what it may do: the operations (e.g.
+// pdf.sign, jobs.read), the signing keys, the profiles (appearance
+// templates) and the PAdES levels. Operations must be listed; keys,
+// profiles and levels left empty allow any.
+//
+//	"auth": {
+//	    "clients": [{"name": "erp", "api_keys_sha256": ["9f86d0..."],
+//	                 "operations": ["pdf.sign", "pdf.verify", "jobs.read"],
+//	                 "keys": ["pkcs11"], "profiles": ["corporate"], "levels": ["B-T", "B-LT"]}],
+//	    "hmac_max_skew_seconds": 300,
+//	    "jwt": {"jwks_file": "...", "issuer": "...", "audience": "...", "subject_claim": "sub"}
+//	}
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Client string // configured client name
+	Method string // api-key, hmac, mtls or jwt
+	// Subject is the certificate subject, JWT subject or key ID that
+	// matched; API keys have none.
+	Subject string
+	Remote  string // client IP address
+}
+
+// String describes the caller for logs and the audit log.
+func (id *Identity) String() string {
+	if id.Subject != "" {
+		return fmt.Sprintf("%s (%s %s, %s)", id.Client, id.Method, id.Subject, id.Remote)
+	}
+	return fmt.Sprintf("%s (%s, %s)", id.Client, id.Method, id.Remote)
+}
+
+// Client is a configured caller and what it may do.
+type Client struct {
+	Name         string    `mapstructure:"name"`
+	APIKeys      []string  `mapstructure:"api_keys_sha256"` // hex SHA-256 of each key
+	HMACKeys     []HMACKey `mapstructure:"hmac_keys"`
+	CertSubjects []string  `mapstructure:"cert_subjects"` // certificate CN, DNS or email SAN
+	JWTSubjects  []string  `mapstructure:"jwt_subjects"`
+	Operations   []string  `mapstructure:"operations"` // "*" allows all
+	Keys         []string  `mapstructure:"keys"`
+	Profiles     []string  `mapstructure:"profiles"`
+	Levels       []string  `mapstructure:"levels"`
+}
+
+// HMACKey is a shared secret for request signing.
+type HMACKey struct {
+	ID     string `mapstructure:"id"`
+	Secret string `mapstructure:"secret"` // base64
+	secret []byte
+}
+
+// Authenticator checks the credentials of requests against the clients.
+type Authenticator struct {
+	clients  []*Client
+	apiKeys  map[string]*Client // by hex SHA-256
+	hmacKeys map[string]*hmacEntry
+	maxSkew  time.Duration
+	replay   replayCache  // HMAC signatures already accepted
+	jwt      *jwtVerifier // nil without auth.jwt
+}
+
+type hmacEntry struct {
+	client *Client
+	secret []byte
+}
+
+// Request is what a handler asks to be allowed. Empty fields are not
+// checked.
+type Request struct {
+	Operation string
+	Key       string
+	Profile   string
+	Level     string
+}
+
+var (
+	// ErrUnauthenticated means the request has no valid credentials.
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrForbidden means the caller is not allowed the request.
+	ErrForbidden = errors.New("not allowed")
+)
+
+// LoadFromConfig reads the auth key. It returns nil, nil when the key is
+// absent; the services then only listen on the loopback interface (see
+// apiserver.Config.ListenAddr).
+func LoadFromConfig(vip *viper.Viper) (*Authenticator, error) {
+	if !vip.IsSet("auth") {
+		return nil, nil
+	}
+	var clients []*Client
+	if err := vip.UnmarshalKey("auth.clients", &clients); err != nil {
+		return nil, fmt.Errorf("invalid auth.clients: %w", err)
+	}
+	if len(clients) == 0 {
+		return nil, errors.New("auth.clients is empty")
+	}
+	a := &Authenticator{
+		clients:  clients,
+		apiKeys:  make(map[string]*Client),
+		hmacKeys: make(map[string]*hmacEntry),
+		maxSkew:  time.Duration(vip.GetInt("auth.hmac_max_skew_seconds")) * time.Second,
+	}
+	if a.maxSkew <= 0 {
+		a.maxSkew = 5 * time.Minute
+	}
+	names := make(map[string]bool)
+	for _, c := range clients {
+		if c.Name == "" {
+			return nil, errors.New("auth.clients: a client has no name")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("auth.clients: client '%s' is configured twice", c.Name)
+		}
+		names[c.Name] = true
+		if len(c.Operations) == 0 {
+			slog.Warn("auth client has no operations and can do nothing", "client", c.Name)
+		}
+		for _, k := range c.APIKeys {
+			sum, err := hex.DecodeString(k)
+			if err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("auth client '%s': api_keys_sha256 entries are hex SHA-256 digests", c.Name)
+			}
+			a.apiKeys[strings.ToLower(k)] = c
+		}
+		for i := range c.HMACKeys {
+			k := &c.HMACKeys[i]
+			secret, err := decodeSecret(k.Secret)
+			if err != nil || k.ID == "" {
+				return nil, fmt.Errorf("auth client '%s': hmac_keys need an id and a base64 secret of at least 32 bytes", c.Name)
+			}
+			if _, dup := a.hmacKeys[k.ID]; dup {
+				return nil, fmt.Errorf("auth: HMAC key ID '%s' is used twice", k.ID)
+			}
+			a.hmacKeys[k.ID] = &hmacEntry{client: c, secret: secret}
+		}
+	}
+
+	if vip.IsSet("auth.jwt") {
+		v, err := loadJWTVerifier(vip)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = v
+	}
+	slog.Info("API authentication enabled", "clients", len(clients), "jwt", a.jwt != nil)
+	return a, nil
+}
+
+// Middleware authenticates every request before next sees it. fail writes
+// the 401 response for an error wrapping ErrUnauthenticated.
+func (a *Authenticator) Middleware(next http.Handler, fail func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.authenticate(w, r)
+		if err != nil {
+			slog.Warn("request rejected", "remote", remoteIP(r), "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer, HMAC-SHA256`)
+			fail(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// authenticate tries the credentials the request carries. Credentials that
+// are present but wrong fail at once rather than falling through.
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request) (*Identity, error) {
+	remote := remoteIP(r)
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		for hash, c := range a.apiKeys {
+			want, _ := hex.DecodeString(hash)
+			if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+				return &Identity{Client: c.Name, Method: "api-key", Remote: remote}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	switch {
+	case strings.EqualFold(scheme, hmacScheme):
+		return a.authenticateHMAC(w, r, credentials)
+	case strings.EqualFold(scheme, "Bearer"):
+		if a.jwt == nil {
+			return nil, fmt.Errorf("%w: bearer tokens are not accepted (no auth.jwt)", ErrUnauthenticated)
+		}
+		sub, err := a.jwt.verify(strings.TrimSpace(credentials), time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+		}
+		for _, c := range a.clients {
+			if slices.Contains(c.JWTSubjects, sub) {
+				return &Identity{Client: c.Name, Method: "jwt", Subject: sub, Remote: remote}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: token subject '%s' is not a configured client", ErrUnauthenticated, sub)
+	case scheme != "":
+		return nil, fmt.Errorf("%w: unsupported authorization scheme '%s'", ErrUnauthenticated, scheme)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+		names = append(names, cert.EmailAddresses...)
+		for _, c := range a.clients {
+			for _, n := range names {
+				if n != "" && slices.Contains(c.CertSubjects, n) {
+					return &Identity{Client: c.Name, Method: "mtls", Subject: n, Remote: remote}, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("%w: client certificate '%s' is not a configured client", ErrUnauthenticated, cert.Subject.CommonName)
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Authorize checks req against the rules of the caller of r. Without
+// authentication (no auth key) everything is allowed.
+func (a *Authenticator) Authorize(r *http.Request, req Request) error {
+	if a == nil {
+		return nil
+	}
+	id, ok := FromContext(r.Context())
+	if !ok {
+		return ErrUnauthenticated
+	}
+	var c *Client
+	for _, cl := range a.clients {
+		if cl.Name == id.Client {
+			c = cl
+		}
+	}
+	if c == nil {
+		return ErrUnauthenticated
+	}
+	check := func(what, value string, allowed []string, emptyAllows bool) error {
+		if value == "" || (emptyAllows && len(allowed) == 0) {
+			return nil
+		}
+		if slices.Contains(allowed, "*") || slices.ContainsFunc(allowed, func(s string) bool { return strings.EqualFold(s, value) }) {
+			return nil
+		}
+		return fmt.Errorf("%w: client '%s' may not use %s '%s'", ErrForbidden, c.Name, what, value)
+	}
+	for _, err := range []error{
+		check("operation", req.Operation, c.Operations, false),
+		check("key", req.Key, c.Keys, true),
+		check("profile", req.Profile, c.Profiles, true),
+		check("level", req.Level, c.Levels, true),
+	} {
+		if err != nil {
+			slog.Warn("request forbidden", "client", c.Name, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+type identityKey struct{}
+
+// FromContext returns the authenticated caller of a request.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Caller describes the caller of r for logs and the audit log: the
+// authenticated identity, or "api:<address>" without authentication.
+func Caller(r *http.Request) string {
+	if id, ok := FromContext(r.Context()); ok {
+		return id.String()
+	}
+	return "api:" + remoteIP(r)
+}
+
+// ClientName is the authenticated client of r, or "" without
+// authentication.
+func ClientName(r *http.Request) string {
+	if id, ok := FromContext(r.Context()); ok {
+		return id.Client
+	}
+	return ""
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,360 @@
+package apiauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	testAPIKey     = "test-api-key"
+	testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+)
+
+// testAuth loads an authenticator with one client, "erp", reachable by API
+// key, HMAC key "erp-1" and JWT subject "erp-service"; jwks is written to
+// the JWKS file.
+func testAuth(t *testing.T, jwks []map[string]any) *Authenticator {
+	t.Helper()
+	sum := sha256.Sum256([]byte(testAPIKey))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, err := json.Marshal(map[string]any{"keys": jwks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	vip := viper.New()
+	vip.Set("auth", map[string]any{
+		"clients": []map[string]any{{
+			"name":            "erp",
+			"api_keys_sha256": []string{hex.EncodeToString(sum[:])},
+			"hmac_keys":       []map[string]any{{"id": "erp-1", "secret": base64.StdEncoding.EncodeToString(testHMACSecret)}},
+			"jwt_subjects":    []string{"erp-service"},
+			"operations":      []string{"pdf.sign"},
+			"keys":            []string{"pkcs11"},
+		}},
+		"jwt": map[string]any{"jwks_file": path, "issuer": "https://idp.example", "audience": "signing"},
+	})
+	a, err := LoadFromConfig(vip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func ecJWK(t *testing.T, key *ecdsa.PrivateKey, kid, alg string) map[string]any {
+	t.Helper()
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]any{
+		"kty": "EC", "kid": kid, "alg": alg, "crv": key.Curve.Params().Name,
+		"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func rsaJWK(key *rsa.PrivateKey, kid string) map[string]any {
+	return map[string]any{
+		"kty": "RSA", "kid": kid,
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// signJWT makes a token with header alg and kid, signed by key with hash
+// (for ECDSA, r || s padded to the key's curve).
+func signJWT(t *testing.T, alg, kid string, claims map[string]any, key crypto.Signer, hash crypto.Hash) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newRequest(method, target string, body []byte) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(string(body)))
+	r.RemoteAddr = "10.0.0.1:40000"
+	return r
+}
+
+func TestJWT(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := testAuth(t, []map[string]any{
+		ecJWK(t, p256, "ec-256", ""),
+		ecJWK(t, p384, "ec-384", ""),
+		rsaJWK(rsaKey, "rsa"),
+	})
+
+	now := time.Now()
+	claims := func(change func(c map[string]any)) map[string]any {
+		c := map[string]any{
+			"sub": "erp-service", "iss": "https://idp.example", "aud": []string{"signing"},
+			"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(-time.Minute).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string // "" when the token is accepted
+	}{
+		{"ES256 with P-256", signJWT(t, "ES256", "ec-256", claims(nil), p256, crypto.SHA256), ""},
+		{"ES384 with P-384", signJWT(t, "ES384", "ec-384", claims(nil), p384, crypto.SHA384), ""},
+		{"RS256", signJWT(t, "RS256", "rsa", claims(nil), rsaKey, crypto.SHA256), ""},
+		{"without kid", signJWT(t, "ES256", "", claims(nil), p256, crypto.SHA256), ""},
+		// The P-384 key signs SHA-256 with a 96 byte r || s: ES256 must
+		// still not accept it.
+		{"ES256 with P-384", signJWT(t, "ES256", "ec-384", claims(nil), p384, crypto.SHA256), "does not verify"},
+		{"ES384 with P-256", signJWT(t, "ES384", "ec-256", claims(nil), p256, crypto.SHA384), "does not verify"},
+		{"RS256 with an EC key", signJWT(t, "RS256", "ec-256", claims(nil), p256, crypto.SHA256), "does not verify"},
+		{"unknown key", signJWT(t, "ES256", "ec-256", claims(nil), other, crypto.SHA256), "does not verify"},
+		{"kid of another key", signJWT(t, "ES256", "rsa", claims(nil), p256, crypto.SHA256), "does not verify"},
+		{"expired", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() }), p256, crypto.SHA256), "expired"},
+		{"within leeway", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() }), p256, crypto.SHA256), ""},
+		{"no exp", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { delete(c, "exp") }), p256, crypto.SHA256), "no exp"},
+		{"not valid yet", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { c["nbf"] = now.Add(time.Hour).Unix() }), p256, crypto.SHA256), "not valid yet"},
+		{"other issuer", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { c["iss"] = "https://evil.example" }), p256, crypto.SHA256), "issuer"},
+		{"other audience", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { c["aud"] = "billing" }), p256, crypto.SHA256), "audience"},
+		{"unknown subject", signJWT(t, "ES256", "ec-256", claims(func(c map[string]any) { c["sub"] = "someone" }), p256, crypto.SHA256), "not a configured client"},
+		{"alg none", strings.Join(strings.Split(signJWT(t, "none", "", claims(nil), p256, crypto.SHA256), ".")[:2], ".") + ".", "does not verify"},
+		{"malformed", "not-a-token", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(http.MethodPost, "/v1/pdf/sign", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			id, err := a.authenticate(httptest.NewRecorder(), r)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id.Client != "erp" || id.Method != "jwt" || id.Subject != "erp-service" {
+					t.Fatalf("identity = %+v", id)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSAlgMustMatchCurve(t *testing.T) {
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if _, err := parseJWKS(mustJSON(t, map[string]any{"keys": []any{ecJWK(t, p384, "k", "ES256")}})); err == nil {
+		t.Fatal("a P-384 key declared ES256 was accepted")
+	}
+	if _, err := parseJWKS(mustJSON(t, map[string]any{"keys": []any{ecJWK(t, p384, "k", "ES384")}})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestHMAC(t *testing.T) {
+	a := testAuth(t, []map[string]any{rsaJWK(mustRSA(t), "rsa")})
+	body := []byte(`{"document":"..."}`)
+	now := time.Now()
+	tests := []struct {
+		name    string
+		header  func() string
+		target  string // path the request goes to; the header signs /v1/pdf/sign
+		body    []byte
+		wantErr string
+	}{
+		{"valid", func() string { return SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, now) }, "/v1/pdf/sign", body, ""},
+		{"within skew", func() string {
+			return SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, now.Add(-4*time.Minute))
+		}, "/v1/pdf/sign", body, ""},
+		{"expired", func() string {
+			return SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, now.Add(-6*time.Minute))
+		}, "/v1/pdf/sign", body, "off the server clock"},
+		{"from the future", func() string {
+			return SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, now.Add(6*time.Minute))
+		}, "/v1/pdf/sign", body, "off the server clock"},
+		{"body changed", func() string {
+			return SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, now.Add(time.Second))
+		}, "/v1/pdf/sign", []byte(`{}`), "does not match"},
+		{"path changed", func() string {
+			return SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, now.Add(2*time.Second))
+		}, "/v1/pdf/sign?level=B-B", body, "does not match"},
+		{"wrong secret", func() string {
+			return SignRequest("erp-1", []byte("another secret of at least 32 bytes"), "POST", "/v1/pdf/sign", body, now)
+		}, "/v1/pdf/sign", body, "does not match"},
+		{"unknown key ID", func() string { return SignRequest("erp-2", testHMACSecret, "POST", "/v1/pdf/sign", body, now) }, "/v1/pdf/sign", body, "unknown HMAC key ID"},
+		{"missing signature", func() string { return "HMAC-SHA256 KeyId=erp-1, Timestamp=1" }, "/v1/pdf/sign", body, "needs KeyId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(http.MethodPost, tt.target, tt.body)
+			r.Header.Set("Authorization", tt.header())
+			id, err := a.authenticate(httptest.NewRecorder(), r)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id.Client != "erp" || id.Method != "hmac" || id.Subject != "erp-1" {
+					t.Fatalf("identity = %+v", id)
+				}
+				// The handler still gets the body.
+				if got, _ := io.ReadAll(r.Body); string(got) != string(tt.body) {
+					t.Fatalf("body = %q", got)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHMACReplay(t *testing.T) {
+	a := testAuth(t, []map[string]any{rsaJWK(mustRSA(t), "rsa")})
+	body := []byte("%PDF-1.7")
+	header := SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, time.Now())
+	send := func(header string) error {
+		r := newRequest(http.MethodPost, "/v1/pdf/sign", body)
+		r.Header.Set("Authorization", header)
+		_, err := a.authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	if err := send(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(header); !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), "already been used") {
+		t.Fatalf("replay: err = %v", err)
+	}
+	// A retry signed again with a new timestamp goes through.
+	if err := send(SignRequest("erp-1", testHMACSecret, "POST", "/v1/pdf/sign", body, time.Now().Add(time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	// A signature that does not match is not recorded.
+	bad := strings.Replace(header, "Signature=", "Signature=AAAA", 1)
+	for i := 0; i < 2; i++ {
+		if err := send(bad); err == nil || strings.Contains(err.Error(), "already been used") {
+			t.Fatalf("bad signature, attempt %d: err = %v", i, err)
+		}
+	}
+}
+
+func TestReplayCacheSweep(t *testing.T) {
+	var c replayCache
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		if !c.add(fmt.Sprint(i), start.Add(5*time.Minute), start) {
+			t.Fatalf("signature %d refused", i)
+		}
+	}
+	if c.add("0", start.Add(5*time.Minute), start.Add(time.Minute)) {
+		t.Fatal("signature accepted twice")
+	}
+	// Out of the window the signatures are dropped; the timestamp check
+	// refuses them from then on.
+	later := start.Add(5*time.Minute + replaySweepInterval)
+	if !c.add("new", later.Add(5*time.Minute), later) {
+		t.Fatal("new signature refused")
+	}
+	if len(c.seen) != 1 {
+		t.Fatalf("%d signatures kept after the sweep, want 1", len(c.seen))
+	}
+}
+
+func mustRSA(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAPIKeyAndAuthorize(t *testing.T) {
+	a := testAuth(t, []map[string]any{rsaJWK(mustRSA(t), "rsa")})
+	tests := []struct {
+		name    string
+		key     string
+		req     Request
+		wantErr error
+	}{
+		{"allowed", testAPIKey, Request{Operation: "pdf.sign", Key: "pkcs11", Level: "B-LT"}, nil},
+		{"key case-insensitive", testAPIKey, Request{Operation: "pdf.sign", Key: "PKCS11"}, nil},
+		{"operation not listed", testAPIKey, Request{Operation: "keys.reload"}, ErrForbidden},
+		{"key not listed", testAPIKey, Request{Operation: "pdf.sign", Key: "pfx"}, ErrForbidden},
+		{"unknown API key", "other", Request{Operation: "pdf.sign"}, ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authErr error
+			handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authErr = a.Authorize(r, tt.req)
+			}), func(w http.ResponseWriter, r *http.Request, err error) {
+				authErr = err
+				w.WriteHeader(http.StatusUnauthorized)
+			})
+			r := newRequest(http.MethodPost, "/v1/pdf/sign", nil)
+			r.Header.Set("X-API-Key", tt.key)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if tt.wantErr == nil && authErr != nil || tt.wantErr != nil && !errors.Is(authErr, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", authErr, tt.wantErr)
+			}
+		})
+	}
+}
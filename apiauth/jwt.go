@@ -0,0 +1,304 @@
+package apiauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // SHA-384 and SHA-512 for RS384, ES512, ...
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- JWT Bearer Tokens ---
+// Tokens from the company identity provider are checked offline against a
+// JWKS file kept next to config.json (refreshed by whoever rotates the
+// provider's keys), so a request never waits on the provider. RS*, PS*,
+// ES* and EdDSA signatures are accepted; "none" and HMAC algorithms are
+// not, since a JWKS holds public keys. An ES* algorithm must match the
+// curve of the key (ES256 with P-256, and so on). exp is required; nbf, iss
+// and aud are checked when configured or present. The subject claim (sub unless
+// auth.jwt.subject_claim says otherwise) names the client.
+
+type jwtVerifier struct {
+	keys         []jwk
+	issuer       string
+	audience     string
+	subjectClaim string
+	leeway       time.Duration
+}
+
+type jwk struct {
+	kid string
+	alg string // may be empty
+	key crypto.PublicKey
+}
+
+func loadJWTVerifier(vip *viper.Viper) (*jwtVerifier, error) {
+	path := vip.GetString("auth.jwt.jwks_file")
+	if path == "" {
+		return nil, errors.New("auth.jwt.jwks_file is empty")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS '%s': %w", path, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS '%s': %w", path, err)
+	}
+	v := &jwtVerifier{
+		keys:         keys,
+		issuer:       vip.GetString("auth.jwt.issuer"),
+		audience:     vip.GetString("auth.jwt.audience"),
+		subjectClaim: vip.GetString("auth.jwt.subject_claim"),
+		leeway:       time.Minute,
+	}
+	if v.subjectClaim == "" {
+		v.subjectClaim = "sub"
+	}
+	return v, nil
+}
+
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []jwk
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var pub crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			pub, err = rsaKey(k.N, k.E)
+		case "EC":
+			pub, err = ecKey(k.Crv, k.X, k.Y)
+		case "OKP":
+			if k.Crv != "Ed25519" {
+				err = fmt.Errorf("unsupported OKP curve '%s'", k.Crv)
+				break
+			}
+			var x []byte
+			if x, err = b64(k.X); err == nil && len(x) != ed25519.PublicKeySize {
+				err = errors.New("invalid Ed25519 key")
+			}
+			pub = ed25519.PublicKey(x)
+		default:
+			err = fmt.Errorf("unsupported key type '%s'", k.Kty)
+		}
+		if ec, ok := pub.(*ecdsa.PublicKey); ok && k.Alg != "" && k.Alg != ecCurveAlg[ec.Curve.Params().Name] {
+			err = fmt.Errorf("algorithm '%s' does not match curve '%s'", k.Alg, k.Crv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%s): %w", i, k.Kid, err)
+		}
+		keys = append(keys, jwk{kid: k.Kid, alg: k.Alg, key: pub})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := b64(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := b64(e)
+	if err != nil || len(eb) > 4 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	exp := 0
+	for _, b := range eb {
+		exp = exp<<8 | int(b)
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: exp}
+	if key.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key of %d bits is too short", key.N.BitLen())
+	}
+	return key, nil
+}
+
+// ecCurveAlg is the JWS algorithm for each curve.
+var ecCurveAlg = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported EC curve '%s'", crv)
+	}
+	xb, err1 := b64(x)
+	yb, err2 := b64(y)
+	if err1 != nil || err2 != nil {
+		return nil, errors.New("invalid EC point")
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("EC point is not on the curve")
+	}
+	return key, nil
+}
+
+func b64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// verify checks token at now and returns its subject.
+func (v *jwtVerifier) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	hb, err := b64(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil {
+		return "", errors.New("malformed token header")
+	}
+	sig, err := b64(parts[2])
+	if err != nil {
+		return "", errors.New("malformed token signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	verified := false
+	for _, k := range v.keys {
+		if (header.Kid != "" && k.kid != header.Kid) || (k.alg != "" && k.alg != header.Alg) {
+			continue
+		}
+		if err := verifyJWS(header.Alg, k.key, signed, sig); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", fmt.Errorf("token signature (%s, kid '%s') does not verify with the JWKS", header.Alg, header.Kid)
+	}
+
+	pb, err := b64(parts[1])
+	if err != nil {
+		return "", errors.New("malformed token payload")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return "", errors.New("malformed token claims")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", errors.New("token has no exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.leeway)) {
+		return "", errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return "", errors.New("token is not valid yet")
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return "", fmt.Errorf("token issuer '%v' is not '%s'", claims["iss"], v.issuer)
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return "", fmt.Errorf("token is not for audience '%s'", v.audience)
+	}
+	sub, _ := claims[v.subjectClaim].(string)
+	if sub == "" {
+		return "", fmt.Errorf("token has no '%s' claim", v.subjectClaim)
+	}
+	return sub, nil
+}
+
+func hasAudience(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		return slices.Contains(a, any(want))
+	}
+	return false
+}
+
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	}
+	digest := func() []byte {
+		h := hash.New()
+		h.Write(signed)
+		return h.Sum(nil)
+	}
+	switch {
+	case strings.HasPrefix(alg, "RS") && hash != 0:
+		if k, ok := key.(*rsa.PublicKey); ok {
+			return rsa.VerifyPKCS1v15(k, hash, digest(), sig)
+		}
+	case strings.HasPrefix(alg, "PS") && hash != 0:
+		if k, ok := key.(*rsa.PublicKey); ok {
+			return rsa.VerifyPSS(k, hash, digest(), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case strings.HasPrefix(alg, "ES") && hash != 0:
+		if k, ok := key.(*ecdsa.PublicKey); ok {
+			if curve := k.Curve.Params().Name; ecCurveAlg[curve] != alg {
+				return fmt.Errorf("algorithm '%s' is not accepted for a %s key", alg, curve)
+			}
+			// JWS ECDSA signatures are r || s, each padded to the curve size.
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				return errors.New("invalid ECDSA signature length")
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest(), r, s) {
+				return nil
+			}
+			return errors.New("ECDSA signature does not verify")
+		}
+	case alg == "EdDSA":
+		if k, ok := key.(ed25519.PublicKey); ok {
+			if ed25519.Verify(k, signed, sig) {
+				return nil
+			}
+			return errors.New("Ed25519 signature does not verify")
+		}
+	}
+	return fmt.Errorf("algorithm '%s' is not accepted for this key", alg)
+}
@@ -0,0 +1,329 @@
+package apilimit
+
+import (
+	"chilkattest/apiauth"
+	"chilkattest/metrics"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Rate Limits and Quotas ---
+// Every signature costs HSM time and, for timestamped levels, a TSA
+// request that may be billed, so one runaway client must not be able to
+// use them up. Signing operations are limited twice: per client (the
+// authenticated client of apiauth, or the remote address without
+// authentication) and per signing key, whoever asks. Each limit is a token
+// bucket (rate_per_second, with bursts of up to burst requests) and a
+// daily quota (daily_quota requests per UTC day):
+//
+//	"limits": {
+//	    "operations": ["xml.sign", "pdf.sign", "jws.create"],
+//	    "usage_dir": "C:/chilkatPackage/chilkattest/usage",
+//	    "default_client": {"rate_per_second": 1, "burst": 5, "daily_quota": 1000},
+//	    "clients": [{"name": "erp", "rate_per_second": 5, "burst": 20, "daily_quota": 20000}],
+//	    "keys": [{"name": "pkcs11", "rate_per_second": 10, "burst": 20, "daily_quota": 50000}]
+//	}
+//
+// A client or key that is not listed gets default_client, or no limit for
+// keys. A zero rate or quota means none. A request over a limit is refused
+// with 429 and a Retry-After header; it does not use up the other limits,
+// and neither does a request that is allowed but then not carried out (see
+// Refund).
+// The daily counts are kept in usage_dir, in a file per service
+// (<service>.json, see usage.go), so a restart does not reset them; the
+// token buckets start full. The services share the limits but count
+// separately. A bucket that has refilled is the same as a new one, so
+// buckets idle that long are dropped every sweepInterval and clients
+// seen once (e.g. by address) do not pile up.
+
+// Limit is one entry of limits.clients or limits.keys.
+type Limit struct {
+	Name          string  `mapstructure:"name"`
+	RatePerSecond float64 `mapstructure:"rate_per_second"`
+	Burst         int     `mapstructure:"burst"`
+	DailyQuota    int64   `mapstructure:"daily_quota"`
+}
+
+// ErrLimited is wrapped by the errors of Allow.
+var ErrLimited = errors.New("rate limit exceeded")
+
+// LimitError is a request refused by a limit.
+type LimitError struct {
+	Scope      string // "client" or "key"
+	Name       string
+	Quota      bool // the daily quota, rather than the rate
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	if e.Quota {
+		return fmt.Sprintf("daily quota of %s '%s' used up, retry after %s", e.Scope, e.Name, e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("rate limit of %s '%s' exceeded, retry after %s", e.Scope, e.Name, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LimitError) Unwrap() error { return ErrLimited }
+
+// Limiter enforces the limits. A nil *Limiter allows everything.
+type Limiter struct {
+	operations    []string
+	defaultClient *Limit
+	clients       map[string]*Limit
+	keys          map[string]*Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket // by scope:name
+	swept   time.Time          // when full buckets were last dropped
+	usage   *usageStore
+}
+
+const sweepInterval = time.Minute
+
+var refused = metrics.NewCounter("rate_limited_total",
+	"Requests refused by a rate limit or daily quota, by scope (client or key) and reason (rate or quota).", "scope", "reason")
+
+// LoadFromConfig reads the limits key for the service name. It returns nil,
+// nil when the key is absent.
+func LoadFromConfig(vip *viper.Viper, name string) (*Limiter, error) {
+	if vip == nil || !vip.IsSet("limits") {
+		return nil, nil
+	}
+	l := &Limiter{
+		operations: vip.GetStringSlice("limits.operations"),
+		clients:    make(map[string]*Limit),
+		keys:       make(map[string]*Limit),
+		buckets:    make(map[string]*bucket),
+	}
+	if len(l.operations) == 0 {
+		l.operations = []string{"xml.sign", "pdf.sign", "jws.create"}
+	}
+	if vip.IsSet("limits.default_client") {
+		l.defaultClient = &Limit{Name: "default"}
+		if err := vip.UnmarshalKey("limits.default_client", l.defaultClient); err != nil {
+			return nil, fmt.Errorf("invalid limits.default_client: %w", err)
+		}
+		if err := l.defaultClient.check("limits.default_client"); err != nil {
+			return nil, err
+		}
+	}
+	for _, scope := range []struct {
+		key   string
+		limit map[string]*Limit
+	}{{"limits.clients", l.clients}, {"limits.keys", l.keys}} {
+		var limits []*Limit
+		if err := vip.UnmarshalKey(scope.key, &limits); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", scope.key, err)
+		}
+		for _, lim := range limits {
+			if lim.Name == "" {
+				return nil, fmt.Errorf("%s: an entry has no name", scope.key)
+			}
+			if _, dup := scope.limit[lim.Name]; dup {
+				return nil, fmt.Errorf("%s: '%s' is configured twice", scope.key, lim.Name)
+			}
+			if err := lim.check(scope.key); err != nil {
+				return nil, err
+			}
+			scope.limit[lim.Name] = lim
+		}
+	}
+
+	dir := vip.GetString("limits.usage_dir")
+	if dir == "" {
+		return nil, errors.New("limits.usage_dir is empty")
+	}
+	path := filepath.Join(dir, name+".json")
+	usage, err := openUsageStore(path, &l.mu)
+	if err != nil {
+		return nil, err
+	}
+	l.usage = usage
+	slog.Info("rate limits enabled", "clients", len(l.clients), "keys", len(l.keys),
+		"default_client", l.defaultClient != nil, "operations", l.operations, "usage_file", path)
+	return l, nil
+}
+
+func (lim *Limit) check(key string) error {
+	if lim.RatePerSecond < 0 || lim.Burst < 0 || lim.DailyQuota < 0 {
+		return fmt.Errorf("%s '%s': rate_per_second, burst and daily_quota cannot be negative", key, lim.Name)
+	}
+	if lim.Burst == 0 {
+		lim.Burst = int(math.Max(1, math.Ceil(lim.RatePerSecond)))
+	}
+	return nil
+}
+
+type scoped struct {
+	scope, name string
+	limit       *Limit
+}
+
+func (c scoped) id() string { return c.scope + ":" + c.name }
+
+// checks returns the limits that apply to an operation by the caller of r
+// with the signing key key.
+func (l *Limiter) checks(r *http.Request, operation, key string) []scoped {
+	if l == nil || !slices.Contains(l.operations, operation) {
+		return nil
+	}
+	var checks []scoped
+	client := apiauth.ClientName(r)
+	limit := l.clients[client]
+	if client == "" {
+		client = "ip:" + remoteIP(r)
+	}
+	if limit == nil {
+		limit = l.defaultClient
+	}
+	if limit != nil {
+		checks = append(checks, scoped{"client", client, limit})
+	}
+	if limit := l.keys[key]; key != "" && limit != nil {
+		checks = append(checks, scoped{"key", key, limit})
+	}
+	return checks
+}
+
+// Allow counts one operation by the caller of r with the signing key key
+// ("" when none) against the limits, or returns a *LimitError and counts
+// nothing. Operations outside limits.operations are always allowed.
+func (l *Limiter) Allow(r *http.Request, operation, key string) error {
+	checks := l.checks(r, operation, key)
+	if len(checks) == 0 {
+		return nil
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	for _, c := range checks {
+		id := c.id()
+		if c.limit.DailyQuota > 0 && l.usage.count(id, now) >= c.limit.DailyQuota {
+			refused.Inc(c.scope, "quota")
+			return &LimitError{Scope: c.scope, Name: c.name, Quota: true, RetryAfter: untilTomorrow(now)}
+		}
+		if c.limit.RatePerSecond > 0 {
+			if wait := l.bucket(id, c.limit, now).wait(now); wait > 0 {
+				refused.Inc(c.scope, "rate")
+				return &LimitError{Scope: c.scope, Name: c.name, RetryAfter: wait}
+			}
+		}
+	}
+	for _, c := range checks {
+		id := c.id()
+		if c.limit.RatePerSecond > 0 {
+			l.bucket(id, c.limit, now).take()
+		}
+		if c.limit.DailyQuota > 0 {
+			l.usage.add(id, now, 1)
+		}
+	}
+	return nil
+}
+
+// Refund gives back what a successful Allow with the same arguments
+// counted, for an operation that was then not carried out, e.g. because
+// the signing queue was full.
+func (l *Limiter) Refund(r *http.Request, operation, key string) {
+	checks := l.checks(r, operation, key)
+	if len(checks) == 0 {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range checks {
+		id := c.id()
+		if b, ok := l.buckets[id]; ok && c.limit.RatePerSecond > 0 {
+			b.tokens = math.Min(b.burst, b.tokens+1)
+		}
+		if c.limit.DailyQuota > 0 {
+			l.usage.add(id, now, -1)
+		}
+	}
+}
+
+// Close writes the usage counts.
+func (l *Limiter) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.usage.close()
+}
+
+// SetRetryAfter sets the Retry-After header (whole seconds, rounded up) for
+// an error of Allow.
+func SetRetryAfter(w http.ResponseWriter, err error) {
+	var le *LimitError
+	if errors.As(err, &le) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(le.RetryAfter.Seconds()))))
+	}
+}
+
+func (l *Limiter) bucket(id string, limit *Limit, now time.Time) *bucket {
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{rate: limit.RatePerSecond, burst: float64(limit.Burst), tokens: float64(limit.Burst), last: now}
+		l.buckets[id] = b
+	}
+	return b
+}
+
+// sweep drops the buckets that have refilled since their last use, at most
+// once every sweepInterval.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for id, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// bucket is a token bucket: it holds up to burst tokens and gains rate
+// tokens per second; a request takes one.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// wait refills the bucket and returns how long until it holds a token.
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take() { b.tokens-- }
+
+func untilTomorrow(now time.Time) time.Duration {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
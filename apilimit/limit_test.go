@@ -0,0 +1,228 @@
+package apilimit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func newLimiter(t *testing.T, dir string, limits map[string]any) *Limiter {
+	t.Helper()
+	vip := viper.New()
+	limits["usage_dir"] = dir
+	vip.Set("limits", limits)
+	l, err := LoadFromConfig(vip, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func request(addr string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/sign", nil)
+	r.RemoteAddr = addr + ":40000"
+	return r
+}
+
+func TestBucketRefill(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	b := &bucket{rate: 2, burst: 3, tokens: 3, last: start}
+	tests := []struct {
+		after time.Duration // since start
+		wait  time.Duration // 0 when a token is taken
+	}{
+		{0, 0},
+		{0, 0},
+		{0, 0},
+		{0, 500 * time.Millisecond},
+		{250 * time.Millisecond, 250 * time.Millisecond},
+		{500 * time.Millisecond, 0},
+		{500 * time.Millisecond, 500 * time.Millisecond},
+		{time.Hour, 0}, // refilled to burst, not beyond
+		{time.Hour, 0},
+		{time.Hour, 0},
+		{time.Hour, 500 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		wait := b.wait(start.Add(tt.after))
+		if wait != tt.wait {
+			t.Fatalf("request %d at +%s: wait = %s, want %s", i, tt.after, wait, tt.wait)
+		}
+		if wait == 0 {
+			b.take()
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	l := newLimiter(t, t.TempDir(), map[string]any{
+		"operations":     []string{"pdf.sign"},
+		"default_client": map[string]any{"rate_per_second": 0.001, "burst": 2},
+		"keys":           []map[string]any{{"name": "hsm", "daily_quota": 3}},
+	})
+	defer l.Close()
+
+	tests := []struct {
+		name      string
+		addr, op  string
+		key       string
+		wantScope string // "" when allowed
+		quota     bool
+	}{
+		{"first", "10.0.0.1", "pdf.sign", "hsm", "", false},
+		{"burst", "10.0.0.1", "pdf.sign", "hsm", "", false},
+		{"over the rate", "10.0.0.1", "pdf.sign", "hsm", "client", false},
+		{"other operation", "10.0.0.1", "xml.sign", "hsm", "", false},
+		{"other client", "10.0.0.2", "pdf.sign", "hsm", "", false},
+		{"key quota used up", "10.0.0.3", "pdf.sign", "hsm", "key", true},
+		{"other key", "10.0.0.3", "pdf.sign", "pfx", "", false},
+	}
+	for _, tt := range tests {
+		err := l.Allow(request(tt.addr), tt.op, tt.key)
+		if tt.wantScope == "" {
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var le *LimitError
+		if !errors.As(err, &le) || !errors.Is(err, ErrLimited) {
+			t.Fatalf("%s: err = %v, want a *LimitError", tt.name, err)
+		}
+		if le.Scope != tt.wantScope || le.Quota != tt.quota || le.RetryAfter <= 0 {
+			t.Fatalf("%s: err = %+v, want scope %s, quota %v", tt.name, le, tt.wantScope, tt.quota)
+		}
+	}
+
+	// The refused requests counted nothing: 10.0.0.3 still has its burst.
+	if err := l.Allow(request("10.0.0.3"), "pdf.sign", ""); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	SetRetryAfter(w, l.Allow(request("10.0.0.3"), "pdf.sign", "hsm"))
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("no Retry-After header")
+	}
+}
+
+func TestRefund(t *testing.T) {
+	l := newLimiter(t, t.TempDir(), map[string]any{
+		"default_client": map[string]any{"rate_per_second": 0.001, "burst": 1, "daily_quota": 1},
+	})
+	defer l.Close()
+	r := request("10.0.0.1")
+	for i := 0; i < 3; i++ {
+		if err := l.Allow(r, "pdf.sign", ""); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		l.Refund(r, "pdf.sign", "")
+	}
+	if err := l.Allow(r, "pdf.sign", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(r, "pdf.sign", ""); err == nil {
+		t.Fatal("second request allowed")
+	}
+}
+
+func TestQuotaPersists(t *testing.T) {
+	dir := t.TempDir()
+	limits := map[string]any{"default_client": map[string]any{"daily_quota": 2}}
+	l := newLimiter(t, dir, limits)
+	r := request("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(r, "pdf.sign", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart the quota is still used up.
+	l = newLimiter(t, dir, limits)
+	defer l.Close()
+	var le *LimitError
+	if err := l.Allow(r, "pdf.sign", ""); !errors.As(err, &le) || !le.Quota {
+		t.Fatalf("after reopening: err = %v, want the quota used up", err)
+	}
+	if err := l.Allow(request("10.0.0.2"), "pdf.sign", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsageOfEarlierDayDropped(t *testing.T) {
+	dir := t.TempDir()
+	old := `{"day": "2020-01-01", "counts": {"client:ip:10.0.0.1": 5}}`
+	if err := os.WriteFile(filepath.Join(dir, "test.json"), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := newLimiter(t, dir, map[string]any{"default_client": map[string]any{"daily_quota": 1}})
+	defer l.Close()
+	if err := l.Allow(request("10.0.0.1"), "pdf.sign", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweepDropsRefilledBuckets(t *testing.T) {
+	l := newLimiter(t, t.TempDir(), map[string]any{
+		"default_client": map[string]any{"rate_per_second": 1, "burst": 2},
+	})
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		if err := l.Allow(request(fmt.Sprintf("10.0.1.%d", i)), "pdf.sign", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Use one bucket up; the others have a token left and refill in a second.
+	busy := request("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(busy, "pdf.sign", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l.mu.Lock()
+	before := len(l.buckets)
+	now := time.Now()
+	for id, b := range l.buckets {
+		if id != "client:ip:10.0.0.1" {
+			b.last = now.Add(-2 * time.Second)
+		}
+	}
+	l.swept = now.Add(-sweepInterval)
+	l.sweep(now)
+	n := len(l.buckets)
+	_, kept := l.buckets["client:ip:10.0.0.1"]
+	l.mu.Unlock()
+	if before != 101 {
+		t.Fatalf("%d buckets before the sweep, want 101", before)
+	}
+	if n != 1 || !kept {
+		t.Fatalf("%d buckets after the sweep, want only the one in use", n)
+	}
+	if err := l.Allow(busy, "pdf.sign", ""); err == nil {
+		t.Fatal("the sweep refilled a bucket in use")
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	if err := l.Allow(request("10.0.0.1"), "pdf.sign", "hsm"); err != nil {
+		t.Fatal(err)
+	}
+	l.Refund(request("10.0.0.1"), "pdf.sign", "hsm")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package apilimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// --- Usage File ---
+// The daily counts of the current UTC day, as JSON:
+//
+//	{"day": "2026-10-18", "counts": {"client:erp": 1520, "key:pkcs11": 1877}}
+//
+// Counting happens in memory; the file is written every flushInterval when
+// something changed, and on Close. It is written to a temporary file that
+// then replaces it, so a crash leaves the old counts rather than half a
+// file. A crash can still lose the requests of the last interval, which
+// lets a client go over its quota by that much. Counts of earlier days are
+// dropped when the day changes.
+
+const flushInterval = 5 * time.Second
+
+type usageFile struct {
+	Day    string           `json:"day"`
+	Counts map[string]int64 `json:"counts"`
+}
+
+// usageStore is guarded by the Limiter's mutex, except for the file
+// writes, which have their own.
+type usageStore struct {
+	path  string
+	usage usageFile
+	dirty bool
+
+	writeMu sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	mu      *sync.Mutex // the Limiter's
+}
+
+func openUsageStore(path string, mu *sync.Mutex) (*usageStore, error) {
+	s := &usageStore{path: path, mu: mu, stop: make(chan struct{}), done: make(chan struct{})}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("reading the usage file: %w", err)
+	default:
+		if err := json.Unmarshal(data, &s.usage); err != nil {
+			return nil, fmt.Errorf("usage file '%s' is not valid: %w", path, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating limits.usage_dir: %w", err)
+	}
+	s.today(time.Now())
+	go s.flushLoop()
+	return s, nil
+}
+
+// today drops the counts of an earlier day.
+func (s *usageStore) today(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if s.usage.Day != day {
+		s.usage = usageFile{Day: day, Counts: make(map[string]int64)}
+		s.dirty = true
+	}
+	if s.usage.Counts == nil {
+		s.usage.Counts = make(map[string]int64)
+	}
+}
+
+func (s *usageStore) count(id string, now time.Time) int64 {
+	s.today(now)
+	return s.usage.Counts[id]
+}
+
+// add adds n (1, or -1 for a refund) to the count of id.
+func (s *usageStore) add(id string, now time.Time, n int64) {
+	s.today(now)
+	if s.usage.Counts[id]+n < 0 {
+		return // counted on an earlier day
+	}
+	s.usage.Counts[id] += n
+	s.dirty = true
+}
+
+func (s *usageStore) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				slog.Error("writing the usage file failed", "path", s.path, "error", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flush writes the counts if they changed.
+func (s *usageStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.usage, "", "    ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return s.failed(err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return s.failed(err)
+	}
+	return nil
+}
+
+// failed marks the counts as not written, so the next flush tries again.
+func (s *usageStore) failed(err error) error {
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+	return err
+}
+
+func (s *usageStore) close() error {
+	close(s.stop)
+	<-s.done
+	return s.flush()
+}
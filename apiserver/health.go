@@ -0,0 +1,113 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- Health and Readiness ---
+// /healthz answers 200 as long as the process serves requests at all
+// (liveness). /readyz runs the service's readiness checks, e.g. an HSM
+// login, and answers 200 when all pass, 503 when one fails or the server
+// is shutting down. Both are answered before authentication so that a load
+// balancer needs no credentials; the answer names the failed checks, the
+// errors themselves only go to the log.
+//
+// A check can be expensive (the signing API signs with the HSM), and
+// anyone who reaches the port may probe. The checks therefore run one round
+// at a time and the result, ready or not, is reused for
+// ready_cache_seconds: probes cause at most one round per interval however
+// often they come.
+
+// readyTimeout bounds one readiness check.
+const readyTimeout = 10 * time.Second
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readyCache holds the last /readyz result. mu is held while the checks
+// run, so probes that arrive meanwhile wait for that round.
+type readyCache struct {
+	mu     sync.Mutex
+	at     time.Time // zero before the first round
+	status int
+	body   map[string]any
+}
+
+// AddReadinessCheck adds a check to /readyz. check must give up when ctx
+// is done.
+func (s *Server) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	s.mu.Lock()
+	s.checks = append(s.checks, readinessCheck{name, check})
+	s.mu.Unlock()
+}
+
+// withHealth serves /healthz and /readyz and passes everything else to
+// next.
+func (s *Server) withHealth(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, map[string]any{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.Handle("/", next)
+	return mux
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeStatus(w, http.StatusServiceUnavailable, map[string]any{"status": "shutting down"})
+		return
+	}
+	status, body := s.readiness(time.Now())
+	writeStatus(w, status, body)
+}
+
+// readiness returns the cached result, or runs the checks when it is older
+// than ReadyCacheTTL. The checks do not use the probe's context: a probe
+// that gives up must not turn the result reused by the others into a
+// failure.
+func (s *Server) readiness(now time.Time) (int, map[string]any) {
+	s.ready.mu.Lock()
+	defer s.ready.mu.Unlock()
+	if !s.ready.at.IsZero() && now.Sub(s.ready.at) < s.cfg.ReadyCacheTTL {
+		return s.ready.status, s.ready.body
+	}
+
+	s.mu.Lock()
+	checks := s.checks
+	s.mu.Unlock()
+
+	status, results := http.StatusOK, make(map[string]string, len(checks))
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+		err := c.check(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("readiness check failed", "server", s.cfg.Name, "check", c.name, "error", err)
+			status, results[c.name] = http.StatusServiceUnavailable, "failed"
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	body := map[string]any{"status": "ready", "checks": results}
+	if status != http.StatusOK {
+		body["status"] = "not ready"
+	}
+	// The time the round ended: a slow check must not shorten the interval
+	s.ready.at, s.ready.status, s.ready.body = time.Now(), status, body
+	return status, body
+}
+
+func writeStatus(w http.ResponseWriter, status int, body map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,107 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	cfg, err := LoadFromConfig(nil, "test", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(cfg, http.NotFoundHandler(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func probe(s *Server) int {
+	w := httptest.NewRecorder()
+	s.http.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return w.Code
+}
+
+func TestReadyzCached(t *testing.T) {
+	s := newTestServer(t)
+	var calls atomic.Int32
+	var fail atomic.Bool
+	s.AddReadinessCheck("key", func(ctx context.Context) error {
+		calls.Add(1)
+		if fail.Load() {
+			return errors.New("HSM gone")
+		}
+		return nil
+	})
+
+	for i := 0; i < 5; i++ {
+		if code := probe(s); code != http.StatusOK {
+			t.Fatalf("probe %d: %d, want 200", i, code)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d checks for 5 probes, want 1", n)
+	}
+
+	// A failure is cached as well, and seen once the interval is over.
+	fail.Store(true)
+	s.ready.mu.Lock()
+	s.ready.at = s.ready.at.Add(-s.cfg.ReadyCacheTTL)
+	s.ready.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if code := probe(s); code != http.StatusServiceUnavailable {
+			t.Fatalf("probe %d after the failure: %d, want 503", i, code)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("%d checks, want 2", n)
+	}
+}
+
+func TestReadyzConcurrentProbesShareOneRound(t *testing.T) {
+	s := newTestServer(t)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	s.AddReadinessCheck("key", func(ctx context.Context) error {
+		calls.Add(1)
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = probe(s)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("probe %d: %d, want 200", i, code)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d checks for 10 concurrent probes, want 1", n)
+	}
+}
+
+func TestReadyzDraining(t *testing.T) {
+	s := newTestServer(t)
+	s.draining.Store(true)
+	if code := probe(s); code != http.StatusServiceUnavailable {
+		t.Fatalf("probe while draining: %d, want 503", code)
+	}
+}
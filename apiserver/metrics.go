@@ -0,0 +1,56 @@
+package apiserver
+
+import (
+	"chilkattest/metrics"
+	"net/http"
+	"time"
+)
+
+// --- Operation Metrics ---
+// Every signing or verification a service performs is counted by
+// operation (the authorization operation, e.g. pdf.sign), key (the key
+// name of the authorization rules, "" when no key is involved) and result
+// ("ok", a pdfsign.ResultLabel, or for operations measured at the HTTP
+// level "rejected" for 4xx and "error" for 5xx). The services serve the
+// metrics at /metrics, behind authentication (operation metrics.read).
+
+var (
+	operations = metrics.NewCounter("signing_operations_total",
+		"Signing and verification operations, by operation, key and result.", "operation", "key", "result")
+	operationDuration = metrics.NewHistogram("signing_operation_duration_seconds",
+		"Duration of signing and verification operations, by operation, key and result.", nil, "operation", "key", "result")
+)
+
+// ObserveOperation records an operation started at start.
+func ObserveOperation(operation, key, result string, start time.Time) {
+	operations.Inc(operation, key, result)
+	operationDuration.Observe(time.Since(start).Seconds(), operation, key, result)
+}
+
+// Instrument records every request to next as operation, with the result
+// taken from the response status.
+func Instrument(operation, key string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r)
+		result := "ok"
+		switch {
+		case sw.status >= 500:
+			result = "error"
+		case sw.status >= 400:
+			result = "rejected"
+		}
+		ObserveOperation(operation, key, result, start)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,264 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Server Bootstrap ---
+// The HTTP services share how they listen and stop. Each has a section
+// under "servers", named after the service:
+//
+//	"servers": {
+//	    "signature_api": {
+//	        "address": "0.0.0.0", "port": 8080,
+//	        "tls": {"cert_file": "...", "key_file": "...", "client_ca_files": ["..."]},
+//	        "max_body_mb": 64,
+//	        "read_header_timeout_seconds": 10, "read_timeout_seconds": 120,
+//	        "write_timeout_seconds": 180, "idle_timeout_seconds": 120,
+//	        "shutdown_timeout_seconds": 60, "ready_cache_seconds": 10
+//	    }
+//	}
+//
+// Without an address, a service with authentication listens on every
+// interface and one without only on 127.0.0.1. With tls the service speaks
+// HTTPS only; the certificate and key are read again when their files
+// change, so a renewed certificate needs no restart. Client certificates
+// from client_ca_files are requested, not required (see apiauth).
+//
+// SIGINT or SIGTERM stops the listener, lets the requests in progress
+// finish for up to shutdown_timeout_seconds, and then runs the service's
+// shutdown hooks. The hooks get the rest of that time, but a signing
+// operation that has started is always waited for: an HSM session must not
+// be closed in the middle of a signature. Further signals while draining
+// are logged and ignored.
+
+// Config is the configuration of one service's listener.
+type Config struct {
+	Name              string
+	Address           string // "" for the default (see ListenAddr)
+	Port              int
+	CertFile          string
+	KeyFile           string
+	ClientCAFiles     []string
+	MaxBodyBytes      int64
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	ReadyCacheTTL     time.Duration // how long a /readyz result is reused
+}
+
+// LoadFromConfig reads servers.<name>. vip may be nil (no config.json), in
+// which case every setting has its default.
+func LoadFromConfig(vip *viper.Viper, name string, defaultPort int) (*Config, error) {
+	if vip == nil {
+		vip = viper.New()
+	}
+	key := func(k string) string { return "servers." + name + "." + k }
+	seconds := func(k string, def int) time.Duration {
+		if !vip.IsSet(key(k)) {
+			return time.Duration(def) * time.Second
+		}
+		return time.Duration(vip.GetInt(key(k))) * time.Second
+	}
+	c := &Config{
+		Name:              name,
+		Address:           vip.GetString(key("address")),
+		Port:              vip.GetInt(key("port")),
+		CertFile:          vip.GetString(key("tls.cert_file")),
+		KeyFile:           vip.GetString(key("tls.key_file")),
+		ClientCAFiles:     vip.GetStringSlice(key("tls.client_ca_files")),
+		MaxBodyBytes:      int64(vip.GetInt(key("max_body_mb"))) << 20,
+		ReadHeaderTimeout: seconds("read_header_timeout_seconds", 10),
+		ReadTimeout:       seconds("read_timeout_seconds", 120),
+		WriteTimeout:      seconds("write_timeout_seconds", 180),
+		IdleTimeout:       seconds("idle_timeout_seconds", 120),
+		ShutdownTimeout:   seconds("shutdown_timeout_seconds", 60),
+		ReadyCacheTTL:     seconds("ready_cache_seconds", 10),
+	}
+	if c.Port == 0 {
+		c.Port = defaultPort
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return nil, fmt.Errorf("invalid servers.%s.port %d", name, c.Port)
+	}
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = 64 << 20
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("servers.%s.tls needs both cert_file and key_file", name)
+	}
+	if len(c.ClientCAFiles) > 0 && c.CertFile == "" {
+		return nil, fmt.Errorf("servers.%s.tls.client_ca_files needs cert_file and key_file", name)
+	}
+	for k, d := range map[string]time.Duration{
+		"read_header_timeout_seconds": c.ReadHeaderTimeout,
+		"read_timeout_seconds":        c.ReadTimeout,
+		"write_timeout_seconds":       c.WriteTimeout,
+		"idle_timeout_seconds":        c.IdleTimeout,
+		"shutdown_timeout_seconds":    c.ShutdownTimeout,
+		"ready_cache_seconds":         c.ReadyCacheTTL,
+	} {
+		if d <= 0 {
+			return nil, fmt.Errorf("servers.%s.%s must be positive", name, k)
+		}
+	}
+	return c, nil
+}
+
+// TLS reports whether the service speaks HTTPS.
+func (c *Config) TLS() bool {
+	return c.CertFile != ""
+}
+
+// ListenAddr is the address to listen on: the configured one, or every
+// interface when callers must authenticate and only the loopback interface
+// when they need not.
+func (c *Config) ListenAddr(authenticated bool) string {
+	port := strconv.Itoa(c.Port)
+	switch {
+	case c.Address != "":
+		if !authenticated {
+			slog.Warn("no auth key in config.json, but listening on a configured address", "server", c.Name, "address", c.Address)
+		}
+		return net.JoinHostPort(c.Address, port)
+	case authenticated:
+		return ":" + port
+	default:
+		slog.Warn("no auth key in config.json, listening on localhost only", "server", c.Name, "port", c.Port)
+		return "127.0.0.1:" + port
+	}
+}
+
+// URL is the base URL for log messages.
+func (c *Config) URL() string {
+	scheme := "http"
+	if c.TLS() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://localhost:%d", scheme, c.Port)
+}
+
+// Server is a service's HTTP server with its shutdown hooks and readiness
+// checks.
+type Server struct {
+	cfg      *Config
+	http     *http.Server
+	draining atomic.Bool
+	mu       sync.Mutex
+	hooks    []func(ctx context.Context)
+	checks   []readinessCheck
+	ready    readyCache
+}
+
+// New sets up the server for handler, adding /healthz and /readyz (see
+// health.go); authenticated says whether handler checks credentials (see
+// ListenAddr).
+func New(cfg *Config, handler http.Handler, authenticated bool) (*Server, error) {
+	s := &Server{cfg: cfg}
+	s.http = &http.Server{
+		Addr:              cfg.ListenAddr(authenticated),
+		Handler:           http.MaxBytesHandler(s.withHealth(handler), cfg.MaxBodyBytes),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if cfg.TLS() {
+		tlsConfig, err := serverTLS(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.http.TLSConfig = tlsConfig
+	}
+	return s, nil
+}
+
+// Addr is the address the server listens on.
+func (s *Server) Addr() string {
+	return s.http.Addr
+}
+
+// OnShutdown adds a hook that runs once no request is in progress any
+// more, e.g. to stop workers. Hooks run in the order they were added.
+func (s *Server) OnShutdown(hook func(ctx context.Context)) {
+	s.mu.Lock()
+	s.hooks = append(s.hooks, hook)
+	s.mu.Unlock()
+}
+
+// Run serves until SIGINT or SIGTERM and then shuts down gracefully. It
+// returns an error if the server could not listen; the shutdown hooks run
+// in either case.
+func (s *Server) Run() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		s.runHooks(context.Background())
+		return err
+	}
+	served := make(chan error, 1)
+	go func() {
+		if s.http.TLSConfig != nil {
+			served <- s.http.ServeTLS(ln, "", "") // the certificate comes from TLSConfig
+		} else {
+			served <- s.http.Serve(ln)
+		}
+	}()
+	slog.Info("server started", "server", s.cfg.Name, "address", s.http.Addr, "tls", s.cfg.TLS())
+
+	select {
+	case err = <-served:
+		// Serve only returns early on a listener failure.
+		s.runHooks(context.Background())
+		return err
+	case sig := <-signals:
+		s.draining.Store(true)
+		slog.Info("shutting down, waiting for requests in progress", "server", s.cfg.Name, "signal", sig.String(), "timeout", s.cfg.ShutdownTimeout)
+	}
+	go func() {
+		for sig := range signals {
+			slog.Warn("still shutting down, signal ignored", "server", s.cfg.Name, "signal", sig.String())
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(ctx); err != nil {
+		// Connections still open are closed; handlers that are waiting
+		// on a signing job stop waiting, the job itself goes on.
+		slog.Warn("requests still in progress after the shutdown timeout, closing their connections", "server", s.cfg.Name, "error", err)
+		s.http.Close()
+	}
+	s.runHooks(ctx)
+	slog.Info("server stopped", "server", s.cfg.Name)
+	return nil
+}
+
+func (s *Server) runHooks(ctx context.Context) {
+	s.mu.Lock()
+	hooks := s.hooks
+	s.hooks = nil
+	s.mu.Unlock()
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
@@ -0,0 +1,104 @@
+package apiserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// --- TLS Certificate Reload ---
+// The certificate is loaded at start and then again when the modification
+// time of the certificate or key file changes, checked at most every
+// certCheckInterval during handshakes. A file that cannot be loaded (e.g.
+// the certificate was replaced before the key) keeps the previous
+// certificate and is tried again at the next check.
+
+const certCheckInterval = 10 * time.Second
+
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	loaded  time.Time // modification time of the files cert was loaded from
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.modTime()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate '%s': %w", certFile, err)
+	}
+	r.cert, r.loaded, r.checked = &cert, modTime, time.Now()
+	return r, nil
+}
+
+func (r *certReloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	modTime, err := r.modTime()
+	if err != nil || modTime.Equal(r.loaded) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		slog.Warn("reloading TLS certificate failed, keeping the previous one", "cert_file", r.certFile, "error", err)
+		return r.cert, nil
+	}
+	r.cert, r.loaded = &cert, modTime
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		slog.Info("TLS certificate reloaded", "cert_file", r.certFile, "subject", leaf.Subject.CommonName, "not_after", leaf.NotAfter)
+	}
+	return r.cert, nil
+}
+
+func serverTLS(cfg *Config) (*tls.Config, error) {
+	certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{GetCertificate: certs.getCertificate, MinVersion: tls.VersionTLS12}
+	if len(cfg.ClientCAFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, f := range cfg.ClientCAFiles {
+			pem, err := os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("reading client CA '%s': %w", f, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates in client CA '%s'", f)
+			}
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
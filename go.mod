@@ -9,13 +9,16 @@ replace chilkat => C:/Users/admin/chilkatsoft.com/chilkat
 require (
 	chilkat v0.0.0-00010101000000-000000000000
 	github.com/ThalesGroup/crypto11 v1.4.1
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/spf13/viper v1.20.1
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/fsnotify/fsnotify v1.8.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
-	github.com/miekg/pkcs11 v1.1.1 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sagikazarmark/locafero v0.7.0 // indirect
@@ -28,6 +31,4 @@ require (
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
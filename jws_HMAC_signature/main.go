@@ -2,10 +2,20 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/apiauth"
+	"chilkattest/apilimit"
+	"chilkattest/apiserver"
+	"chilkattest/metrics"
+	"chilkattest/pdfsign"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+
+	"github.com/spf13/viper"
 )
 
 /*
@@ -38,24 +48,31 @@ type ValidateResponse struct {
 	Error   string      `json:"error,omitempty"`
 }
 
-// --- Global Chilkat Unlock (Consider thread safety for production) ---
-// It might be safer to unlock in each handler if concurrency is high
-var chilkatUnlocked bool = false
+// --- Global Chilkat Unlock ---
+// The handlers and /readyz run concurrently; unlockMu guards chilkatUnlocked
+// and makes them wait for an unlock in progress. A failed unlock is tried
+// again on the next request.
+var (
+	unlockMu        sync.Mutex
+	chilkatUnlocked bool
+)
 
 func ensureChilkatUnlocked() bool {
+	unlockMu.Lock()
+	defer unlockMu.Unlock()
 	if chilkatUnlocked {
 		return true
 	}
 	glob := chilkat.NewGlobal()
 	success := glob.UnlockBundle("Anything for 30-day trial")
 	if success != true {
-		log.Println("Chilkat unlock failed:", glob.LastErrorText())
+		pdfsign.LogFailure(slog.Default(), "Chilkat unlock failed", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 		glob.DisposeGlobal()
 		return false
 	}
 	glob.DisposeGlobal() // Dispose after unlock check
 	chilkatUnlocked = true
-	log.Println("Chilkat library unlocked successfully.")
+	slog.Info("Chilkat library unlocked")
 	return true
 }
 
@@ -99,7 +116,7 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 	success := jws.SetMacKey(signatureIndex, req.HmacKey, "base64url")
 	if !success {
 		// Note: SetMacKey often doesn't produce LastErrorText immediately if format is wrong
-		log.Printf("Failed to set HMAC key (potential format issue?): %s", jws.LastErrorText())
+		slog.Warn("failed to set HMAC key (potential format issue?)", "chilkat_log", jws.LastErrorText())
 		writeJsonError(w, "Failed to set HMAC key (is it valid base64url?)", http.StatusInternalServerError)
 		return
 	}
@@ -107,7 +124,7 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 	// Set protected header
 	success = jws.SetProtectedHeader(signatureIndex, jwsProtHdr)
 	if !success {
-		log.Printf("Failed to set protected header: %s", jws.LastErrorText())
+		slog.Error("failed to set protected header", "chilkat_log", jws.LastErrorText())
 		writeJsonError(w, "Failed to set protected header", http.StatusInternalServerError)
 		return
 	}
@@ -116,7 +133,7 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 	bIncludeBom := false
 	success = jws.SetPayload(req.Payload, "utf-8", bIncludeBom)
 	if !success {
-		log.Printf("Failed to set payload: %s", jws.LastErrorText())
+		slog.Error("failed to set payload", "chilkat_log", jws.LastErrorText())
 		writeJsonError(w, "Failed to set payload", http.StatusInternalServerError)
 		return
 	}
@@ -124,13 +141,13 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 	// Create the JWS
 	jwsCompactPtr := jws.CreateJws()
 	if jws.LastMethodSuccess() != true {
-		log.Printf("Failed to create JWS: %s", jws.LastErrorText())
+		slog.Error("failed to create JWS", "chilkat_log", jws.LastErrorText())
 		writeJsonError(w, "Failed to create JWS", http.StatusInternalServerError)
 		return
 	}
 	jwsCompact := *jwsCompactPtr
 
-	log.Printf("Successfully created JWS for payload: %.30s...", req.Payload)
+	slog.Info("JWS created", "caller", apiauth.Caller(r), "payload_bytes", len(req.Payload))
 	resp := CreateResponse{Jws: jwsCompact}
 	json.NewEncoder(w).Encode(resp)
 }
@@ -165,7 +182,7 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 	// Load the JWS
 	success := jws2.LoadJws(req.Jws)
 	if !success {
-		log.Printf("Failed to load JWS string: %s", jws2.LastErrorText())
+		slog.Warn("failed to load JWS string", "chilkat_log", jws2.LastErrorText())
 		writeJsonError(w, "Failed to load JWS string (is it valid compact JWS?)", http.StatusBadRequest)
 		return
 	}
@@ -174,7 +191,7 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 	signatureIndex := 0
 	success = jws2.SetMacKey(signatureIndex, req.HmacKey, "base64url")
 	if !success {
-		log.Printf("Failed to set HMAC key for validation: %s", jws2.LastErrorText())
+		slog.Warn("failed to set HMAC key for validation", "chilkat_log", jws2.LastErrorText())
 		writeJsonError(w, "Failed to set HMAC key for validation (is it valid base64url?)", http.StatusInternalServerError)
 		return
 	}
@@ -182,14 +199,14 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the signature
 	v := jws2.Validate(signatureIndex)
 	if v < 0 {
-		log.Printf("JWS validation failed (possible unlock issue): %s", jws2.LastErrorText())
+		slog.Error("JWS validation method failed (possible unlock issue)", "chilkat_log", jws2.LastErrorText())
 		writeJsonError(w, "JWS validation method failed", http.StatusInternalServerError)
 		return
 	}
 
 	resp := ValidateResponse{}
 	if v == 0 {
-		log.Printf("JWS validation failed for JWS: %.30s...", req.Jws)
+		slog.Info("JWS signature invalid", "caller", apiauth.Caller(r))
 		resp.IsValid = false
 		resp.Error = "Invalid signature. Key incorrect or JWS modified."
 		json.NewEncoder(w).Encode(resp)
@@ -198,7 +215,7 @@ func validateHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Signature is valid
 	resp.IsValid = true
-	log.Printf("JWS validated successfully: %.30s...", req.Jws)
+	slog.Info("JWS validated", "caller", apiauth.Caller(r))
 
 	// Recover payload
 	payloadPtr := jws2.GetPayload("utf-8")
@@ -235,14 +252,93 @@ func main() {
 	// Attempt initial unlock (optional, as handlers also check)
 	// ensureChilkatUnlocked()
 
-	http.HandleFunc("/create", createHandler)
-	http.HandleFunc("/validate", validateHandler)
+	// Callers are authenticated as configured by the auth key of
+	// config.json; without it the server only listens on localhost. The
+	// listener is configured by servers.jws_hmac_signature (see apiserver).
+	// Logging is configured by the logging key; the secrets of config.json
+	// are masked in every line.
+	var auth *apiauth.Authenticator
+	var limits *apilimit.Limiter
+	vip := viper.New()
+	vip.SetConfigName("config")
+	vip.SetConfigType("json")
+	vip.AddConfigPath("C:/chilkatPackage/chilkattest")
+	if err := vip.ReadInConfig(); err != nil {
+		slog.Warn("no config.json, running without authentication", "error", err)
+		vip = nil
+	} else {
+		closeLog, err := pdfsign.SetupLogging(vip)
+		if err != nil {
+			pdfsign.Fatal(nil, "invalid logging configuration", err)
+		}
+		defer closeLog()
+		if auth, err = apiauth.LoadFromConfig(vip); err != nil {
+			pdfsign.Fatal(nil, "invalid auth configuration", err)
+		}
+		if limits, err = apilimit.LoadFromConfig(vip, "jws_hmac_signature"); err != nil {
+			pdfsign.Fatal(nil, "invalid limits configuration", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", requireOperation(auth, "jws.create", rateLimited(limits, "jws.create", apiserver.Instrument("jws.create", "", createHandler))))
+	mux.HandleFunc("/validate", requireOperation(auth, "jws.validate", rateLimited(limits, "jws.validate", apiserver.Instrument("jws.validate", "", validateHandler))))
+	mux.HandleFunc("GET /metrics", requireOperation(auth, "metrics.read", metrics.Handler().ServeHTTP))
+
+	cfg, err := apiserver.LoadFromConfig(vip, "jws_hmac_signature", 8081)
+	if err != nil {
+		pdfsign.Fatal(nil, "invalid server configuration", err)
+	}
+	server, err := apiserver.New(cfg, auth.Middleware(mux, authFailed), auth != nil)
+	if err != nil {
+		pdfsign.Fatal(nil, "setting up the server failed", err)
+	}
+	server.AddReadinessCheck("chilkat", func(ctx context.Context) error {
+		if !ensureChilkatUnlocked() {
+			return errors.New("Chilkat library not unlocked")
+		}
+		return nil
+	})
+	server.OnShutdown(func(ctx context.Context) {
+		if err := limits.Close(); err != nil {
+			slog.Warn("writing the usage file failed", "error", err)
+		}
+	})
+	slog.Info("starting JWS HMAC server", "addr", server.Addr(), "create", cfg.URL()+"/create", "validate", cfg.URL()+"/validate")
+
+	if err := server.Run(); err != nil {
+		pdfsign.Fatal(nil, "starting the server failed", err)
+	}
+}
 
-	port := "8080" // Use a different port
-	log.Printf("Starting JWS HMAC server on port %s...\n", port)
-	log.Printf("Endpoints: POST http://localhost:%s/create, POST http://localhost:%s/validate", port, port)
+// requireOperation lets only callers allowed operation reach next.
+func requireOperation(auth *apiauth.Authenticator, operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := auth.Authorize(r, apiauth.Request{Operation: operation}); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			writeJsonError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+// rateLimited counts a request against the rate limits and quotas of
+// operation before next (limits.operations says which are limited); one
+// over a limit gets 429.
+func rateLimited(limits *apilimit.Limiter, operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := limits.Allow(r, operation, ""); err != nil {
+			apilimit.SetRetryAfter(w, err)
+			w.Header().Set("Content-Type", "application/json")
+			writeJsonError(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
 	}
 }
+
+func authFailed(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJsonError(w, err.Error(), http.StatusUnauthorized)
+}
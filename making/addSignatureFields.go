@@ -0,0 +1,65 @@
+package main
+
+import (
+	"chilkattest/pdfsign"
+	"os"
+
+	"github.com/spf13/viper" // 匯入 viper
+)
+
+// 在 PDF 中新增多個空白簽名欄位 (含可選的種子值)，供多方依序簽署。
+// 每一方之後可用 signUnsignedField.go 並設定 unsigned_field_name 填入自己的欄位。
+// 此操作以增量更新附加在檔案後方，不會破壞既有簽章，也不需要 Chilkat。
+
+func main() {
+	// --- 使用 Viper 載入設定 ---
+	viper.SetConfigName("config")                        // 設定檔名稱 (不含副檔名)
+	viper.SetConfigType("json")                          // 如果設定檔名不含副檔名，則必須指定類型
+	viper.AddConfigPath("C:/chilkatPackage/chilkattest") // 指定設定檔的絕對路徑
+
+	err := viper.ReadInConfig() // 尋找並讀取設定檔
+	if err != nil {             // 處理讀取設定檔時的錯誤
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("add-signature-fields")
+
+	pdfInputPath := viper.GetString("signature_fields_input_path")
+	pdfOutputPath := viper.GetString("signature_fields_output_path")
+	if pdfInputPath == "" || pdfOutputPath == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "signature_fields_input_path, signature_fields_output_path")
+	}
+
+	// signature_fields: [{ "name", "page", "rect": [llx, lly, urx, ury], "seed": {...} }]
+	specs, err := pdfsign.LoadSignatureFieldSpecsFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名欄位設定錯誤", err)
+	}
+	// --- 設定載入結束 ---
+
+	data, err := os.ReadFile(pdfInputPath)
+	if err != nil {
+		pdfsign.Fatal(log, "讀取 PDF 失敗", err)
+	}
+
+	out, err := pdfsign.AddSignatureFields(data, specs)
+	if err != nil {
+		pdfsign.Fatal(log, "新增簽名欄位失敗", err)
+	}
+
+	if err := os.WriteFile(pdfOutputPath, out, 0644); err != nil {
+		pdfsign.Fatal(log, "寫入 PDF 失敗", err)
+	}
+
+	log.Info("已新增空白簽名欄位", "output", pdfOutputPath, "fields", len(specs))
+	for i, s := range specs {
+		log.Info("簽名欄位", "index", i+1, "name", s.Name, "page", s.Page, "rect", s.Rect)
+	}
+	log.Info("請依序由各簽署方以 unsigned_field_name 指定自己的欄位進行簽署。")
+}
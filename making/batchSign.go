@@ -0,0 +1,122 @@
+package main
+
+import (
+	"chilkat"
+	"chilkattest/pdfsign"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/viper" // 匯入 viper
+)
+
+/*
+#cgo CFLAGS: -I C:/Users/admin/chilkatsoft.com/chilkat-9.5.0-x64/include
+#cgo LDFLAGS: -LC:/Users/admin/chilkatsoft.com/native_c_lib -lchilkatExt -lstdc++ -lws2_32
+*/
+import "C"
+
+// 批次簽署: 依 batch_manifest_path 指定的清單，以同一把金鑰 (PFX) 簽署多份文件。
+// 清單中每份文件可各自指定輸出路徑、要填入的簽名欄位，以及簽章資訊
+// (原因、地點、聯絡資訊、簽署者名稱、承諾類型)；未指定的項目使用清單的 defaults，
+// 再退回 config.json 的 signature_info。
+// 單一文件失敗不會中止整批，最後列出每份文件的結果。
+
+func main() {
+	// --- 使用 Viper 載入設定 ---
+	viper.SetConfigName("config") // 設定檔名稱 (不含副檔名)
+	viper.SetConfigType("json")   // 如果設定檔名不含副檔名，則必須指定類型
+	viper.AddConfigPath("C:/chilkatPackage/chilkattest")
+
+	err := viper.ReadInConfig() // 尋找並讀取設定檔
+	if err != nil {             // 處理讀取設定檔時的錯誤
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log := slog.Default() // SignBatch 的日誌另帶自己的 job_id
+
+	// 存取設定值
+	manifestPath := viper.GetString("batch_manifest_path")
+	pfxPath := viper.GetString("pfx_file_path")
+	pfxPassword := viper.GetString("pfx_password")
+
+	// 基本驗證
+	if manifestPath == "" || pfxPath == "" || pfxPassword == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "batch_manifest_path, pfx_file_path, pfx_password")
+	}
+
+	manifest, err := pdfsign.LoadBatchManifest(manifestPath)
+	if err != nil {
+		pdfsign.Fatal(log, "批次清單錯誤", err)
+	}
+	info, err := pdfsign.LoadSignatureInfoFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章資訊設定錯誤", err)
+	}
+	manifest.Defaults = manifest.Defaults.Merge(info)
+	if err := manifest.Defaults.Validate(); err != nil {
+		pdfsign.Fatal(log, "簽章資訊設定錯誤", err)
+	}
+	// signature_policy: 可選，整批文件以同一簽章政策簽署 (PAdES-EPES)
+	policy, err := pdfsign.LoadSignaturePolicyFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章政策設定錯誤", err)
+	}
+	// signature_algorithm: 開始簽署前依金鑰類型檢查
+	alg, err := pdfsign.LoadAlgorithmFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章演算法設定錯誤", err)
+	}
+	// --- 設定載入結束 ---
+
+	// --- Chilkat Global Unlock ---
+	glob := chilkat.NewGlobal()
+	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
+	if !success {
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
+	}
+	// --- Global Unlock 結束 ---
+
+	// 稽核日誌 (config.json 的 audit 鍵，可選): 每份文件一筆雜湊鏈紀錄，結束時以時戳封存
+	audit, err := pdfsign.LoadAuditLogFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "稽核日誌設定錯誤", err)
+	}
+	defer func() {
+		if err := audit.Close(); err != nil {
+			log.Warn("稽核日誌封存失敗", "error", err)
+		}
+	}()
+
+	keys := &pdfsign.PfxKeySource{Path: pfxPath, Password: pfxPassword}
+	defer keys.Close()
+
+	results, err := pdfsign.SignBatch(manifest, keys, pdfsign.BatchOptions{
+		Configure: func(jsonOptions *chilkat.JsonObject) {
+			jsonOptions.UpdateString("subFilter", "/ETSI.CAdES.detached")
+		},
+		Policy:    policy,
+		Algorithm: &alg,
+		Audit:     audit,
+	})
+	if err != nil {
+		pdfsign.Fatal(log, "批次簽署失敗", err)
+	}
+
+	// 每份文件的結果是報告，印在 stdout；失敗的完整 Chilkat 日誌在日誌中 (依 doc_id 查找)
+	failed := 0
+	for _, r := range results {
+		if r.Error != nil {
+			failed++
+			fmt.Printf("失敗: %s: %s (doc_id %s)\n", r.Input, r.Error, r.DocID)
+			continue
+		}
+		fmt.Printf("完成: %s -> %s (%s)\n", r.Input, r.Output, r.Space)
+	}
+	fmt.Printf("批次簽署結束: %d 份成功，%d 份失敗\n", len(results)-failed, failed)
+}
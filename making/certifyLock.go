@@ -2,8 +2,8 @@ package main
 
 import (
 	"chilkat"
-	"fmt"
-	"log" // 使用 log 進行嚴重錯誤記錄
+	"chilkattest/pdfsign"
+	"os"
 
 	"github.com/spf13/viper" // 匯入 viper
 )
@@ -26,10 +26,17 @@ func main() {
 
 	err := viper.ReadInConfig() // 尋找並讀取設定檔
 	if err != nil {             // 處理讀取設定檔時的錯誤
-		// 對於關鍵的啟動錯誤，使用 log.Fatalf
-		log.Fatalf("讀取設定檔時發生嚴重錯誤: %s \n", err)
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤", err)
 	}
 
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("certify-lock")
+
 	// 存取設定值
 	pdfInputPath := viper.GetString("pdf_input_path")
 	pfxPath := viper.GetString("pfx_path")
@@ -38,7 +45,20 @@ func main() {
 
 	// 基本驗證
 	if pdfInputPath == "" || pfxPath == "" || pfxPassword == "" || pdfOutputPath == "" {
-		log.Fatalf("設定檔 config.json 缺少必要欄位 (pdf_input_path, pfx_path, pfx_password, pdf_output_path)\n")
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "pdf_input_path, pfx_path, pfx_password, pdf_output_path")
+	}
+
+	// 認證等級 (DocMDP P=1/2/3) 與欄位鎖定 (FieldMDP All/Include/Exclude)
+	// certification_level 未設定時維持原本行為: P=1 不允許任何變更並鎖定所有欄位
+	if !viper.IsSet("certification_level") {
+		viper.Set("certification_level", "no_changes")
+		if viper.GetString("field_lock_action") == "" {
+			viper.Set("field_lock_action", "All")
+		}
+	}
+	mdpOptions, err := pdfsign.LoadMDPOptionsFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "認證/鎖定設定錯誤", err)
 	}
 	// --- 設定載入結束 ---
 
@@ -47,17 +67,33 @@ func main() {
 	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
 	if success != true {
 		// 解鎖失敗是嚴重錯誤
-		log.Fatalf("Chilkat 解鎖失敗: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 	// 注意：glob 物件通常不需要明確 Dispose，除非您有特殊需求
 
 	pdf := chilkat.NewPdf()
 	defer pdf.DisposePdf() // 確保 pdf 物件在使用完畢後被釋放
 
-	// 載入要認證和鎖定的 PDF (使用設定檔中的路徑)
-	success = pdf.LoadFile(pdfInputPath)
+	// 讀取 PDF 並檢查認證/鎖定設定；Include/Exclude 鎖定會以增量更新寫入簽名欄位的 /Lock
+	pdfData, err := os.ReadFile(pdfInputPath)
+	if err != nil {
+		pdfsign.LogFailure(log, "讀取 PDF 失敗", err)
+		return
+	}
+	pdfData, err = pdfsign.PrepareMDP(pdfData, mdpOptions)
+	if err != nil {
+		pdfsign.LogFailure(log, "準備認證/鎖定失敗", err)
+		return
+	}
+	log.Info("認證/鎖定設定", "options", mdpOptions.String())
+
+	// 載入要認證和鎖定的 PDF
+	pdfBd := chilkat.NewBinData()
+	defer pdfBd.DisposeBinData()
+	pdfBd.AppendBinary(pdfData)
+	success = pdf.LoadBd(pdfBd)
 	if !success {
-		fmt.Println("載入 PDF 失敗:", pdf.LastErrorText()) // 對於操作中的非嚴重錯誤，使用 fmt.Println
+		pdfsign.LogFailure(log, "載入 PDF 失敗", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()))
 		return
 	}
 
@@ -70,16 +106,17 @@ func main() {
 	jsonOptions.UpdateInt("signingTime", 1)
 
 	// --- 認證與鎖定 PDF 的關鍵設定 ---
-	// 與普通的批准簽名相比，認證和鎖定 PDF 的唯一程式碼差異是
-	// 在 JSON 中加入 "lockAfterSigning" 和 "docMDP.add"
-	jsonOptions.UpdateBool("lockAfterSigning", true)
-	jsonOptions.UpdateBool("docMDP.add", true)
+	// 與普通的批准簽名相比，認證和鎖定 PDF 的差異是
+	// 在 JSON 中加入 "docMDP.add"/"docMDP.accessPermissions" 與欄位鎖定
+	pdfsign.ApplyMDP(jsonOptions, mdpOptions)
 	// --- 關鍵設定結束 ---
 
-	// 將簽名放在第一頁，左上角
-	jsonOptions.UpdateInt("page", 1)
-	jsonOptions.UpdateString("appearance.y", "top")
-	jsonOptions.UpdateString("appearance.x", "left")
+	// 未指定既有簽名欄位時，將簽名放在第一頁，左上角
+	if mdpOptions.SignatureField == "" {
+		jsonOptions.UpdateInt("page", 1)
+		jsonOptions.UpdateString("appearance.y", "top")
+		jsonOptions.UpdateString("appearance.x", "left")
+	}
 
 	// 使用 10.0 的字型縮放比例
 	jsonOptions.UpdateString("appearance.fontScale", "10.0")
@@ -95,23 +132,34 @@ func main() {
 	defer cert.DisposeCert() // 確保 cert 物件在使用完畢後被釋放
 	success = cert.LoadPfxFile(pfxPath, pfxPassword)
 	if !success {
-		fmt.Println("載入 PFX 憑證失敗:", cert.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PFX 憑證失敗", pdfsign.NewChilkatError("loading the PFX", cert.LastErrorText()))
 		return
 	}
 
 	// 告知 pdf 物件使用此憑證進行簽署
 	success = pdf.SetSigningCert(cert)
 	if !success {
-		fmt.Println("設定簽名憑證失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "設定簽名憑證失敗", pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText()))
 		return
 	}
 
 	// 認證並儲存 PDF (使用設定檔中的輸出路徑)
 	success = pdf.SignPdf(jsonOptions, pdfOutputPath)
 	if !success {
-		fmt.Println("認證與鎖定 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "認證與鎖定 PDF 失敗", pdfsign.NewChilkatError("SignPdf", pdf.LastErrorText()))
+		return
+	}
+
+	// SignPdf 成功不代表鎖定已套用，重新讀取輸出確認 DocMDP/FieldMDP 參照存在
+	signed, err := os.ReadFile(pdfOutputPath)
+	if err != nil {
+		pdfsign.LogFailure(log, "讀取已簽署 PDF 失敗", err, "output", pdfOutputPath)
+		return
+	}
+	if err := pdfsign.CheckMDP(signed, mdpOptions); err != nil {
+		pdfsign.LogFailure(log, "已簽署 PDF 未套用認證/鎖定", err, "output", pdfOutputPath)
 		return
 	}
 
-	fmt.Printf("PDF 已成功認證、鎖定並儲存至 %s\n", pdfOutputPath)
+	log.Info("PDF 已成功認證、鎖定並儲存", "output", pdfOutputPath)
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"chilkat"
+	"chilkattest/pdfsign"
+	"fmt"
+
+	"github.com/spf13/viper" // 匯入 viper
+)
+
+/*
+#cgo CFLAGS: -I C:/Users/admin/chilkatsoft.com/chilkat-9.5.0-x64/include
+#cgo LDFLAGS: -LC:/Users/admin/chilkatsoft.com/native_c_lib -lchilkatExt -lstdc++ -lws2_32
+*/
+import "C"
+
+// 簽署前檢查 (doctor): 在真正簽署前逐項檢查，避免錯誤直到 SignPdf 才以一大段
+// LastErrorText 出現。檢查項目:
+//   - 設定檔與密碼/PIN 是否齊全 (密碼本身不會印出)
+//   - PKCS#11 程式庫可載入、slot 與 token 存在、PIN 未鎖定、找得到私鑰與憑證
+//   - 憑證有效期間、金鑰用途允許簽署、憑證鏈可建立到信任錨點
+//   - TSA、OCSP、CRL 端點可連線且回應正確
+//   - 輸入的 PDF 可解析、未加密、未被「不允許變更」的認證簽章鎖定
+// 結果以表格列出，任何一項 FAIL 時以結束碼 1 結束。
+// 設定在 config.json 的 preflight 鍵: key_source 為 pfx 或 pkcs11。
+
+func main() {
+	// --- 使用 Viper 載入設定 ---
+	viper.SetConfigName("config") // 設定檔名稱 (不含副檔名)
+	viper.SetConfigType("json")   // 如果設定檔名不含副檔名，則必須指定類型
+	viper.AddConfigPath("C:/chilkatPackage/chilkattest")
+
+	err := viper.ReadInConfig() // 尋找並讀取設定檔
+	if err != nil {             // 處理讀取設定檔時的錯誤
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("doctor")
+
+	// 各項設定的載入結果本身就是檢查項目，載入失敗不會中止
+	opts, results := pdfsign.LoadPreflightFromConfig(viper.GetViper())
+	// --- 設定載入結束 ---
+
+	// --- Chilkat Global Unlock ---
+	glob := chilkat.NewGlobal()
+	if glob.UnlockBundle("Anything for 30-day trial") { // 請替換成您的有效解鎖碼
+		results = append(results, pdfsign.CheckResult{Name: "chilkat: unlock", Status: pdfsign.CheckPass, Detail: glob.Version()})
+	} else {
+		results = append(results, pdfsign.CheckResult{Name: "chilkat: unlock", Status: pdfsign.CheckFail, Detail: glob.LastErrorText()})
+		opts.Keys = nil // 未解鎖無法載入憑證
+	}
+	// --- Global Unlock 結束 ---
+
+	log.Info("執行簽署前檢查...")
+	results = append(results, pdfsign.Preflight(opts)...)
+	if opts.Keys != nil {
+		opts.Keys.Close() // os.Exit 不會執行 defer，先釋放 HSM session
+	}
+	// 檢查結果表格是報告，印在 stdout；日誌寫到 stderr 或日誌檔
+	fmt.Print(pdfsign.FormatPreflightTable(results))
+
+	if pdfsign.PreflightFailed(results) {
+		pdfsign.Fatal(log, "檢查未通過，請先修正 FAIL 項目再簽署。", nil)
+	}
+	log.Info("檢查通過，可以開始簽署。")
+}
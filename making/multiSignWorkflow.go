@@ -0,0 +1,156 @@
+package main
+
+import (
+	"chilkat"
+	"chilkattest/pdfsign"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/viper" // 匯入 viper
+)
+
+/*
+#cgo CFLAGS: -IC:/Users/admin/chilkatsoft.com/chilkat-10.1.3-x64/c_includes
+#cgo LDFLAGS: -LC:/Users/admin/chilkatsoft.com/native_c_lib -lchilkatExt -lstdc++ -lws2_32
+*/
+import "C"
+
+// 多方依序簽署: 每位簽署者簽入自己的空白簽名欄位 (可先用 addSignatureFields.go 建立)，
+// 每一步都以增量更新附加，並在簽署後重新驗證所有先前的簽章，一旦有簽章失效立即停止。
+
+func main() {
+	// --- 使用 Viper 載入設定 ---
+	viper.SetConfigName("config")                        // 設定檔名稱 (不含副檔名)
+	viper.SetConfigType("json")                          // 如果設定檔名不含副檔名，則必須指定類型
+	viper.AddConfigPath("C:/chilkatPackage/chilkattest") // 指定設定檔的絕對路徑
+
+	err := viper.ReadInConfig() // 尋找並讀取設定檔
+	if err != nil {             // 處理讀取設定檔時的錯誤
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log := slog.Default() // SignSequentially 的日誌另帶自己的 job_id
+
+	pdfInputPath := viper.GetString("workflow_input_path")
+	pdfOutputPath := viper.GetString("workflow_output_path")
+	stepDir := viper.GetString("workflow_step_dir") // 可選: 保存每一步的結果
+	if pdfInputPath == "" || pdfOutputPath == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "workflow_input_path, workflow_output_path")
+	}
+
+	// workflow_signers: 依簽署順序排列，每位簽署者指定欄位與金鑰來源 (PFX 或 PKCS#11)
+	signers, err := pdfsign.LoadSignersFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽署者設定錯誤", err)
+	}
+	for _, s := range signers {
+		defer s.Keys.Close()
+	}
+
+	// appearance_template: 可選，每位簽署者的欄位在簽署前套用相同的印章樣式
+	tmpl, err := pdfsign.LoadAppearanceTemplateFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名外觀樣板錯誤", err)
+	}
+	values, err := pdfsign.LoadAppearanceValuesFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名外觀設定錯誤", err)
+	}
+
+	// signature_info: 所有簽署者共用的原因、地點、聯絡資訊與承諾類型；
+	// 個別簽署者可在 workflow_signers 的 signature_info 覆寫
+	info, err := pdfsign.LoadSignatureInfoFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章資訊設定錯誤", err)
+	}
+
+	// signature_policy: 可選，所有簽署者以同一簽章政策簽署 (PAdES-EPES)
+	policy, err := pdfsign.LoadSignaturePolicyFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章政策設定錯誤", err)
+	}
+
+	// signature_algorithm: 開始簽署前，依每位簽署者的金鑰與 HSM 支援的機制檢查
+	alg, err := pdfsign.LoadAlgorithmFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章演算法設定錯誤", err)
+	}
+
+	trust, err := pdfsign.LoadTrustStoreFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "載入信任錨點失敗", err)
+	}
+	// --- 設定載入結束 ---
+
+	// Chilkat Global Unlock
+	glob := chilkat.NewGlobal()
+	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
+	if !success {
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
+	}
+
+	if trust != nil {
+		tRoots, err := trust.ActivateChilkatRoots(pdfsign.SigningTrust)
+		if err != nil {
+			pdfsign.Fatal(log, "啟用信任錨點失敗", err)
+		}
+		defer tRoots.DisposeTrustedRoots()
+		defer tRoots.Deactivate()
+	}
+
+	// 稽核日誌 (config.json 的 audit 鍵，可選): 每位簽署者一筆雜湊鏈紀錄，結束時以時戳封存
+	audit, err := pdfsign.LoadAuditLogFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "稽核日誌設定錯誤", err)
+	}
+	defer func() {
+		if err := audit.Close(); err != nil {
+			log.Warn("稽核日誌封存失敗", "error", err)
+		}
+	}()
+
+	data, err := os.ReadFile(pdfInputPath)
+	if err != nil {
+		pdfsign.Fatal(log, "讀取 PDF 失敗", err)
+	}
+
+	opts := pdfsign.WorkflowOptions{
+		Trust:            trust,
+		StepDir:          stepDir,
+		Appearance:       tmpl,
+		AppearanceValues: values,
+		Info:             info,
+		Policy:           policy,
+		Algorithm:        &alg,
+		Audit:            audit,
+		Document:         pdfInputPath,
+		Configure: func(jsonOptions *chilkat.JsonObject) {
+			jsonOptions.UpdateString("subFilter", "/ETSI.CAdES.detached")
+			if tmpl == nil { // 未設定樣板時使用 Chilkat 預設外觀
+				jsonOptions.UpdateString("appearance.text[0]", "Digitally signed by: cert_cn")
+				jsonOptions.UpdateString("appearance.text[1]", "Date: current_dt")
+			}
+		},
+	}
+	out, steps, err := pdfsign.SignSequentially(data, signers, opts)
+	for _, step := range steps {
+		// 簽章空間用於調整預留大小
+		log.Info("簽署步驟完成", "signer", step.Signer, "field", step.Field, "signatures", len(step.Reports), "sig_space", step.Space.String())
+	}
+	if err != nil {
+		pdfsign.LogFailure(log, "多方簽署流程中止", err)
+		return
+	}
+
+	if err := os.WriteFile(pdfOutputPath, out, 0644); err != nil {
+		pdfsign.LogFailure(log, "寫入 PDF 失敗", err)
+		return
+	}
+	log.Info("所有簽署者已依序完成簽署", "signers", len(signers), "output", pdfOutputPath)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"chilkattest/pdfsign"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper" // 匯入 viper
+)
+
+// 簽名外觀預覽: 不載入任何金鑰、憑證或 HSM，設計者可反覆調整樣板與位置。
+// 1. 以目前的樣板與位置建立未簽署的簽名欄位，輸出 PDF (任何 PDF 閱讀器都能看到外觀)
+// 2. 輸出欄位所在頁面的 PNG: 頁面文字以灰色色塊表示，印章依樣板繪製，欄位矩形以紅框標示
+// cert_cn 以 preview_cert_cn 代替憑證主體名稱。
+
+func main() {
+
+	// --- 使用 Viper 載入設定 ---
+	viper.SetConfigName("config") // 設定檔名稱 (不含副檔名)
+	viper.SetConfigType("json")   // 如果設定檔名不含副檔名，則必須指定類型
+	// 指定設定檔的絕對路徑
+	viper.AddConfigPath("C:/chilkatPackage/chilkattest")
+
+	err := viper.ReadInConfig() // 尋找並讀取設定檔
+	if err != nil {             // 處理讀取設定檔時的錯誤
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("preview-appearance")
+
+	// 存取設定值
+	pdfInputPath := viper.GetString("pdf_input_path")
+	fieldName := viper.GetString("appearance_field")
+	pdfOutputPath := viper.GetString("preview_pdf_output_path") // 省略則不輸出 PDF
+	pngOutputPath := viper.GetString("preview_png_output_path") // 省略則不輸出 PNG
+	dpi := viper.GetFloat64("preview_dpi")
+	certCN := viper.GetString("preview_cert_cn")
+
+	// 基本驗證
+	if pdfInputPath == "" || fieldName == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "pdf_input_path, appearance_field")
+	}
+	if pdfOutputPath == "" && pngOutputPath == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 需要 preview_pdf_output_path 或 preview_png_output_path", nil)
+	}
+	if certCN == "" {
+		certCN = "Preview Signer"
+	}
+
+	tmpl, err := pdfsign.LoadAppearanceTemplateFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名外觀樣板錯誤", err)
+	}
+	if tmpl == nil {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "appearance_template")
+	}
+	values, err := pdfsign.LoadAppearanceValuesFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名外觀設定錯誤", err)
+	}
+	placement, err := pdfsign.LoadPlacementFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名位置設定錯誤", err)
+	}
+	values.CertCN = certCN
+	values.SigningTime = time.Now()
+	// --- 設定載入結束 ---
+
+	data, err := os.ReadFile(pdfInputPath)
+	if err != nil {
+		pdfsign.Fatal(log, "讀取 PDF 失敗", err)
+	}
+
+	// 建立欄位 (若有 appearance_placement) 並繪入外觀；欄位維持未簽署
+	preview, err := pdfsign.PreviewAppearance(data, fieldName, placement, tmpl, values)
+	if err != nil {
+		pdfsign.Fatal(log, "套用簽名外觀失敗", err)
+	}
+	if pdfOutputPath != "" {
+		if err := os.WriteFile(pdfOutputPath, preview, 0644); err != nil {
+			pdfsign.Fatal(log, "寫入 PDF 失敗", err)
+		}
+		log.Info("預覽 PDF 已儲存", "output", pdfOutputPath)
+	}
+
+	if pngOutputPath != "" {
+		pages, err := pdfsign.RenderPreview(preview, fieldName, tmpl, values, dpi)
+		if err != nil {
+			pdfsign.Fatal(log, "繪製預覽圖失敗", err)
+		}
+		for _, page := range pages {
+			path := pngOutputPath
+			// 欄位出現在多頁時 (page: every) 每頁一個檔案: preview-p3.png
+			if len(pages) > 1 {
+				ext := filepath.Ext(path)
+				path = fmt.Sprintf("%s-p%d%s", strings.TrimSuffix(path, ext), page.Page, ext)
+			}
+			if err := os.WriteFile(path, page.PNG, 0644); err != nil {
+				pdfsign.Fatal(log, "寫入 PNG 失敗", err)
+			}
+			log.Info("預覽圖已儲存", "page", page.Page, "output", path)
+		}
+	}
+
+	log.Info("樣板預覽完成 (未使用任何金鑰)", "template", tmpl.Name)
+}
@@ -2,8 +2,7 @@ package main
 
 import (
 	"chilkat"
-	"fmt"
-	"log" // 使用 log 進行嚴重錯誤記錄
+	"chilkattest/pdfsign"
 
 	"github.com/spf13/viper" // 匯入 viper
 )
@@ -23,9 +22,17 @@ func main() {
 
 	err := viper.ReadInConfig() // 尋找並讀取設定檔
 	if err != nil {             // 處理讀取設定檔時的錯誤
-		log.Fatalf("讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確): %s\n", err)
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
 	}
 
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("sign-unsigned-field")
+
 	// 存取設定值
 	// 注意：此處的 pdf_input_path 應指向一個 *包含* 未簽署簽名欄位的 PDF
 	pdfInputPath := viper.GetString("pdf_input_path_unsigned")
@@ -38,28 +45,28 @@ func main() {
 
 	// 基本驗證
 	if pdfInputPath == "" || pfxPath == "" || pfxPassword == "" || pdfOutputPath == "" {
-		log.Fatalf("設定檔 config.json 缺少必要欄位 (pdf_input_path_unsigned, pfx_path, pfx_password, unsigned_pdf_output_path)\n")
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "pdf_input_path_unsigned, pfx_path, pfx_password, unsigned_pdf_output_path")
 	}
 	// --- 設定載入結束 ---
 
 	// Chilkat Global Unlock
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true)                            // 啟用詳細日誌 (可選)
-	fmt.Println("Chilkat Library Version:", glob.Version()) // 印出版本
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
+	log.Info("Chilkat 程式庫版本", "version", glob.Version())
 
 	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
 	if !success {
-		log.Fatalf("Chilkat 解鎖失敗: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 
 	pdf := chilkat.NewPdf()
-	defer pdf.DisposePdf()      // 使用 defer 確保釋放
-	pdf.SetVerboseLogging(true) // 啟用 PDF 物件的詳細日誌 (可選)
+	defer pdf.DisposePdf()                             // 使用 defer 確保釋放
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
 
 	// 載入包含未簽署簽名欄位的 PDF
 	success = pdf.LoadFile(pdfInputPath)
 	if !success {
-		fmt.Println("載入 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PDF 失敗", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()))
 		return
 	}
 
@@ -80,9 +87,9 @@ func main() {
 	// 可以透過名稱指定 (從 config.json 讀取)
 	if unsignedFieldName != "" {
 		jsonOptions.UpdateString("unsignedSignatureField", unsignedFieldName)
-		fmt.Printf("指定簽署欄位: %s\n", unsignedFieldName)
+		log.Info("指定簽署欄位", "field", unsignedFieldName)
 	} else {
-		fmt.Println("未指定欄位名稱，將嘗試簽署第一個找到的未簽署欄位。")
+		log.Info("未指定欄位名稱，將嘗試簽署第一個找到的未簽署欄位。")
 	}
 
 	// --- 外觀設定 (將自動縮放以符合欄位大小) ---
@@ -100,23 +107,23 @@ func main() {
 	defer cert.DisposeCert() // 使用 defer 確保釋放
 	success = cert.LoadPfxFile(pfxPath, pfxPassword)
 	if !success {
-		fmt.Println("載入 PFX 憑證失敗:", cert.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PFX 憑證失敗", pdfsign.NewChilkatError("loading the PFX", cert.LastErrorText()))
 		return
 	}
 
 	// 告知 pdf 物件使用此憑證進行簽署
 	success = pdf.SetSigningCert(cert)
 	if !success {
-		fmt.Println("設定簽名憑證失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "設定簽名憑證失敗", pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText()))
 		return
 	}
 
 	// 簽署 PDF，填充未簽署的簽名欄位
 	success = pdf.SignPdf(jsonOptions, pdfOutputPath)
 	if !success {
-		fmt.Println("簽署 PDF (填充欄位) 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "簽署 PDF (填充欄位) 失敗", pdfsign.NewChilkatError("SignPdf", pdf.LastErrorText()))
 		return
 	}
 
-	fmt.Printf("PDF 已成功簽署 (填充欄位) 並儲存至 %s\n", pdfOutputPath)
+	log.Info("PDF 已成功簽署 (填充欄位)", "output", pdfOutputPath)
 }
@@ -2,8 +2,9 @@ package main
 
 import (
 	"chilkat"
-	"fmt"
-	"log" // 使用 log 進行嚴重錯誤記錄
+	"chilkattest/pdfsign"
+	"os"
+	"time"
 
 	"github.com/spf13/viper" // 匯入 viper
 )
@@ -14,6 +15,13 @@ import (
 */
 import "C"
 
+// 以外觀樣板 (templates/*.yaml|json) 簽署: 版面、文字行、圖片、透明度與框線都由樣板決定，
+// 法務可直接修改樣板而不需改程式。樣板在簽署前先完整驗證，再繪入未簽署欄位的外觀，
+// 之後 Chilkat 只負責填入該欄位的簽章。
+// 圖片可為 JPG、PNG (保留透明背景) 或 SVG (轉為向量路徑)，依欄位大小等比例縮放，
+// 超過樣板 image.dpi 的點陣圖會先縮小。appearance_values.signature_image 可指定簽署人
+// 自己的手寫簽名圖片，取代樣板的預設圖片。
+
 func main() {
 
 	// --- 使用 Viper 載入設定 ---
@@ -24,111 +32,135 @@ func main() {
 
 	err := viper.ReadInConfig() // 尋找並讀取設定檔
 	if err != nil {             // 處理讀取設定檔時的錯誤
-		log.Fatalf("讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確): %s \n", err)
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
 	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("sign-with-image")
 
 	// 存取設定值
 	pdfInputPath := viper.GetString("pdf_input_path")
 	pfxPath := viper.GetString("pfx_path")
 	pfxPassword := viper.GetString("pfx_password")
 	pdfOutputPath := viper.GetString("image_pdf_output_path")
-	jpgImagePath := viper.GetString("jpg_image_path") // 新增：讀取 JPG 圖片路徑
+	fieldName := viper.GetString("appearance_field") // 要填入的簽名欄位名稱
+
+	// 基本驗證
+	if pdfInputPath == "" || pfxPath == "" || pfxPassword == "" || pdfOutputPath == "" || fieldName == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "pdf_input_path, pfx_path, pfx_password, image_pdf_output_path, appearance_field")
+	}
 
-	// 基本驗證 (包含新的 jpg_image_path)
-	if pdfInputPath == "" || pfxPath == "" || pfxPassword == "" || pdfOutputPath == "" || jpgImagePath == "" {
-		log.Fatalf("設定檔 config.json 缺少必要欄位 (pdf_input_path, pfx_path, pfx_password, pdf_output_path, jpg_image_path)\n")
+	// 外觀樣板在載入時即驗證 (版面、顏色、圖片路徑、佔位符名稱)
+	tmpl, err := pdfsign.LoadAppearanceTemplateFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名外觀樣板錯誤", err)
+	}
+	if tmpl == nil {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "appearance_template")
+	}
+	values, err := pdfsign.LoadAppearanceValuesFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名外觀設定錯誤", err)
+	}
+	// appearance_placement: 欄位不存在時的位置 (rect / position / anchor，page 可為頁碼、last 或 every)；
+	// 省略表示 appearance_field 已存在於 PDF 中
+	placement, err := pdfsign.LoadPlacementFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽名位置設定錯誤", err)
 	}
 	// --- 設定載入結束 ---
 
 	// Chilkat Global Unlock
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true) // <--- 在 Global 物件上啟用詳細日誌
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
 	// ---> 加入這行來印出版本 <---
-	fmt.Println("Chilkat Library Version:", glob.Version())
+	log.Info("Chilkat 程式庫版本", "version", glob.Version())
 
 	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
 	if !success {
-		log.Fatalf("Chilkat 解鎖失敗: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 
-	pdf := chilkat.NewPdf()
-	defer pdf.DisposePdf()      // 使用 defer 確保釋放
-	pdf.SetVerboseLogging(true) // <--- 啟用詳細日誌紀錄
-
-	// 載入要簽署的 PDF (使用設定檔中的路徑)
-	success = pdf.LoadFile(pdfInputPath)
+	// 載入簽名憑證 (使用設定檔中的路徑和密碼)
+	cert := chilkat.NewCert()
+	defer cert.DisposeCert() // 使用 defer 確保釋放
+	success = cert.LoadPfxFile(pfxPath, pfxPassword)
 	if !success {
-		fmt.Println("載入 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PFX 憑證失敗", pdfsign.NewChilkatError("loading the PFX", cert.LastErrorText()))
 		return
 	}
 
-	// 簽署選項以 JSON 格式指定
-	jsonOptions := chilkat.NewJsonObject()
-	defer jsonOptions.DisposeJsonObject() // 使用 defer 確保釋放
-
-	// 基本簽署選項
-	jsonOptions.UpdateInt("signingCertificateV2", 1)
-	jsonOptions.UpdateInt("signingTime", 1)
-	jsonOptions.UpdateInt("page", 1)
-	jsonOptions.UpdateString("appearance.y", "top")
-	jsonOptions.UpdateString("appearance.x", "right")
-	jsonOptions.UpdateString("appearance.fontScale", "10.0")
-
-	// 簽名外觀文字行
-	jsonOptions.UpdateString("appearance.text[0]", "Digitally signed by: cert_cn")
-	jsonOptions.UpdateString("appearance.text[1]", "Date: current_dt")
-	jsonOptions.UpdateString("appearance.text[2]", "The crazy brown fox jumps over the lazy dog.")
-
-	// --- 載入並設定簽名圖片 ---
-	jpgData := chilkat.NewBinData()
-	defer jpgData.DisposeBinData() // 使用 defer 確保釋放
-
-	// 從設定檔讀取的路徑載入 JPG 圖片
-	success = jpgData.LoadFile(jpgImagePath)
-	if !success {
-		fmt.Printf("載入 JPG 圖片失敗 (%s): %s\n", jpgImagePath, glob.LastErrorText()) // 使用 glob 物件取得錯誤訊息
+	data, err := os.ReadFile(pdfInputPath)
+	if err != nil {
+		pdfsign.LogFailure(log, "讀取 PDF 失敗", err)
 		return
 	}
 
-	// 將圖片資料設定給 PDF 物件
-	success = pdf.SetSignatureJpeg(jpgData)
-	fmt.Println("設定簽名圖片成功:", pdf.LastErrorText())
-	if !success {
-		fmt.Println("設定簽名圖片失敗:", pdf.LastErrorText())
+	// 欄位不存在時先依位置設定建立空白簽名欄位 (會檢查 MediaBox/CropBox 與頁面旋轉)
+	if placement != nil {
+		data, err = pdfsign.PlaceSignatureField(data, fieldName, *placement, tmpl.Width, tmpl.Height)
+		if err != nil {
+			pdfsign.LogFailure(log, "建立簽名欄位失敗", err)
+			return
+		}
+	}
+
+	// 依樣板繪製簽名外觀 (cert_cn 取自憑證，current_dt 為目前時間)
+	values.CertCN = cert.SubjectCN()
+	values.SigningTime = time.Now()
+	data, err = pdfsign.PrepareAppearance(data, fieldName, tmpl, values)
+	if err != nil {
+		pdfsign.LogFailure(log, "套用簽名外觀樣板失敗", err)
 		return
 	}
 
-	// 在 JSON 選項中指定圖片顯示方式
-	jsonOptions.UpdateString("appearance.image", "signature") //custom-jpg//result-pass//signature//document-check//green-check-grey-circle
-	// jsonOptions.UpdateString("appearance.width", "100")  // Width in points (adjust as needed)
-	// jsonOptions.UpdateString("appearance.height", "200") // Height in points (adjust as needed)
-	fmt.Println("設定簽名圖片成功2:", pdf.LastErrorText())
-	jsonOptions.UpdateString("appearance.imagePlacement", "center") // 圖片置中
-	jsonOptions.UpdateString("appearance.imageOpacity", "50")       // 設定 30% 透明度//0 表示完全透明，1 表示完全不透明//0.3 表示 30% 不透明度，即 70% 透明度
-	// --- 圖片設定結束 ---
+	pdf := chilkat.NewPdf()
+	defer pdf.DisposePdf()                             // 使用 defer 確保釋放
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
 
-	// 載入簽名憑證 (使用設定檔中的路徑和密碼)
-	cert := chilkat.NewCert()
-	defer cert.DisposeCert() // 使用 defer 確保釋放
-	success = cert.LoadPfxFile(pfxPath, pfxPassword)
+	inBd := chilkat.NewBinData()
+	defer inBd.DisposeBinData()
+	inBd.AppendBinary(data)
+	success = pdf.LoadBd(inBd)
 	if !success {
-		fmt.Println("載入 PFX 憑證失敗:", cert.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PDF 失敗", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()))
 		return
 	}
 
+	// 簽署選項以 JSON 格式指定
+	jsonOptions := chilkat.NewJsonObject()
+	defer jsonOptions.DisposeJsonObject() // 使用 defer 確保釋放
+
+	// 基本簽署選項；外觀已由樣板繪製，不再設定 appearance.text
+	jsonOptions.UpdateInt("signingCertificateV2", 1)
+	jsonOptions.UpdateInt("signingTime", 1)
+	jsonOptions.UpdateBool("appearance.fillUnsignedSignatureField", true)
+	jsonOptions.UpdateString("unsignedSignatureField", fieldName)
+
 	// 告知 pdf 物件使用此憑證進行簽署
 	success = pdf.SetSigningCert(cert)
 	if !success {
-		fmt.Println("設定簽名憑證失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "設定簽名憑證失敗", pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText()))
 		return
 	}
 
 	// 簽署 PDF (使用設定檔中的輸出路徑)
-	success = pdf.SignPdf(jsonOptions, pdfOutputPath)
+	outBd := chilkat.NewBinData()
+	defer outBd.DisposeBinData()
+	success = pdf.SignPdfBd(jsonOptions, outBd)
 	if !success {
-		fmt.Println("簽署 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "簽署 PDF 失敗", pdfsign.NewChilkatError("SignPdf", pdf.LastErrorText()))
+		return
+	}
+	if err := os.WriteFile(pdfOutputPath, outBd.GetBinary(), 0644); err != nil {
+		pdfsign.LogFailure(log, "寫入 PDF 失敗", err)
 		return
 	}
 
-	fmt.Printf("PDF 已成功簽署 (包含圖片) 並儲存至 %s\n", pdfOutputPath)
+	log.Info("PDF 已依樣板成功簽署", "template", tmpl.Name, "output", pdfOutputPath)
 }
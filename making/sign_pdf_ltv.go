@@ -2,8 +2,7 @@ package main
 
 import (
 	"chilkat"
-	"fmt"
-	"log" // 使用 log 進行嚴重錯誤記錄
+	"chilkattest/pdfsign"
 
 	"github.com/spf13/viper" // 匯入 viper
 )
@@ -23,9 +22,17 @@ func main() {
 
 	err := viper.ReadInConfig() // 尋找並讀取設定檔
 	if err != nil {             // 處理讀取設定檔時的錯誤
-		log.Fatalf("讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確): %s \n", err)
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
 	}
 
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("sign-ltv")
+
 	// 存取設定值 (LTV簽名需要這些)
 	pdfInputPath := viper.GetString("pdf_input_path")
 	pfxPath := viper.GetString("pfx_path")
@@ -36,18 +43,18 @@ func main() {
 
 	// 基本驗證
 	if pdfInputPath == "" || pfxPath == "" || pfxPassword == "" || pdfOutputPath == "" {
-		log.Fatalf("設定檔 config.json 缺少必要欄位 (pdf_input_path, pfx_path, pfx_password, pdf_output_path)\n")
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "pdf_input_path, pfx_path, pfx_password, pdf_output_path")
 	}
 	// --- 設定載入結束 ---
 
 	// --- Chilkat Global Unlock ---
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true)                            // 啟用詳細日誌
-	fmt.Println("Chilkat Library Version:", glob.Version()) // 印出版本
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
+	log.Info("Chilkat 程式庫版本", "version", glob.Version())
 
 	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
 	if !success {
-		log.Fatalf("Chilkat 解鎖失敗: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 	// --- Global Unlock 結束 ---
 
@@ -55,13 +62,13 @@ func main() {
 	// See Global Unlock Sample for sample code.
 
 	pdf := chilkat.NewPdf()
-	defer pdf.DisposePdf()      // 使用 defer 確保釋放
-	pdf.SetVerboseLogging(true) // 啟用 PDF 物件的詳細日誌
+	defer pdf.DisposePdf()                             // 使用 defer 確保釋放
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
 
 	// Load a PDF to be signed. (使用設定檔中的路徑)
 	success = pdf.LoadFile(pdfInputPath)
 	if !success {
-		fmt.Println("載入 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PDF 失敗", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()))
 		// pdf.DisposePdf() // defer 會處理
 		return
 	}
@@ -117,7 +124,7 @@ func main() {
 
 	success = cert.LoadPfxFile(pfxPath, pfxPassword)
 	if !success {
-		fmt.Println("載入 PFX 憑證失敗:", cert.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PFX 憑證失敗", pdfsign.NewChilkatError("loading the PFX", cert.LastErrorText()))
 		// 清理已建立的物件 (defer 會處理)
 		// pdf.DisposePdf()
 		// json.DisposeJsonObject()
@@ -128,7 +135,7 @@ func main() {
 	// Tell the pdf object to use the certificate for signing.
 	success = pdf.SetSigningCert(cert)
 	if !success {
-		fmt.Println("設定簽名憑證失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "設定簽名憑證失敗", pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText()))
 		// 清理已建立的物件 (defer 會處理)
 		// pdf.DisposePdf()
 		// json.DisposeJsonObject()
@@ -139,7 +146,7 @@ func main() {
 	// Sign the PDF (使用設定檔中的輸出路徑)
 	success = pdf.SignPdf(json, pdfOutputPath)
 	if !success {
-		fmt.Println("簽署 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "簽署 PDF 失敗", pdfsign.NewChilkatError("SignPdf", pdf.LastErrorText()))
 		// 清理已建立的物件 (defer 會處理)
 		// pdf.DisposePdf()
 		// json.DisposeJsonObject()
@@ -147,8 +154,7 @@ func main() {
 		return
 	}
 
-	fmt.Println("The PDF has been successfully cryptographically signed with long-term validation.")
-	fmt.Printf("Signed PDF saved to: %s\n", pdfOutputPath)
+	log.Info("The PDF has been successfully cryptographically signed with long-term validation", "output", pdfOutputPath)
 
 	// If you open the Signature Panel in Adobe Acrobat, it will indicate that the signature is LTV enabled
 	// as shown here:
@@ -2,8 +2,8 @@ package main
 
 import (
 	"chilkat"
-	"fmt"
-	"log" // 使用 log 進行嚴重錯誤記錄
+	"chilkattest/pdfsign"
+	"os"
 
 	"github.com/spf13/viper" // 匯入 viper
 )
@@ -22,9 +22,17 @@ func main() {
 
 	err := viper.ReadInConfig() // 尋找並讀取設定檔
 	if err != nil {             // 處理讀取設定檔時的錯誤
-		log.Fatalf("讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確): %s \n", err)
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
 	}
 
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("sign-pades-b")
+
 	// 存取設定值
 	pdfInputPath := viper.GetString("pdf_input_path")
 	pfxPath := viper.GetString("pfx_path")
@@ -35,29 +43,41 @@ func main() {
 
 	// 基本驗證
 	if pdfInputPath == "" || pfxPath == "" || pfxPassword == "" || pdfOutputPath == "" {
-		log.Fatalf("設定檔 config.json 缺少必要欄位 (pdf_input_path, pfx_path, pfx_password, pdf_output_path)\n")
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "pdf_input_path, pfx_path, pfx_password, pdf_output_path")
+	}
+
+	// signature_algorithm: 雜湊 (sha256/sha384/sha512) 與簽章機制 (pkcs1v15/pss/ecdsa/ed25519)
+	alg, err := pdfsign.LoadAlgorithmFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章演算法設定錯誤", err)
+	}
+
+	// signature_policy: 可選，設定 oid 後產生 PAdES-EPES (簽章政策識別碼、政策文件雜湊與 SPURI)
+	policy, err := pdfsign.LoadSignaturePolicyFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章政策設定錯誤", err)
 	}
 	// --- 設定載入結束 ---
 
 	// --- Chilkat Global Unlock ---
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true)                            // 啟用詳細日誌
-	fmt.Println("Chilkat Library Version:", glob.Version()) // 印出版本
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
+	log.Info("Chilkat 程式庫版本", "version", glob.Version())
 
 	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
 	if !success {
-		log.Fatalf("Chilkat 解鎖失敗: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 	// --- Global Unlock 結束 ---
 
 	pdf := chilkat.NewPdf()
-	defer pdf.DisposePdf()      // 使用 defer 確保釋放
-	pdf.SetVerboseLogging(true) // 啟用 PDF 物件的詳細日誌
+	defer pdf.DisposePdf()                             // 使用 defer 確保釋放
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
 
 	// Load a PDF to be signed (使用設定檔中的路徑)
 	success = pdf.LoadFile(pdfInputPath)
 	if !success {
-		fmt.Println("載入 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PDF 失敗", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()))
 		return
 	}
 
@@ -70,15 +90,18 @@ func main() {
 	// 這些屬性決定了產生的簽章類型，符合 PAdES B-Level 基本要求
 	// - subFilter 指定使用 ETSI CADES 標準的 detached 簽章格式
 	// - signingCertificateV2 和 signingTime 是常見的基礎屬性
-	// - hashAlgorithm 指定雜湊演算法
+	// - hashAlgorithm / signingAlgorithm 依 signature_algorithm 設定，載入憑證後才套用
 	// 註: 要產生更高層級的 PAdES (如 LTV)，需要加入 ltvOcsp 或 timestampToken 等屬性
 	// ---------------------------------------------------------------------
 	json.UpdateString("subFilter", "/ETSI.CAdES.detached")
 	json.UpdateBool("signingCertificateV2", true)
-	json.UpdateString("signingAlgorithm", "pkcs")
-	json.UpdateString("hashAlgorithm", "sha256")
 	// json.UpdateInt("signingTime", 1) // 加入簽署時間
 
+	// PAdES-EPES: 加入 signature-policy-identifier 簽署屬性
+	if err := pdfsign.ApplySignaturePolicy(json, policy); err != nil {
+		pdfsign.Fatal(log, "簽章政策設定錯誤", err)
+	}
+
 	// -----------------------------------------------------------
 	// 設定簽章外觀
 	json.UpdateInt("page", 1)
@@ -95,26 +118,49 @@ func main() {
 	defer cert.DisposeCert() // 使用 defer 確保釋放
 	success = cert.LoadPfxFile(pfxPath, pfxPassword)
 	if !success {
-		fmt.Println("載入 PFX 憑證失敗:", cert.LastErrorText())
+		pdfsign.LogFailure(log, "載入 PFX 憑證失敗", pdfsign.NewChilkatError("loading the PFX", cert.LastErrorText()))
+		return
+	}
+
+	// 依憑證金鑰類型 (RSA / EC / Ed25519) 檢查並套用簽章演算法
+	alg, err = pdfsign.CheckAlgorithm(alg, cert)
+	if err != nil {
+		pdfsign.LogFailure(log, "簽章演算法不適用於此憑證", err)
 		return
 	}
+	pdfsign.ApplyAlgorithm(json, alg)
+	log.Info("簽章演算法", "algorithm", alg.String())
 
 	// 告知 pdf 物件使用此憑證進行簽署
 	success = pdf.SetSigningCert(cert)
 	if !success {
-		fmt.Println("設定簽名憑證失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "設定簽名憑證失敗", pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText()))
 		return
 	}
 
 	// 簽署 PDF，建立輸出檔案 (使用設定檔中的輸出路徑)
 	success = pdf.SignPdf(json, pdfOutputPath)
 	if !success {
-		fmt.Println("簽署 PDF 失敗:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "簽署 PDF 失敗", pdfsign.NewChilkatError("SignPdf", pdf.LastErrorText()))
+		return
+	}
+
+	if policy != nil {
+		// 確認簽章中確實帶有設定的簽章政策
+		signed, err := os.ReadFile(pdfOutputPath)
+		if err != nil {
+			pdfsign.LogFailure(log, "讀取已簽署 PDF 失敗", err)
+			return
+		}
+		if err := pdfsign.CheckSignaturePolicy(signed, "", policy); err != nil {
+			pdfsign.LogFailure(log, "簽章政策檢查失敗", err)
+			return
+		}
+		log.Info("PDF 已成功簽署 (PAdES-EPES)", "policy", policy.OID, "output", pdfOutputPath)
 		return
 	}
 
-	fmt.Println("PDF 已成功簽署 (PAdES B-Level).")
-	fmt.Printf("Signed PDF saved to: %s\n", pdfOutputPath)
+	log.Info("PDF 已成功簽署 (PAdES B-Level)", "output", pdfOutputPath)
 
 	// pdf.DisposePdf() // defer 會處理
 	// json.DisposeJsonObject() // defer 會處理
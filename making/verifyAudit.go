@@ -0,0 +1,69 @@
+package main
+
+import (
+	"chilkattest/pdfsign"
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper" // 匯入 viper
+)
+
+/*
+#cgo CFLAGS: -I C:/Users/admin/chilkatsoft.com/chilkat-9.5.0-x64/include
+#cgo LDFLAGS: -LC:/Users/admin/chilkatsoft.com/native_c_lib -lchilkatExt -lstdc++ -lws2_32
+*/
+import "C"
+
+// verify-audit: 檢查簽署稽核日誌 (config.json 的 audit.path，或第一個命令列參數)。
+// 重新計算每筆紀錄的雜湊與前後鏈結，找出被修改、刪除、插入或重排的紀錄與序號缺口，
+// 並驗證每個封存紀錄的 RFC 3161 時戳；時戳簽署者須鏈結至 trust_tsa_anchors，
+// 未設定 TSA 錨點時封存視為未錨定 (不算通過)。
+// 有任何問題時以結束碼 1 結束。
+
+func main() {
+	// --- 使用 Viper 載入設定 ---
+	viper.SetConfigName("config") // 設定檔名稱 (不含副檔名)
+	viper.SetConfigType("json")   // 如果設定檔名不含副檔名，則必須指定類型
+	viper.AddConfigPath("C:/chilkatPackage/chilkattest")
+
+	err := viper.ReadInConfig() // 尋找並讀取設定檔
+	if err != nil {             // 處理讀取設定檔時的錯誤
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
+	}
+
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("verify-audit")
+
+	// 存取設定值
+	auditPath := viper.GetString("audit.path")
+	if len(os.Args) > 1 {
+		auditPath = os.Args[1]
+	}
+	if auditPath == "" {
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位 (audit.path)，或請以參數指定稽核日誌", nil, "keys", "audit.path")
+	}
+
+	// 信任錨點 (可選): 用於驗證封存時戳的 TSA 憑證鏈
+	trust, err := pdfsign.LoadTrustStoreFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "載入信任錨點失敗", err)
+	}
+	// --- 設定載入結束 ---
+
+	log.Info("檢查稽核日誌", "audit_log", auditPath)
+	report, err := pdfsign.VerifyAuditLog(auditPath, trust)
+	if err != nil {
+		pdfsign.Fatal(log, "讀取稽核日誌失敗", err)
+	}
+	// 檢查報告印在 stdout；日誌寫到 stderr 或日誌檔
+	fmt.Print(pdfsign.FormatAuditReport(report))
+	if !report.OK() {
+		closeLog()
+		os.Exit(1)
+	}
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/pdfsign"
 	"fmt"
-	"log" // 使用 log 進行嚴重錯誤記錄
+	"os"
 
 	"github.com/spf13/viper" // 匯入 viper
 )
@@ -22,93 +23,234 @@ func main() {
 
 	err := viper.ReadInConfig() // 尋找並讀取設定檔
 	if err != nil {             // 處理讀取設定檔時的錯誤
-		log.Fatalf("讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確): %s \n", err)
+		pdfsign.Fatal(nil, "讀取設定檔時發生嚴重錯誤 (請確認 C:/chilkatPackage/chilkattest/config.json 存在且格式正確)", err)
 	}
 
+	// 日誌設定 (config.json 的 logging 鍵): 層級與 JSON/文字格式，PIN 與密碼一律遮蔽
+	closeLog, err := pdfsign.SetupLogging(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(nil, "日誌設定錯誤", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("verify")
+
 	// 存取設定值
 	// *** 重要: 請確保此路徑指向您想要驗證的 PDF 檔案 ***
 	pdfToVerifyPath := viper.GetString("signed_hsm_pdf_output_path") // 讀取要驗證的 PDF 路徑 (請根據需要修改此鍵名)
 
 	// 基本驗證
 	if pdfToVerifyPath == "" {
-		log.Fatalf("設定檔 config.json 缺少必要欄位 (例如 pdf_output_path 或您指定的鍵名)\n")
+		pdfsign.Fatal(log, "設定檔 config.json 缺少必要欄位", nil, "keys", "signed_hsm_pdf_output_path")
 	}
 	// --- 設定載入結束 ---
 
 	// --- Chilkat Global Unlock ---
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true)                            // 啟用詳細日誌
-	fmt.Println("Chilkat Library Version:", glob.Version()) // 印出版本
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
+	log.Info("Chilkat 程式庫版本", "version", glob.Version())
 
 	success := glob.UnlockBundle("Anything for 30-day trial") // 請替換成您的有效解鎖碼
 	if !success {
-		log.Fatalf("Chilkat 解鎖失敗: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat 解鎖失敗", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 	// --- Global Unlock 結束 ---
 
 	pdf := chilkat.NewPdf()
-	defer pdf.DisposePdf()      // 使用 defer 確保釋放
-	pdf.SetVerboseLogging(true) // 啟用 PDF 物件的詳細日誌
+	defer pdf.DisposePdf()                             // 使用 defer 確保釋放
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // 僅在 debug 層級啟用詳細日誌
 
 	// Load the PDF file to be verified (使用設定檔中的路徑)
 	success = pdf.LoadFile(pdfToVerifyPath)
 	if !success {
-		fmt.Printf("載入待驗證的 PDF (%s) 失敗: %s\n", pdfToVerifyPath, pdf.LastErrorText())
+		pdfsign.LogFailure(log, "載入待驗證的 PDF 失敗", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()), "document", pdfToVerifyPath)
 		return
 	}
 
-	fmt.Printf("Verifying signatures in: %s\n", pdfToVerifyPath)
+	log.Info("驗證簽章", "document", pdfToVerifyPath)
 
 	// 取得 PDF 中的簽章數量
 	numSignatures := pdf.NumSignatures()
 	if numSignatures < 0 {
-		fmt.Println("無法取得簽章數量:", pdf.LastErrorText())
+		pdfsign.LogFailure(log, "無法取得簽章數量", pdfsign.NewChilkatError("counting signatures", pdf.LastErrorText()))
 		return
 	}
 
 	if numSignatures == 0 {
-		fmt.Println("指定的 PDF 文件中沒有任何簽章。")
+		log.Info("指定的 PDF 文件中沒有任何簽章。")
 		return
 	}
 
-	fmt.Printf("發現 %d 個簽章。\n", numSignatures)
+	log.Info("發現簽章", "signatures", numSignatures)
 
-	// 建立一個 JsonObject 來重複接收每次驗證的詳細資訊
-	// 每次呼叫 VerifySignature 都會覆寫其內容
-	sigInfo := chilkat.NewJsonObject()
-	defer sigInfo.DisposeJsonObject() // 確保 sigInfo 在 main 函數結束時被釋放
-	sigInfo.SetEmitCompact(false)     // 設定為輸出易讀的 JSON 格式
+	// 依設定檔載入信任錨點 (簽署憑證與 TSA 憑證分開)，未設定時只做密碼學驗證
+	trust, err := pdfsign.LoadTrustStoreFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "載入信任錨點失敗", err)
+	}
+	if trust != nil {
+		// 讓 Chilkat 自身的憑證鏈檢查也使用相同的簽署信任錨點 (TSA 憑證鏈由 pdfsign 以 TSA 錨點另行檢查)
+		tRoots, err := trust.ActivateChilkatRoots(pdfsign.SigningTrust)
+		if err != nil {
+			pdfsign.Fatal(log, "啟用信任錨點失敗", err)
+		}
+		defer tRoots.DisposeTrustedRoots()
+		defer tRoots.Deactivate()
+	} else {
+		log.Warn("未設定信任錨點 (trust_signing_anchors / trust_tsa_anchors)，將不檢查憑證鏈。")
+	}
 
-	// 迭代驗證每一個簽章
-	for i := 0; i < numSignatures; i++ {
-		fmt.Printf("--- 驗證簽章索引 %d ---\n", i)
+	// 離線模式: 只用 PDF 內嵌的 DSS / CMS 憑證、OCSP 與 CRL 驗證撤銷狀態，不連網
+	opts := pdfsign.VerifyOptions{Trust: trust, Offline: viper.GetBool("verify_offline")}
+	// signature_policy: 有設定時，以本機的政策文件 (或公布的雜湊) 核對簽章中的政策雜湊
+	policy, err := pdfsign.LoadSignaturePolicyFromConfig(viper.GetViper())
+	if err != nil {
+		pdfsign.Fatal(log, "簽章政策設定錯誤", err)
+	}
+	if policy != nil {
+		opts.Policies = append(opts.Policies, policy)
+	}
+	if opts.Offline {
+		log.Info("離線驗證模式: 僅使用文件內嵌的撤銷資訊。")
+	}
+	// 以 VerifyDocument 驗證，才能讀到 DSS 與簽章字典 (欄位名稱、原因、地點等)
+	data, err := os.ReadFile(pdfToVerifyPath)
+	if err != nil {
+		pdfsign.Fatal(log, "讀取 PDF 失敗", err)
+	}
+	reports, err := pdfsign.VerifyDocument(data, opts)
+	if err != nil {
+		pdfsign.LogFailure(log, "驗證簽章失敗", err)
+		return
+	}
 
-		// 驗證指定索引的簽章，並將詳細結果存入 sigInfo
-		verified := pdf.VerifySignature(i, sigInfo)
+	// 迭代輸出每一個簽章的驗證結果: 報告印在 stdout，Chilkat 的日誌與 sigInfo 寫入日誌
+	for _, r := range reports {
+		fmt.Printf("--- 驗證簽章索引 %d ---\n", r.Index)
 
-		if verified {
-			fmt.Printf("簽章 %d: 有效\n", i)
+		if r.Valid {
+			fmt.Printf("簽章 %d: 有效 (簽署者: %s)\n", r.Index, r.SignerCN)
 		} else {
-			fmt.Printf("簽章 %d: 無效\n", i)
+			fmt.Printf("簽章 %d: 無效\n", r.Index)
 			// 無效時，LastErrorText 通常包含主要原因
-			fmt.Printf("LastErrorText (簽章 %d):\n%s\n", i, pdf.LastErrorText())
+			log.Warn("簽章無效", "index", r.Index, "chilkat_log", r.Error)
+		}
+		if r.Field != "" {
+			fmt.Printf("簽名欄位: %s\n", r.Field)
+		}
+		printSignatureInfo(r.Info)
+		printPolicy(r.Policy)
+		switch {
+		case r.DocumentTimestamp:
+			fmt.Println("類型: 文件時戳 (DocTimeStamp)")
+		case r.Level != "":
+			fmt.Printf("PAdES 等級: %s\n", r.Level)
+		}
+		printModifications(r.Modifications)
+		if r.Timestamped && r.SigningTime != nil {
+			fmt.Printf("時間戳記: %s (TSA: %s)\n", r.SigningTime.Format("2006-01-02 15:04:05 MST"), r.TsaCN)
+		}
+		printChainResult("簽署憑證鏈", r.SignerTrust)
+		printChainResult("TSA 憑證鏈", r.TsaTrust)
+		printRevocation("簽署憑證撤銷狀態", r.SignerRevocation)
+		printRevocation("TSA 憑證撤銷狀態", r.TsaRevocation)
+		if len(r.Unprovable) > 0 {
+			fmt.Printf("警告: 下列憑證無法離線證明未被撤銷: %v\n", r.Unprovable)
 		}
 
-		// 輸出 sigInfo 的 JSON 內容，包含詳細的驗證資訊
-		// 使用 * 取消對 Emit() 返回的字串指標的引用
-		fmt.Printf("驗證詳情 JSON (簽章 %d):\n%s\n", i, *sigInfo.Emit())
-
-		// (可選) 獲取簽署者資訊
-		// signerCert := pdf.GetSignerCert(i)
-		// if signerCert != nil {
-		// 	fmt.Printf("簽署者 (簽章 %d): %s\n", i, signerCert.SubjectCN())
-		// 	signerCert.DisposeCert() // 釋放 GetSignerCert 返回的 Cert 物件
-		// }
+		// sigInfo 的 JSON 內容包含詳細的驗證資訊，僅在 debug 層級記錄
+		log.Debug("驗證詳情", "index", r.Index, "sig_info", r.Details)
 
 		fmt.Println("---")
 	}
 
-	fmt.Println("簽章驗證完成。")
+	log.Info("簽章驗證完成。")
 
 	// pdf.DisposePdf() // defer 會處理
 }
+
+// 輸出憑證鏈驗證結果
+func printChainResult(label string, res *pdfsign.ChainResult) {
+	if res == nil {
+		return
+	}
+	if res.Trusted {
+		fmt.Printf("%s: 受信任 %v\n", label, res.Chain)
+	} else {
+		fmt.Printf("%s: 不受信任 (%s)\n", label, res.Error)
+	}
+}
+
+// 輸出簽章的原因、地點、聯絡資訊、簽署者名稱與承諾類型
+func printSignatureInfo(info *pdfsign.SignatureInfo) {
+	if info == nil {
+		return
+	}
+	for _, item := range []struct{ label, value string }{
+		{"原因", info.Reason},
+		{"地點", info.Location},
+		{"聯絡資訊", info.ContactInfo},
+		{"簽署者名稱", info.Name},
+		{"承諾類型", info.Commitment},
+	} {
+		if item.value != "" {
+			fmt.Printf("%s: %s\n", item.label, item.value)
+		}
+	}
+}
+
+// 輸出簽章政策 (PAdES-EPES) 的核對結果
+func printPolicy(res *pdfsign.PolicyResult) {
+	if res == nil {
+		return
+	}
+	switch {
+	case res.Verified:
+		fmt.Printf("簽章政策: %s (%s 雜湊與本機政策文件相符)\n", res.OID, res.HashAlgorithm)
+	case res.OID != "":
+		fmt.Printf("簽章政策: %s 無法確認 (%s)\n", res.OID, res.Error)
+	default:
+		fmt.Printf("簽章政策: %s\n", res.Error)
+	}
+	if res.URI != "" {
+		fmt.Printf("政策文件位置 (SPURI): %s\n", res.URI)
+	}
+}
+
+// 輸出簽署後附加的修訂內容: 後續簽章、DSS、表單欄位與其他 (可能改變顯示內容的) 變更
+func printModifications(m *pdfsign.ModificationReport) {
+	if m == nil {
+		return
+	}
+	if m.CoversDocument {
+		fmt.Printf("涵蓋範圍: 整份文件 (第 %d/%d 版)\n", m.Revision, m.Revisions)
+		return
+	}
+	fmt.Printf("涵蓋範圍: 第 %d/%d 版，之後另有 %d 個簽章或時戳\n", m.Revision, m.Revisions, m.LaterSignatures)
+	if m.DSSUpdates {
+		fmt.Println("  簽署後加入驗證資料 (DSS)")
+	}
+	if len(m.FormChanges) > 0 {
+		fmt.Printf("  簽署後變更的表單欄位: %v\n", m.FormChanges)
+	}
+	if len(m.OtherChanges) > 0 {
+		fmt.Printf("  警告: 簽署後的其他變更: %v\n", m.OtherChanges)
+	}
+}
+
+// 輸出每張憑證使用的撤銷資訊來源
+func printRevocation(label string, checks []pdfsign.RevocationCheck) {
+	if len(checks) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", label)
+	for _, c := range checks {
+		switch {
+		case c.Proven && c.Source != "":
+			fmt.Printf("  %s: %s (%s, 來源: %s)\n", c.Subject, c.Status, c.Method, c.Source)
+		case c.Proven:
+			fmt.Printf("  %s: %s (%s)\n", c.Subject, c.Status, c.Method)
+		default:
+			fmt.Printf("  %s: 無法證明 (%s)\n", c.Subject, c.Error)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// write returns the text of m alone.
+func write(m metric) string {
+	var buf bytes.Buffer
+	m.write(&buf)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests,\nby result.", "operation", "result")
+	c.Inc("pdf.sign", "ok")
+	c.Add(2, "pdf.sign", "ok")
+	c.Inc("xml.sign", `say "hi"\`)
+	want := `# HELP test_requests_total Requests,\nby result.
+# TYPE test_requests_total counter
+test_requests_total{operation="pdf.sign",result="ok"} 3
+test_requests_total{operation="xml.sign",result="say \"hi\"\\"} 1
+`
+	if got := write(c); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("a counter went down")
+		}
+	}()
+	c.Add(-1, "pdf.sign", "ok")
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_workers", "Workers.")
+	g.Set(4)
+	g.Inc()
+	g.Dec()
+	g.Dec()
+	want := "# HELP test_workers Workers.\n# TYPE test_workers gauge\ntest_workers 3\n"
+	if got := write(g); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1}, "operation")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "pdf.sign")
+	}
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{operation="pdf.sign",le="0.1"} 2
+test_duration_seconds_bucket{operation="pdf.sign",le="1"} 3
+test_duration_seconds_bucket{operation="pdf.sign",le="+Inf"} 4
+test_duration_seconds_sum{operation="pdf.sign"} 3.65
+test_duration_seconds_count{operation="pdf.sign"} 4
+`
+	if got := write(h); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewGaugeFunc("test_queue_jobs", "Queued jobs.", func() float64 { return 7 })
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "\ntest_queue_jobs 7\n") {
+		t.Fatalf("metrics:\n%s", w.Body.String())
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	c := NewCounter("test_labels_total", "Labels.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic for a missing label value")
+		}
+	}()
+	c.Inc("only one")
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/pdfsign"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"time"
@@ -25,9 +25,17 @@ func main() {
 	vip.AddConfigPath("C:/chilkatPackage/chilkattest")
 	err := vip.ReadInConfig()
 	if err != nil {
-		log.Fatalf("Fatal error reading config file: %v", err)
+		pdfsign.Fatal(nil, "reading config file failed", err)
 	}
-	fmt.Println("Configuration file loaded successfully.")
+
+	// Logging is configured by the "logging" key; PINs and passwords from the
+	// config are masked in every line.
+	closeLog, err := pdfsign.SetupLogging(vip)
+	if err != nil {
+		pdfsign.Fatal(nil, "setting up logging failed", err)
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("pfx-sign-loop")
 
 	// --- Get required paths and password from config (ONCE) ---
 	pfxFilePath := vip.GetString("pfx_file_path")
@@ -37,72 +45,69 @@ func main() {
 
 	// Validate essential config values (ONCE)
 	if pfxFilePath == "" {
-		log.Fatal("Error: 'pfx_file_path' not found or empty in config.json")
+		pdfsign.Fatal(log, "'pfx_file_path' not found or empty in config.json", nil)
 	}
 	if pfxPassword == "" {
-		fmt.Println("Warning: 'pfx_password' is empty.")
+		log.Warn("'pfx_password' is empty")
 	}
 	if inputPdfPath == "" {
-		log.Fatal("Error: 'pdf_input_path' not found or empty in config.json")
+		pdfsign.Fatal(log, "'pdf_input_path' not found or empty in config.json", nil)
 	}
 
 	// --- Create the output directory if it doesn't exist (ONCE) ---
 	err = os.MkdirAll(outputDirectory, 0755)
 	if err != nil {
-		log.Fatalf("Failed to create output directory '%s': %v", outputDirectory, err)
+		pdfsign.Fatal(log, "creating output directory failed", err, "path", outputDirectory)
 	}
-	fmt.Printf("Ensured output directory exists: %s\n", outputDirectory)
 
 	// --- Chilkat Initialization and Unlock (ONCE) ---
 	glob := chilkat.NewGlobal()
 	// Defer global cleanup AFTER loop
 	defer glob.DisposeGlobal()
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // Verbose only at debug level
 	success := glob.UnlockBundle("Anything for 30-day trial")
 	if !success {
-		log.Fatalf("Failed to unlock Chilkat: %s\n", glob.LastErrorText())
+		pdfsign.Fatal(log, "Chilkat initialization failed", pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText()))
 	}
 	status := glob.UnlockStatus()
-	if status != 2 {
-		fmt.Println("Chilkat unlocked in trial mode.")
-	} else {
-		fmt.Println("Chilkat unlocked using purchased unlock code.")
-	}
+	log.Info("Chilkat unlocked", "purchased", status == 2, "version", glob.Version())
 
 	// --- Load Certificate and Private Key from PFX (ONCE) ---
 	cert := chilkat.NewCert()
 	// Defer cert cleanup AFTER loop
 	defer cert.DisposeCert()
 	if _, err := os.Stat(pfxFilePath); os.IsNotExist(err) {
-		log.Fatalf("Error: PFX file not found at %s\n", pfxFilePath)
+		pdfsign.Fatal(log, "PFX file not found", nil, "path", pfxFilePath)
 	}
 	success = cert.LoadPfxFile(pfxFilePath, pfxPassword)
 	if !success {
-		log.Fatalf("Failed to load PFX file '%s': %s\n", pfxFilePath, cert.LastErrorText())
+		pdfsign.Fatal(log, "loading PFX file failed", pdfsign.NewChilkatError("loading the PFX", cert.LastErrorText()), "path", pfxFilePath)
 	}
 	if !cert.HasPrivateKey() {
-		log.Printf("Warning: Loaded certificate does not have a private key context.")
+		log.Warn("loaded certificate does not have a private key context")
 	}
-	fmt.Printf("Successfully loaded PFX. Certificate SubjectCN: %s\n", cert.SubjectCN())
+	log.Info("loaded PFX", "path", pfxFilePath, "subject_cn", cert.SubjectCN())
 
 	// --- Load Original PDF Document (ONCE) ---
 	pdf := chilkat.NewPdf()
 	// Defer PDF cleanup AFTER loop
 	defer pdf.DisposePdf()
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log))
 	if _, err := os.Stat(inputPdfPath); os.IsNotExist(err) {
-		log.Fatalf("Error: Input PDF file not found at %s\n", inputPdfPath)
+		pdfsign.Fatal(log, "input PDF file not found", nil, "path", inputPdfPath)
 	}
 	success = pdf.LoadFile(inputPdfPath)
 	if !success {
-		log.Fatalf("Failed to load input PDF '%s': %s\n", inputPdfPath, pdf.LastErrorText())
+		pdfsign.Fatal(log, "loading input PDF failed", pdfsign.NewChilkatError("loading the PDF", pdf.LastErrorText()), "path", inputPdfPath)
 	}
-	fmt.Printf("Loaded original unsigned PDF: %s\n", inputPdfPath)
+	log.Debug("loaded original unsigned PDF", "path", inputPdfPath)
 
 	// --- Set Signing Certificate (ONCE) ---
 	success = pdf.SetSigningCert(cert)
 	if !success {
-		log.Fatalf("Failed to set signing certificate on PDF object: %s\n", pdf.LastErrorText())
+		pdfsign.Fatal(log, "setting signing certificate failed", pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText()))
 	}
-	fmt.Println("Signing certificate set successfully on PDF object.")
+	log.Debug("signing certificate set on PDF object")
 
 	// --- Configure Signing JSON (ONCE) ---
 	json := chilkat.NewJsonObject()
@@ -134,31 +139,26 @@ func main() {
 
 	// --- Signing Loop ---
 	numberOfSignatures := 10
-	fmt.Printf("\n--- Starting Signing Loop (%d iterations) ---\n", numberOfSignatures)
+	log.Info("signing loop started", "iterations", numberOfSignatures)
 	for i := 1; i <= numberOfSignatures; i++ {
 		// --- Generate unique output filename for this iteration ---
 		outputFilename := fmt.Sprintf("signed_output_%d.pdf", i)
 		outputFilePath := filepath.Join(outputDirectory, outputFilename)
-
-		fmt.Printf("\n--- Iteration %d ---\n", i)
-		fmt.Printf("Attempting to sign and save to: %s\n", outputFilePath)
+		docLog, _ := pdfsign.DocumentLogger(log, outputFilePath)
+		docLog = docLog.With("iteration", i)
 
 		// --- Sign the PDF (using the single loaded pdf object) ---
-		// Clear LastErrorText before the operation for cleaner logs per iteration
-		pdf.LastErrorText()
 		success = pdf.SignPdf(json, outputFilePath)
 		lastErr := pdf.LastErrorText() // Capture error text immediately
 
 		if !success {
-			log.Printf("ERROR: Failed to sign PDF for iteration %d: %s\n", i, lastErr)
+			pdfsign.LogFailure(docLog, "signing failed", pdfsign.NewChilkatError("SignPdf", lastErr))
 			// Optional: break here if one failure should stop the test
 		} else {
-			fmt.Printf("Successfully signed PDF for iteration %d.\n", i)
-			if lastErr != "" {
-				// It succeeded but there might be warnings (like OCSP/CRL issues)
-				log.Printf("INFO: SignPdf succeeded for iteration %d, but LastErrorText contained warnings:\n%s\n", i, lastErr)
-				log.Println("      External verification needed to confirm B-LT level.")
-			}
+			docLog.Info("PDF signed", "output", outputFilePath)
+			// It succeeded but there might be warnings (like OCSP/CRL
+			// issues); external verification is needed to confirm B-LT
+			docLog.Debug("SignPdf log", "chilkat_log", lastErr)
 			// --- Optional: Add LTA Step Here ---
 			// if you want LTA for each signed file:
 			// errLta := addLtaTimestamp(outputFilePath, "path/to/output_lta_" + outputFilename)
@@ -170,12 +170,11 @@ func main() {
 		}
 
 		// --- Sleep between iterations ---
-		fmt.Printf("Sleeping for 3 seconds...\n")
 		time.Sleep(3 * time.Second)
 	}
 
-	fmt.Printf("\nFinished signing loop (%d iterations).\n", numberOfSignatures)
-	fmt.Println("Program finished successfully.")
+	log.Info("signing loop finished", "iterations", numberOfSignatures)
+	log.Info("program finished")
 	// Deferred cleanup functions will run now
 }
 
@@ -2,9 +2,11 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/pdfsign"
 	"encoding/base64" // <-- Import standard base64 encoding library
 	"errors"
 	"fmt"
+	"log/slog"
 	"os" // Added for directory creation
 	"path/filepath"
 	"strings" // Added for error message parsing
@@ -28,102 +30,94 @@ func loadConfig() (*viper.Viper, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fatal error config file: %w", err)
 	}
-	fmt.Println("Configuration file loaded successfully.")
 	return vip, nil
 }
 
 // --- Chilkat Initialization (Copied from p11/ocsp/signBLT.go) ---
-func initializeChilkat() error {
+func initializeChilkat(log *slog.Logger) error {
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true)
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // Verbose only at debug level
 
 	success := glob.UnlockBundle("Anything for 30-day trial")
 	if !success {
-		errMsg := glob.LastErrorText()
-		return fmt.Errorf("failed to unlock Chilkat: %s", errMsg)
+		return pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText())
 	}
 
 	status := glob.UnlockStatus()
-	if status == 2 {
-		fmt.Println("Chilkat unlocked using purchased unlock code.")
-	} else {
-		fmt.Println("Chilkat unlocked in trial mode.")
-	}
+	log.Info("Chilkat unlocked", "purchased", status == 2, "version", glob.Version())
 	return nil
 }
 
 // --- PKCS11 Initialization (Copied from p11/ocsp/signBLT.go) ---
-func initializePkcs11(libPath string) (*chilkat.Pkcs11, error) {
+func initializePkcs11(log *slog.Logger, libPath string) (*chilkat.Pkcs11, error) {
 	if libPath == "" {
 		return nil, errors.New("PKCS11 library path is empty")
 	}
 	pkcs11 := chilkat.NewPkcs11()
-	pkcs11.SetVerboseLogging(true)
-	fmt.Printf("Using PKCS11 library path: %s\n", libPath)
+	pkcs11.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // Verbose only at debug level
+	log.Info("using PKCS11 library", "path", libPath)
 	pkcs11.SetSharedLibPath(libPath)
 
 	success := pkcs11.Initialize()
 	if !success {
 		errMsg := pkcs11.LastErrorText()
 		pkcs11.DisposePkcs11()
-		return nil, fmt.Errorf("PKCS11 Initialize failed: %s", errMsg)
+		return nil, pdfsign.NewChilkatError("PKCS11 Initialize", errMsg)
 	}
-	fmt.Println("PKCS11 Initialize successful.")
+	log.Info("PKCS11 initialized")
 	return pkcs11, nil
 }
 
 // --- PKCS11 Session Establishment (Copied from p11/ocsp/signBLT.go) ---
-func establishPkcs11Session(pkcs11 *chilkat.Pkcs11, pin string, userType int) (slotID int, err error) {
+func establishPkcs11Session(log *slog.Logger, pkcs11 *chilkat.Pkcs11, pin string, userType int) (slotID int, err error) {
 	if pin == "" {
 		return -1, errors.New("HSM PIN is empty")
 	}
 	slotID = 0 // Hardcoded Slot ID
-	fmt.Printf("Attempting to use hardcoded Slot ID: %d\n", slotID)
+	log.Debug("using hardcoded slot", "slot", slotID)
 
 	readWrite := true
 	success := pkcs11.OpenSession(slotID, readWrite)
 	if !success {
-		return -1, fmt.Errorf("PKCS11 OpenSession failed for Slot ID %d: %s", slotID, pkcs11.LastErrorText())
+		return -1, pdfsign.NewChilkatError(fmt.Sprintf("PKCS11 OpenSession for Slot ID %d", slotID), pkcs11.LastErrorText())
 	}
-	fmt.Printf("PKCS11 OpenSession successful for Slot ID: %d\n", slotID)
+	log.Info("PKCS11 session opened", "slot", slotID)
 
 	success = pkcs11.Login(userType, pin)
 	if !success {
 		pkcs11.CloseSession()
-		return -1, fmt.Errorf("PKCS11 Login failed for Slot ID %d: %s", slotID, pkcs11.LastErrorText())
+		return -1, pdfsign.NewChilkatError(fmt.Sprintf("PKCS11 Login for Slot ID %d", slotID), pkcs11.LastErrorText())
 	}
-	fmt.Printf("PKCS11 Login successful for Slot ID: %d, UserType: %d\n", slotID, userType)
+	log.Info("PKCS11 login successful", "slot", slotID, "user_type", userType)
 	return slotID, nil
 }
 
 // --- Certificate Finding (Copied from p11/ocsp/signBLT.go) ---
-func findCertificateWithPrivateKey(pkcs11 *chilkat.Pkcs11) (*chilkat.Cert, error) {
+func findCertificateWithPrivateKey(log *slog.Logger, pkcs11 *chilkat.Pkcs11) (*chilkat.Cert, error) {
 	cert := chilkat.NewCert()
 	success := pkcs11.FindCert("privateKey", "", cert)
 	if !success {
 		cert.DisposeCert()
-		errMsg := pkcs11.LastErrorText()
-		if errMsg == "No certificates with private keys found." || errMsg == "Did not find cert matching criteria." {
-			fmt.Println("No certificates having a private key were found.")
+		err := pdfsign.NewChilkatError("finding a certificate with a private key", pkcs11.LastErrorText())
+		if errors.Is(err, pdfsign.ErrKeyNotFound) {
+			log.Warn("no certificates having a private key were found")
 			return nil, nil
 		}
-		return nil, fmt.Errorf("error finding certificate with private key: %s", errMsg)
+		return nil, err
 	}
-	fmt.Println("Found cert with potential private key association: ", cert.SubjectCN())
+	log.Debug("found cert with potential private key association", "subject_cn", cert.SubjectCN())
 
 	if !cert.HasPrivateKey() {
 		subjectCN := cert.SubjectCN()
 		cert.DisposeCert()
-		errMsg := fmt.Sprintf("Error: The certificate found (CN: %s) via pkcs11.FindCert does NOT have an associated private key according to Chilkat.", subjectCN)
-		fmt.Println(errMsg)
-		return nil, errors.New(errMsg)
+		return nil, fmt.Errorf("certificate '%s' found via pkcs11.FindCert: %w", subjectCN, pdfsign.ErrKeyNotFound)
 	}
-	fmt.Println("Certificate object confirmed to have an associated private key.")
+	log.Debug("certificate has an associated private key", "subject_cn", cert.SubjectCN())
 	return cert, nil
 }
 
 // --- Configure Signing Options for ONE-STEP B-LT Attempt ---
-func configureSigningOptionsOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert) (*chilkat.JsonObject, error) {
+func configureSigningOptionsOneStep(log *slog.Logger, pdf *chilkat.Pdf, cert *chilkat.Cert) (*chilkat.JsonObject, error) {
 	json := chilkat.NewJsonObject()
 	pdf.SetSigningCert(cert) // Set cert early
 
@@ -202,7 +196,7 @@ func configureSigningOptionsOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert) (*chil
 	defer intermediateCert.DisposeCert() // Dispose intermediate cert object
 	// IMPORTANT: Replace with the ACTUAL path to your intermediate CA cert file
 	intermediateCertPath := "C:/chilkatPackage/chilkattest/certs/intermediateCA.cer" // Example path, ADJUST AS NEEDED
-	fmt.Printf("Attempting to load intermediate CA certificate from: %s\n", intermediateCertPath)
+	log.Debug("loading intermediate CA certificate", "path", intermediateCertPath)
 	if intermediateCert.LoadFromFile(intermediateCertPath) {
 		// Assuming GetEncoded returns *string with raw DER data
 		derStringPtr := intermediateCert.GetEncoded()
@@ -224,21 +218,19 @@ func configureSigningOptionsOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert) (*chil
 				certsArray.DisposeJsonArray() // Dispose the array object reference
 
 				if successAdd {
-					fmt.Printf("Successfully added intermediate cert (Subject: %s) to certsToEmbedBase64 option.\n", intermediateCert.SubjectCN())
+					log.Debug("added intermediate cert to certsToEmbedBase64 option", "subject_cn", intermediateCert.SubjectCN())
 				} else {
-					fmt.Println("Warning: Failed to add intermediate cert Base64 using JsonArray.AddStringAt.")
-					// Check json.LastErrorText() or certsArray.LastErrorText()
-					fmt.Println("LastErrorText after AddStringAt failure:", json.LastErrorText())
+					log.Warn("failed to add intermediate cert Base64 using JsonArray.AddStringAt", "chilkat_log", json.LastErrorText())
 				}
 			} else {
-				fmt.Println("Warning: Failed to get JsonArray object for certsToEmbedBase64.")
+				log.Warn("failed to get JsonArray object for certsToEmbedBase64")
 			}
 
 		} else {
-			fmt.Println("Warning: Failed to get DER data (*string) from intermediate certificate:", intermediateCert.LastErrorText())
+			log.Warn("failed to get DER data from intermediate certificate", "chilkat_log", intermediateCert.LastErrorText())
 		}
 	} else {
-		fmt.Printf("Warning: Failed to load intermediate CA certificate from '%s': %s\n", intermediateCertPath, intermediateCert.LastErrorText())
+		log.Warn("failed to load intermediate CA certificate", "path", intermediateCertPath, "chilkat_log", intermediateCert.LastErrorText())
 		// Consider returning an error if intermediate is mandatory
 		// return nil, fmt.Errorf("failed to load required intermediate CA certificate from %s", intermediateCertPath)
 	}
@@ -253,33 +245,37 @@ func configureSigningOptionsOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert) (*chil
 	json.UpdateString("appearance.text[1]", "current_dt")
 	json.UpdateString("appearance.text[2]", "PAdES Signature (One-Step B-LT Attempt)")
 
-	fmt.Println("Configured comprehensive settings for one-step B-LT attempt.")
+	log.Debug("configured comprehensive settings for one-step B-LT attempt")
 	return json, nil
 }
 
 // --- PDF Loading with enhanced settings (Copied from p11/ocsp/signBLT.go) ---
-func loadPdfDocument(filePath string) (*chilkat.Pdf, error) {
+func loadPdfDocument(log *slog.Logger, filePath string) (*chilkat.Pdf, error) {
 	if filePath == "" {
 		return nil, errors.New("unsigned PDF input path is empty")
 	}
 	pdf := chilkat.NewPdf()
-	pdf.SetVerboseLogging(true)
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log))
 	pdf.SetSigAllocateSize(100000)
-	// Add all potentially useful debugging options
-	pdf.SetUncommonOptions("LOG_OCSP_HTTP,LOG_CRL_HTTP,OCSP_RESP_DETAILS,CRL_DETAILS,DSS_DEBUG,FORCE_DSS,CACHE_OCSP_RESPONSES,CACHE_CRL_RESPONSES")
+	// Add all potentially useful debugging options, only at debug level
+	if pdfsign.ChilkatVerbose(log) {
+		pdf.SetUncommonOptions("LOG_OCSP_HTTP,LOG_CRL_HTTP,OCSP_RESP_DETAILS,CRL_DETAILS,DSS_DEBUG,FORCE_DSS,CACHE_OCSP_RESPONSES,CACHE_CRL_RESPONSES")
+	} else {
+		pdf.SetUncommonOptions("FORCE_DSS,CACHE_OCSP_RESPONSES,CACHE_CRL_RESPONSES")
+	}
 
 	success := pdf.LoadFile(filePath)
 	if !success {
 		errMsg := pdf.LastErrorText()
 		pdf.DisposePdf()
-		return nil, fmt.Errorf("failed to load PDF '%s': %s", filePath, errMsg)
+		return nil, pdfsign.NewChilkatError(fmt.Sprintf("loading PDF '%s'", filePath), errMsg)
 	}
-	fmt.Printf("Loaded unsigned PDF: %s\n", filePath)
+	log.Debug("loaded unsigned PDF", "path", filePath)
 	return pdf, nil
 }
 
 // --- PDF Signing Function for ONE-STEP Attempt ---
-func performSigningOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert, json *chilkat.JsonObject, outputPath string) error {
+func performSigningOneStep(log *slog.Logger, pdf *chilkat.Pdf, cert *chilkat.Cert, json *chilkat.JsonObject, outputPath string) error {
 	if outputPath == "" {
 		return errors.New("signed PDF output path is empty")
 	}
@@ -290,49 +286,35 @@ func performSigningOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert, json *chilkat.J
 	// Certificate should already be set in configureSigningOptions
 
 	// --- Attempt One-Step Sign ---
-	fmt.Println("--- Beginning PDF Signing (One-Step B-LT Attempt) --- (Verbose logs follow)")
-	fmt.Printf("Attempting to sign PDF and save to: %s\n", outputPath)
+	log.Debug("signing PDF (one-step B-LT attempt)", "output", outputPath)
 
 	outputDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %s", err)
 	}
 
-	// Clear LastErrorText before SignPdf
-	pdf.LastErrorText()
 	success := pdf.SignPdf(json, outputPath)
-	errMsg := pdf.LastErrorText() // Capture error text immediately
-
-	if errMsg != "" {
-		fmt.Println("--- Verbose LastErrorText after SignPdf attempt (One-Step): ---")
-		fmt.Println(errMsg)
-		fmt.Println("--- End of Verbose LastErrorText (One-Step) ---")
-	} else {
-		fmt.Println("SignPdf attempt completed, LastErrorText is empty.")
-	}
-
 	if !success {
-		return fmt.Errorf("failed to sign PDF (One-Step SignPdf): %s", errMsg)
+		return pdfsign.NewSigningError("SignPdf (one-step)", pdf.LastErrorText())
 	}
+	// The verbose log of a successful call shows what was fetched for the DSS
+	log.Debug("SignPdf log", "chilkat_log", pdf.LastErrorText())
 
-	fmt.Println("PDF SignPdf call completed successfully (One-Step). Check actual signature level and DSS content.")
+	log.Info("PDF signed (one-step); check the actual signature level and DSS content", "output", outputPath)
 
 	// --- Optional: Get and print DSS content after signing for verification ---
 	dssJson := chilkat.NewJsonObject()
 	defer dssJson.DisposeJsonObject()
-	fmt.Println("Attempting to get DSS content after one-step signing...")
 	gotDss := pdf.GetDss(dssJson)
 	if gotDss {
 		dssContent := dssJson.Emit()
-		fmt.Println("Successfully retrieved DSS content:")
-		fmt.Println(*dssContent) // Dereference to print the string value
+		log.Debug("DSS content after signing", "dss", *dssContent)
 		// Analyze dssContent here or externally to see if it's complete
 		if *dssContent == "{}" || !strings.Contains(*dssContent, "VRI") { // Basic check
-			fmt.Println("WARNING: DSS content appears empty or incomplete based on basic check.")
+			log.Warn("DSS content appears empty or incomplete based on basic check", "output", outputPath)
 		}
 	} else {
-		fmt.Println("Failed to get DSS content after signing:")
-		fmt.Println(pdf.LastErrorText())
+		log.Warn("failed to get DSS content after signing", "chilkat_log", pdf.LastErrorText())
 	}
 	// ---------------------------------------------------------------------
 
@@ -340,16 +322,16 @@ func performSigningOneStep(pdf *chilkat.Pdf, cert *chilkat.Cert, json *chilkat.J
 }
 
 // --- PKCS11 Logout (Copied from p11/ocsp/signBLT.go) ---
-func pkcs11Logout(pkcs11 *chilkat.Pkcs11) error {
+func pkcs11Logout(log *slog.Logger, pkcs11 *chilkat.Pkcs11) error {
 	if pkcs11 == nil {
 		return errors.New("cannot logout, PKCS11 object is nil")
 	}
 	success := pkcs11.Logout()
 	if !success {
-		fmt.Println("Warning: PKCS11 Logout failed (non-critical):", pkcs11.LastErrorText())
-		return fmt.Errorf("PKCS11 logout failed: %s", pkcs11.LastErrorText())
+		// Returned for info; main logs it as a warning, as cleanup should still proceed
+		return pdfsign.NewChilkatError("PKCS11 Logout", pkcs11.LastErrorText())
 	}
-	fmt.Println("PKCS11 Logout successful.")
+	log.Info("PKCS11 logout successful")
 	return nil
 }
 
@@ -357,15 +339,25 @@ func pkcs11Logout(pkcs11 *chilkat.Pkcs11) error {
 func main() {
 	defer chilkat.NewGlobal().DisposeGlobal()
 
-	err := initializeChilkat()
+	vip, err := loadConfig()
 	if err != nil {
-		fmt.Println("Error during Chilkat initialization:", err)
+		fmt.Println("Error loading configuration:", err)
 		return
 	}
 
-	vip, err := loadConfig()
+	// Logging is configured by the "logging" key; PINs and passwords from the
+	// config are masked in every line.
+	closeLog, err := pdfsign.SetupLogging(vip)
 	if err != nil {
-		fmt.Println("Error loading configuration:", err)
+		fmt.Println("Error setting up logging:", err)
+		return
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("hsm-sign-onestep")
+
+	err = initializeChilkat(log)
+	if err != nil {
+		pdfsign.LogFailure(log, "Chilkat initialization failed", err)
 		return
 	}
 
@@ -377,41 +369,44 @@ func main() {
 	baseOutputFilename := "signed_onestep_ecc"
 	userType := 1
 
-	pkcs11, err := initializePkcs11(pkcs11LibPath)
+	pkcs11, err := initializePkcs11(log, pkcs11LibPath)
 	if err != nil {
-		fmt.Println("Error initializing PKCS11:", err)
+		pdfsign.LogFailure(log, "initializing PKCS11 failed", err)
 		return
 	}
 	defer pkcs11.DisposePkcs11()
 
-	_, err = establishPkcs11Session(pkcs11, pin, userType)
+	_, err = establishPkcs11Session(log, pkcs11, pin, userType)
 	if err != nil {
-		fmt.Println("Error establishing PKCS11 session:", err)
+		pdfsign.LogFailure(log, "establishing PKCS11 session failed", err)
 		return
 	}
 	defer pkcs11.CloseSession()
 
 	// --- Signing Loop ---
 	numberOfSignatures := 1 // Let's try one first for focused debugging
-	fmt.Printf("\n--- Starting One-Step Signing Loop (%d iterations) ---\n", numberOfSignatures)
+	log.Info("one-step signing loop started", "iterations", numberOfSignatures)
 	for i := 1; i <= numberOfSignatures; i++ {
-		fmt.Printf("\n--- Iteration %d of %d ---\n", i, numberOfSignatures)
+		outputFilename := fmt.Sprintf("%s_%d.pdf", baseOutputFilename, i)
+		iterationOutputPath := filepath.Join(onepieceOutputDir, outputFilename)
+		docLog, _ := pdfsign.DocumentLogger(log, iterationOutputPath)
+		docLog = docLog.With("iteration", i)
 
-		pdf, err := loadPdfDocument(unsignedPdfPath)
+		pdf, err := loadPdfDocument(docLog, unsignedPdfPath)
 		if err != nil {
-			fmt.Println("Error loading PDF:", err)
+			pdfsign.LogFailure(docLog, "loading PDF failed", err)
 			return
 		}
 		// Defer disposal within the loop iteration
 		defer pdf.DisposePdf()
 
-		cert, err := findCertificateWithPrivateKey(pkcs11)
+		cert, err := findCertificateWithPrivateKey(docLog, pkcs11)
 		if err != nil {
-			fmt.Println("Error finding certificate:", err)
+			pdfsign.LogFailure(docLog, "finding certificate failed", err)
 			return
 		}
 		if cert == nil {
-			fmt.Println("Could not find a usable certificate with an associated private key on the HSM.")
+			docLog.Error("could not find a usable certificate with an associated private key on the HSM")
 			return
 		}
 		// Defer disposal within the loop iteration
@@ -419,35 +414,30 @@ func main() {
 
 		// No need to call pdf.SetSigningCert here, configureSigningOptionsOneStep handles it
 
-		json, err := configureSigningOptionsOneStep(pdf, cert)
+		json, err := configureSigningOptionsOneStep(docLog, pdf, cert)
 		if err != nil {
-			fmt.Println("Error configuring signing options:", err)
+			docLog.Error("configuring signing options failed", "error", err)
 			return
 		}
 		// Defer disposal within the loop iteration
 		defer json.DisposeJsonObject()
 
-		outputFilename := fmt.Sprintf("%s_%d.pdf", baseOutputFilename, i)
-		iterationOutputPath := filepath.Join(onepieceOutputDir, outputFilename)
-
-		err = performSigningOneStep(pdf, cert, json, iterationOutputPath)
+		err = performSigningOneStep(docLog, pdf, cert, json, iterationOutputPath)
 		if err != nil {
-			fmt.Printf("Error during one-step signing iteration %d: %v\n", i, err)
+			pdfsign.LogFailure(docLog, "one-step signing failed", err)
 			// break // Stop loop on first error
-		} else {
-			fmt.Printf("One-step signing process completed for iteration %d. Please verify the output file: %s\n", i, iterationOutputPath)
 		}
 
 		// Optional: Pause if running multiple iterations
 		// fmt.Println("Sleeping for 2 seconds...")
 		// time.Sleep(2 * time.Second)
 	}
-	fmt.Printf("\n--- Finished One-Step Signing Loop ---\n\n")
+	log.Info("one-step signing loop finished")
 
-	err = pkcs11Logout(pkcs11)
+	err = pkcs11Logout(log, pkcs11)
 	if err != nil {
-		fmt.Println("Note: Logout failed, proceeding with cleanup.")
+		log.Warn("PKCS11 logout failed (non-critical), proceeding with cleanup", "error", err, "chilkat_log", pdfsign.ErrorDetail(err))
 	}
 
-	fmt.Println("Program finished.")
+	log.Info("program finished")
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/pdfsign"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -29,30 +31,24 @@ func loadConfig() (*viper.Viper, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fatal error config file: %w", err)
 	}
-	fmt.Println("Configuration file loaded successfully.")
 	return vip, nil
 }
 
 // --- Chilkat Initialization ---
-func initializeChilkat() error {
+func initializeChilkat(log *slog.Logger) error {
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true)
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log))       // Verbose only at debug level
 	success := glob.UnlockBundle("Anything for 30-day trial") // Use your actual unlock code
 	if !success {
-		errMsg := glob.LastErrorText()
-		return fmt.Errorf("failed to unlock Chilkat: %s", errMsg)
+		return pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText())
 	}
 	status := glob.UnlockStatus()
-	if status == 2 {
-		fmt.Println("Chilkat unlocked using purchased unlock code.")
-	} else {
-		fmt.Println("Chilkat unlocked in trial mode.")
-	}
+	log.Info("Chilkat unlocked", "purchased", status == 2, "version", glob.Version())
 	return nil
 }
 
 // --- Certificate Loading from PFX ---
-func loadCertificateFromPfx(pfxPath, password string) (*chilkat.Cert, error) {
+func loadCertificateFromPfx(log *slog.Logger, pfxPath, password string) (*chilkat.Cert, error) {
 	if pfxPath == "" {
 		return nil, errors.New("PFX file path is empty")
 	}
@@ -62,40 +58,39 @@ func loadCertificateFromPfx(pfxPath, password string) (*chilkat.Cert, error) {
 	if !success {
 		errMsg := cert.LastErrorText()
 		cert.DisposeCert() // Dispose if load fails
-		return nil, fmt.Errorf("failed to load certificate from PFX '%s': %s", pfxPath, errMsg)
+		return nil, pdfsign.NewChilkatError(fmt.Sprintf("loading certificate from PFX '%s'", pfxPath), errMsg)
 	}
-	fmt.Printf("Successfully loaded certificate from PFX: %s (SubjectCN: %s)\n", pfxPath, cert.SubjectCN())
+	log.Info("loaded certificate from PFX", "path", pfxPath, "subject_cn", cert.SubjectCN())
 
 	// Optional: Check if the loaded cert has a private key
 	if !cert.HasPrivateKey() {
-		errMsg := fmt.Sprintf("Error: The certificate loaded from PFX '%s' does not have an associated private key.", pfxPath)
 		cert.DisposeCert()
-		return nil, errors.New(errMsg)
+		return nil, fmt.Errorf("certificate loaded from PFX '%s': %w", pfxPath, pdfsign.ErrKeyNotFound)
 	}
-	fmt.Println("Certificate from PFX confirmed to have an associated private key.")
+	log.Debug("certificate from PFX has an associated private key")
 	return cert, nil
 }
 
 // --- PDF Loading ---
-func loadPdfDocument(filePath string) (*chilkat.Pdf, error) {
+func loadPdfDocument(log *slog.Logger, filePath string) (*chilkat.Pdf, error) {
 	if filePath == "" {
 		return nil, errors.New("unsigned PDF input path is empty")
 	}
 	pdf := chilkat.NewPdf()
-	pdf.SetVerboseLogging(true)
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log))
 	pdf.SetSigAllocateSize(30000)
 	success := pdf.LoadFile(filePath)
 	if !success {
 		errMsg := pdf.LastErrorText()
 		pdf.DisposePdf()
-		return nil, fmt.Errorf("failed to load PDF '%s': %s", filePath, errMsg)
+		return nil, pdfsign.NewChilkatError(fmt.Sprintf("loading PDF '%s'", filePath), errMsg)
 	}
-	fmt.Printf("Loaded unsigned PDF: %s\n", filePath)
+	log.Debug("loaded unsigned PDF", "path", filePath)
 	return pdf, nil
 }
 
 // --- Configure Signing Options ---
-func configureSigningOptions() (*chilkat.JsonObject, error) {
+func configureSigningOptions(log *slog.Logger) (*chilkat.JsonObject, error) {
 	json := chilkat.NewJsonObject()
 	// Use standard PKCS7 detached signature for PFX signing unless specific needs require others
 	json.UpdateString("subFilter", "/adbe.pkcs7.detached")
@@ -112,12 +107,12 @@ func configureSigningOptions() (*chilkat.JsonObject, error) {
 	json.UpdateString("appearance.text[0]", "Digitally signed by: cert_cn")
 	json.UpdateString("appearance.text[1]", "current_dt")
 	json.UpdateString("appearance.text[2]", "Validated via PFX") // Updated text
-	fmt.Println("Signing options configured.")
+	log.Debug("signing options configured")
 	return json, nil
 }
 
 // --- PDF Signing using PFX Certificate Object ---
-func performPdfSigningPfx(pdf *chilkat.Pdf, cert *chilkat.Cert, pfxPassword string, jsonOptions *chilkat.JsonObject, outputPath string) error {
+func performPdfSigningPfx(log *slog.Logger, pdf *chilkat.Pdf, cert *chilkat.Cert, pfxPassword string, jsonOptions *chilkat.JsonObject, outputPath string) error {
 	if outputPath == "" {
 		return errors.New("signed PDF output path is empty")
 	}
@@ -126,7 +121,7 @@ func performPdfSigningPfx(pdf *chilkat.Pdf, cert *chilkat.Cert, pfxPassword stri
 	}
 	outputDir := filepath.Dir(outputPath)
 	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-		fmt.Printf("Output directory %s does not exist, creating it.\n", outputDir)
+		log.Info("creating output directory", "path", outputDir)
 		err = os.MkdirAll(outputDir, 0755)
 		if err != nil {
 			return fmt.Errorf("failed to create output directory '%s': %w", outputDir, err)
@@ -134,27 +129,20 @@ func performPdfSigningPfx(pdf *chilkat.Pdf, cert *chilkat.Cert, pfxPassword stri
 	}
 
 	// 設定從 PFX 載入的憑證物件
-	fmt.Println("正在設定簽署憑證（來自 PFX）到 PDF 物件...")
 	success := pdf.SetSigningCert(cert)
 	// pdf.SetUncommonOptions("NO_VERIFY_CERT_SIGNATURES") // Keep if needed
 
 	if !success {
-		return fmt.Errorf("failed to set signing certificate (from PFX) on PDF object: %s", pdf.LastErrorText())
+		return pdfsign.NewChilkatError("setting the signing certificate (from PFX)", pdf.LastErrorText())
 	}
-	fmt.Println("PFX signing certificate configured successfully on PDF object.")
+	log.Debug("PFX signing certificate set on PDF object")
 
-	// Sign the PDF
-	fmt.Println("--- Beginning PDF Signing (PFX) --- (Verbose logs follow if error occurs)")
-	fmt.Printf("Attempting to sign PDF and save to: %s\n", outputPath)
+	// Sign the PDF; on failure the Chilkat log goes with the error
 	success = pdf.SignPdf(jsonOptions, outputPath)
 	if !success {
-		errMsg := pdf.LastErrorText()
-		fmt.Println("--- PDF Signing (PFX) Failed --- Verbose LastErrorText: ---")
-		fmt.Println(errMsg)
-		fmt.Println("--- End of Verbose LastErrorText ---")
-		return fmt.Errorf("failed to sign PDF '%s' using PFX (see verbose log above)", outputPath)
+		return pdfsign.NewChilkatError(fmt.Sprintf("SignPdf for '%s' using PFX", outputPath), pdf.LastErrorText())
 	}
-	fmt.Printf("PDF signed successfully using PFX: %s\n", outputPath)
+	log.Info("PDF signed using PFX", "output", outputPath)
 	return nil
 }
 
@@ -163,15 +151,25 @@ func main() {
 	// Defer global Chilkat cleanup
 	defer chilkat.NewGlobal().DisposeGlobal()
 
-	err := initializeChilkat()
+	vip, err := loadConfig()
 	if err != nil {
-		fmt.Println("Error during Chilkat initialization:", err)
+		fmt.Println("Error loading configuration:", err)
 		return
 	}
 
-	vip, err := loadConfig()
+	// Logging is configured by the "logging" key; PINs and passwords from the
+	// config are masked in every line.
+	closeLog, err := pdfsign.SetupLogging(vip)
 	if err != nil {
-		fmt.Println("Error loading configuration:", err)
+		fmt.Println("Error setting up logging:", err)
+		return
+	}
+	defer closeLog()
+	log, _ := pdfsign.JobLogger("pfx-sign")
+
+	err = initializeChilkat(log)
+	if err != nil {
+		pdfsign.LogFailure(log, "Chilkat initialization failed", err)
 		return
 	}
 
@@ -184,60 +182,61 @@ func main() {
 
 	// --- Check required PFX config ---
 	if pfxFilePath == "" {
-		fmt.Println("Error: 'pfx_file_path' not found or empty in config file.")
+		log.Error("'pfx_file_path' not found or empty in config file")
 		return
 	}
 	// pfxPassword can be empty if the PFX file has no password
 
 	// --- Load resources needed for signing ---
-	pdf, err := loadPdfDocument(unsignedPdfPath)
+	pdf, err := loadPdfDocument(log, unsignedPdfPath)
 	if err != nil {
-		fmt.Println("Error loading PDF:", err)
+		pdfsign.LogFailure(log, "loading PDF failed", err)
 		return
 	}
 	defer pdf.DisposePdf()
 
 	// Load certificate from PFX file
-	cert, err := loadCertificateFromPfx(pfxFilePath, pfxPassword)
+	cert, err := loadCertificateFromPfx(log, pfxFilePath, pfxPassword)
 	if err != nil {
-		fmt.Println("Error loading certificate from PFX:", err)
+		pdfsign.LogFailure(log, "loading certificate from PFX failed", err)
 		return
 	}
 	if cert == nil { // Should be handled by error, but double-check
-		fmt.Println("Failed to load a valid certificate from PFX.")
+		log.Error("failed to load a valid certificate from PFX")
 		return
 	}
 	defer cert.DisposeCert()
 
-	jsonOptions, err := configureSigningOptions()
+	jsonOptions, err := configureSigningOptions(log)
 	if err != nil {
-		fmt.Println("Error configuring signing options:", err)
+		log.Error("configuring signing options failed", "error", err)
 		return
 	}
 	defer jsonOptions.DisposeJsonObject()
 
 	// --- Signing Loop ---
 	numberOfSignatures := 10
-	fmt.Printf("\n--- Starting PFX Signing Loop (%d iterations) ---\n", numberOfSignatures)
+	log.Info("PFX signing loop started", "iterations", numberOfSignatures)
+	signed := 0
 	for i := 1; i <= numberOfSignatures; i++ {
-		fmt.Printf("\n--- Iteration %d of %d ---\n", i, numberOfSignatures)
-
 		outputFilename := fmt.Sprintf("%s_%d.pdf", baseOutputFilename, i)
 		iterationOutputPath := filepath.Join(loopOutputDir, outputFilename)
+		docLog, _ := pdfsign.DocumentLogger(log, iterationOutputPath)
+		docLog = docLog.With("iteration", i)
 
 		// Perform the signing for this iteration using PFX cert
-		err = performPdfSigningPfx(pdf, cert, pfxPassword, jsonOptions, iterationOutputPath)
+		err = performPdfSigningPfx(docLog, pdf, cert, pfxPassword, jsonOptions, iterationOutputPath)
 		if err != nil {
-			fmt.Printf("Error during signing iteration %d: %v\n", i, err)
 			// break // Uncomment to stop loop on first error
-			fmt.Println("Continuing to next iteration despite error...")
+			pdfsign.LogFailure(docLog, "signing failed, continuing with the next iteration", err)
+		} else {
+			signed++
 		}
 
-		fmt.Println("Sleeping for 2 seconds...")
 		time.Sleep(2 * time.Second)
 	}
-	fmt.Printf("\n--- Finished PFX Signing Loop ---\n\n")
+	log.Info("PFX signing loop finished", "signed", signed, "failed", numberOfSignatures-signed)
 
-	fmt.Println("Program finished successfully.")
+	log.Info("program finished")
 	// Deferred cleanup: DisposeJsonObject, DisposeCert, DisposePdf, DisposeGlobal
 }
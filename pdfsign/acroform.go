@@ -0,0 +1,83 @@
+package pdfsign
+
+// --- AcroForm Fields ---
+
+// formField is a terminal field of the document's interactive form.
+type formField struct {
+	Name   string // fully qualified, parent names joined with "."
+	Ref    pdfRef
+	Dict   pdfDict
+	Type   pdfName // /FT, possibly inherited
+	Signed bool    // signature field whose /V is set
+}
+
+// formFields returns every terminal field, depth first in /Fields order.
+func (doc *pdfDocument) formFields() []formField {
+	acro := doc.dict(doc.catalog()["AcroForm"])
+	if acro == nil {
+		return nil
+	}
+	var out []formField
+	seen := make(map[int]bool)
+	var walk func(items pdfArray, prefix string, ft pdfName, depth int)
+	walk = func(items pdfArray, prefix string, ft pdfName, depth int) {
+		if depth > 32 {
+			return
+		}
+		for _, item := range items {
+			ref, ok := item.(pdfRef)
+			if !ok || seen[ref.Num] || len(seen) >= maxTreeNodes {
+				continue
+			}
+			seen[ref.Num] = true
+			d := doc.dict(ref)
+			if d == nil {
+				continue
+			}
+			name := prefix
+			if t, ok := doc.resolve(d["T"]).(pdfString); ok {
+				if name != "" {
+					name += "."
+				}
+				name += decodeTextString(t.Value)
+			}
+			fieldType := ft
+			if t, ok := doc.resolve(d["FT"]).(pdfName); ok {
+				fieldType = t
+			}
+			// Kids without /T are widgets of this field, not child fields.
+			var childFields pdfArray
+			for _, kid := range doc.array(d["Kids"]) {
+				if kd := doc.dict(kid); kd != nil {
+					if _, named := kd["T"]; named {
+						childFields = append(childFields, kid)
+					}
+				}
+			}
+			if len(childFields) > 0 {
+				walk(childFields, name, fieldType, depth+1)
+				continue
+			}
+			_, hasValue := d["V"]
+			out = append(out, formField{
+				Name:   name,
+				Ref:    ref,
+				Dict:   d,
+				Type:   fieldType,
+				Signed: fieldType == "Sig" && hasValue && doc.resolve(d["V"]) != nil,
+			})
+		}
+	}
+	walk(doc.array(acro["Fields"]), "", "", 0)
+	return out
+}
+
+// findField looks a field up by its fully qualified name.
+func (doc *pdfDocument) findField(name string) (formField, bool) {
+	for _, f := range doc.formFields() {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return formField{}, false
+}
@@ -0,0 +1,328 @@
+package pdfsign
+
+import (
+	"chilkat"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+)
+
+// --- Signing Algorithms ---
+// The supported matrix is:
+//
+//	key          schemes           hashes
+//	RSA >= 2048  pkcs1v15, pss     sha256, sha384, sha512
+//	EC P-256     ecdsa             sha256, sha384, sha512
+//	EC P-384     ecdsa             sha256, sha384, sha512
+//	EC P-521     ecdsa             sha256, sha384, sha512
+//	Ed25519      ed25519           sha512 (RFC 8419)
+//
+// The scheme defaults to the natural one for the key (pkcs1v15 for RSA).
+// An algorithm is checked against the signer's certificate and, for HSM
+// keys, against the token's mechanism list before anything is signed, so a
+// batch does not fail halfway with an opaque CKR_MECHANISM_INVALID.
+
+// SignatureScheme is the public key signature scheme.
+type SignatureScheme string
+
+const (
+	SchemeAuto     SignatureScheme = ""         // from the key type
+	SchemePKCS1v15 SignatureScheme = "pkcs1v15" // RSA PKCS#1 v1.5
+	SchemePSS      SignatureScheme = "pss"      // RSASSA-PSS
+	SchemeECDSA    SignatureScheme = "ecdsa"
+	SchemeEd25519  SignatureScheme = "ed25519"
+)
+
+// Algorithm is a hash and signature scheme pair.
+type Algorithm struct {
+	Hash   string          `json:"hash" mapstructure:"hash"` // sha256 (default), sha384 or sha512
+	Scheme SignatureScheme `json:"scheme" mapstructure:"scheme"`
+}
+
+var algorithmHashes = map[string]bool{"sha256": true, "sha384": true, "sha512": true}
+
+// String returns e.g. "RSA-PSS with SHA-384".
+func (a Algorithm) String() string {
+	scheme := map[SignatureScheme]string{
+		SchemeAuto:     "key default",
+		SchemePKCS1v15: "RSA PKCS#1 v1.5",
+		SchemePSS:      "RSA-PSS",
+		SchemeECDSA:    "ECDSA",
+		SchemeEd25519:  "Ed25519",
+	}[a.Scheme]
+	if scheme == "" {
+		scheme = string(a.Scheme)
+	}
+	hash := a.Hash
+	if hash == "" {
+		hash = "sha256"
+	}
+	if strings.HasPrefix(hash, "sha") {
+		hash = "SHA-" + hash[3:]
+	}
+	return fmt.Sprintf("%s with %s", scheme, hash)
+}
+
+// validate normalises the names and checks them against the matrix,
+// independently of any key.
+func (a *Algorithm) validate() error {
+	a.Hash = strings.ToLower(strings.ReplaceAll(a.Hash, "-", ""))
+	if a.Hash == "" {
+		a.Hash = "sha256"
+	}
+	if !algorithmHashes[a.Hash] {
+		return fmt.Errorf("unsupported hash algorithm '%s' (use sha256, sha384 or sha512)", a.Hash)
+	}
+	a.Scheme = SignatureScheme(strings.ToLower(string(a.Scheme)))
+	switch a.Scheme {
+	case SchemeAuto, SchemePKCS1v15, SchemePSS, SchemeECDSA, SchemeEd25519:
+	case "pkcs", "pkcs1":
+		a.Scheme = SchemePKCS1v15
+	default:
+		return fmt.Errorf("unsupported signature scheme '%s' (use pkcs1v15, pss, ecdsa or ed25519)", a.Scheme)
+	}
+	return nil
+}
+
+// LoadAlgorithmFromConfig reads the config.json key signature_algorithm
+// ({hash, scheme}). A missing key gives SHA-256 with the key's default
+// scheme.
+func LoadAlgorithmFromConfig(vip *viper.Viper) (Algorithm, error) {
+	var a Algorithm
+	if vip.IsSet("signature_algorithm") {
+		if err := vip.UnmarshalKey("signature_algorithm", &a); err != nil {
+			return a, fmt.Errorf("invalid signature_algorithm: %w", err)
+		}
+	}
+	return a, a.validate()
+}
+
+// CheckAlgorithm checks a against the signing certificate's public key and
+// returns it with the scheme resolved.
+func CheckAlgorithm(a Algorithm, cert *chilkat.Cert) (Algorithm, error) {
+	if err := a.validate(); err != nil {
+		return a, err
+	}
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		return a, err
+	}
+	return checkAlgorithm(a, leaf)
+}
+
+// checkAlgorithm is CheckAlgorithm for a validated a and the parsed
+// certificate.
+func checkAlgorithm(a Algorithm, leaf *x509.Certificate) (Algorithm, error) {
+	desc, err := describeKey(leaf.PublicKey)
+	if err != nil {
+		return a, fmt.Errorf("certificate '%s': %w", leaf.Subject.CommonName, err)
+	}
+	allowed := map[string][]SignatureScheme{
+		"RSA":     {SchemePKCS1v15, SchemePSS},
+		"EC":      {SchemeECDSA},
+		"Ed25519": {SchemeEd25519},
+	}[desc.family]
+	if a.Scheme == SchemeAuto {
+		a.Scheme = allowed[0]
+	}
+	ok := false
+	for _, s := range allowed {
+		ok = ok || s == a.Scheme
+	}
+	if !ok {
+		return a, fmt.Errorf("%s is not possible with the %s key of certificate '%s'", a, desc, leaf.Subject.CommonName)
+	}
+	if a.Scheme == SchemeEd25519 && a.Hash != "sha512" {
+		return a, fmt.Errorf("Ed25519 signatures require sha512 (RFC 8419), not %s", a.Hash)
+	}
+	return a, nil
+}
+
+// CheckKeySourceAlgorithm opens keys, checks a against its certificate and,
+// for a PKCS#11 source, against the token's mechanisms.
+func CheckKeySourceAlgorithm(keys KeySource, a Algorithm) (Algorithm, error) {
+	cert, err := keys.Cert()
+	if err != nil {
+		return a, err
+	}
+	if a, err = CheckAlgorithm(a, cert); err != nil {
+		return a, fmt.Errorf("%s: %w", keys, err)
+	}
+	if p, ok := keys.(*Pkcs11KeySource); ok {
+		if err := CheckHsmMechanisms(p.LibPath, p.Slot, a, cert); err != nil {
+			return a, fmt.Errorf("%s: %w", keys, err)
+		}
+	}
+	return a, nil
+}
+
+// ApplyAlgorithm sets the Chilkat SignPdf options for a, which must have
+// been resolved by CheckAlgorithm. EC and Ed25519 keys need no scheme
+// option; Chilkat signs with what the key is.
+func ApplyAlgorithm(jsonOptions *chilkat.JsonObject, a Algorithm) {
+	jsonOptions.UpdateString("hashAlgorithm", a.Hash)
+	switch a.Scheme {
+	case SchemePKCS1v15:
+		jsonOptions.UpdateString("signingAlgorithm", "pkcs")
+	case SchemePSS:
+		jsonOptions.UpdateString("signingAlgorithm", "pss")
+	}
+}
+
+// keyDescription is a public key's place in the matrix.
+type keyDescription struct {
+	family string // RSA, EC or Ed25519
+	bits   int
+	curve  string
+}
+
+func (d keyDescription) String() string {
+	switch d.family {
+	case "RSA":
+		return fmt.Sprintf("RSA %d", d.bits)
+	case "EC":
+		return "EC " + d.curve
+	}
+	return d.family
+}
+
+func describeKey(pub any) (keyDescription, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		d := keyDescription{family: "RSA", bits: k.N.BitLen()}
+		if d.bits < 2048 {
+			return d, fmt.Errorf("RSA key of %d bits is too short (2048 minimum)", d.bits)
+		}
+		return d, nil
+	case *ecdsa.PublicKey:
+		d := keyDescription{family: "EC", bits: k.Curve.Params().BitSize, curve: k.Curve.Params().Name}
+		switch d.curve {
+		case "P-256", "P-384", "P-521":
+			return d, nil
+		}
+		return d, fmt.Errorf("unsupported EC curve %s (use P-256, P-384 or P-521)", d.curve)
+	case ed25519.PublicKey:
+		return keyDescription{family: "Ed25519", bits: 256}, nil
+	}
+	return keyDescription{}, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// ckmEdDSA is CKM_EDDSA (PKCS#11 v3.0), missing from the pkcs11 package.
+const ckmEdDSA = 0x00001057
+
+// hsmMechanisms lists, per scheme and hash, the PKCS#11 mechanisms any one
+// of which lets the token produce the signature: the hash-and-sign
+// mechanism, or the raw one when the digest is computed on the host.
+func hsmMechanisms(a Algorithm) []uint {
+	switch a.Scheme {
+	case SchemePKCS1v15:
+		return []uint{map[string]uint{
+			"sha256": pkcs11.CKM_SHA256_RSA_PKCS,
+			"sha384": pkcs11.CKM_SHA384_RSA_PKCS,
+			"sha512": pkcs11.CKM_SHA512_RSA_PKCS,
+		}[a.Hash], pkcs11.CKM_RSA_PKCS}
+	case SchemePSS:
+		return []uint{map[string]uint{
+			"sha256": pkcs11.CKM_SHA256_RSA_PKCS_PSS,
+			"sha384": pkcs11.CKM_SHA384_RSA_PKCS_PSS,
+			"sha512": pkcs11.CKM_SHA512_RSA_PKCS_PSS,
+		}[a.Hash], pkcs11.CKM_RSA_PKCS_PSS}
+	case SchemeECDSA:
+		return []uint{map[string]uint{
+			"sha256": pkcs11.CKM_ECDSA_SHA256,
+			"sha384": pkcs11.CKM_ECDSA_SHA384,
+			"sha512": pkcs11.CKM_ECDSA_SHA512,
+		}[a.Hash], pkcs11.CKM_ECDSA}
+	case SchemeEd25519:
+		return []uint{ckmEdDSA}
+	}
+	return nil
+}
+
+var mechanismNames = map[uint]string{
+	pkcs11.CKM_RSA_PKCS:            "CKM_RSA_PKCS",
+	pkcs11.CKM_SHA256_RSA_PKCS:     "CKM_SHA256_RSA_PKCS",
+	pkcs11.CKM_SHA384_RSA_PKCS:     "CKM_SHA384_RSA_PKCS",
+	pkcs11.CKM_SHA512_RSA_PKCS:     "CKM_SHA512_RSA_PKCS",
+	pkcs11.CKM_RSA_PKCS_PSS:        "CKM_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA256_RSA_PKCS_PSS: "CKM_SHA256_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA384_RSA_PKCS_PSS: "CKM_SHA384_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA512_RSA_PKCS_PSS: "CKM_SHA512_RSA_PKCS_PSS",
+	pkcs11.CKM_ECDSA:               "CKM_ECDSA",
+	pkcs11.CKM_ECDSA_SHA256:        "CKM_ECDSA_SHA256",
+	pkcs11.CKM_ECDSA_SHA384:        "CKM_ECDSA_SHA384",
+	pkcs11.CKM_ECDSA_SHA512:        "CKM_ECDSA_SHA512",
+	ckmEdDSA:                       "CKM_EDDSA",
+}
+
+// CheckHsmMechanisms asks the PKCS#11 library for the mechanisms of slot
+// and fails unless one of those that can produce a (resolved) is there,
+// allows signing and, for RSA, accepts the certificate's key size. It
+// loads the library on its own; a Chilkat session on the same library may
+// already be open.
+func CheckHsmMechanisms(libPath string, slot int, a Algorithm, cert *chilkat.Cert) error {
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		return err
+	}
+	desc, err := describeKey(leaf.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	ctx := pkcs11.New(libPath)
+	if ctx == nil {
+		return fmt.Errorf("failed to load PKCS11 library '%s'", libPath)
+	}
+	defer ctx.Destroy()
+	// Chilkat may have initialised the library in this process already;
+	// then it also owns finalising it.
+	if err := ctx.Initialize(); err != nil {
+		var p11err pkcs11.Error
+		if !errors.As(err, &p11err) || p11err != pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+			return fmt.Errorf("PKCS11 Initialize failed for '%s': %w", libPath, err)
+		}
+	} else {
+		defer ctx.Finalize()
+	}
+
+	list, err := ctx.GetMechanismList(uint(slot))
+	if err != nil {
+		return fmt.Errorf("failed to list the mechanisms of Slot ID %d: %w", slot, err)
+	}
+	available := make(map[uint]bool, len(list))
+	for _, m := range list {
+		available[m.Mechanism] = true
+	}
+
+	var names []string
+	var problems []string
+	for _, mech := range hsmMechanisms(a) {
+		names = append(names, mechanismNames[mech])
+		if !available[mech] {
+			continue
+		}
+		info, err := ctx.GetMechanismInfo(uint(slot), []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)})
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", mechanismNames[mech], err))
+		case info.Flags&pkcs11.CKF_SIGN == 0:
+			problems = append(problems, mechanismNames[mech]+" cannot sign")
+		case desc.family == "RSA" && info.MaxKeySize > 0 && (uint(desc.bits) < info.MinKeySize || uint(desc.bits) > info.MaxKeySize):
+			problems = append(problems, fmt.Sprintf("%s supports %d-%d bit keys, not %d", mechanismNames[mech], info.MinKeySize, info.MaxKeySize, desc.bits))
+		default:
+			return nil
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s is not usable on Slot ID %d: %s", a, slot, strings.Join(problems, "; "))
+	}
+	return fmt.Errorf("%s is not supported on Slot ID %d (needs one of %s)", a, slot, strings.Join(names, ", "))
+}
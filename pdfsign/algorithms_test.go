@@ -0,0 +1,110 @@
+package pdfsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keyCert issues a certificate for the public key of priv.
+func keyCert(t *testing.T, priv crypto.Signer) *x509.Certificate {
+	t.Helper()
+	ca, caKey := testCert(t, "ca", nil, nil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, priv.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestAlgorithmValidate(t *testing.T) {
+	tests := []struct {
+		in   Algorithm
+		want Algorithm
+		err  string
+	}{
+		{Algorithm{}, Algorithm{Hash: "sha256"}, ""},
+		{Algorithm{Hash: "SHA-384", Scheme: "PSS"}, Algorithm{Hash: "sha384", Scheme: SchemePSS}, ""},
+		{Algorithm{Hash: "sha512", Scheme: "pkcs1"}, Algorithm{Hash: "sha512", Scheme: SchemePKCS1v15}, ""},
+		{Algorithm{Hash: "md5"}, Algorithm{}, "unsupported hash algorithm 'md5'"},
+		{Algorithm{Scheme: "dsa"}, Algorithm{}, "unsupported signature scheme 'dsa'"},
+	}
+	for _, tt := range tests {
+		a := tt.in
+		err := a.validate()
+		switch {
+		case tt.err == "" && (err != nil || a != tt.want):
+			t.Errorf("validate(%+v) = %+v, %v; want %+v", tt.in, a, err, tt.want)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("validate(%+v) = %v, want an error containing %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestCheckAlgorithm(t *testing.T) {
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		in   Algorithm
+		want SignatureScheme
+		err  string
+	}{
+		{"RSA default", rsa2048, Algorithm{}, SchemePKCS1v15, ""},
+		{"RSA-PSS", rsa2048, Algorithm{Hash: "sha384", Scheme: SchemePSS}, SchemePSS, ""},
+		{"RSA with ECDSA", rsa2048, Algorithm{Scheme: SchemeECDSA}, "", "ECDSA with SHA-256 is not possible with the RSA 2048 key"},
+		{"short RSA", rsa1024, Algorithm{}, "", "RSA key of 1024 bits is too short"},
+		{"EC default", p256, Algorithm{Hash: "sha512"}, SchemeECDSA, ""},
+		{"EC with PSS", p256, Algorithm{Scheme: SchemePSS}, "", "is not possible with the EC P-256 key"},
+		{"unsupported curve", p224, Algorithm{}, "", "unsupported EC curve P-224"},
+		{"Ed25519", ed, Algorithm{Hash: "sha512"}, SchemeEd25519, ""},
+		{"Ed25519 with SHA-256", ed, Algorithm{}, "", "Ed25519 signatures require sha512"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.in
+			if err := a.validate(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := checkAlgorithm(a, keyCert(t, tt.key))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("checkAlgorithm: %v", err)
+			case tt.err == "" && got.Scheme != tt.want:
+				t.Fatalf("scheme = %q, want %q", got.Scheme, tt.want)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("checkAlgorithm = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package pdfsign
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// --- Appearance Templates ---
+// A template describes the visible stamp of a signature declaratively, so
+// stamp layouts live in YAML/JSON files next to config.json instead of in
+// appearance.text[i] calls scattered over the mains. Templates are loaded by
+// name, validated as a whole before anything is signed, and rendered into
+// the widget of an unsigned signature field by PrepareAppearance.
+
+// AppearanceTemplate is one stamp layout. Sizes are in PDF points.
+type AppearanceTemplate struct {
+	Name string `json:"name" yaml:"name"`
+	// Width and Height are the stamp size used when a new field is created
+	// for it; an existing field keeps its own rectangle.
+	Width  float64 `json:"width" yaml:"width"`
+	Height float64 `json:"height" yaml:"height"`
+	// Layout places the image relative to the text: text-only, image-left,
+	// image-right, image-top or image-background.
+	Layout  string  `json:"layout" yaml:"layout"`
+	Padding float64 `json:"padding" yaml:"padding"`
+	// Background is a #RRGGBB fill; empty means transparent.
+	Background string `json:"background" yaml:"background"`
+	// Opacity applies to the whole stamp, 0 < opacity <= 1 (default 1).
+	Opacity float64      `json:"opacity" yaml:"opacity"`
+	Border  *BorderStyle `json:"border" yaml:"border"`
+	Font    FontStyle    `json:"font" yaml:"font"`
+	Align   string       `json:"align" yaml:"align"` // left, center, right
+	Image   *ImageStyle  `json:"image" yaml:"image"`
+	Lines   []string     `json:"lines" yaml:"lines"`
+	// DateFormat is a Go time layout for {current_dt}.
+	DateFormat string `json:"dateFormat" yaml:"dateFormat"`
+
+	path string // file the template was loaded from, for relative image paths
+}
+
+// BorderStyle draws a rectangle around the stamp.
+type BorderStyle struct {
+	Width float64 `json:"width" yaml:"width"`
+	Color string  `json:"color" yaml:"color"`
+}
+
+// FontStyle sets the appearance text. Size is the largest size used; text
+// is shrunk down to MinSize to fit the field. Files is the fallback chain of
+// TrueType fonts to embed (e.g. C:/Windows/Fonts/msjh.ttc#0, kaiu.ttf):
+// each character uses the first font that has it, and the standard
+// Helvetica font is the last resort.
+type FontStyle struct {
+	Files       []string `json:"files" yaml:"files"`
+	Size        float64  `json:"size" yaml:"size"`
+	MinSize     float64  `json:"minSize" yaml:"minSize"`
+	Color       string   `json:"color" yaml:"color"`
+	LineSpacing float64  `json:"lineSpacing" yaml:"lineSpacing"`
+}
+
+// ImageStyle places a picture (company seal, handwritten signature) in the
+// stamp: a JPEG, a PNG (transparency is kept) or an SVG file. Path may be
+// left empty when every signer supplies a signature image. Ratio is the
+// share of the stamp the image area takes for the image-left/right/top
+// layouts. DPI caps the resolution raster images are embedded at (default
+// 300); larger images are downsampled.
+type ImageStyle struct {
+	Path    string  `json:"path" yaml:"path"`
+	Opacity float64 `json:"opacity" yaml:"opacity"`
+	Ratio   float64 `json:"ratio" yaml:"ratio"`
+	DPI     float64 `json:"dpi" yaml:"dpi"`
+}
+
+// AppearanceValues fills the placeholders of a template. {cert_cn} comes
+// from the signing certificate and {current_dt} from the signing time.
+// SignatureImage, when set, replaces the template's image with the
+// signer's own (e.g. a captured handwritten signature).
+type AppearanceValues struct {
+	CertCN         string            `mapstructure:"-"`
+	SigningTime    time.Time         `mapstructure:"-"`
+	Reason         string            `mapstructure:"reason"`
+	Location       string            `mapstructure:"location"`
+	ContactInfo    string            `mapstructure:"contact_info"`
+	Custom         map[string]string `mapstructure:"custom"`
+	SignatureImage string            `mapstructure:"signature_image"`
+}
+
+var appearanceLayouts = map[string]bool{
+	"text-only":        true,
+	"image-left":       true,
+	"image-right":      true,
+	"image-top":        true,
+	"image-background": true,
+}
+
+var appearancePlaceholders = map[string]bool{
+	"cert_cn":      true,
+	"current_dt":   true,
+	"reason":       true,
+	"location":     true,
+	"contact_info": true,
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_.]+)\}`)
+
+// LoadAppearanceTemplates reads and validates every *.yaml, *.yml and
+// *.json file in dir and returns the templates by name. A file's name
+// (without extension) is used when the template has no name of its own.
+func LoadAppearanceTemplates(dir string) (map[string]*AppearanceTemplate, error) {
+	templates, err := readAppearanceTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		if err := t.validateFile(); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// LoadAppearanceTemplateFile reads and validates a single template file.
+func LoadAppearanceTemplateFile(path string) (*AppearanceTemplate, error) {
+	t, err := readAppearanceTemplate(path)
+	if err != nil {
+		return nil, err
+	}
+	return t, t.validateFile()
+}
+
+// LoadAppearanceTemplateFromConfig reads the config.json keys
+// appearance_templates_dir and appearance_template. It returns nil when no
+// template is configured. Only the selected template is validated, so a
+// template whose fonts are missing on this machine does not block others.
+func LoadAppearanceTemplateFromConfig(vip *viper.Viper) (*AppearanceTemplate, error) {
+	name := vip.GetString("appearance_template")
+	if name == "" {
+		return nil, nil
+	}
+	dir := vip.GetString("appearance_templates_dir")
+	if dir == "" {
+		return nil, errors.New("appearance_template is set but appearance_templates_dir is empty")
+	}
+	templates, err := readAppearanceTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("appearance template '%s' not found in %s", name, dir)
+	}
+	if err := t.validateFile(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func readAppearanceTemplates(dir string) (map[string]*AppearanceTemplate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory '%s': %w", dir, err)
+	}
+	templates := make(map[string]*AppearanceTemplate)
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		t, err := readAppearanceTemplate(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := templates[t.Name]; ok {
+			return nil, fmt.Errorf("template name '%s' is defined twice (in %s and %s)", t.Name, filepath.Base(prev.path), e.Name())
+		}
+		templates[t.Name] = t
+	}
+	return templates, nil
+}
+
+// readAppearanceTemplate decodes a template file without validating it.
+// Unknown keys are rejected, so a misspelt option is not silently ignored.
+func readAppearanceTemplate(path string) (*AppearanceTemplate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template '%s': %w", path, err)
+	}
+	t := &AppearanceTemplate{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(t)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		dec.KnownFields(true)
+		err = dec.Decode(t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template '%s': %w", path, err)
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	t.path = path
+	return t, nil
+}
+
+func (t *AppearanceTemplate) validateFile() error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("template '%s' (%s): %w", t.Name, t.path, err)
+	}
+	return nil
+}
+
+// LoadAppearanceValuesFromConfig reads the config.json key appearance_values
+// ({reason, location, contact_info, custom: {...}}).
+func LoadAppearanceValuesFromConfig(vip *viper.Viper) (AppearanceValues, error) {
+	var v AppearanceValues
+	if err := vip.UnmarshalKey("appearance_values", &v); err != nil {
+		return v, fmt.Errorf("invalid appearance_values: %w", err)
+	}
+	return v, nil
+}
+
+// Validate checks the template and fills in defaults. It does not need any
+// placeholder values, so a broken template is rejected at load time.
+func (t *AppearanceTemplate) Validate() error {
+	if t.Layout == "" {
+		t.Layout = "text-only"
+		if t.Image != nil {
+			t.Layout = "image-left"
+		}
+	}
+	if !appearanceLayouts[t.Layout] {
+		return fmt.Errorf("unknown layout '%s'", t.Layout)
+	}
+	if t.Width < 0 || t.Height < 0 || (t.Width == 0) != (t.Height == 0) {
+		return fmt.Errorf("width and height must both be positive (got %g x %g)", t.Width, t.Height)
+	}
+	if t.Padding < 0 {
+		return fmt.Errorf("padding must not be negative (got %g)", t.Padding)
+	}
+	if t.Opacity == 0 {
+		t.Opacity = 1
+	}
+	if t.Opacity < 0 || t.Opacity > 1 {
+		return fmt.Errorf("opacity must be in (0, 1] (got %g)", t.Opacity)
+	}
+	if t.Background != "" {
+		if _, err := parseColor(t.Background); err != nil {
+			return fmt.Errorf("background: %w", err)
+		}
+	}
+	if b := t.Border; b != nil {
+		if b.Width <= 0 {
+			return fmt.Errorf("border width must be positive (got %g)", b.Width)
+		}
+		if b.Color == "" {
+			b.Color = "#000000"
+		}
+		if _, err := parseColor(b.Color); err != nil {
+			return fmt.Errorf("border color: %w", err)
+		}
+	}
+
+	f := &t.Font
+	if f.Size == 0 {
+		f.Size = 10
+	}
+	if f.MinSize == 0 {
+		f.MinSize = 4
+	}
+	if f.LineSpacing == 0 {
+		f.LineSpacing = 1.2
+	}
+	if f.Color == "" {
+		f.Color = "#000000"
+	}
+	if f.Size < 0 || f.MinSize < 0 || f.MinSize > f.Size {
+		return fmt.Errorf("font size %g / minSize %g are invalid", f.Size, f.MinSize)
+	}
+	if f.LineSpacing < 1 {
+		return fmt.Errorf("font lineSpacing must be at least 1 (got %g)", f.LineSpacing)
+	}
+	if _, err := parseColor(f.Color); err != nil {
+		return fmt.Errorf("font color: %w", err)
+	}
+	for _, file := range f.Files {
+		if _, err := loadTrueType(t.resolvePath(file)); err != nil {
+			return err
+		}
+	}
+
+	switch t.Align {
+	case "":
+		t.Align = "left"
+	case "left", "center", "right":
+	default:
+		return fmt.Errorf("unknown align '%s'", t.Align)
+	}
+
+	if t.Layout == "text-only" {
+		if t.Image != nil {
+			return errors.New("layout text-only does not take an image")
+		}
+	} else {
+		img := t.Image
+		if img == nil {
+			return fmt.Errorf("layout %s needs an image", t.Layout)
+		}
+		if img.Opacity == 0 {
+			img.Opacity = 1
+		}
+		if img.Opacity < 0 || img.Opacity > 1 {
+			return fmt.Errorf("image opacity must be in (0, 1] (got %g)", img.Opacity)
+		}
+		if img.Ratio == 0 {
+			img.Ratio = 0.35
+		}
+		if img.Ratio <= 0 || img.Ratio >= 1 {
+			return fmt.Errorf("image ratio must be in (0, 1) (got %g)", img.Ratio)
+		}
+		if img.DPI == 0 {
+			img.DPI = 300
+		}
+		if img.DPI < 72 {
+			return fmt.Errorf("image dpi must be at least 72 (got %g)", img.DPI)
+		}
+		if img.Path != "" {
+			if _, err := loadStampImage(t.imagePath()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(t.Lines) == 0 && t.Layout != "image-background" && t.Image == nil {
+		return errors.New("template has neither lines nor an image")
+	}
+	for i, line := range t.Lines {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(line, -1) {
+			key := m[1]
+			if appearancePlaceholders[key] {
+				continue
+			}
+			if custom, ok := strings.CutPrefix(key, "custom."); ok && custom != "" {
+				continue
+			}
+			return fmt.Errorf("line %d: unknown placeholder {%s}", i+1, key)
+		}
+	}
+	if t.DateFormat == "" {
+		t.DateFormat = "2006-01-02 15:04:05 -07:00"
+	}
+	return nil
+}
+
+// imagePath resolves image.path relative to the template file.
+func (t *AppearanceTemplate) imagePath() string {
+	return t.resolvePath(t.Image.Path)
+}
+
+// imageFor returns the image to draw for v: the signer's signature image,
+// or else the template's own.
+func (t *AppearanceTemplate) imageFor(v AppearanceValues) (string, error) {
+	if t.Layout == "text-only" {
+		if v.SignatureImage != "" {
+			return "", errors.New("layout text-only does not take a signature image")
+		}
+		return "", nil
+	}
+	if v.SignatureImage != "" {
+		return v.SignatureImage, nil
+	}
+	if t.Image.Path == "" {
+		return "", errors.New("no signature image given and the template has no image.path")
+	}
+	return t.imagePath(), nil
+}
+
+// resolvePath makes a path from the template relative to the template file.
+func (t *AppearanceTemplate) resolvePath(p string) string {
+	if filepath.IsAbs(p) || t.path == "" {
+		return p
+	}
+	return filepath.Join(filepath.Dir(t.path), p)
+}
+
+// fontChain returns fresh per-appearance fonts for the configured files,
+// followed by Helvetica.
+func (t *AppearanceTemplate) fontChain() ([]appearanceFont, error) {
+	var chain []appearanceFont
+	for _, file := range t.Font.Files {
+		tt, err := loadTrueType(t.resolvePath(file))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, newEmbeddedFont(tt))
+	}
+	return append(chain, helvetica), nil
+}
+
+// expandLines substitutes the placeholders. A placeholder without a value
+// is an error: a stamp reading "Reason: " is worse than not signing.
+func (t *AppearanceTemplate) expandLines(v AppearanceValues) ([]string, error) {
+	lines := make([]string, 0, len(t.Lines))
+	for i, line := range t.Lines {
+		var missing []string
+		out := placeholderPattern.ReplaceAllStringFunc(line, func(m string) string {
+			key := m[1 : len(m)-1]
+			var val string
+			switch key {
+			case "cert_cn":
+				val = v.CertCN
+			case "current_dt":
+				if !v.SigningTime.IsZero() {
+					val = v.SigningTime.Format(t.DateFormat)
+				}
+			case "reason":
+				val = v.Reason
+			case "location":
+				val = v.Location
+			case "contact_info":
+				val = v.ContactInfo
+			default:
+				val = v.Custom[strings.TrimPrefix(key, "custom.")]
+			}
+			if val == "" {
+				missing = append(missing, m)
+			}
+			return val
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("line %d: no value for %s", i+1, strings.Join(missing, ", "))
+		}
+		lines = append(lines, out)
+	}
+	return lines, nil
+}
+
+// rgbColor is a DeviceRGB color with components in [0, 1].
+type rgbColor [3]float64
+
+func parseColor(s string) (rgbColor, error) {
+	var c rgbColor
+	h, ok := strings.CutPrefix(s, "#")
+	if !ok || len(h) != 6 {
+		return c, fmt.Errorf("color '%s' is not #RRGGBB", s)
+	}
+	for i := range c {
+		v, err := strconv.ParseUint(h[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return c, fmt.Errorf("color '%s' is not #RRGGBB", s)
+		}
+		c[i] = float64(v) / 255
+	}
+	return c, nil
+}
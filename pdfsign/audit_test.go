@@ -0,0 +1,171 @@
+package pdfsign
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditChain builds a valid log from kinds, "sign" or "seal"; a seal covers
+// the record before it and is made by tsa.
+func auditChain(t *testing.T, tsa *testTsa, kinds ...string) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	for i, kind := range kinds {
+		r := AuditRecord{Seq: int64(i + 1), Type: kind, Time: time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC)}
+		if kind == AuditSign {
+			r.Input, r.Result = "in.pdf", AuditOK
+		} else {
+			r.Sealed = int64(i)
+		}
+		records = append(records, r)
+	}
+	return rechain(t, tsa, records, 0)
+}
+
+// rechain recomputes prev_hash, hash and the seal tokens from index from on,
+// the way someone rewriting the log would.
+func rechain(t *testing.T, tsa *testTsa, records []AuditRecord, from int) []AuditRecord {
+	t.Helper()
+	for i := from; i < len(records); i++ {
+		r := &records[i]
+		r.PrevHash = ""
+		if i > 0 {
+			r.PrevHash = records[i-1].Hash
+		}
+		if r.Type == AuditSeal {
+			digest, err := hex.DecodeString(records[r.Sealed-1].Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.TimestampToken = tsa.token(t, digest)
+		}
+		hash, err := r.computeHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Hash = hash
+	}
+	return records
+}
+
+func writeAuditLog(t *testing.T, records []AuditRecord) string {
+	t.Helper()
+	var b strings.Builder
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	tsa := newTestTsa(t)
+	forger := newTestTsa(t)
+	trust := NewTrustStore()
+	trust.AddAnchor(TsaTrust, tsa.root)
+	log := func() []AuditRecord { return auditChain(t, tsa, AuditSign, AuditSign, AuditSeal, AuditSign) }
+
+	tests := []struct {
+		name       string
+		records    []AuditRecord
+		trust      *TrustStore
+		problem    string // expected in one of the problems; empty for none
+		unanchored int
+	}{
+		{name: "intact", records: log(), trust: trust},
+		{name: "seals without anchors", records: log(), unanchored: 1},
+		{name: "edited", records: func() []AuditRecord {
+			r := log()
+			r[1].Input = "other.pdf"
+			return r
+		}(), trust: trust, problem: "the record was edited"},
+		{name: "edited and rechained", records: func() []AuditRecord {
+			r := log()
+			r[1].Input = "other.pdf"
+			hash, _ := r[1].computeHash()
+			r[1].Hash = hash
+			r[2].PrevHash = hash
+			hash, _ = r[2].computeHash()
+			r[2].Hash = hash
+			r[3].PrevHash = hash
+			r[3].Hash, _ = r[3].computeHash()
+			return r
+		}(), trust: trust, problem: "invalid seal: timestamp token covers a different digest"},
+		{name: "gap", records: func() []AuditRecord {
+			r := log()
+			return append(r[:1:1], r[2:]...)
+		}(), trust: trust, problem: "record 2 is missing"},
+		{name: "reordered", records: func() []AuditRecord {
+			r := log()
+			r[0], r[1] = r[1], r[0]
+			return r
+		}(), trust: trust, problem: "record duplicated or reordered"},
+		{name: "forged seal", records: func() []AuditRecord {
+			r := log()
+			r[0].Input = "other.pdf"
+			return rechain(t, forger, r, 0)
+		}(), trust: trust, problem: "does not chain to a TSA anchor"},
+		// Without anchors a forged rewrite verifies, but is not OK.
+		{name: "forged seal without anchors", records: func() []AuditRecord {
+			r := log()
+			r[0].Input = "other.pdf"
+			return rechain(t, forger, r, 0)
+		}(), unanchored: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, err := VerifyAuditLog(writeAuditLog(t, tt.records), tt.trust)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := FormatAuditReport(rep)
+			if rep.Unanchored != tt.unanchored {
+				t.Errorf("%d unanchored seals, want %d:\n%s", rep.Unanchored, tt.unanchored, out)
+			}
+			if tt.problem == "" {
+				if len(rep.Problems) > 0 {
+					t.Fatalf("problems in an intact log:\n%s", out)
+				}
+				if ok := tt.unanchored == 0; rep.OK() != ok {
+					t.Fatalf("OK() = %v, want %v:\n%s", rep.OK(), ok, out)
+				}
+				return
+			}
+			if rep.OK() || !strings.Contains(out, tt.problem) {
+				t.Fatalf("report does not show %q:\n%s", tt.problem, out)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogCounts(t *testing.T) {
+	tsa := newTestTsa(t)
+	trust := NewTrustStore()
+	trust.AddAnchor(TsaTrust, tsa.root)
+	records := auditChain(t, tsa, AuditSign, AuditSign, AuditSeal, AuditSign)
+	records[1].Result = AuditFailed
+	records = rechain(t, tsa, records, 1)
+	rep, err := VerifyAuditLog(writeAuditLog(t, records), trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || rep.Records != 3 || rep.Failed != 1 || rep.Seals != 1 || rep.LastSealed != 2 || rep.Unsealed != 1 {
+		t.Fatalf("report = %+v", rep)
+	}
+	if !strings.HasSuffix(FormatAuditReport(rep), "audit log OK\n") {
+		t.Fatalf("report:\n%s", FormatAuditReport(rep))
+	}
+}
@@ -0,0 +1,196 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkat"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Batch Signing ---
+// A batch manifest lists the documents one key signs in a run, each with
+// its own output path, optional target field and signature metadata:
+//
+//	{
+//	    "defaults": {"location": "Taipei", "commitment": "proof-of-approval"},
+//	    "documents": [
+//	        {"input": "in/a.pdf", "output": "out/a.pdf", "field": "Approver"},
+//	        {"input": "in/b.pdf", "output": "out/b.pdf", "info": {"name": "Jane Doe"}}
+//	    ]
+//	}
+//
+// Relative paths are relative to the manifest file. A failing document does
+// not stop the batch; its error is reported in its BatchResult.
+
+// BatchManifest is a parsed batch manifest file.
+type BatchManifest struct {
+	// Defaults fills the fields each document's Info leaves empty.
+	Defaults  SignatureInfo   `json:"defaults"`
+	Documents []BatchDocument `json:"documents"`
+}
+
+// BatchDocument is one document of a batch.
+type BatchDocument struct {
+	Input  string        `json:"input"`
+	Output string        `json:"output"`
+	Field  string        `json:"field,omitempty"` // unsigned field to fill; empty adds a new signature
+	Info   SignatureInfo `json:"info"`
+}
+
+// BatchResult is the outcome of one BatchDocument.
+type BatchResult struct {
+	DocID  string // doc_id of the document's log lines
+	Input  string
+	Output string
+	Space  SigSpaceReport
+	Error  error
+}
+
+// LoadBatchManifest reads and validates a JSON batch manifest. Unknown keys
+// are rejected, so a misspelt option is not silently ignored.
+func LoadBatchManifest(path string) (*BatchManifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch manifest '%s': %w", path, err)
+	}
+	m := &BatchManifest{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("batch manifest '%s': %w", path, err)
+	}
+	if len(m.Documents) == 0 {
+		return nil, fmt.Errorf("batch manifest '%s' has no documents", path)
+	}
+
+	dir := filepath.Dir(path)
+	outputs := make(map[string]int)
+	for i := range m.Documents {
+		d := &m.Documents[i]
+		if d.Input == "" || d.Output == "" {
+			return nil, fmt.Errorf("batch manifest '%s': document %d needs input and output", path, i+1)
+		}
+		if !filepath.IsAbs(d.Input) {
+			d.Input = filepath.Join(dir, d.Input)
+		}
+		if !filepath.IsAbs(d.Output) {
+			d.Output = filepath.Join(dir, d.Output)
+		}
+		if prev, ok := outputs[d.Output]; ok {
+			return nil, fmt.Errorf("batch manifest '%s': documents %d and %d both write '%s'", path, prev, i+1, d.Output)
+		}
+		outputs[d.Output] = i + 1
+		if err := d.Info.Merge(m.Defaults).Validate(); err != nil {
+			return nil, fmt.Errorf("batch manifest '%s': document %d: %w", path, i+1, err)
+		}
+	}
+	return m, nil
+}
+
+// BatchOptions controls SignBatch.
+type BatchOptions struct {
+	// Configure sets the signing options shared by all documents
+	// (subFilter, timestamp, appearance, ...). Field routing and signature
+	// metadata are added per document.
+	Configure func(jsonOptions *chilkat.JsonObject)
+	// Policy, when set, makes every signature policy-based (PAdES-EPES).
+	Policy *SignaturePolicy
+	// Algorithm, when set, is checked against the key (and HSM mechanisms)
+	// before the first document is signed.
+	Algorithm *Algorithm
+	// Audit, when set, gets a record for every document. A document whose
+	// record cannot be written is reported as failed.
+	Audit *AuditLog
+}
+
+// SignBatch signs every document of m with keys.
+func SignBatch(m *BatchManifest, keys KeySource, opts BatchOptions) ([]BatchResult, error) {
+	if keys == nil {
+		return nil, errors.New("batch has no key source")
+	}
+	if opts.Policy != nil {
+		if err := opts.Policy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := keys.Cert()
+	if err != nil {
+		return nil, err
+	}
+	var alg *Algorithm
+	if opts.Algorithm != nil {
+		resolved, err := CheckKeySourceAlgorithm(keys, *opts.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		alg = &resolved
+	}
+	log, jobID := JobLogger("batch")
+	log.Info("batch started", "documents", len(m.Documents), "key_source", keys.String())
+	results := make([]BatchResult, 0, len(m.Documents))
+	failed := 0
+	for _, d := range m.Documents {
+		docLog, docID := DocumentLogger(log, d.Input)
+		res := BatchResult{DocID: docID, Input: d.Input, Output: d.Output}
+		var data, signed []byte
+		data, signed, res.Space, res.Error = signBatchDocument(d, cert, signParams{
+			field:     d.Field,
+			configure: opts.Configure,
+			info:      d.Info.Merge(m.Defaults),
+			policy:    opts.Policy,
+			algorithm: alg,
+			log:       docLog,
+		})
+		err := opts.Audit.Append(AuditEntry{
+			JobID: jobID, DocID: docID,
+			Input: d.Input, Output: d.Output, Field: d.Field,
+			InputData: data, OutputData: signed,
+			Keys: keys, Cert: cert, Err: res.Error,
+		})
+		if err != nil && res.Error == nil {
+			res.Error = fmt.Errorf("signed, but the audit record failed: %w", err)
+		}
+		if res.Error != nil {
+			failed++
+			LogFailure(docLog, "document failed", res.Error)
+		} else {
+			docLog.Info("document signed", "output", d.Output, "sig_space_used", res.Space.Used, "sig_space_reserved", res.Space.Reserved)
+		}
+		results = append(results, res)
+	}
+	log.Info("batch finished", "signed", len(results)-failed, "failed", failed)
+	return results, nil
+}
+
+// signBatchDocument returns the input as read and, when it was written,
+// the signed output, for the audit record.
+func signBatchDocument(d BatchDocument, cert *chilkat.Cert, sp signParams) ([]byte, []byte, SigSpaceReport, error) {
+	var space SigSpaceReport
+	data, err := os.ReadFile(d.Input)
+	if err != nil {
+		return nil, nil, space, err
+	}
+	if d.Field != "" {
+		doc, err := parsePdf(data)
+		if err != nil {
+			return data, nil, space, err
+		}
+		if err := checkUnsignedField(doc, d.Field); err != nil {
+			return data, nil, space, err
+		}
+	}
+	signed, space, err := signPdfData(data, cert, sp)
+	if err != nil {
+		return data, nil, space, err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.Output), 0755); err != nil {
+		return data, nil, space, err
+	}
+	if err := os.WriteFile(d.Output, signed, 0644); err != nil {
+		return data, nil, space, err
+	}
+	return data, signed, space, nil
+}
@@ -0,0 +1,351 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// --- Minimal CMS (PKCS#7) Parsing ---
+// Chilkat verifies the signature bytes for us, but it does not expose the
+// certificates, timestamp token or signed attributes inside /Contents in a
+// form we can reason about. This file decodes just enough of the SignedData
+// structure to get at them.
+
+var (
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTimeStampToken  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidTSTInfo         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSigningTimeAttr = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidContentTypeAttr = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidKeyRSA          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidKeyECDSA        = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+)
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type cmsSignedDataASN1 struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsSignerInfoASN1 struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint struct {
+		HashAlgorithm pkix.AlgorithmIdentifier
+		HashedMessage []byte
+	}
+	SerialNumber *big.Int
+	GenTime      time.Time `asn1:"generalized"`
+}
+
+// cmsSignedData is the decoded subset of a CMS SignedData we care about.
+type cmsSignedData struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte
+	Certificates []*x509.Certificate
+	CRLs         [][]byte
+	SignerInfos  []cmsSignerInfo
+}
+
+// cmsSignerInfo is a decoded SignerInfo with its attributes split out.
+type cmsSignerInfo struct {
+	sid                asn1.RawValue
+	DigestAlgorithm    asn1.ObjectIdentifier
+	SignatureAlgorithm asn1.ObjectIdentifier
+	SignedAttrs        []cmsAttribute
+	UnsignedAttrs      []cmsAttribute
+	// kept for verifySignerInfo
+	rawSignedAttrs []byte
+	sigAlg         pkix.AlgorithmIdentifier
+	signature      []byte
+}
+
+// parseCMS decodes a DER ContentInfo wrapping a SignedData. PDF signature
+// /Contents are zero padded, so trailing bytes after the structure are ignored.
+func parseCMS(der []byte) (*cmsSignedData, error) {
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("failed to parse CMS ContentInfo: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("CMS content type %s is not SignedData", ci.ContentType)
+	}
+
+	var sd cmsSignedDataASN1
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("failed to parse CMS SignedData: %w", err)
+	}
+
+	out := &cmsSignedData{EContentType: sd.EncapContentInfo.EContentType}
+	if len(sd.EncapContentInfo.EContent.Bytes) > 0 {
+		var octets []byte
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &octets); err == nil {
+			out.EContent = octets
+		}
+	}
+
+	certs, err := parseCertificateSet(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	out.Certificates = certs
+
+	for rest := sd.CRLs.Bytes; len(rest) > 0; {
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CMS CRL set: %w", err)
+		}
+		// OtherRevocationInfoFormat is [1] tagged; only plain CRLs are kept.
+		if raw.Class == asn1.ClassUniversal {
+			out.CRLs = append(out.CRLs, raw.FullBytes)
+		}
+	}
+
+	for rest := sd.SignerInfos.Bytes; len(rest) > 0; {
+		var si cmsSignerInfoASN1
+		var err error
+		rest, err = asn1.Unmarshal(rest, &si)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CMS SignerInfo: %w", err)
+		}
+		signed, err := parseAttributes(si.SignedAttrs.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		unsigned, err := parseAttributes(si.UnsignedAttrs.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		out.SignerInfos = append(out.SignerInfos, cmsSignerInfo{
+			sid:                si.SID,
+			DigestAlgorithm:    si.DigestAlgorithm.Algorithm,
+			SignatureAlgorithm: si.SignatureAlgorithm.Algorithm,
+			SignedAttrs:        signed,
+			UnsignedAttrs:      unsigned,
+			rawSignedAttrs:     si.SignedAttrs.FullBytes,
+			sigAlg:             si.SignatureAlgorithm,
+			signature:          si.Signature,
+		})
+	}
+	return out, nil
+}
+
+// parseCertificateSet decodes the contents of a CertificateSet (or any run of
+// concatenated DER certificates), skipping the tagged non-X.509 choices.
+func parseCertificateSet(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := b; len(rest) > 0; {
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate set: %w", err)
+		}
+		if raw.Class != asn1.ClassUniversal {
+			continue
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse embedded certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func parseAttributes(b []byte) ([]cmsAttribute, error) {
+	var attrs []cmsAttribute
+	for rest := b; len(rest) > 0; {
+		var attr cmsAttribute
+		var err error
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CMS attribute: %w", err)
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// findAttribute returns the first value of the attribute with the given type.
+func findAttribute(attrs []cmsAttribute, oid asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	for _, a := range attrs {
+		if !a.Type.Equal(oid) {
+			continue
+		}
+		var v asn1.RawValue
+		if _, err := asn1.Unmarshal(a.Values.Bytes, &v); err != nil {
+			return asn1.RawValue{}, false
+		}
+		return v, true
+	}
+	return asn1.RawValue{}, false
+}
+
+// signerCert finds the certificate identified by the SignerInfo's sid among
+// the SignedData's certificates.
+func (sd *cmsSignedData) signerCert(si cmsSignerInfo) (*x509.Certificate, error) {
+	if si.sid.Class == asn1.ClassContextSpecific && si.sid.Tag == 0 {
+		// subjectKeyIdentifier [0]
+		for _, c := range sd.Certificates {
+			if bytes.Equal(c.SubjectKeyId, si.sid.Bytes) {
+				return c, nil
+			}
+		}
+		return nil, errors.New("signer certificate (by subject key identifier) not embedded in CMS")
+	}
+	var ias cmsIssuerAndSerial
+	if _, err := asn1.Unmarshal(si.sid.FullBytes, &ias); err != nil {
+		return nil, fmt.Errorf("failed to parse signer identifier: %w", err)
+	}
+	for _, c := range sd.Certificates {
+		if c.SerialNumber.Cmp(ias.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) {
+			return c, nil
+		}
+	}
+	return nil, errors.New("signer certificate (by issuer and serial) not embedded in CMS")
+}
+
+// timestampToken returns the RFC 3161 token from the first SignerInfo's
+// unsigned attributes, or nil if the signature is not timestamped.
+func (sd *cmsSignedData) timestampToken() (*cmsSignedData, error) {
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil
+	}
+	v, ok := findAttribute(sd.SignerInfos[0].UnsignedAttrs, oidTimeStampToken)
+	if !ok {
+		return nil, nil
+	}
+	return parseCMS(v.FullBytes)
+}
+
+// tstInfo decodes the TSTInfo carried by a timestamp token.
+func (sd *cmsSignedData) tstInfo() (*tstInfo, error) {
+	if !sd.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("timestamp token content type %s is not TSTInfo", sd.EContentType)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(sd.EContent, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse TSTInfo: %w", err)
+	}
+	return &info, nil
+}
+
+// verifySignerInfo checks the signature of si by signer over the signed
+// attributes and that their message digest matches the encapsulated
+// content. It is used for content Chilkat does not verify for us: the
+// timestamp tokens of signatures and of the audit log seals.
+func (sd *cmsSignedData) verifySignerInfo(si cmsSignerInfo, signer *x509.Certificate) error {
+	if len(si.rawSignedAttrs) == 0 {
+		return errors.New("CMS SignerInfo has no signed attributes")
+	}
+	h, ok := hashFromOID(si.DigestAlgorithm)
+	if !ok {
+		return fmt.Errorf("unsupported CMS digest algorithm %s", si.DigestAlgorithm)
+	}
+	v, ok := findAttribute(si.SignedAttrs, oidMessageDigest)
+	if !ok {
+		return errors.New("CMS SignerInfo has no message digest")
+	}
+	var digest []byte
+	if _, err := asn1.Unmarshal(v.FullBytes, &digest); err != nil {
+		return fmt.Errorf("invalid CMS message digest: %w", err)
+	}
+	if !bytes.Equal(digest, hashBytes(h, sd.EContent)) {
+		return errors.New("CMS message digest does not match the content")
+	}
+	if v, ok := findAttribute(si.SignedAttrs, oidContentTypeAttr); ok {
+		var ct asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(v.FullBytes, &ct); err != nil || !ct.Equal(sd.EContentType) {
+			return errors.New("CMS content type attribute does not match the content")
+		}
+	}
+
+	alg, err := cmsSignatureAlgorithm(h, si.sigAlg)
+	if err != nil {
+		return err
+	}
+	// The signature covers the attributes as a SET, not as the [0]
+	// IMPLICIT field they are stored in.
+	signed := append([]byte{0x31}, si.rawSignedAttrs[1:]...)
+	if err := signer.CheckSignature(alg, signed, si.signature); err != nil {
+		return fmt.Errorf("CMS signature invalid: %w", err)
+	}
+	return nil
+}
+
+// cmsSignatureAlgorithm also accepts the bare key algorithms (rsaEncryption,
+// ecPublicKey) CMS allows in SignerInfo, taking the hash from the digest
+// algorithm.
+func cmsSignatureAlgorithm(h crypto.Hash, alg pkix.AlgorithmIdentifier) (x509.SignatureAlgorithm, error) {
+	switch {
+	case alg.Algorithm.Equal(oidKeyRSA):
+		switch h {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA, nil
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case alg.Algorithm.Equal(oidKeyECDSA):
+		switch h {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1, nil
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	default:
+		return signatureAlgorithm(alg)
+	}
+	return 0, fmt.Errorf("unsupported CMS signature algorithm %s with %s", alg.Algorithm, h)
+}
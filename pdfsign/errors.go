@@ -0,0 +1,158 @@
+package pdfsign
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// --- Errors ---
+// A failed Chilkat call only says what went wrong in LastErrorText, a
+// verbose, indented log of the whole call. ChilkatError keeps that log as
+// Detail and classifies it into one of the sentinel errors below, so callers
+// test errors.Is(err, ErrPinIncorrect) instead of comparing messages, and a
+// service can map the kind to a status code or a retry.
+//
+// The classification matches Chilkat's and the PKCS#11 library's wording
+// (CKR_* codes, "Did not find cert matching criteria", ...); a log that
+// matches nothing known gets no kind and is still reported with its
+// Detail.
+
+var (
+	ErrKeyNotFound      = errors.New("no certificate with a private key found")
+	ErrPinIncorrect     = errors.New("incorrect PIN or password")
+	ErrPinLocked        = errors.New("PIN is locked")
+	ErrTsaUnavailable   = errors.New("timestamp authority unavailable")
+	ErrOcspFailed       = errors.New("OCSP request failed")
+	ErrPdfEncrypted     = errors.New("PDF is encrypted")
+	ErrSigSpaceTooSmall = errors.New("signature does not fit the space reserved for it")
+	// ErrInvalidInput is not a Chilkat failure: the document or the signing
+	// options given to SignDocument cannot be used as they are.
+	ErrInvalidInput = errors.New("invalid document or signing options")
+)
+
+// ChilkatError is a failed Chilkat call.
+type ChilkatError struct {
+	Op     string // what was attempted, e.g. "PKCS11 Login for Slot ID 0"
+	Kind   error  // one of the Err* sentinels, or nil when not recognised
+	Detail string // the full LastErrorText
+}
+
+// NewChilkatError classifies lastErrorText, taken right after op failed.
+func NewChilkatError(op, lastErrorText string) *ChilkatError {
+	return &ChilkatError{Op: op, Kind: classifyChilkatError(lastErrorText), Detail: lastErrorText}
+}
+
+// chilkatError is NewChilkatError with a kind to assume when the log
+// matches nothing known, for calls whose failure means one thing.
+func chilkatError(op, lastErrorText string, fallback error) *ChilkatError {
+	e := NewChilkatError(op, lastErrorText)
+	if e.Kind == nil {
+		e.Kind = fallback
+	}
+	return e
+}
+
+// Error is one line; the full log is in Detail.
+func (e *ChilkatError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("%s failed: %s", e.Op, e.Kind)
+	}
+	if line := chilkatFailureLine(e.Detail); line != "" {
+		return fmt.Sprintf("%s failed: %s", e.Op, line)
+	}
+	return e.Op + " failed"
+}
+
+func (e *ChilkatError) Unwrap() error { return e.Kind }
+
+// ErrorDetail returns the LastErrorText carried by err, or "" when err does
+// not wrap a ChilkatError.
+func ErrorDetail(err error) string {
+	var ce *ChilkatError
+	if errors.As(err, &ce) {
+		return ce.Detail
+	}
+	return ""
+}
+
+// Retryable reports whether err is a failure of a remote service (TSA,
+// OCSP responder) that may succeed when tried again.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrTsaUnavailable) || errors.Is(err, ErrOcspFailed)
+}
+
+// chilkatErrorKinds are checked in order against the whole log; the first
+// kind with a matching (lower case) substring wins. PIN and encryption come
+// first because a failed login or decryption is often followed by
+// "certificate not found" style noise.
+var chilkatErrorKinds = []struct {
+	kind     error
+	patterns []string
+}{
+	{ErrPinLocked, []string{"ckr_pin_locked", "ckr_pin_expired"}},
+	{ErrPinIncorrect, []string{"ckr_pin_incorrect", "ckr_pin_len_range", "ckr_pin_invalid", "invalid password", "incorrect password", "wrong password", "password is incorrect", "mac verification failed"}},
+	{ErrPdfEncrypted, []string{"pdf is encrypted", "encrypted pdf"}},
+	{ErrSigSpaceTooSmall, []string{"not enough space", "signature too large"}},
+	{ErrKeyNotFound, []string{"did not find cert matching criteria", "no certificates with private keys found", "no private key", "ckr_key_handle_invalid", "ckr_object_handle_invalid"}},
+}
+
+// chilkatContextKinds are only matched on lines that also report a
+// failure, since a SignPdf log mentions the TSA, OCSP and allocation
+// settings even when they worked.
+var chilkatContextKinds = []struct {
+	kind     error
+	patterns []string
+}{
+	{ErrSigSpaceTooSmall, []string{"sigallocatesize", "allocated space", "reserved space"}},
+	{ErrTsaUnavailable, []string{"timestamp", "time-stamp", "tsa"}},
+	{ErrOcspFailed, []string{"ocsp"}},
+}
+
+var chilkatFailureWords = []string{"fail", "error", "unable", "could not", "timed out", "timeout", "refused"}
+
+func classifyChilkatError(text string) error {
+	lower := strings.ToLower(text)
+	for _, k := range chilkatErrorKinds {
+		if containsAny(lower, k.patterns) {
+			return k.kind
+		}
+	}
+	for _, line := range strings.Split(lower, "\n") {
+		if !containsAny(line, chilkatFailureWords) {
+			continue
+		}
+		for _, k := range chilkatContextKinds {
+			if containsAny(line, k.patterns) {
+				return k.kind
+			}
+		}
+	}
+	return nil
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// chilkatFailureLine picks the line of a LastErrorText log that states the
+// failure, skipping the method/context headers ("SignPdf:", "DllDate: ...").
+func chilkatFailureLine(text string) string {
+	var last string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") || line == "Failed." || line == "--" {
+			continue
+		}
+		if containsAny(strings.ToLower(line), chilkatFailureWords) {
+			return line
+		}
+		last = line
+	}
+	return last
+}
@@ -0,0 +1,87 @@
+package pdfsign
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyChilkatError(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		kind error
+	}{
+		{"pin incorrect", "PKCS11 Login:\n  C_Login: CKR_PIN_INCORRECT\n  Failed.", ErrPinIncorrect},
+		{"pin locked", "C_Login: CKR_PIN_LOCKED", ErrPinLocked},
+		// The login failure wins over the noise that follows it.
+		{"pin before key", "CKR_PIN_INCORRECT\nDid not find cert matching criteria", ErrPinIncorrect},
+		{"pfx password", "LoadPfxFile:\n  Invalid password for PFX.\n", ErrPinIncorrect},
+		{"key not found", "FindCert:\n  Did not find cert matching criteria.\n", ErrKeyNotFound},
+		{"encrypted", "LoadFile:\n  PDF is encrypted.\n", ErrPdfEncrypted},
+		{"sig space", "SignPdf:\n  Not enough space for the signature.\n", ErrSigSpaceTooSmall},
+		{"tsa", "SignPdf:\n  timestampToken:\n    Failed to connect to TSA server.\n", ErrTsaUnavailable},
+		{"ocsp", "SignPdf:\n  ocspRequest:\n    OCSP responder timed out.\n", ErrOcspFailed},
+		// A successful TSA or OCSP step mentioned in a log is no failure.
+		{"tsa mentioned", "SignPdf:\n  tsaUrl: http://tsa.example\n  OCSP response status: successful\n  Failed to write output.\n", nil},
+		{"unknown", "SignPdf:\n  Something else.\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyChilkatError(tt.log); got != tt.kind {
+				t.Fatalf("kind = %v, want %v", got, tt.kind)
+			}
+		})
+	}
+}
+
+func TestChilkatErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *ChilkatError
+		want string
+	}{
+		{NewChilkatError("PKCS11 Login", "CKR_PIN_INCORRECT"), "PKCS11 Login failed: incorrect PIN or password"},
+		{NewChilkatError("SignPdf", "SignPdf:\n  DllDate: 2024\n  Could not write the file.\n  Failed."), "SignPdf failed: Could not write the file."},
+		{NewChilkatError("SignPdf", ""), "SignPdf failed"},
+		{chilkatError("setting the signing certificate", "", ErrKeyNotFound), "setting the signing certificate failed: " + ErrKeyNotFound.Error()},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestResultLabel(t *testing.T) {
+	wrapped := func(kind error) error {
+		return fmt.Errorf("signing 'a.pdf': %w", &ChilkatError{Op: "SignPdf", Kind: kind, Detail: "..."})
+	}
+	tests := []struct {
+		err       error
+		label     string
+		retryable bool
+	}{
+		{nil, "ok", false},
+		{fmt.Errorf("%w: no pages", ErrInvalidInput), "invalid_input", false},
+		{wrapped(ErrPdfEncrypted), "invalid_input", false},
+		{wrapped(ErrTsaUnavailable), "tsa_unavailable", true},
+		{wrapped(ErrOcspFailed), "ocsp_failed", true},
+		{wrapped(ErrPinIncorrect), "pin_incorrect", false},
+		{wrapped(ErrPinLocked), "pin_locked", false},
+		{wrapped(ErrKeyNotFound), "key_not_found", false},
+		{wrapped(ErrSigSpaceTooSmall), "sig_space_too_small", false},
+		{wrapped(nil), "error", false},
+		{errors.New("disk full"), "error", false},
+	}
+	for _, tt := range tests {
+		if got := ResultLabel(tt.err); got != tt.label {
+			t.Errorf("ResultLabel(%v) = %q, want %q", tt.err, got, tt.label)
+		}
+		if got := Retryable(tt.err); got != tt.retryable {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.retryable)
+		}
+	}
+	if got := ErrorDetail(wrapped(nil)); got != "..." {
+		t.Errorf("ErrorDetail = %q", got)
+	}
+}
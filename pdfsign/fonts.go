@@ -0,0 +1,84 @@
+package pdfsign
+
+import (
+	"golang.org/x/text/encoding/charmap"
+)
+
+// --- Appearance Fonts ---
+// Stamp text is drawn with a chain of fonts: every character uses the first
+// font of the chain that covers it. The standard Helvetica font needs no
+// embedding and covers WinAnsi (Western European) text only.
+
+// appearanceFont is a font that stamp text can be drawn with.
+type appearanceFont interface {
+	// covers reports whether the font can draw r.
+	covers(r rune) bool
+	// encode returns the string operand for s; every rune of s is covered.
+	encode(s string) []byte
+	// width returns the advance of s in units of the font size.
+	width(s string) float64
+	// object writes the font dictionary; it is called once per appearance,
+	// after all text has been encoded.
+	object(u *pdfUpdate) pdfRef
+}
+
+// standardFont is one of the 14 standard fonts with WinAnsiEncoding.
+type standardFont struct {
+	base   pdfName
+	widths [95]int // ASCII 32..126, in 1/1000 em
+	other  int     // width used for the Latin-1 range
+}
+
+var helvetica = &standardFont{
+	base: "Helvetica",
+	widths: [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space../
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0..9
+		278, 278, 584, 584, 584, 556, 1015, // :..@
+		667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A..M
+		722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N..Z
+		278, 278, 278, 469, 556, 333, // [..`
+		556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a..m
+		556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n..z
+		334, 260, 334, 584, // {..~
+	},
+	other: 556,
+}
+
+func (f *standardFont) covers(r rune) bool {
+	if r < 32 {
+		return false
+	}
+	_, ok := charmap.Windows1252.EncodeRune(r)
+	return ok
+}
+
+func (f *standardFont) encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		b, _ := charmap.Windows1252.EncodeRune(r)
+		out = append(out, b)
+	}
+	return out
+}
+
+func (f *standardFont) width(s string) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += f.widths[r-32]
+		} else {
+			total += f.other
+		}
+	}
+	return float64(total) / 1000
+}
+
+func (f *standardFont) object(u *pdfUpdate) pdfRef {
+	return u.add(pdfDict{
+		"Type":     pdfName("Font"),
+		"Subtype":  pdfName("Type1"),
+		"BaseFont": f.base,
+		"Encoding": pdfName("WinAnsiEncoding"),
+	})
+}
@@ -0,0 +1,252 @@
+package pdfsign
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // registers PNG for image.Decode
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// --- Stamp Images ---
+// A stamp picture (company seal, handwritten signature) is a JPEG, a PNG or
+// an SVG file:
+//   - JPEG is embedded as is (DCTDecode) so no quality is lost;
+//   - PNG is embedded losslessly (FlateDecode) with its alpha channel as a
+//     soft mask, so a signature scanned on a transparent background blends
+//     into the page;
+//   - SVG is converted to PDF paths (see svg.go).
+//
+// Raster images are scaled into the field keeping their aspect ratio, and
+// downsampled when they have more pixels than ImageStyle.DPI needs at the
+// displayed size: a 4000 px phone photo of a signature in a 2 inch field is
+// embedded at 600 px for 300 dpi. Decoded images and their PDF encodings
+// are cached per process, keyed by path, size and modification time, so a
+// batch that signs hundreds of documents with the same signature decodes,
+// resamples and compresses it once.
+
+// stampImage is a decoded picture file; it is shared through the cache.
+type stampImage struct {
+	path          string
+	format        string  // jpeg, png or svg
+	width, height float64 // pixels, or the SVG viewBox size
+	size          int64
+	modTime       time.Time
+	raw           []byte      // JPEG file data
+	pixels        image.Image // jpeg and png
+	svg           *svgImage
+
+	mu      sync.Mutex
+	encoded map[[2]int]*encodedImage // by embedded pixel size
+}
+
+// encodedImage is the PDF form of a stampImage, ready to be written into
+// any number of documents.
+type encodedImage struct {
+	dict  pdfDict
+	data  []byte
+	smask *pdfStream // alpha channel of a PNG
+}
+
+var (
+	stampImagesMu sync.Mutex
+	stampImages   = make(map[string]*stampImage)
+)
+
+// loadStampImage returns the picture at path, decoding it only when the
+// file is new or has changed since it was last loaded.
+func loadStampImage(path string) (*stampImage, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image '%s': %w", path, err)
+	}
+	stampImagesMu.Lock()
+	defer stampImagesMu.Unlock()
+	if img, ok := stampImages[path]; ok && img.size == st.Size() && img.modTime.Equal(st.ModTime()) {
+		return img, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image '%s': %w", path, err)
+	}
+	img, err := decodeStampImage(raw, strings.ToLower(filepath.Ext(path)) == ".svg")
+	if err != nil {
+		return nil, fmt.Errorf("image '%s': %w", path, err)
+	}
+	img.path, img.size, img.modTime = path, st.Size(), st.ModTime()
+	stampImages[path] = img
+	return img, nil
+}
+
+func decodeStampImage(raw []byte, svg bool) (*stampImage, error) {
+	if svg {
+		drawing, err := parseSVG(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &stampImage{format: "svg", width: drawing.width, height: drawing.height, svg: drawing}, nil
+	}
+	pixels, format, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	img := &stampImage{
+		format: format,
+		width:  float64(pixels.Bounds().Dx()),
+		height: float64(pixels.Bounds().Dy()),
+		pixels: pixels,
+	}
+	switch format {
+	case "jpeg":
+		if _, ok := pixels.(*image.CMYK); ok {
+			return nil, fmt.Errorf("CMYK JPEGs are not supported, convert it to RGB")
+		}
+		img.raw = raw
+	case "png":
+	default:
+		return nil, fmt.Errorf("unsupported format %s (JPEG, PNG or SVG)", format)
+	}
+	return img, nil
+}
+
+// object writes the image into u for display in box (points) and returns
+// an XObject that maps the unit square onto the picture.
+func (img *stampImage) object(u *pdfUpdate, box stampBox, dpi float64) (pdfRef, error) {
+	w, h := img.pixelSize(box, dpi)
+	img.mu.Lock()
+	enc, ok := img.encoded[[2]int{w, h}]
+	if !ok {
+		var err error
+		if enc, err = img.encode(w, h); err != nil {
+			img.mu.Unlock()
+			return pdfRef{}, fmt.Errorf("image '%s': %w", img.path, err)
+		}
+		if img.encoded == nil {
+			img.encoded = make(map[[2]int]*encodedImage)
+		}
+		img.encoded[[2]int{w, h}] = enc
+	}
+	img.mu.Unlock()
+
+	dict := copyDict(enc.dict)
+	if enc.smask != nil {
+		dict["SMask"] = u.add(enc.smask)
+	}
+	return u.add(&pdfStream{Dict: dict, Raw: enc.data}), nil
+}
+
+// pixelSize returns the size to embed a raster image at: its own size, or
+// less when that exceeds dpi in box. SVG drawings have no pixel size.
+func (img *stampImage) pixelSize(box stampBox, dpi float64) (int, int) {
+	if img.svg != nil {
+		return 0, 0
+	}
+	w, h := int(img.width), int(img.height)
+	scale := min(box.w/72*dpi/img.width, box.h/72*dpi/img.height)
+	if scale < 1 {
+		w, h = max(1, int(math.Ceil(img.width*scale))), max(1, int(math.Ceil(img.height*scale)))
+	}
+	return w, h
+}
+
+// scaled returns the pixels resampled to w x h (the original when the size
+// is unchanged).
+func (img *stampImage) scaled(w, h int) image.Image {
+	b := img.pixels.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return img.pixels
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img.pixels, b, xdraw.Src, nil)
+	return dst
+}
+
+func (img *stampImage) encode(w, h int) (*encodedImage, error) {
+	if img.svg != nil {
+		content, gstates := img.svg.content()
+		form := pdfDict{
+			"Type":    pdfName("XObject"),
+			"Subtype": pdfName("Form"),
+			"BBox":    pdfArray{0, 0, img.svg.width, img.svg.height},
+			// Scale the drawing to the unit square like an image XObject.
+			"Matrix": pdfArray{1 / img.svg.width, 0, 0, 1 / img.svg.height, 0, 0},
+			"Filter": pdfName("FlateDecode"),
+		}
+		if len(gstates) > 0 {
+			form["Resources"] = pdfDict{"ExtGState": gstates}
+		} else {
+			form["Resources"] = pdfDict{}
+		}
+		return &encodedImage{dict: form, data: deflate(content)}, nil
+	}
+
+	dict := pdfDict{
+		"Type":             pdfName("XObject"),
+		"Subtype":          pdfName("Image"),
+		"Width":            w,
+		"Height":           h,
+		"BitsPerComponent": 8,
+	}
+	gray := img.pixels.ColorModel() == color.GrayModel || img.pixels.ColorModel() == color.Gray16Model
+	dict["ColorSpace"] = pdfName("DeviceRGB")
+	if gray {
+		dict["ColorSpace"] = pdfName("DeviceGray")
+	}
+
+	if img.format == "jpeg" {
+		dict["Filter"] = pdfName("DCTDecode")
+		if b := img.pixels.Bounds(); b.Dx() == w && b.Dy() == h {
+			return &encodedImage{dict: dict, data: img.raw}, nil
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img.scaled(w, h), &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+		return &encodedImage{dict: dict, data: buf.Bytes()}, nil
+	}
+
+	pixels := img.scaled(w, h)
+	b := pixels.Bounds()
+	samples := 3
+	if gray {
+		samples = 1
+	}
+	data := make([]byte, 0, w*h*samples)
+	alpha := make([]byte, 0, w*h)
+	opaque := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(pixels.At(x, y)).(color.NRGBA)
+			if gray {
+				data = append(data, c.R)
+			} else {
+				data = append(data, c.R, c.G, c.B)
+			}
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 0xFF
+		}
+	}
+	dict["Filter"] = pdfName("FlateDecode")
+	enc := &encodedImage{dict: dict, data: deflate(data)}
+	if !opaque {
+		enc.smask = &pdfStream{Dict: pdfDict{
+			"Type":             pdfName("XObject"),
+			"Subtype":          pdfName("Image"),
+			"Width":            w,
+			"Height":           h,
+			"ColorSpace":       pdfName("DeviceGray"),
+			"BitsPerComponent": 8,
+			"Filter":           pdfName("FlateDecode"),
+		}, Raw: deflate(alpha)}
+	}
+	return enc, nil
+}
@@ -0,0 +1,189 @@
+package pdfsign
+
+import (
+	"chilkat"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// --- Key Sources ---
+// A KeySource hands out the Chilkat certificate a signer signs with; the
+// private key stays wherever the source keeps it (PFX file in memory, or an
+// HSM session that must stay open while signing).
+
+// KeySource yields a certificate with an associated private key.
+type KeySource interface {
+	// Cert opens the source if needed and returns the signing certificate.
+	// The certificate is owned by the source.
+	Cert() (*chilkat.Cert, error)
+	// Close releases the certificate and any session.
+	Close()
+	// String describes the source for logs, without secrets.
+	String() string
+}
+
+// PfxKeySource loads the certificate and key from a PKCS#12 file.
+type PfxKeySource struct {
+	Path     string
+	Password string
+	cert     *chilkat.Cert
+	release  func() // of the cert's object count
+}
+
+func (s *PfxKeySource) Cert() (*chilkat.Cert, error) {
+	if s.cert != nil {
+		return s.cert, nil
+	}
+	cert := chilkat.NewCert()
+	if !cert.LoadPfxFile(s.Path, s.Password) {
+		errMsg := cert.LastErrorText()
+		cert.DisposeCert()
+		return nil, NewChilkatError(fmt.Sprintf("loading PFX '%s'", s.Path), errMsg)
+	}
+	if !cert.HasPrivateKey() {
+		cert.DisposeCert()
+		return nil, fmt.Errorf("PFX '%s': %w", s.Path, ErrKeyNotFound)
+	}
+	s.cert, s.release = cert, trackChilkat("Cert")
+	return cert, nil
+}
+
+func (s *PfxKeySource) Close() {
+	if s.cert != nil {
+		s.cert.DisposeCert()
+		s.cert = nil
+		s.release()
+	}
+}
+
+func (s *PfxKeySource) String() string { return "pfx:" + s.Path }
+
+// Pkcs11KeySource logs in to an HSM token and uses the first certificate
+// that has a private key on it.
+type Pkcs11KeySource struct {
+	LibPath  string
+	Slot     int
+	Pin      string
+	UserType int // 1 = normal user
+	// TokenLabel names the token in audit records; the slot selects it.
+	TokenLabel string
+	pkcs11     *chilkat.Pkcs11
+	cert       *chilkat.Cert
+	loggedIn   bool
+	release    []func() // of the object counts
+}
+
+func (s *Pkcs11KeySource) Cert() (*chilkat.Cert, error) {
+	if s.cert != nil {
+		return s.cert, nil
+	}
+	if s.Pin == "" {
+		return nil, errors.New("HSM PIN is empty")
+	}
+	userType := s.UserType
+	if userType == 0 {
+		userType = 1
+	}
+
+	s.pkcs11 = chilkat.NewPkcs11()
+	s.release = append(s.release, trackChilkat("Pkcs11"))
+	s.pkcs11.SetSharedLibPath(s.LibPath)
+	if !s.pkcs11.Initialize() {
+		errMsg := s.pkcs11.LastErrorText()
+		s.Close()
+		return nil, NewChilkatError(fmt.Sprintf("PKCS11 Initialize of '%s'", s.LibPath), errMsg)
+	}
+	if !s.pkcs11.OpenSession(s.Slot, true) {
+		errMsg := s.pkcs11.LastErrorText()
+		s.Close()
+		return nil, NewChilkatError(fmt.Sprintf("PKCS11 OpenSession for Slot ID %d", s.Slot), errMsg)
+	}
+	if !s.pkcs11.Login(userType, s.Pin) {
+		errMsg := s.pkcs11.LastErrorText()
+		s.pkcs11.CloseSession()
+		s.Close()
+		return nil, NewChilkatError(fmt.Sprintf("PKCS11 Login for Slot ID %d", s.Slot), errMsg)
+	}
+	s.loggedIn = true
+	pkcs11Sessions.Inc()
+
+	cert := chilkat.NewCert()
+	if !s.pkcs11.FindCert("privateKey", "", cert) {
+		errMsg := s.pkcs11.LastErrorText()
+		cert.DisposeCert()
+		s.Close()
+		return nil, chilkatError(fmt.Sprintf("finding a certificate with a private key on Slot ID %d", s.Slot), errMsg, ErrKeyNotFound)
+	}
+	if !cert.HasPrivateKey() {
+		cn := cert.SubjectCN()
+		cert.DisposeCert()
+		s.Close()
+		return nil, fmt.Errorf("certificate '%s' on Slot ID %d: %w", cn, s.Slot, ErrKeyNotFound)
+	}
+	s.cert = cert
+	s.release = append(s.release, trackChilkat("Cert"))
+	return cert, nil
+}
+
+func (s *Pkcs11KeySource) Close() {
+	if s.cert != nil {
+		s.cert.DisposeCert()
+		s.cert = nil
+	}
+	if s.pkcs11 == nil {
+		return
+	}
+	if s.loggedIn {
+		s.pkcs11.Logout()
+		s.pkcs11.CloseSession()
+		s.loggedIn = false
+		pkcs11Sessions.Dec()
+	}
+	s.pkcs11.DisposePkcs11()
+	s.pkcs11 = nil
+	for _, release := range s.release {
+		release()
+	}
+	s.release = nil
+}
+
+func (s *Pkcs11KeySource) String() string {
+	return fmt.Sprintf("pkcs11:%s slot %d", s.LibPath, s.Slot)
+}
+
+// CheckKeySource proves that keys can sign now, for readiness checks. It
+// opens the source if needed, asks the HSM for the key again so that a
+// dropped session or a removed key is noticed (and the source reopened),
+// and checks that the certificate is within its validity period.
+func CheckKeySource(keys KeySource) error {
+	if p, ok := keys.(*Pkcs11KeySource); ok && p.cert != nil {
+		if err := p.findKeyAgain(); err != nil {
+			slog.Warn("HSM session lost the signing key, logging in again", "key_source", p.String(), "error", err)
+			p.Close()
+		}
+	}
+	cert, err := keys.Cert()
+	if err != nil {
+		return err
+	}
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("signing certificate '%s' is only valid from %s to %s", leaf.Subject.CommonName,
+			leaf.NotBefore.Format(time.RFC3339), leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (s *Pkcs11KeySource) findKeyAgain() error {
+	cert := chilkat.NewCert()
+	defer cert.DisposeCert()
+	if !s.pkcs11.FindCert("privateKey", "", cert) {
+		return chilkatError(fmt.Sprintf("finding a certificate with a private key on Slot ID %d", s.Slot), s.pkcs11.LastErrorText(), ErrKeyNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package pdfsign
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// --- Logging ---
+// pdfsign and the signing programs log through log/slog. SetupLogging
+// installs the default logger from the "logging" config key:
+//
+//	"logging": {"level": "info", "format": "json", "file": ""}
+//
+// level is debug, info, warn or error; format is json or text; an empty
+// file logs to stderr, so stdout stays free for reports and tables.
+//
+// Every line of a batch, workflow or signing run carries a job_id, and
+// every line about one document a doc_id, so the lines of one document can
+// be picked out of a run that signs hundreds.
+//
+// Attributes named like a secret (pin, password, passphrase, secret) are
+// never written, and the values of such keys found in the configuration are
+// masked wherever they appear in a message, error or Chilkat log.
+//
+// Chilkat's verbose logging is only switched on at debug level (see
+// ChilkatVerbose); otherwise its LastErrorText is only logged when a call
+// fails, attached to the error record as chilkat_log.
+
+const (
+	LogKeyJob   = "job_id"
+	LogKeyDoc   = "doc_id"
+	redacted    = "[REDACTED]"
+	minRedact   = 4 // shorter secret values are only hidden by attribute name
+	logFileMode = 0644
+)
+
+// LogOptions configures NewLogger.
+type LogOptions struct {
+	Level  slog.Level
+	Format string // "json" or "text"
+	File   string // empty for stderr
+}
+
+// LoadLoggingFromConfig reads the optional "logging" key. Without it the
+// defaults are JSON at info level on stderr.
+func LoadLoggingFromConfig(vip *viper.Viper) (LogOptions, error) {
+	opts := LogOptions{Level: slog.LevelInfo, Format: "json"}
+	if level := vip.GetString("logging.level"); level != "" {
+		if err := opts.Level.UnmarshalText([]byte(level)); err != nil {
+			return opts, fmt.Errorf("invalid logging.level '%s': use debug, info, warn or error", level)
+		}
+	}
+	if format := strings.ToLower(vip.GetString("logging.format")); format != "" {
+		if format != "json" && format != "text" {
+			return opts, fmt.Errorf("invalid logging.format '%s': use json or text", format)
+		}
+		opts.Format = format
+	}
+	opts.File = vip.GetString("logging.file")
+	return opts, nil
+}
+
+// SetupLogging installs the logger configured in vip as the slog default,
+// masking the secret values of vip. The returned function closes the log
+// file; it is safe to call when logging goes to stderr.
+func SetupLogging(vip *viper.Viper) (func(), error) {
+	opts, err := LoadLoggingFromConfig(vip)
+	if err != nil {
+		return func() {}, err
+	}
+	var w io.Writer = os.Stderr
+	closer := func() {}
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFileMode)
+		if err != nil {
+			return closer, fmt.Errorf("failed to open log file '%s': %w", opts.File, err)
+		}
+		w = f
+		closer = func() { f.Close() }
+	}
+	slog.SetDefault(NewLogger(w, opts, configSecrets(vip.AllSettings())...))
+	return closer, nil
+}
+
+// NewLogger returns a logger writing to w that masks secrets in every
+// string it writes.
+func NewLogger(w io.Writer, opts LogOptions, secrets ...string) *slog.Logger {
+	r := newRedactor(secrets)
+	ho := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: r.replaceAttr}
+	if opts.Format == "text" {
+		return slog.New(slog.NewTextHandler(w, ho))
+	}
+	return slog.New(slog.NewJSONHandler(w, ho))
+}
+
+// NewCorrelationID returns a random 16 hex digit ID for job_id or doc_id.
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return hex.EncodeToString(b)
+}
+
+// JobLogger returns the default logger with a new job_id and the job kind,
+// and the job_id so audit records can refer to it.
+func JobLogger(kind string) (*slog.Logger, string) {
+	id := NewCorrelationID()
+	return slog.Default().With("job", kind, LogKeyJob, id), id
+}
+
+// DocumentLogger returns log with a new doc_id and the document's path,
+// and the doc_id so results can refer to it.
+func DocumentLogger(log *slog.Logger, path string) (*slog.Logger, string) {
+	id := NewCorrelationID()
+	return log.With(LogKeyDoc, id, "document", path), id
+}
+
+// ChilkatVerbose reports whether Chilkat objects used under log should have
+// verbose logging on. A nil log means the default logger.
+func ChilkatVerbose(log *slog.Logger) bool {
+	if log == nil {
+		log = slog.Default()
+	}
+	return log.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// LogChilkat logs the LastErrorText of a successful Chilkat call at debug
+// level, where ChilkatVerbose has made it a full trace.
+func LogChilkat(log *slog.Logger, op, lastErrorText string) {
+	if ChilkatVerbose(log) {
+		log.Debug(op+" succeeded", "chilkat_log", lastErrorText)
+	}
+}
+
+// LogFailure logs err at error level with its kind, whether retrying may
+// help and, for a Chilkat failure, the full LastErrorText.
+func LogFailure(log *slog.Logger, msg string, err error, args ...any) {
+	args = append(args, "error", err)
+	var ce *ChilkatError
+	if errors.As(err, &ce) {
+		if ce.Kind != nil {
+			args = append(args, "kind", ce.Kind.Error())
+		}
+		args = append(args, "chilkat_log", ce.Detail)
+	}
+	if Retryable(err) {
+		args = append(args, "retryable", true)
+	}
+	log.Error(msg, args...)
+}
+
+// Fatal logs err like LogFailure, or only msg and args when err is nil, and
+// exits with status 1. It is for the main functions of the programs; their
+// deferred calls do not run. A nil log means the default logger.
+func Fatal(log *slog.Logger, msg string, err error, args ...any) {
+	if log == nil {
+		log = slog.Default()
+	}
+	if err != nil {
+		LogFailure(log, msg, err, args...)
+	} else {
+		log.Error(msg, args...)
+	}
+	os.Exit(1)
+}
+
+// --- Redaction ---
+
+var secretKeyWords = []string{"password", "passphrase", "secret"}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return key == "pin" || strings.HasSuffix(key, "_pin") || containsAny(key, secretKeyWords)
+}
+
+// configSecrets collects the non-empty values of secret keys anywhere in
+// settings, including lists of signers.
+func configSecrets(settings map[string]any) []string {
+	var secrets []string
+	var walk func(key string, v any)
+	walk = func(key string, v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, e := range v {
+				walk(k, e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(key, e)
+			}
+		default:
+			if isSecretKey(key) {
+				if s := fmt.Sprint(v); s != "" {
+					secrets = append(secrets, s)
+				}
+			}
+		}
+	}
+	walk("", settings)
+	return secrets
+}
+
+type redactor struct {
+	values *strings.Replacer
+}
+
+func newRedactor(secrets []string) *redactor {
+	var pairs []string
+	for _, s := range secrets {
+		if len(s) >= minRedact {
+			pairs = append(pairs, s, redacted)
+		}
+	}
+	r := &redactor{}
+	if len(pairs) > 0 {
+		r.values = strings.NewReplacer(pairs...)
+	}
+	return r
+}
+
+func (r *redactor) replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if isSecretKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	if r.values == nil {
+		return a
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.values.Replace(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(r.values.Replace(err.Error()))
+		}
+	}
+	return a
+}
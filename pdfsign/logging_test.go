@@ -0,0 +1,57 @@
+package pdfsign
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRedaction(t *testing.T) {
+	vip := viper.New()
+	vip.Set("hsm_pin", "4711-0815")
+	vip.Set("pfx_password", "correct horse")
+	vip.Set("signers", []any{map[string]any{"name": "cfo", "pfx_password": "battery staple"}})
+	vip.Set("auth", map[string]any{"hmac_keys": []any{map[string]any{"id": "erp-1", "secret": "c2VjcmV0IHNlY3JldCBzZWNyZXQ="}}})
+	vip.Set("pin", "123") // too short to mask in text, only by attribute name
+	vip.Set("unsigned_pdf_input_path", "in.pdf")
+
+	for _, format := range []string{"json", "text"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := NewLogger(&buf, LogOptions{Level: slog.LevelDebug, Format: format}, configSecrets(vip.AllSettings())...)
+			log.Info("login with 4711-0815 failed", "document", "in.pdf")
+			log.Warn("retrying", "pfx_password", "anything", "pin", "123", "user_pin", "999999")
+			LogFailure(log, "loading PFX failed",
+				NewChilkatError("loading the PFX", "LoadPfxFile:\n  password: battery staple\n  Failed."),
+				"input", "correct horse.pfx")
+			log.Error("HMAC", "error", errors.New("secret c2VjcmV0IHNlY3JldCBzZWNyZXQ= rejected"))
+
+			out := buf.String()
+			for _, secret := range []string{"4711-0815", "correct horse", "battery staple", "c2VjcmV0IHNlY3JldCBzZWNyZXQ=", "999999", "anything"} {
+				if strings.Contains(out, secret) {
+					t.Errorf("%q written to the log:\n%s", secret, out)
+				}
+			}
+			if !strings.Contains(out, "in.pdf") || strings.Count(out, redacted) < 8 {
+				t.Errorf("log lost more than the secrets:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestChilkatVerbose(t *testing.T) {
+	var buf bytes.Buffer
+	info := NewLogger(&buf, LogOptions{Level: slog.LevelInfo})
+	debug := NewLogger(&buf, LogOptions{Level: slog.LevelDebug})
+	if ChilkatVerbose(info) || !ChilkatVerbose(debug) {
+		t.Fatal("Chilkat verbose logging must follow the debug level")
+	}
+	LogChilkat(info, "SignPdf", "trace")
+	if buf.Len() != 0 {
+		t.Fatalf("LogChilkat wrote at info level: %s", buf.String())
+	}
+}
@@ -0,0 +1,333 @@
+package pdfsign
+
+import (
+	"chilkat"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// --- Modification Detection and Prevention (DocMDP / FieldMDP) ---
+// A certification signature carries a DocMDP level that says what later
+// revisions may do. A field lock (/Lock on the signature field, enforced as
+// FieldMDP) freezes selected form fields once that field is signed, which is
+// what approval workflows need: certify with P=2, then each approver locks
+// only the fields they are responsible for.
+
+// CertificationLevel is the DocMDP /P value of a certification signature.
+type CertificationLevel int
+
+const (
+	// NotCertified produces an ordinary approval signature.
+	NotCertified CertificationLevel = iota
+	// CertifyNoChanges (P=1) allows no changes at all after certifying.
+	CertifyNoChanges
+	// CertifyFormFilling (P=2) allows filling in forms and signing.
+	CertifyFormFilling
+	// CertifyAnnotations (P=3) additionally allows annotations.
+	CertifyAnnotations
+)
+
+func (l CertificationLevel) String() string {
+	switch l {
+	case NotCertified:
+		return "not certified"
+	case CertifyNoChanges:
+		return "P=1 no changes"
+	case CertifyFormFilling:
+		return "P=2 form filling and signing"
+	case CertifyAnnotations:
+		return "P=3 form filling, signing and annotations"
+	}
+	return fmt.Sprintf("CertificationLevel(%d)", int(l))
+}
+
+// ParseCertificationLevel accepts 0-3 or none, no_changes, form_filling and
+// annotations.
+func ParseCertificationLevel(s string) (CertificationLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "none":
+		return NotCertified, nil
+	case "1", "no_changes":
+		return CertifyNoChanges, nil
+	case "2", "form_filling":
+		return CertifyFormFilling, nil
+	case "3", "annotations":
+		return CertifyAnnotations, nil
+	}
+	return NotCertified, fmt.Errorf("invalid certification level '%s' (use 1/no_changes, 2/form_filling or 3/annotations)", s)
+}
+
+// FieldLockAction is the FieldMDP /Action.
+type FieldLockAction string
+
+const (
+	LockAll     FieldLockAction = "All"     // every field in the document
+	LockInclude FieldLockAction = "Include" // only the listed fields
+	LockExclude FieldLockAction = "Exclude" // every field except the listed ones
+)
+
+// FieldLock names the form fields that become read-only once a signature
+// field is signed.
+type FieldLock struct {
+	Action FieldLockAction
+	Fields []string // fully qualified names; unused for LockAll
+}
+
+// ParseFieldLockAction accepts all, include or exclude in any case.
+func ParseFieldLockAction(s string) (FieldLockAction, error) {
+	for _, a := range []FieldLockAction{LockAll, LockInclude, LockExclude} {
+		if strings.EqualFold(strings.TrimSpace(s), string(a)) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("invalid field lock action '%s' (use All, Include or Exclude)", s)
+}
+
+func (l *FieldLock) validate() error {
+	switch l.Action {
+	case LockAll:
+		if len(l.Fields) > 0 {
+			return errors.New("field lock action All does not take a field list")
+		}
+	case LockInclude, LockExclude:
+		if len(l.Fields) == 0 {
+			return fmt.Errorf("field lock action %s needs at least one field name", l.Action)
+		}
+	default:
+		return fmt.Errorf("invalid field lock action '%s'", l.Action)
+	}
+	return nil
+}
+
+// MDPOptions combines the certification level and field lock of one
+// signature.
+type MDPOptions struct {
+	Certification CertificationLevel
+	Lock          *FieldLock
+	// SignatureField is an existing unsigned signature field to sign. It is
+	// required for Include/Exclude locks, which are attached to the field.
+	SignatureField string
+}
+
+// PrepareMDP checks opts against the document and returns the bytes to
+// sign. When a field lock must be attached to SignatureField, the result is
+// data plus an incremental update adding the /Lock dictionary; otherwise it
+// is data unchanged.
+func PrepareMDP(data []byte, opts MDPOptions) ([]byte, error) {
+	if opts.Certification == NotCertified && opts.Lock == nil && opts.SignatureField == "" {
+		return data, nil
+	}
+	if opts.Certification < NotCertified || opts.Certification > CertifyAnnotations {
+		return nil, fmt.Errorf("invalid certification level %d", int(opts.Certification))
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.encrypted() {
+		return nil, fmt.Errorf("%w: cannot be prepared for certification or field locking", ErrPdfEncrypted)
+	}
+
+	fields := doc.formFields()
+	if opts.Certification != NotCertified {
+		// A certification signature must be the first signature.
+		for _, f := range fields {
+			if f.Signed {
+				return nil, fmt.Errorf("cannot certify: document is already signed (field '%s'); certification must be the first signature", f.Name)
+			}
+		}
+	}
+
+	var target *formField
+	if opts.SignatureField != "" {
+		f, ok := doc.findField(opts.SignatureField)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("signature field '%s' not found", opts.SignatureField)
+		case f.Type != "Sig":
+			return nil, fmt.Errorf("field '%s' is not a signature field (/FT /%s)", opts.SignatureField, f.Type)
+		case f.Signed:
+			return nil, fmt.Errorf("signature field '%s' is already signed", opts.SignatureField)
+		}
+		target = &f
+	}
+
+	if opts.Lock == nil {
+		return data, nil
+	}
+	if err := opts.Lock.validate(); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f.Name] = true
+	}
+	for _, name := range opts.Lock.Fields {
+		if !known[name] {
+			return nil, fmt.Errorf("field lock names unknown field '%s'", name)
+		}
+	}
+	if target == nil {
+		if opts.Lock.Action == LockAll {
+			// Chilkat's lockAfterSigning covers this without a prepared field.
+			return data, nil
+		}
+		return nil, fmt.Errorf("field lock action %s requires an existing unsigned signature field", opts.Lock.Action)
+	}
+
+	lock := pdfDict{
+		"Type":   pdfName("SigFieldLock"),
+		"Action": pdfName(opts.Lock.Action),
+	}
+	if len(opts.Lock.Fields) > 0 {
+		names := make(pdfArray, len(opts.Lock.Fields))
+		for i, name := range opts.Lock.Fields {
+			names[i] = pdfTextString(name)
+		}
+		lock["Fields"] = names
+	}
+
+	u := doc.newUpdate()
+	field := copyDict(target.Dict)
+	field["Lock"] = u.add(lock)
+	u.set(target.Ref, field)
+	return u.bytes(), nil
+}
+
+// CheckMDP confirms that a signed PDF carries what opts asked for: the
+// DocMDP level in /Perms and, for a field lock, a signature whose /Reference
+// holds a FieldMDP transform with the lock's action and fields. SignPdf
+// succeeds even when Chilkat leaves the lock out, so its result alone does
+// not prove the fields are locked.
+func CheckMDP(signed []byte, opts MDPOptions) error {
+	if opts.Certification == NotCertified && opts.Lock == nil {
+		return nil
+	}
+	doc, err := parsePdf(signed)
+	if err != nil {
+		return err
+	}
+	if opts.Certification != NotCertified {
+		if got := doc.certificationLevel(); got != opts.Certification {
+			return fmt.Errorf("signed PDF is certified as %s, want %s", got, opts.Certification)
+		}
+	}
+	if opts.Lock == nil {
+		return nil
+	}
+
+	var candidates []formField
+	if opts.SignatureField != "" {
+		f, ok := doc.findField(opts.SignatureField)
+		if !ok || !f.Signed {
+			return fmt.Errorf("signature field '%s' is not signed in the output", opts.SignatureField)
+		}
+		candidates = []formField{f}
+	} else {
+		for _, f := range doc.formFields() {
+			if f.Signed {
+				candidates = append(candidates, f)
+			}
+		}
+	}
+	for _, f := range candidates {
+		if doc.hasFieldMDP(doc.dict(f.Dict["V"]), *opts.Lock) {
+			return nil
+		}
+	}
+	if opts.SignatureField != "" {
+		return fmt.Errorf("signature in field '%s' has no FieldMDP reference for the %s field lock", opts.SignatureField, opts.Lock.Action)
+	}
+	return fmt.Errorf("no signature has a FieldMDP reference for the %s field lock", opts.Lock.Action)
+}
+
+// hasFieldMDP reports whether the signature dictionary sig has a FieldMDP
+// /Reference entry matching lock.
+func (doc *pdfDocument) hasFieldMDP(sig pdfDict, lock FieldLock) bool {
+	for _, ref := range doc.array(sig["Reference"]) {
+		r := doc.dict(ref)
+		if m, _ := doc.resolve(r["TransformMethod"]).(pdfName); m != "FieldMDP" {
+			continue
+		}
+		params := doc.dict(r["TransformParams"])
+		if a, _ := doc.resolve(params["Action"]).(pdfName); a != pdfName(lock.Action) {
+			continue
+		}
+		want := make(map[string]bool, len(lock.Fields))
+		for _, name := range lock.Fields {
+			want[name] = true
+		}
+		got := make(map[string]bool)
+		for _, name := range doc.array(params["Fields"]) {
+			if s, ok := doc.resolve(name).(pdfString); ok {
+				got[decodeTextString(s.Value)] = true
+			}
+		}
+		if len(got) != len(want) {
+			continue
+		}
+		matched := true
+		for name := range want {
+			matched = matched && got[name]
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyMDP sets the Chilkat SignPdf options for opts on jsonOptions.
+func ApplyMDP(jsonOptions *chilkat.JsonObject, opts MDPOptions) {
+	if opts.Certification != NotCertified {
+		jsonOptions.UpdateBool("docMDP.add", true)
+		jsonOptions.UpdateInt("docMDP.accessPermissions", int(opts.Certification))
+	}
+	if opts.SignatureField != "" {
+		jsonOptions.UpdateBool("appearance.fillUnsignedSignatureField", true)
+		jsonOptions.UpdateString("unsignedSignatureField", opts.SignatureField)
+	} else if opts.Lock != nil && opts.Lock.Action == LockAll {
+		jsonOptions.UpdateBool("lockAfterSigning", true)
+	}
+}
+
+// LoadMDPOptionsFromConfig reads the config.json keys certification_level,
+// field_lock_action, field_lock_fields and signature_field. An empty
+// field_lock_action means no lock.
+func LoadMDPOptionsFromConfig(vip *viper.Viper) (MDPOptions, error) {
+	var opts MDPOptions
+	level, err := ParseCertificationLevel(vip.GetString("certification_level"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Certification = level
+	opts.SignatureField = vip.GetString("signature_field")
+	if action := vip.GetString("field_lock_action"); action != "" {
+		a, err := ParseFieldLockAction(action)
+		if err != nil {
+			return opts, err
+		}
+		opts.Lock = &FieldLock{Action: a, Fields: vip.GetStringSlice("field_lock_fields")}
+	}
+	return opts, nil
+}
+
+// String returns a one-line summary for console output.
+func (opts MDPOptions) String() string {
+	parts := []string{"certification: " + opts.Certification.String()}
+	if opts.Lock != nil {
+		lock := "lock: " + string(opts.Lock.Action)
+		if len(opts.Lock.Fields) > 0 {
+			lock += " " + strconv.Quote(strings.Join(opts.Lock.Fields, ", "))
+		}
+		parts = append(parts, lock)
+	}
+	if opts.SignatureField != "" {
+		parts = append(parts, "field: "+opts.SignatureField)
+	}
+	return strings.Join(parts, "; ")
+}
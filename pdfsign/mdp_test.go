@@ -0,0 +1,55 @@
+package pdfsign
+
+import (
+	"strings"
+	"testing"
+)
+
+// signedPdf builds a document whose field Approver is signed with a
+// signature dictionary holding the given /Reference entries; perms is added
+// to the catalog.
+func signedPdf(reference, perms string) []byte {
+	return classicPdf([]string{
+		"<< /Type /Catalog /AcroForm << /Fields [2 0 R 4 0 R] >> " + perms + " >>",
+		"<< /FT /Sig /T (Approver) /V 3 0 R >>",
+		"<< /Type /Sig /Filter /Adobe.PPKLite /Reference [" + reference + "] >>",
+		"<< /FT /Tx /T (Amount) >>",
+	}, "")
+}
+
+func TestCheckMDP(t *testing.T) {
+	const (
+		fieldMDP = "<< /Type /SigRef /TransformMethod /FieldMDP /TransformParams << /Type /TransformParams /Action /Include /Fields [(Amount)] /V /1.2 >> >>"
+		docMDP   = "<< /Type /SigRef /TransformMethod /DocMDP /TransformParams << /Type /TransformParams /P 2 /V /1.2 >> >>"
+	)
+	include := &FieldLock{Action: LockInclude, Fields: []string{"Amount"}}
+	tests := []struct {
+		name string
+		data []byte
+		opts MDPOptions
+		err  string
+	}{
+		{"nothing asked", signedPdf("", ""), MDPOptions{}, ""},
+		{"locked", signedPdf(fieldMDP, ""), MDPOptions{Lock: include, SignatureField: "Approver"}, ""},
+		{"locked, any field", signedPdf(fieldMDP, ""), MDPOptions{Lock: include}, ""},
+		{"lock missing", signedPdf("", ""), MDPOptions{Lock: include, SignatureField: "Approver"}, "FieldMDP reference for the"},
+		{"other action", signedPdf(fieldMDP, ""), MDPOptions{Lock: &FieldLock{Action: LockAll}}, "FieldMDP reference for the"},
+		{"other fields", signedPdf(fieldMDP, ""), MDPOptions{Lock: &FieldLock{Action: LockInclude, Fields: []string{"Amount", "Date"}}}, "FieldMDP reference for the"},
+		{"field not signed", signedPdf(fieldMDP, ""), MDPOptions{Lock: include, SignatureField: "Amount"}, "is not signed"},
+		{"certified and locked", signedPdf(docMDP+" "+fieldMDP, "/Perms << /DocMDP 3 0 R >>"),
+			MDPOptions{Certification: CertifyFormFilling, Lock: include, SignatureField: "Approver"}, ""},
+		{"certification missing", signedPdf(fieldMDP, ""), MDPOptions{Certification: CertifyFormFilling, Lock: include}, "certified as not certified"},
+		{"wrong level", signedPdf(docMDP, "/Perms << /DocMDP 3 0 R >>"), MDPOptions{Certification: CertifyNoChanges}, "certified as P=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckMDP(tt.data, tt.opts)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("CheckMDP: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("CheckMDP = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package pdfsign
+
+import (
+	"chilkattest/metrics"
+	"errors"
+	"time"
+)
+
+// --- Metrics ---
+// What the services cannot see from outside pdfsign: the requests pdfsign
+// sends to TSAs, OCSP responders and CRL distribution points itself (audit
+// seals, preflight), the Chilkat objects alive at a time and the PKCS#11
+// sessions the key sources hold. The TSA and OCSP requests Chilkat sends
+// while signing are inside SignPdf and cannot be timed apart from it; when
+// they fail, the signing fails with ErrTsaUnavailable or ErrOcspFailed,
+// which is what the services count (see ResultLabel). NewSigningError
+// counts these failures by service as well, for every program that signs.
+
+var (
+	remoteRequests = metrics.NewCounter("pdfsign_remote_requests_total",
+		"Requests pdfsign sent to TSAs, OCSP responders and CRL distribution points, by service and result.", "service", "result")
+	remoteDuration = metrics.NewHistogram("pdfsign_remote_request_duration_seconds",
+		"Duration of requests pdfsign sent to TSAs, OCSP responders and CRL distribution points.", nil, "service")
+	chilkatObjects = metrics.NewGauge("pdfsign_chilkat_objects",
+		"Chilkat objects created by pdfsign and not yet disposed, by type.", "type")
+	pkcs11Sessions = metrics.NewGauge("pdfsign_pkcs11_sessions",
+		"PKCS#11 sessions logged in by key sources.")
+	signingRemoteFailures = metrics.NewCounter("pdfsign_signing_remote_failures_total",
+		"Signing operations that failed because a request Chilkat sent to a TSA or OCSP responder failed, by service.", "service")
+)
+
+// NewSigningError is NewChilkatError for a failed SignPdf or
+// AddVerificationInfo. When the log shows that Chilkat's request to a TSA
+// or OCSP responder failed, the failure is counted under "tsa" or "ocsp".
+func NewSigningError(op, lastErrorText string) *ChilkatError {
+	e := NewChilkatError(op, lastErrorText)
+	switch {
+	case errors.Is(e, ErrTsaUnavailable):
+		signingRemoteFailures.Inc("tsa")
+	case errors.Is(e, ErrOcspFailed):
+		signingRemoteFailures.Inc("ocsp")
+	}
+	return e
+}
+
+// observeRemote records a request to service ("tsa", "ocsp" or "crl")
+// started at start.
+func observeRemote(service string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	remoteRequests.Inc(service, result)
+	remoteDuration.Observe(time.Since(start).Seconds(), service)
+}
+
+// trackChilkat counts a new Chilkat object of type kind; call the returned
+// function when it is disposed.
+func trackChilkat(kind string) (disposed func()) {
+	chilkatObjects.Inc(kind)
+	return func() { chilkatObjects.Dec(kind) }
+}
+
+// ResultLabel is a short, fixed name for the outcome of a signing
+// operation, for metric labels: "ok", or the kind of err.
+func ResultLabel(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrInvalidInput), errors.Is(err, ErrPdfEncrypted):
+		return "invalid_input"
+	case errors.Is(err, ErrTsaUnavailable):
+		return "tsa_unavailable"
+	case errors.Is(err, ErrOcspFailed):
+		return "ocsp_failed"
+	case errors.Is(err, ErrPinIncorrect):
+		return "pin_incorrect"
+	case errors.Is(err, ErrPinLocked):
+		return "pin_locked"
+	case errors.Is(err, ErrKeyNotFound):
+		return "key_not_found"
+	case errors.Is(err, ErrSigSpaceTooSmall):
+		return "sig_space_too_small"
+	}
+	return "error"
+}
@@ -0,0 +1,65 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkattest/metrics"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// remoteFailures reads pdfsign_signing_remote_failures_total for service
+// from the metrics text.
+func remoteFailures(t *testing.T, service string) float64 {
+	t.Helper()
+	var buf bytes.Buffer
+	metrics.WriteText(&buf)
+	series := `pdfsign_signing_remote_failures_total{service="` + service + `"} `
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if v, ok := strings.CutPrefix(line, series); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}
+	}
+	return 0
+}
+
+func TestNewSigningError(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     string
+		kind    error
+		service string // "" when nothing is counted
+	}{
+		{"tsa", "SignPdf:\n  Failed to get timestamp from TSA server\n", ErrTsaUnavailable, "tsa"},
+		{"ocsp", "SignPdf:\n  OCSP request failed: connection refused\n", ErrOcspFailed, "ocsp"},
+		{"tsa setting only", "SignPdf:\n  timestampToken.tsaUrl: http://tsa.example\n  Failed to open key\n", nil, ""},
+		{"pin", "CKR_PIN_INCORRECT", ErrPinIncorrect, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsa, ocsp := remoteFailures(t, "tsa"), remoteFailures(t, "ocsp")
+			err := NewSigningError("SignPdf", tt.log)
+			if tt.kind == nil && err.Kind != nil || tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Fatalf("kind = %v, want %v", err.Kind, tt.kind)
+			}
+			wantTsa, wantOcsp := tsa, ocsp
+			switch tt.service {
+			case "tsa":
+				wantTsa++
+			case "ocsp":
+				wantOcsp++
+			}
+			if got := remoteFailures(t, "tsa"); got != wantTsa {
+				t.Fatalf("tsa failures = %v, want %v", got, wantTsa)
+			}
+			if got := remoteFailures(t, "ocsp"); got != wantOcsp {
+				t.Fatalf("ocsp failures = %v, want %v", got, wantOcsp)
+			}
+		})
+	}
+}
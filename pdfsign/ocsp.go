@@ -0,0 +1,318 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// --- Minimal OCSP Response Parsing ---
+// Only what is needed to judge an OCSP response embedded in a PDF (DSS or
+// adbe-revocationInfoArchival) offline: the single responses, the responder
+// and its signature. Verification never sends requests; only the
+// pre-flight check queries responders (preflight.go).
+
+var (
+	oidOcspBasic        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidOcspNoCheck      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+	oidHashSHA1         = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidHashSHA256       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidHashSHA384       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidHashSHA512       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSigSHA1WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSigSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSigSHA384WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSigSHA512WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidSigRSAPSS        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidSigECDSASHA1     = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidSigECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSigECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSigECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidSigEd25519       = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+type ocspResponseASN1 struct {
+	Status asn1.Enumerated
+	Bytes  struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponseASN1 struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseDataASN1 struct {
+	Version     int `asn1:"optional,explicit,default:0,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponseASN1
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspSingleResponseASN1 struct {
+	CertID  ocspCertID
+	Good    asn1.Flag `asn1:"tag:0,optional"`
+	Revoked struct {
+		RevocationTime time.Time       `asn1:"generalized"`
+		Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+	} `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+const (
+	ocspGood    = "good"
+	ocspRevoked = "revoked"
+	ocspUnknown = "unknown"
+)
+
+// ocspResponse is a decoded BasicOCSPResponse.
+type ocspResponse struct {
+	tbs          []byte
+	sigAlg       pkix.AlgorithmIdentifier
+	signature    []byte
+	responderKey []byte // byKey responder ID, or nil
+	responderDN  []byte // raw byName responder ID, or nil
+	ProducedAt   time.Time
+	Certificates []*x509.Certificate
+	Responses    []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID         ocspCertID
+	Status         string
+	RevocationTime time.Time
+	ThisUpdate     time.Time
+	NextUpdate     time.Time
+}
+
+// parseOCSPResponse accepts a full OCSPResponse, as stored in the DSS and in
+// revocationInfoArchival.
+func parseOCSPResponse(der []byte) (*ocspResponse, error) {
+	var resp ocspResponseASN1
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse OCSP response: %w", err)
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("OCSP response status is %d, not successful", resp.Status)
+	}
+	if !resp.Bytes.ResponseType.Equal(oidOcspBasic) {
+		return nil, fmt.Errorf("unsupported OCSP response type %s", resp.Bytes.ResponseType)
+	}
+
+	var basic ocspBasicResponseASN1
+	if _, err := asn1.Unmarshal(resp.Bytes.Response, &basic); err != nil {
+		return nil, fmt.Errorf("failed to parse BasicOCSPResponse: %w", err)
+	}
+	var data ocspResponseDataASN1
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse OCSP ResponseData: %w", err)
+	}
+
+	out := &ocspResponse{
+		tbs:        basic.TBSResponseData.FullBytes,
+		sigAlg:     basic.SignatureAlgorithm,
+		signature:  basic.Signature.RightAlign(),
+		ProducedAt: data.ProducedAt,
+	}
+	switch data.ResponderID.Tag {
+	case 1:
+		out.responderDN = data.ResponderID.Bytes
+	case 2:
+		if _, err := asn1.Unmarshal(data.ResponderID.Bytes, &out.responderKey); err != nil {
+			return nil, fmt.Errorf("invalid OCSP responder key hash: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid OCSP responder ID tag %d", data.ResponderID.Tag)
+	}
+	for _, raw := range basic.Certificates {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in OCSP response: %w", err)
+		}
+		out.Certificates = append(out.Certificates, cert)
+	}
+	for _, r := range data.Responses {
+		single := ocspSingleResponse{
+			CertID:     r.CertID,
+			ThisUpdate: r.ThisUpdate,
+			NextUpdate: r.NextUpdate,
+		}
+		switch {
+		case bool(r.Good):
+			single.Status = ocspGood
+		case bool(r.Unknown):
+			single.Status = ocspUnknown
+		default:
+			single.Status = ocspRevoked
+			single.RevocationTime = r.Revoked.RevocationTime
+		}
+		out.Responses = append(out.Responses, single)
+	}
+	return out, nil
+}
+
+// find returns the single response about cert, issued by issuer.
+func (r *ocspResponse) find(cert, issuer *x509.Certificate) (*ocspSingleResponse, bool) {
+	for i := range r.Responses {
+		id := r.Responses[i].CertID
+		if id.SerialNumber == nil || id.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		h, ok := hashFromOID(id.HashAlgorithm.Algorithm)
+		if !ok {
+			continue
+		}
+		keyBits, err := subjectPublicKeyBits(issuer)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(id.IssuerNameHash, hashBytes(h, issuer.RawSubject)) &&
+			bytes.Equal(id.IssuerKeyHash, hashBytes(h, keyBits)) {
+			return &r.Responses[i], true
+		}
+	}
+	return nil, false
+}
+
+// responder returns the certificate that signed the response, taken from the
+// issuer itself or the candidates, and checks it is authorised: either the
+// issuing CA or a delegated responder with id-kp-OCSPSigning issued by it.
+func (r *ocspResponse) responder(issuer *x509.Certificate, candidates []*x509.Certificate) (*x509.Certificate, error) {
+	all := append([]*x509.Certificate{issuer}, r.Certificates...)
+	all = append(all, candidates...)
+	for _, c := range all {
+		if !r.identifies(c) {
+			continue
+		}
+		if c.Equal(issuer) || (bytes.Equal(c.RawSubject, issuer.RawSubject) && bytes.Equal(c.RawSubjectPublicKeyInfo, issuer.RawSubjectPublicKeyInfo)) {
+			return c, nil
+		}
+		if err := c.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		for _, eku := range c.ExtKeyUsage {
+			if eku == x509.ExtKeyUsageOCSPSigning {
+				return c, nil
+			}
+		}
+		return nil, fmt.Errorf("OCSP responder '%s' lacks id-kp-OCSPSigning", c.Subject.CommonName)
+	}
+	return nil, errors.New("OCSP responder certificate not found or not issued by the certificate's CA")
+}
+
+func (r *ocspResponse) identifies(c *x509.Certificate) bool {
+	if r.responderDN != nil {
+		return bytes.Equal(r.responderDN, c.RawSubject)
+	}
+	bits, err := subjectPublicKeyBits(c)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(r.responderKey, hashBytes(crypto.SHA1, bits))
+}
+
+// checkSignature verifies the response signature with the responder's key.
+func (r *ocspResponse) checkSignature(responder *x509.Certificate) error {
+	alg, err := signatureAlgorithm(r.sigAlg)
+	if err != nil {
+		return err
+	}
+	if err := responder.CheckSignature(alg, r.tbs, r.signature); err != nil {
+		return fmt.Errorf("OCSP response signature invalid: %w", err)
+	}
+	return nil
+}
+
+func subjectPublicKeyBits(c *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(c.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	return spki.PublicKey.RightAlign(), nil
+}
+
+func hashFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	switch {
+	case oid.Equal(oidHashSHA1):
+		return crypto.SHA1, true
+	case oid.Equal(oidHashSHA256):
+		return crypto.SHA256, true
+	case oid.Equal(oidHashSHA384):
+		return crypto.SHA384, true
+	case oid.Equal(oidHashSHA512):
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+func hashBytes(h crypto.Hash, data []byte) []byte {
+	hh := h.New()
+	hh.Write(data)
+	return hh.Sum(nil)
+}
+
+// signatureAlgorithm maps a signature AlgorithmIdentifier to the x509
+// constant used by CheckSignature.
+func signatureAlgorithm(alg pkix.AlgorithmIdentifier) (x509.SignatureAlgorithm, error) {
+	switch oid := alg.Algorithm; {
+	case oid.Equal(oidSigSHA1WithRSA):
+		return x509.SHA1WithRSA, nil
+	case oid.Equal(oidSigSHA256WithRSA):
+		return x509.SHA256WithRSA, nil
+	case oid.Equal(oidSigSHA384WithRSA):
+		return x509.SHA384WithRSA, nil
+	case oid.Equal(oidSigSHA512WithRSA):
+		return x509.SHA512WithRSA, nil
+	case oid.Equal(oidSigECDSASHA1):
+		return x509.ECDSAWithSHA1, nil
+	case oid.Equal(oidSigECDSASHA256):
+		return x509.ECDSAWithSHA256, nil
+	case oid.Equal(oidSigECDSASHA384):
+		return x509.ECDSAWithSHA384, nil
+	case oid.Equal(oidSigECDSASHA512):
+		return x509.ECDSAWithSHA512, nil
+	case oid.Equal(oidSigEd25519):
+		return x509.PureEd25519, nil
+	case oid.Equal(oidSigRSAPSS):
+		var params struct {
+			Hash pkix.AlgorithmIdentifier `asn1:"explicit,tag:0,optional"`
+		}
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return 0, fmt.Errorf("invalid RSASSA-PSS parameters: %w", err)
+		}
+		switch h, _ := hashFromOID(params.Hash.Algorithm); h {
+		case crypto.SHA256:
+			return x509.SHA256WithRSAPSS, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSAPSS, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSAPSS, nil
+		}
+		return 0, errors.New("unsupported RSASSA-PSS hash")
+	}
+	return 0, fmt.Errorf("unsupported signature algorithm %s", alg.Algorithm)
+}
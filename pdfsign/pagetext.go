@@ -0,0 +1,459 @@
+package pdfsign
+
+import (
+	"bytes"
+	"math"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// --- Page Text Positions ---
+// A small content stream interpreter that records where each character is
+// drawn, enough to find an anchor string such as "Signature:" and place a
+// stamp next to it. It follows the text state, the CTM and form XObjects;
+// character codes are mapped through /ToUnicode when the font has one.
+// A form that draws itself is not entered again, and a page expands at most
+// maxFormExpansions forms and records at most maxPageGlyphs characters.
+
+const (
+	maxFormExpansions = 1000
+	maxPageGlyphs     = 200000
+)
+
+// pageGlyph is one character on a page. (x0, y0) is the start of its
+// baseline and (x1, y1) the end, in default user space.
+type pageGlyph struct {
+	r              rune
+	x0, y0, x1, y1 float64
+	size           float64 // font size in user space
+}
+
+// matrix is a PDF transformation matrix [a b c d e f].
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// mul returns m × n (apply m first, then n).
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]
+}
+
+// textFont decodes the strings of one font resource.
+type textFont struct {
+	twoByte      bool
+	toUnicode    map[int][]rune
+	widths       map[int]float64 // in 1/1000 em
+	defaultWidth float64
+}
+
+func (f *textFont) codes(s []byte) []int {
+	var out []int
+	if f.twoByte {
+		for i := 0; i+1 < len(s); i += 2 {
+			out = append(out, int(s[i])<<8|int(s[i+1]))
+		}
+		return out
+	}
+	for _, b := range s {
+		out = append(out, int(b))
+	}
+	return out
+}
+
+func (f *textFont) runes(code int) []rune {
+	if r, ok := f.toUnicode[code]; ok {
+		return r
+	}
+	if f.twoByte {
+		return []rune{'�'}
+	}
+	return []rune{charmap.Windows1252.DecodeByte(byte(code))}
+}
+
+func (f *textFont) width(code int) float64 {
+	if w, ok := f.widths[code]; ok {
+		return w
+	}
+	return f.defaultWidth
+}
+
+func (doc *pdfDocument) loadTextFont(obj pdfObject) *textFont {
+	d := doc.dict(obj)
+	f := &textFont{widths: make(map[int]float64), defaultWidth: 500}
+	if d == nil {
+		return f
+	}
+	if d["Subtype"] == pdfName("Type0") {
+		f.twoByte = true
+		f.defaultWidth = 1000
+		if desc := doc.array(d["DescendantFonts"]); len(desc) > 0 {
+			cid := doc.dict(desc[0])
+			if dw, ok := doc.number(cid["DW"]); ok {
+				f.defaultWidth = dw
+			}
+			doc.readCIDWidths(doc.array(cid["W"]), f.widths)
+		}
+	} else {
+		first, _ := doc.number(d["FirstChar"])
+		for i, w := range doc.array(d["Widths"]) {
+			if v, ok := doc.number(w); ok {
+				f.widths[int(first)+i] = v
+			}
+		}
+		if len(f.widths) == 0 {
+			if base, _ := d["BaseFont"].(pdfName); bytes.Contains([]byte(base), []byte("Helvetica")) || bytes.Contains([]byte(base), []byte("Arial")) {
+				for i, w := range helvetica.widths {
+					f.widths[32+i] = float64(w)
+				}
+			}
+		}
+	}
+	if s, ok := doc.resolve(d["ToUnicode"]).(*pdfStream); ok {
+		if data, err := doc.decodeStream(s); err == nil {
+			f.toUnicode = parseToUnicode(data)
+		}
+	}
+	return f
+}
+
+// readCIDWidths reads a CIDFont /W array: c [w1 w2 ...] or c1 c2 w.
+func (doc *pdfDocument) readCIDWidths(w pdfArray, out map[int]float64) {
+	for i := 0; i < len(w); {
+		start, ok := doc.number(w[i])
+		if !ok || i+1 >= len(w) {
+			return
+		}
+		if list, ok := doc.resolve(w[i+1]).(pdfArray); ok {
+			for k, v := range list {
+				if n, ok := doc.number(v); ok {
+					out[int(start)+k] = n
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		end, _ := doc.number(w[i+1])
+		n, _ := doc.number(w[i+2])
+		for c := int(start); c <= int(end) && c-int(start) < 65536; c++ {
+			out[c] = n
+		}
+		i += 3
+	}
+}
+
+// parseToUnicode reads the bfchar and bfrange sections of a ToUnicode CMap.
+func parseToUnicode(data []byte) map[int][]rune {
+	m := make(map[int][]rune)
+	lx := &pdfLexer{data: data}
+	var operands []pdfObject
+	for {
+		tok := lx.token()
+		if tok == nil && lx.pos >= len(data) {
+			return m
+		}
+		kw, ok := tok.(pdfKeyword)
+		if !ok {
+			operands = append(operands, tok)
+			continue
+		}
+		switch kw {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					m[bytesToCode(src.Value)] = utf16BERunes(dst.Value)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				from, to := bytesToCode(lo.Value), bytesToCode(hi.Value)
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					base := utf16BERunes(dst.Value)
+					for c := from; c <= to && c-from < 65536 && len(base) > 0; c++ {
+						r := append([]rune(nil), base...)
+						r[len(r)-1] += rune(c - from)
+						m[c] = r
+					}
+				case pdfArray:
+					for k, item := range dst {
+						if s, ok := item.(pdfString); ok && from+k <= to {
+							m[from+k] = utf16BERunes(s.Value)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func bytesToCode(b []byte) int {
+	c := 0
+	for _, v := range b {
+		c = c<<8 | int(v)
+	}
+	return c
+}
+
+func utf16BERunes(b []byte) []rune {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return utf16.Decode(u)
+}
+
+// textState is the part of the graphics state that affects glyph positions.
+type textState struct {
+	ctm                         matrix
+	font                        *textFont
+	size, charSp, wordSp, scale float64
+	leading, rise               float64
+}
+
+// pageGlyphs returns the characters drawn on a page, in content order.
+func (doc *pdfDocument) pageGlyphs(page pdfRef) []pageGlyph {
+	d := doc.dict(page)
+	var content []byte
+	switch c := doc.resolve(d["Contents"]).(type) {
+	case *pdfStream:
+		content, _ = doc.decodeStream(c)
+	case pdfArray:
+		for _, item := range c {
+			if s, ok := doc.resolve(item).(*pdfStream); ok {
+				if b, err := doc.decodeStream(s); err == nil {
+					content = append(append(content, b...), '\n')
+				}
+			}
+		}
+	}
+	run := &contentRun{active: make(map[*pdfStream]bool)}
+	res := doc.dict(doc.pageAttr(page, "Resources"))
+	doc.runContent(content, res, textState{ctm: identity, scale: 1}, run, 0)
+	return run.glyphs
+}
+
+// contentRun is the state shared by a page's content and the forms it
+// draws.
+type contentRun struct {
+	glyphs []pageGlyph
+	active map[*pdfStream]bool // forms being drawn, to refuse recursion
+	forms  int                 // form XObjects expanded so far
+}
+
+func (doc *pdfDocument) runContent(content []byte, res pdfDict, gs textState, run *contentRun, depth int) {
+	fonts := make(map[pdfName]*textFont)
+	var stack []textState
+	var tm, tlm matrix
+	var operands []pdfObject
+
+	show := func(s []byte) {
+		if gs.font == nil {
+			return
+		}
+		for _, code := range gs.font.codes(s) {
+			w := gs.font.width(code) / 1000
+			trm := matrix{gs.size * gs.scale, 0, 0, gs.size, 0, gs.rise}.mul(tm).mul(gs.ctm)
+			x0, y0 := trm.apply(0, 0)
+			x1, y1 := trm.apply(w, 0)
+			size := math.Hypot(trm[2], trm[3])
+			for _, r := range gs.font.runes(code) {
+				if len(run.glyphs) >= maxPageGlyphs {
+					return
+				}
+				run.glyphs = append(run.glyphs, pageGlyph{r: r, x0: x0, y0: y0, x1: x1, y1: y1, size: size})
+			}
+			tx := w*gs.size + gs.charSp
+			if !gs.font.twoByte && code == ' ' {
+				tx += gs.wordSp
+			}
+			tm = matrix{1, 0, 0, 1, tx * gs.scale, 0}.mul(tm)
+		}
+	}
+	nums := func(n int) ([]float64, bool) {
+		if len(operands) < n {
+			return nil, false
+		}
+		out := make([]float64, n)
+		for i, o := range operands[len(operands)-n:] {
+			v, ok := doc.number(o)
+			if !ok {
+				return nil, false
+			}
+			out[i] = v
+		}
+		return out, true
+	}
+	nextLine := func(tx, ty float64) {
+		tlm = matrix{1, 0, 0, 1, tx, ty}.mul(tlm)
+		tm = tlm
+	}
+
+	lx := &pdfLexer{data: content}
+	for {
+		tok := lx.next()
+		if tok == nil && lx.pos >= len(content) || len(run.glyphs) >= maxPageGlyphs {
+			return
+		}
+		op, ok := tok.(pdfKeyword)
+		if !ok {
+			operands = append(operands, tok)
+			continue
+		}
+		switch op {
+		case "q":
+			stack = append(stack, gs)
+		case "Q":
+			if n := len(stack); n > 0 {
+				gs, stack = stack[n-1], stack[:n-1]
+			}
+		case "cm":
+			if v, ok := nums(6); ok {
+				gs.ctm = matrix{v[0], v[1], v[2], v[3], v[4], v[5]}.mul(gs.ctm)
+			}
+		case "BT":
+			tm, tlm = identity, identity
+		case "Tf":
+			if len(operands) >= 2 {
+				name, _ := operands[len(operands)-2].(pdfName)
+				f, ok := fonts[name]
+				if !ok {
+					f = doc.loadTextFont(doc.dict(res["Font"])[name])
+					fonts[name] = f
+				}
+				gs.font = f
+				gs.size, _ = doc.number(operands[len(operands)-1])
+			}
+		case "Tc":
+			if v, ok := nums(1); ok {
+				gs.charSp = v[0]
+			}
+		case "Tw":
+			if v, ok := nums(1); ok {
+				gs.wordSp = v[0]
+			}
+		case "Tz":
+			if v, ok := nums(1); ok {
+				gs.scale = v[0] / 100
+			}
+		case "TL":
+			if v, ok := nums(1); ok {
+				gs.leading = v[0]
+			}
+		case "Ts":
+			if v, ok := nums(1); ok {
+				gs.rise = v[0]
+			}
+		case "Td":
+			if v, ok := nums(2); ok {
+				nextLine(v[0], v[1])
+			}
+		case "TD":
+			if v, ok := nums(2); ok {
+				gs.leading = -v[1]
+				nextLine(v[0], v[1])
+			}
+		case "Tm":
+			if v, ok := nums(6); ok {
+				tlm = matrix{v[0], v[1], v[2], v[3], v[4], v[5]}
+				tm = tlm
+			}
+		case "T*":
+			nextLine(0, -gs.leading)
+		case "Tj", "'", "\"":
+			if op != "Tj" {
+				if op == "\"" {
+					if v, ok := nums(3); ok {
+						gs.wordSp, gs.charSp = v[0], v[1]
+					}
+				}
+				nextLine(0, -gs.leading)
+			}
+			if n := len(operands); n > 0 {
+				if s, ok := operands[n-1].(pdfString); ok {
+					show(s.Value)
+				}
+			}
+		case "TJ":
+			if n := len(operands); n > 0 {
+				arr, _ := operands[n-1].(pdfArray)
+				for _, item := range arr {
+					switch v := item.(type) {
+					case pdfString:
+						show(v.Value)
+					case int, float64:
+						adj, _ := doc.number(v)
+						tm = matrix{1, 0, 0, 1, -adj / 1000 * gs.size * gs.scale, 0}.mul(tm)
+					}
+				}
+			}
+		case "Do":
+			if n := len(operands); n > 0 && depth < 8 && run.forms < maxFormExpansions {
+				name, _ := operands[n-1].(pdfName)
+				form, ok := doc.resolve(doc.dict(res["XObject"])[name]).(*pdfStream)
+				if ok && form.Dict["Subtype"] == pdfName("Form") && !run.active[form] {
+					run.forms++
+					if data, err := doc.decodeStream(form); err == nil {
+						inner := gs
+						if m := doc.array(form.Dict["Matrix"]); len(m) == 6 {
+							var fm matrix
+							for i := range fm {
+								fm[i], _ = doc.number(m[i])
+							}
+							inner.ctm = fm.mul(gs.ctm)
+						}
+						formRes := doc.dict(form.Dict["Resources"])
+						if formRes == nil {
+							formRes = res
+						}
+						run.active[form] = true
+						doc.runContent(data, formRes, inner, run, depth+1)
+						delete(run.active, form)
+					}
+				}
+			}
+		case "BI":
+			lx.pos = skipInlineImage(content, lx.pos)
+		}
+		operands = operands[:0]
+	}
+}
+
+// skipInlineImage returns the position after the "EI" that ends the inline
+// image starting at pos. The binary data may itself contain "EI", so the
+// operator must stand alone between white space.
+func skipInlineImage(content []byte, pos int) int {
+	i := bytes.Index(content[pos:], []byte("ID"))
+	if i < 0 {
+		return len(content)
+	}
+	for p := pos + i + 3; p+2 <= len(content); p++ {
+		if content[p] == 'E' && content[p+1] == 'I' && isPdfSpace(content[p-1]) &&
+			(p+2 == len(content) || isPdfSpace(content[p+2])) {
+			return p + 2
+		}
+	}
+	return len(content)
+}
@@ -0,0 +1,99 @@
+package pdfsign
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stream returns the body of a stream object with dictionary entries dict.
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// onePage builds a document whose single page (object 3) draws content
+// with font /F1 (object 4, 500 units wide per character) and the form
+// XObjects forms, named /X1, /X2, ... and numbered from 5.
+func onePage(content string, forms ...string) []byte {
+	var xobjects []string
+	for i := range forms {
+		xobjects = append(xobjects, fmt.Sprintf("/X%d %d 0 R", i+1, i+5))
+	}
+	resources := fmt.Sprintf("<< /Font << /F1 4 0 R >> /XObject << %s >> >>", strings.Join(xobjects, " "))
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources %s /Contents %d 0 R >>", resources, len(forms)+5),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+	}
+	for _, form := range forms {
+		objects = append(objects, stream("/Type /XObject /Subtype /Form /BBox [0 0 612 792] /Resources "+resources, form))
+	}
+	return classicPdf(append(objects, stream("", content)), "")
+}
+
+// glyphsWithin returns the page's glyphs, failing the test if they take
+// longer than 5s to find.
+func glyphsWithin(t *testing.T, data []byte) []pageGlyph {
+	t.Helper()
+	doc, err := parsePdf(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []pageGlyph, 1)
+	go func() { done <- doc.pageGlyphs(pdfRef{Num: 3}) }()
+	select {
+	case glyphs := <-done:
+		return glyphs
+	case <-time.After(5 * time.Second):
+		t.Fatal("pageGlyphs did not return within 5s")
+		return nil
+	}
+}
+
+func TestPageGlyphs(t *testing.T) {
+	glyphs := glyphsWithin(t, onePage(
+		"BT /F1 10 Tf 1 0 0 1 100 700 Tm (Hi) Tj ET q /X1 Do Q",
+		"q 1 0 0 1 50 50 cm BT /F1 20 Tf (A) Tj ET Q",
+	))
+	want := []pageGlyph{
+		{r: 'H', x0: 100, y0: 700, x1: 105, y1: 700, size: 10},
+		{r: 'i', x0: 105, y0: 700, x1: 110, y1: 700, size: 10},
+		{r: 'A', x0: 50, y0: 50, x1: 60, y1: 50, size: 20},
+	}
+	if len(glyphs) != len(want) {
+		t.Fatalf("glyphs = %+v, want %+v", glyphs, want)
+	}
+	for i := range want {
+		if glyphs[i] != want[i] {
+			t.Errorf("glyph %d = %+v, want %+v", i, glyphs[i], want[i])
+		}
+	}
+}
+
+func TestPageGlyphsRecursiveForms(t *testing.T) {
+	draws := func(name string) string { return strings.Repeat(name+" Do ", 20) }
+	text := " BT /F1 10 Tf (A) Tj ET"
+
+	// A form that draws itself is drawn once.
+	if glyphs := glyphsWithin(t, onePage("/X1 Do", draws("/X1")+text)); len(glyphs) != 1 {
+		t.Fatalf("self-drawing form: %d glyphs, want 1", len(glyphs))
+	}
+	// X1 and X2 draw each other: X1 draws X2 twenty times, X2 may not
+	// re-enter X1.
+	if glyphs := glyphsWithin(t, onePage("/X1 Do", draws("/X2")+text, draws("/X1")+text)); len(glyphs) != 21 {
+		t.Fatalf("mutually drawing forms: %d glyphs, want 21", len(glyphs))
+	}
+	// Eight distinct forms each drawing the next twenty times would be 20^8
+	// expansions without the limit.
+	var forms []string
+	for i := 2; i <= 8; i++ {
+		forms = append(forms, draws(fmt.Sprintf("/X%d", i)))
+	}
+	forms = append(forms, text)
+	glyphs := glyphsWithin(t, onePage("/X1 Do", forms...))
+	if len(glyphs) == 0 || len(glyphs) > maxFormExpansions {
+		t.Fatalf("nested forms: %d glyphs, want 1..%d", len(glyphs), maxFormExpansions)
+	}
+}
@@ -0,0 +1,973 @@
+package pdfsign
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// --- Minimal PDF Object Reader ---
+// Chilkat loads and signs PDFs, but it gives no access to the object tree.
+// pdfDocument reads the cross-reference data (tables, streams and hybrid
+// files) and resolves objects, including those packed in object streams, so
+// we can inspect the DSS, AcroForm, pages and signature dictionaries.
+// Encrypted files can be detected but their strings and streams are not
+// decrypted.
+//
+// The input is untrusted (uploads to /v1/pdf/verify), so every offset,
+// width and length taken from the file is checked before it is used,
+// reference cycles are an error rather than endless recursion, and
+// arrays and dictionaries may nest at most maxPdfNesting deep. A stream may
+// inflate to at most maxStreamSize bytes and all streams of a document to
+// maxDecodedSize. Walks of the
+// page and field trees visit each object once and at most maxTreeNodes
+// objects in all.
+
+type pdfObject = any
+
+type pdfName string
+
+type pdfRef struct {
+	Num int
+	Gen int
+}
+
+type pdfDict map[pdfName]pdfObject
+
+type pdfArray []pdfObject
+
+type pdfString struct {
+	Value []byte
+	Hex   bool
+}
+
+type pdfStream struct {
+	Dict pdfDict
+	Raw  []byte // still encoded
+}
+
+type xrefEntry struct {
+	offset   int64 // byte offset for type 1 entries
+	gen      int
+	inStream int // object stream number for type 2 entries
+	index    int // index within the object stream
+	free     bool
+}
+
+type pdfDocument struct {
+	data    []byte
+	xref    map[int]xrefEntry
+	trailer pdfDict
+	cache   map[int]pdfObject
+	objStms map[int]*objectStream
+	loading map[int]bool // objects being read, to detect reference cycles
+	// startxref of the last revision and whether it is an xref stream,
+	// needed to append incremental updates
+	lastXref   int64
+	xrefStream bool
+	decoded    int64 // bytes inflated so far, bounded by maxDecodedSize
+}
+
+type objectStream struct {
+	offsets map[int]int // object number -> offset within data
+	data    []byte
+}
+
+// readPdfFile loads and indexes the PDF at path.
+func readPdfFile(path string) (*pdfDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF '%s': %w", path, err)
+	}
+	return parsePdf(data)
+}
+
+// parsePdf indexes every revision's cross-reference section, newest first.
+func parsePdf(data []byte) (*pdfDocument, error) {
+	doc := &pdfDocument{
+		data:    data,
+		xref:    make(map[int]xrefEntry),
+		cache:   make(map[int]pdfObject),
+		objStms: make(map[int]*objectStream),
+		loading: make(map[int]bool),
+	}
+	start, err := findStartXref(data)
+	if err != nil {
+		return nil, err
+	}
+	doc.lastXref = start
+
+	seen := make(map[int64]bool)
+	for offset := start; offset >= 0; {
+		if seen[offset] {
+			return nil, fmt.Errorf("cross-reference loop at offset %d", offset)
+		}
+		seen[offset] = true
+		trailer, err := doc.readXrefSection(offset)
+		if err != nil {
+			return nil, err
+		}
+		if doc.trailer == nil {
+			doc.trailer = trailer
+			doc.xrefStream = trailer["Type"] == pdfName("XRef")
+		}
+		// Hybrid files: the classic table's trailer points at an xref stream
+		// holding the compressed objects of the same revision. Some writers
+		// copy a stale /XRefStm into full-rewrite xref streams, so the key is
+		// only honoured on classic trailers.
+		stm, ok := trailer["XRefStm"].(int)
+		if ok && (stm < 0 || stm >= len(data)) {
+			return nil, fmt.Errorf("invalid /XRefStm offset %d", stm)
+		}
+		if ok && trailer["Type"] != pdfName("XRef") && !seen[int64(stm)] {
+			seen[int64(stm)] = true
+			if _, err := doc.readXrefSection(int64(stm)); err != nil {
+				return nil, err
+			}
+		}
+		prev, ok := trailer["Prev"].(int)
+		if !ok {
+			break
+		}
+		if prev < 0 {
+			return nil, fmt.Errorf("invalid /Prev offset %d", prev)
+		}
+		offset = int64(prev)
+	}
+	if doc.trailer == nil {
+		return nil, errors.New("PDF has no trailer")
+	}
+	return doc, nil
+}
+
+func findStartXref(data []byte) (int64, error) {
+	tail := data
+	if len(tail) > 2048 {
+		tail = tail[len(tail)-2048:]
+	}
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return 0, errors.New("startxref not found, file is not a PDF or is truncated")
+	}
+	lx := &pdfLexer{data: tail, pos: i + len("startxref")}
+	n, ok := lx.next().(int)
+	if !ok || n < 0 || n >= len(data) {
+		return 0, errors.New("invalid startxref offset")
+	}
+	return int64(n), nil
+}
+
+// readXrefSection reads one classic table or xref stream and returns its
+// trailer. Entries already known from a newer revision are kept.
+func (doc *pdfDocument) readXrefSection(offset int64) (pdfDict, error) {
+	if offset < 0 || offset >= int64(len(doc.data)) {
+		return nil, fmt.Errorf("xref offset %d outside the file", offset)
+	}
+	lx := &pdfLexer{data: doc.data, pos: int(offset)}
+	lx.skipSpace()
+	if bytes.HasPrefix(doc.data[lx.pos:], []byte("xref")) {
+		lx.pos += len("xref")
+		return doc.readXrefTable(lx)
+	}
+
+	_, obj, err := lx.indirectObject()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xref stream at %d: %w", offset, err)
+	}
+	stm, ok := obj.(*pdfStream)
+	if !ok || stm.Dict["Type"] != pdfName("XRef") {
+		return nil, fmt.Errorf("object at xref offset %d is not an xref stream", offset)
+	}
+	if err := doc.readXrefStream(stm); err != nil {
+		return nil, err
+	}
+	return stm.Dict, nil
+}
+
+func (doc *pdfDocument) readXrefTable(lx *pdfLexer) (pdfDict, error) {
+	for {
+		lx.skipSpace()
+		if bytes.HasPrefix(doc.data[lx.pos:], []byte("trailer")) {
+			lx.pos += len("trailer")
+			trailer, ok := lx.next().(pdfDict)
+			if !ok {
+				return nil, errors.New("invalid trailer dictionary")
+			}
+			return trailer, nil
+		}
+		first, ok1 := lx.next().(int)
+		count, ok2 := lx.next().(int)
+		if !ok1 || !ok2 {
+			return nil, errors.New("invalid xref subsection header")
+		}
+		for i := 0; i < count; i++ {
+			off, ok1 := lx.next().(int)
+			gen, ok2 := lx.next().(int)
+			kind, ok3 := lx.next().(pdfKeyword)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, errors.New("invalid xref entry")
+			}
+			num := first + i
+			if _, known := doc.xref[num]; known {
+				continue
+			}
+			doc.xref[num] = xrefEntry{offset: int64(off), gen: gen, free: kind == "f"}
+		}
+	}
+}
+
+func (doc *pdfDocument) readXrefStream(stm *pdfStream) error {
+	data, err := doc.decodeStream(stm)
+	if err != nil {
+		return fmt.Errorf("failed to decode xref stream: %w", err)
+	}
+	w, ok := stm.Dict["W"].(pdfArray)
+	if !ok || len(w) != 3 {
+		return errors.New("xref stream has invalid /W")
+	}
+	widths := make([]int, 3)
+	for i := range widths {
+		var ok bool
+		// beUint reads at most 8 bytes.
+		if widths[i], ok = w[i].(int); !ok || widths[i] < 0 || widths[i] > 8 {
+			return fmt.Errorf("xref stream has invalid /W %v", w)
+		}
+	}
+	size, _ := stm.Dict["Size"].(int)
+	index := pdfArray{0, size}
+	if idx, ok := stm.Dict["Index"].(pdfArray); ok {
+		index = idx
+	}
+
+	rowLen := widths[0] + widths[1] + widths[2]
+	if rowLen == 0 {
+		return errors.New("xref stream has invalid /W [0 0 0]")
+	}
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := index[i].(int)
+		count, _ := index[i+1].(int)
+		for j := 0; j < count; j++ {
+			if pos+rowLen > len(data) {
+				return errors.New("xref stream is shorter than its /Index declares")
+			}
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+			kind := 1
+			if widths[0] > 0 {
+				kind = int(beUint(row[:widths[0]]))
+			}
+			f2 := beUint(row[widths[0] : widths[0]+widths[1]])
+			f3 := beUint(row[widths[0]+widths[1]:])
+			num := first + j
+			if _, known := doc.xref[num]; known {
+				continue
+			}
+			switch kind {
+			case 0:
+				doc.xref[num] = xrefEntry{free: true}
+			case 1:
+				if f2 >= uint64(len(doc.data)) {
+					return fmt.Errorf("xref stream entry for object %d points beyond the end of file", num)
+				}
+				doc.xref[num] = xrefEntry{offset: int64(f2), gen: int(f3)}
+			case 2:
+				if f2 == 0 || f2 > math.MaxInt32 || f3 > math.MaxInt32 {
+					return fmt.Errorf("xref stream entry for object %d has an invalid object stream", num)
+				}
+				doc.xref[num] = xrefEntry{inStream: int(f2), index: int(f3)}
+			}
+		}
+	}
+	return nil
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// resolve follows indirect references until it reaches a direct object.
+func (doc *pdfDocument) resolve(obj pdfObject) pdfObject {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		o, err := doc.object(ref.Num)
+		if err != nil {
+			return nil
+		}
+		obj = o
+	}
+	return nil
+}
+
+func (doc *pdfDocument) dict(obj pdfObject) pdfDict {
+	switch v := doc.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.Dict
+	}
+	return nil
+}
+
+func (doc *pdfDocument) array(obj pdfObject) pdfArray {
+	a, _ := doc.resolve(obj).(pdfArray)
+	return a
+}
+
+// number returns an int or real as float64.
+func (doc *pdfDocument) number(obj pdfObject) (float64, bool) {
+	switch v := doc.resolve(obj).(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// object returns indirect object num.
+func (doc *pdfDocument) object(num int) (pdfObject, error) {
+	if obj, ok := doc.cache[num]; ok {
+		return obj, nil
+	}
+	entry, ok := doc.xref[num]
+	if !ok || entry.free {
+		return nil, fmt.Errorf("object %d not found", num)
+	}
+	// A stream whose /Length is itself, or an object stream that contains
+	// itself, would otherwise recurse until the stack overflows.
+	if doc.loading[num] {
+		return nil, fmt.Errorf("object %d refers to itself", num)
+	}
+	doc.loading[num] = true
+	defer delete(doc.loading, num)
+
+	var obj pdfObject
+	if entry.inStream > 0 {
+		stm, err := doc.objectStream(entry.inStream)
+		if err != nil {
+			return nil, err
+		}
+		off, ok := stm.offsets[num]
+		if !ok {
+			return nil, fmt.Errorf("object %d missing from object stream %d", num, entry.inStream)
+		}
+		lx := &pdfLexer{data: stm.data, pos: off}
+		obj = lx.next()
+		if lx.err != nil {
+			return nil, fmt.Errorf("object %d: %w", num, lx.err)
+		}
+	} else {
+		if entry.offset < 0 || entry.offset >= int64(len(doc.data)) {
+			return nil, fmt.Errorf("xref offset %d of object %d is outside the file", entry.offset, num)
+		}
+		lx := &pdfLexer{data: doc.data, pos: int(entry.offset), doc: doc}
+		n, o, err := lx.indirectObject()
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", num, err)
+		}
+		if n != num {
+			return nil, fmt.Errorf("xref for object %d points at object %d", num, n)
+		}
+		obj = o
+	}
+	doc.cache[num] = obj
+	return obj, nil
+}
+
+func (doc *pdfDocument) objectStream(num int) (*objectStream, error) {
+	if stm, ok := doc.objStms[num]; ok {
+		return stm, nil
+	}
+	obj, err := doc.object(num)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, fmt.Errorf("object %d is not an object stream", num)
+	}
+	data, err := doc.decodeStream(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode object stream %d: %w", num, err)
+	}
+	n, _ := s.Dict["N"].(int)
+	first, _ := s.Dict["First"].(int)
+	// Each entry of the header takes at least four bytes.
+	if n < 0 || n > len(data)/4 || first < 0 || first > len(data) {
+		return nil, fmt.Errorf("object stream %d has an invalid /N or /First", num)
+	}
+	stm := &objectStream{offsets: make(map[int]int, n), data: data}
+	lx := &pdfLexer{data: data}
+	for i := 0; i < n; i++ {
+		objNum, ok1 := lx.next().(int)
+		off, ok2 := lx.next().(int)
+		if !ok1 || !ok2 || off < 0 || off >= len(data)-first {
+			return nil, fmt.Errorf("object stream %d has an invalid header", num)
+		}
+		stm.offsets[objNum] = first + off
+	}
+	doc.objStms[num] = stm
+	return stm, nil
+}
+
+// maxStreamSize and maxDecodedSize bound what one stream and all streams
+// of a document may inflate to, against compression bombs.
+const (
+	maxStreamSize  = 64 << 20
+	maxDecodedSize = 256 << 20
+)
+
+// decodeStream applies the stream's filters. Only FlateDecode (with PNG or
+// TIFF predictors) is supported, which covers xref, object, content and
+// DSS streams in practice.
+func (doc *pdfDocument) decodeStream(s *pdfStream) ([]byte, error) {
+	var filters pdfArray
+	switch f := doc.resolve(s.Dict["Filter"]).(type) {
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	var params pdfArray
+	switch p := doc.resolve(s.Dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = pdfArray{p}
+	case pdfArray:
+		params = p
+	}
+
+	data := s.Raw
+	for i, f := range filters {
+		name, _ := doc.resolve(f).(pdfName)
+		if name != "FlateDecode" {
+			return nil, fmt.Errorf("unsupported stream filter /%s", name)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		limit := min(int64(maxStreamSize), maxDecodedSize-doc.decoded)
+		out, err := io.ReadAll(io.LimitReader(zr, limit+1))
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		if int64(len(out)) > limit {
+			if limit < maxStreamSize {
+				return nil, fmt.Errorf("streams inflate to more than %d bytes in all", maxDecodedSize)
+			}
+			return nil, fmt.Errorf("stream inflates to more than %d bytes", maxStreamSize)
+		}
+		doc.decoded += int64(len(out))
+		data = out
+		if i < len(params) {
+			if p := doc.dict(params[i]); p != nil {
+				if data, err = applyPredictor(data, p); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+func applyPredictor(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int)
+	if predictor < 10 {
+		return data, nil
+	}
+	columns := 1
+	if c, ok := params["Columns"].(int); ok {
+		columns = c
+	}
+	colors := 1
+	if c, ok := params["Colors"].(int); ok {
+		colors = c
+	}
+	bpc := 8
+	if b, ok := params["BitsPerComponent"].(int); ok {
+		bpc = b
+	}
+	if columns < 1 || columns > len(data) || colors < 1 || colors > 32 || bpc < 1 || bpc > 16 {
+		return nil, fmt.Errorf("invalid predictor parameters (Columns %d, Colors %d, BitsPerComponent %d)", columns, colors, bpc)
+	}
+	bpp := (colors*bpc + 7) / 8
+	rowLen := (columns*colors*bpc + 7) / 8
+
+	var out []byte
+	prev := make([]byte, rowLen)
+	for pos := 0; pos+rowLen+1 <= len(data); pos += rowLen + 1 {
+		kind := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLen]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("invalid PNG predictor row type %d", kind)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// catalog returns the document catalog (/Root).
+func (doc *pdfDocument) catalog() pdfDict {
+	return doc.dict(doc.trailer["Root"])
+}
+
+// encrypted reports whether the file uses the standard security handler or
+// any other encryption; such files are not further inspected.
+func (doc *pdfDocument) encrypted() bool {
+	_, ok := doc.trailer["Encrypt"]
+	return ok
+}
+
+// --- Lexer / Parser ---
+
+type pdfKeyword string
+
+// maxPdfNesting bounds how deep arrays and dictionaries may nest; real
+// files stay in single digits.
+const maxPdfNesting = 100
+
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	doc   *pdfDocument // for resolving indirect /Length; nil inside object streams
+	depth int          // arrays and dictionaries open at pos
+	err   error        // set when parsing was given up; every token is then nil
+}
+
+func isPdfSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPdfDelim(c byte) bool {
+	return pdfDelims[c]
+}
+
+var pdfDelims = [256]bool{'(': true, ')': true, '<': true, '>': true, '[': true, ']': true, '{': true, '}': true, '/': true, '%': true}
+
+func (lx *pdfLexer) skipSpace() {
+	if lx.pos < 0 {
+		lx.pos = len(lx.data)
+	}
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if isPdfSpace(c) {
+			lx.pos++
+			continue
+		}
+		if c == '%' {
+			for lx.pos < len(lx.data) && lx.data[lx.pos] != '\n' && lx.data[lx.pos] != '\r' {
+				lx.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// indirectObject parses "n g obj ... endobj" at the current position.
+func (lx *pdfLexer) indirectObject() (int, pdfObject, error) {
+	num, ok1 := lx.next().(int)
+	_, ok2 := lx.next().(int)
+	kw, ok3 := lx.next().(pdfKeyword)
+	if !ok1 || !ok2 || !ok3 || kw != "obj" {
+		return 0, nil, errors.New("expected 'n g obj'")
+	}
+	obj := lx.next()
+	if lx.err != nil {
+		return 0, nil, lx.err
+	}
+	if d, ok := obj.(pdfDict); ok {
+		save := lx.pos
+		if kw, ok := lx.next().(pdfKeyword); ok && kw == "stream" {
+			raw, err := lx.streamData(d)
+			if err != nil {
+				return 0, nil, err
+			}
+			return num, &pdfStream{Dict: d, Raw: raw}, nil
+		}
+		lx.pos = save
+	}
+	return num, obj, nil
+}
+
+func (lx *pdfLexer) streamData(d pdfDict) ([]byte, error) {
+	// The keyword is followed by CRLF or LF.
+	if lx.pos < len(lx.data) && lx.data[lx.pos] == '\r' {
+		lx.pos++
+	}
+	if lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+		lx.pos++
+	}
+	length := -1
+	switch l := d["Length"].(type) {
+	case int:
+		length = l
+	case pdfRef:
+		if lx.doc != nil {
+			if n, ok := lx.doc.resolve(l).(int); ok {
+				length = n
+			}
+		}
+	}
+	start := lx.pos
+	if length >= 0 && length <= len(lx.data)-start {
+		end := start + length
+		rest := lx.data[end:]
+		trimmed := bytes.TrimLeft(rest, " \r\n\t")
+		if bytes.HasPrefix(trimmed, []byte("endstream")) {
+			lx.pos = end
+			return lx.data[start:end], nil
+		}
+	}
+	// Missing or wrong /Length: fall back to scanning for endstream.
+	i := bytes.Index(lx.data[start:], []byte("endstream"))
+	if i < 0 {
+		return nil, errors.New("unterminated stream")
+	}
+	end := start + i
+	for end > start && (lx.data[end-1] == '\n' || lx.data[end-1] == '\r') {
+		end--
+	}
+	lx.pos = start + i
+	return lx.data[start:end], nil
+}
+
+// next returns the next object or keyword. References "n g R" are folded
+// into pdfRef.
+func (lx *pdfLexer) next() pdfObject {
+	obj := lx.token()
+	n, ok := obj.(int)
+	if !ok {
+		return obj
+	}
+	save := lx.pos
+	if g, ok := lx.token().(int); ok {
+		if kw, ok := lx.token().(pdfKeyword); ok && kw == "R" {
+			return pdfRef{Num: n, Gen: g}
+		}
+	}
+	lx.pos = save
+	return n
+}
+
+func (lx *pdfLexer) token() pdfObject {
+	lx.skipSpace()
+	if lx.pos >= len(lx.data) || lx.err != nil {
+		return nil
+	}
+	c := lx.data[lx.pos]
+	switch {
+	case c == '/':
+		lx.pos++
+		start := lx.pos
+		for lx.pos < len(lx.data) && !isPdfSpace(lx.data[lx.pos]) && !isPdfDelim(lx.data[lx.pos]) {
+			lx.pos++
+		}
+		return pdfName(decodeNameEscapes(lx.data[start:lx.pos]))
+	case c == '<' && lx.pos+1 < len(lx.data) && lx.data[lx.pos+1] == '<':
+		if !lx.nest() {
+			return nil
+		}
+		defer func() { lx.depth-- }()
+		lx.pos += 2
+		d := pdfDict{}
+		for {
+			lx.skipSpace()
+			if lx.pos+1 < len(lx.data) && lx.data[lx.pos] == '>' && lx.data[lx.pos+1] == '>' {
+				lx.pos += 2
+				return d
+			}
+			if lx.pos >= len(lx.data) {
+				return d
+			}
+			key, ok := lx.token().(pdfName)
+			if !ok {
+				return d
+			}
+			d[key] = lx.next()
+		}
+	case c == '<':
+		lx.pos++
+		start := lx.pos
+		for lx.pos < len(lx.data) && lx.data[lx.pos] != '>' {
+			lx.pos++
+		}
+		hex := lx.data[start:lx.pos]
+		if lx.pos < len(lx.data) {
+			lx.pos++
+		}
+		return pdfString{Value: decodeHexString(hex), Hex: true}
+	case c == '(':
+		return pdfString{Value: lx.literalString()}
+	case c == '[':
+		if !lx.nest() {
+			return nil
+		}
+		defer func() { lx.depth-- }()
+		lx.pos++
+		a := pdfArray{}
+		for {
+			lx.skipSpace()
+			if lx.pos >= len(lx.data) {
+				return a
+			}
+			if lx.data[lx.pos] == ']' {
+				lx.pos++
+				return a
+			}
+			a = append(a, lx.next())
+		}
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		lx.pos++
+		return pdfKeyword(c)
+	}
+
+	start := lx.pos
+	for lx.pos < len(lx.data) && !isPdfSpace(lx.data[lx.pos]) && !isPdfDelim(lx.data[lx.pos]) {
+		lx.pos++
+	}
+	word := string(lx.data[start:lx.pos])
+	switch word {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.Atoi(word); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f
+	}
+	return pdfKeyword(word)
+}
+
+// nest enters an array or dictionary, or gives up parsing when they nest
+// too deep.
+func (lx *pdfLexer) nest() bool {
+	if lx.depth >= maxPdfNesting {
+		lx.err = fmt.Errorf("arrays and dictionaries nest deeper than %d at offset %d", maxPdfNesting, lx.pos)
+		lx.pos = len(lx.data)
+		return false
+	}
+	lx.depth++
+	return true
+}
+
+func (lx *pdfLexer) literalString() []byte {
+	lx.pos++ // (
+	var out []byte
+	depth := 1
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if lx.pos >= len(lx.data) {
+				return out
+			}
+			e := lx.data[lx.pos]
+			lx.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+					lx.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && lx.pos < len(lx.data) && lx.data[lx.pos] >= '0' && lx.data[lx.pos] <= '7'; k++ {
+						v = v*8 + int(lx.data[lx.pos]-'0')
+						lx.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func decodeHexString(h []byte) []byte {
+	var digits []byte
+	for _, c := range h {
+		if !isPdfSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return out[:i]
+		}
+		out[i] = byte(v)
+	}
+	return out
+}
+
+func decodeNameEscapes(b []byte) string {
+	if !bytes.Contains(b, []byte("#")) {
+		return string(b)
+	}
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] == '#' && i+2 < len(b) {
+			if v, err := strconv.ParseUint(string(b[i+1:i+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
+
+// --- Page Tree ---
+
+// maxTreeNodes bounds how many objects a walk of the page or field tree
+// visits, so that /Kids listing the same subtree many times cannot make it
+// exponential.
+const maxTreeNodes = 100000
+
+// pages returns the page objects in document order.
+func (doc *pdfDocument) pages() []pdfRef {
+	var out []pdfRef
+	seen := make(map[int]bool)
+	var walk func(node pdfObject, depth int)
+	walk = func(node pdfObject, depth int) {
+		ref, ok := node.(pdfRef)
+		if !ok || depth > 64 || seen[ref.Num] || len(seen) >= maxTreeNodes {
+			return
+		}
+		seen[ref.Num] = true
+		d := doc.dict(ref)
+		if d == nil {
+			return
+		}
+		if d["Type"] == pdfName("Page") {
+			out = append(out, ref)
+			return
+		}
+		for _, kid := range doc.array(d["Kids"]) {
+			walk(kid, depth+1)
+		}
+	}
+	walk(doc.catalog()["Pages"], 0)
+	return out
+}
+
+// pageAttr returns an inheritable page attribute (MediaBox, CropBox,
+// Rotate, Resources), looking up the /Parent chain.
+func (doc *pdfDocument) pageAttr(page pdfRef, key pdfName) pdfObject {
+	d := doc.dict(page)
+	for i := 0; d != nil && i < 64; i++ {
+		if v, ok := d[key]; ok {
+			return doc.resolve(v)
+		}
+		d = doc.dict(d["Parent"])
+	}
+	return nil
+}
+
+// rectangle reads a PDF rectangle array, normalised so that x1<x2, y1<y2.
+func (doc *pdfDocument) rectangle(obj pdfObject) ([4]float64, bool) {
+	var r [4]float64
+	a := doc.array(obj)
+	if len(a) != 4 {
+		return r, false
+	}
+	for i := range r {
+		v, ok := doc.number(a[i])
+		if !ok {
+			return r, false
+		}
+		r[i] = v
+	}
+	if r[0] > r[2] {
+		r[0], r[2] = r[2], r[0]
+	}
+	if r[1] > r[3] {
+		r[1], r[3] = r[3], r[1]
+	}
+	return r, true
+}
@@ -0,0 +1,293 @@
+package pdfsign
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// classicPdf builds a PDF with a classic xref table from the bodies of
+// objects 1..n; trailer is added to the trailer dictionary.
+func classicPdf(objects []string, trailer string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return b.Bytes()
+}
+
+// xrefStreamPdf builds a PDF whose xref stream (object n+1, /W [1 4 2])
+// lists objects 1..n. inStream maps an object to the object stream and
+// index of a type 2 entry; such objects are not written to the file.
+func xrefStreamPdf(objects []string, inStream map[int][2]int) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	var rows bytes.Buffer
+	rows.Write([]byte{0, 0, 0, 0, 0, 0xff, 0xff})
+	row := func(kind byte, f2 uint32, f3 uint16) {
+		rows.Write([]byte{kind, byte(f2 >> 24), byte(f2 >> 16), byte(f2 >> 8), byte(f2), byte(f3 >> 8), byte(f3)})
+	}
+	for i, o := range objects {
+		if e, ok := inStream[i+1]; ok {
+			row(2, uint32(e[0]), uint16(e[1]))
+			continue
+		}
+		row(1, uint32(b.Len()), 0)
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	n := len(objects) + 1
+	row(1, uint32(xref), 0)
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] /Root 1 0 R /Length %d >>\nstream\n", n, n+1, rows.Len())
+	b.Write(rows.Bytes())
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+	return b.Bytes()
+}
+
+// withStartxref appends startxref pointing at the start of section.
+func withStartxref(prefix, section string) []byte {
+	return []byte(fmt.Sprintf("%s%sstartxref\n%d\n%%%%EOF\n", prefix, section, len(prefix)))
+}
+
+func objStm(header, body string) string {
+	data := header + body
+	return fmt.Sprintf("<< /Type /ObjStm /N 1 /First %d /Length %d >>\nstream\n%s\nendstream", len(header), len(data), data)
+}
+
+func TestParsePdfMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		obj  int // object to read after parsing; 0 when parsing must fail
+	}{
+		{
+			name: "negative XRefStm",
+			data: classicPdf([]string{"<< /Type /Catalog >>"}, "/XRefStm -5"),
+		},
+		{
+			name: "XRefStm beyond end of file",
+			data: classicPdf([]string{"<< /Type /Catalog >>"}, "/XRefStm 999999"),
+		},
+		{
+			name: "negative Prev",
+			data: classicPdf([]string{"<< /Type /Catalog >>"}, "/Prev -1"),
+		},
+		{
+			name: "negative xref table offset",
+			data: withStartxref("%PDF-1.7\n1 0 obj\n<< >>\nendobj\n",
+				"xref\n0 2\n0000000000 65535 f \n-5 00000 n \ntrailer\n<< /Size 2 /Root 1 0 R >>\n"),
+			obj: 1,
+		},
+		{
+			name: "xref table offset beyond end of file",
+			data: withStartxref("%PDF-1.7\n1 0 obj\n<< >>\nendobj\n",
+				"xref\n0 2\n0000000000 65535 f \n9999999999 00000 n \ntrailer\n<< /Size 2 /Root 1 0 R >>\n"),
+			obj: 1,
+		},
+		{
+			name: "negative W",
+			data: withStartxref("%PDF-1.7\n",
+				"1 0 obj\n<< /Type /XRef /Size 2 /W [1 -2 1] /Length 4 >>\nstream\n\x01\x00\x00\x00\nendstream\nendobj\n"),
+		},
+		{
+			name: "W wider than 8 bytes",
+			data: withStartxref("%PDF-1.7\n",
+				"1 0 obj\n<< /Type /XRef /Size 1 /W [1 9 1] /Length 11 >>\nstream\n\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\nendstream\nendobj\n"),
+		},
+		{
+			name: "W all zero",
+			data: withStartxref("%PDF-1.7\n",
+				"1 0 obj\n<< /Type /XRef /Size 1000000000 /W [0 0 0] /Length 0 >>\nstream\n\nendstream\nendobj\n"),
+		},
+		{
+			name: "negative object stream offset",
+			data: xrefStreamPdf([]string{"<< /Type /Catalog >>", "", objStm("2 -3 ", "<< >>")}, map[int][2]int{2: {3, 0}}),
+			obj:  2,
+		},
+		{
+			name: "object stream offset beyond its data",
+			data: xrefStreamPdf([]string{"<< /Type /Catalog >>", "", objStm("2 500 ", "<< >>")}, map[int][2]int{2: {3, 0}}),
+			obj:  2,
+		},
+		{
+			name: "negative object stream First",
+			data: xrefStreamPdf([]string{"<< /Type /Catalog >>", "", "<< /Type /ObjStm /N 1 /First -9 /Length 9 >>\nstream\n2 0 << >>\nendstream"}, map[int][2]int{2: {3, 0}}),
+			obj:  2,
+		},
+		{
+			name: "object stream containing itself",
+			data: xrefStreamPdf([]string{"<< /Type /Catalog >>", ""}, map[int][2]int{2: {2, 0}}),
+			obj:  2,
+		},
+		{
+			name: "object streams containing each other",
+			data: xrefStreamPdf([]string{"<< /Type /Catalog >>", "", ""}, map[int][2]int{2: {3, 0}, 3: {2, 0}}),
+			obj:  2,
+		},
+		{
+			name: "arrays nested too deep",
+			data: classicPdf([]string{"<< /Type /Catalog >>", strings.Repeat("[", 100000)}, ""),
+			obj:  2,
+		},
+		{
+			name: "dictionaries nested too deep",
+			data: classicPdf([]string{"<< /Type /Catalog >>", strings.Repeat("<< /A ", 100000)}, ""),
+			obj:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parsePdf(tt.data)
+			if tt.obj == 0 {
+				if err == nil {
+					t.Fatal("parsePdf succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePdf: %v", err)
+			}
+			if obj, err := doc.object(tt.obj); err == nil {
+				t.Fatalf("object %d = %v, want an error", tt.obj, obj)
+			}
+		})
+	}
+}
+
+func TestStreamLengthReferringToItself(t *testing.T) {
+	doc, err := parsePdf(classicPdf([]string{"<< /Type /Catalog >>", "<< /Length 2 0 R >>\nstream\nabc\nendstream"}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The /Length cannot be resolved, so the stream ends at endstream.
+	obj, err := doc.object(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stm, ok := obj.(*pdfStream)
+	if !ok || string(stm.Raw) != "abc" {
+		t.Fatalf("object 2 = %#v, want a stream with data abc", obj)
+	}
+}
+
+func TestParsePdfObjectStream(t *testing.T) {
+	doc, err := parsePdf(xrefStreamPdf([]string{"<< /Type /Catalog /Pages 2 0 R >>", "", objStm("2 0 ", "<< /Type /Pages /Count 0 >>")}, map[int][2]int{2: {3, 0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := doc.dict(doc.catalog()["Pages"]); d["Type"] != pdfName("Pages") {
+		t.Fatalf("pages = %v", d)
+	}
+}
+
+func TestDecodeStreamLimits(t *testing.T) {
+	doc, err := parsePdf(classicPdf([]string{"<< /Type /Catalog >>"}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flate := pdfDict{"Filter": pdfName("FlateDecode")}
+	bomb := &pdfStream{Dict: flate, Raw: deflate(make([]byte, maxStreamSize+1))}
+	if _, err := doc.decodeStream(bomb); err == nil || !strings.Contains(err.Error(), "inflates to more than") {
+		t.Fatalf("decodeStream of a %d byte stream: %v", maxStreamSize+1, err)
+	}
+
+	small := &pdfStream{Dict: flate, Raw: deflate([]byte("0123456789"))}
+	if data, err := doc.decodeStream(small); err != nil || string(data) != "0123456789" {
+		t.Fatalf("decodeStream = %q, %v", data, err)
+	}
+	doc.decoded = maxDecodedSize - 5
+	if _, err := doc.decodeStream(small); err == nil || !strings.Contains(err.Error(), "in all") {
+		t.Fatalf("decodeStream over the document budget: %v", err)
+	}
+}
+
+// doubledTree builds n /Pages nodes where each lists the next one twice, so
+// that a walk without a visited set takes 2^n steps.
+func doubledTree(n int) []string {
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [2 0 R] >> >>"}
+	for i := 2; i <= n+1; i++ {
+		objects = append(objects, fmt.Sprintf("<< /Type /Pages /T (f%d) /Kids [%d 0 R %d 0 R] >>", i, i+1, i+1))
+	}
+	return append(objects, fmt.Sprintf("<< /Type /Page /T (leaf) /Parent %d 0 R >>", n+2))
+}
+
+func TestTreeCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []string
+		pages   int
+	}{
+		{"self-referencing kids", []string{
+			"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [2 0 R] >> >>",
+			"<< /Type /Pages /T (a) /Kids [2 0 R 2 0 R 3 0 R] >>",
+			"<< /Type /Page /T (b) /Parent 2 0 R >>",
+		}, 1},
+		{"parent loop", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Parent 3 0 R >>",
+			"<< /Type /Page /Parent 2 0 R >>",
+		}, 1},
+		{"page is its own parent", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Page /Parent 2 0 R >>",
+		}, 1},
+		{"doubled kids", doubledTree(60), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parsePdf(classicPdf(tt.objects, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan []pdfRef, 1)
+			go func() {
+				pages := doc.pages()
+				for _, page := range pages {
+					doc.pageAttr(page, "MediaBox")
+				}
+				doc.formFields()
+				doc.objectRoles()
+				done <- pages
+			}()
+			select {
+			case pages := <-done:
+				if len(pages) != tt.pages {
+					t.Fatalf("%d pages, want %d", len(pages), tt.pages)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("tree walk did not return within 5s")
+			}
+		})
+	}
+}
+
+// FuzzParsePdf checks that no input makes the reader panic or hang.
+func FuzzParsePdf(f *testing.F) {
+	f.Add(classicPdf([]string{"<< /Type /Catalog /Pages 2 0 R >>", "<< /Type /Pages /Kids [3 0 R] /Count 1 >>", "<< /Type /Page /Parent 2 0 R >>"}, ""))
+	f.Add(classicPdf([]string{"<< /Type /Catalog >>"}, "/XRefStm -5"))
+	f.Add(classicPdf([]string{"<< /Type /Catalog >>", "<< /Length 2 0 R >>\nstream\nabc\nendstream"}, ""))
+	f.Add(xrefStreamPdf([]string{"<< /Type /Catalog >>", "", objStm("2 0 ", "<< >>")}, map[int][2]int{2: {3, 0}}))
+	f.Add(xrefStreamPdf([]string{"<< /Type /Catalog >>", ""}, map[int][2]int{2: {2, 0}}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := parsePdf(data)
+		if err != nil {
+			return
+		}
+		for num := range doc.xref {
+			doc.object(num)
+		}
+		doc.pages()
+		doc.formFields()
+	})
+}
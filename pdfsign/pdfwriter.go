@@ -0,0 +1,291 @@
+package pdfsign
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// --- Incremental Updates ---
+// Changes are appended after the existing bytes as a new revision, never
+// rewriting what is already there, so earlier signatures stay valid. The
+// new cross-reference section matches the kind used by the previous
+// revision (classic table or xref stream).
+
+type pdfUpdate struct {
+	doc     *pdfDocument
+	objects map[int]pdfObject
+	gens    map[int]int
+	nextNum int
+}
+
+func (doc *pdfDocument) newUpdate() *pdfUpdate {
+	size, _ := doc.trailer["Size"].(int)
+	for num := range doc.xref {
+		if num >= size {
+			size = num + 1
+		}
+	}
+	return &pdfUpdate{doc: doc, objects: make(map[int]pdfObject), gens: make(map[int]int), nextNum: size}
+}
+
+// set replaces the indirect object ref in the new revision.
+func (u *pdfUpdate) set(ref pdfRef, obj pdfObject) {
+	u.objects[ref.Num] = obj
+	u.gens[ref.Num] = ref.Gen
+	u.doc.cache[ref.Num] = obj
+}
+
+// newPdfFile starts a PDF from scratch; the caller adds the objects and
+// sets the trailer's /Root before calling bytes.
+func newPdfFile() *pdfUpdate {
+	doc := &pdfDocument{
+		data:     []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"),
+		xref:     make(map[int]xrefEntry),
+		trailer:  pdfDict{},
+		cache:    make(map[int]pdfObject),
+		objStms:  make(map[int]*objectStream),
+		lastXref: -1,
+	}
+	return &pdfUpdate{doc: doc, objects: make(map[int]pdfObject), gens: make(map[int]int), nextNum: 1}
+}
+
+// add allocates a new indirect object.
+func (u *pdfUpdate) add(obj pdfObject) pdfRef {
+	ref := pdfRef{Num: u.nextNum}
+	u.nextNum++
+	u.set(ref, obj)
+	return ref
+}
+
+// bytes returns the original document followed by the new revision.
+func (u *pdfUpdate) bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(u.doc.data)
+	if n := len(u.doc.data); n > 0 && u.doc.data[n-1] != '\n' && u.doc.data[n-1] != '\r' {
+		buf.WriteByte('\n')
+	}
+
+	nums := make([]int, 0, len(u.objects))
+	for num := range u.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	offsets := make(map[int]int, len(nums))
+	for _, num := range nums {
+		offsets[num] = buf.Len()
+		fmt.Fprintf(&buf, "%d %d obj\n", num, u.gens[num])
+		writePdfObject(&buf, u.objects[num])
+		buf.WriteString("\nendobj\n")
+	}
+
+	trailer := pdfDict{
+		"Size": u.nextNum,
+		"Root": u.doc.trailer["Root"],
+	}
+	if u.doc.lastXref >= 0 {
+		trailer["Prev"] = int(u.doc.lastXref)
+	}
+	for _, key := range []pdfName{"Info", "ID"} {
+		if v, ok := u.doc.trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+
+	xrefOffset := buf.Len()
+	if u.doc.xrefStream {
+		xrefNum := u.nextNum
+		nums = append(nums, xrefNum)
+		offsets[xrefNum] = xrefOffset
+		trailer["Size"] = xrefNum + 1
+		trailer["Type"] = pdfName("XRef")
+		trailer["W"] = pdfArray{1, 4, 2}
+
+		var index pdfArray
+		var rows bytes.Buffer
+		for _, run := range xrefRuns(nums) {
+			index = append(index, run[0], len(run))
+			for _, num := range run {
+				off := offsets[num]
+				rows.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off)})
+				gen := u.gens[num]
+				rows.Write([]byte{byte(gen >> 8), byte(gen)})
+			}
+		}
+		trailer["Index"] = index
+		fmt.Fprintf(&buf, "%d 0 obj\n", xrefNum)
+		writePdfObject(&buf, &pdfStream{Dict: trailer, Raw: rows.Bytes()})
+		buf.WriteString("\nendobj\n")
+	} else {
+		buf.WriteString("xref\n")
+		if u.doc.lastXref < 0 {
+			buf.WriteString("0 1\n0000000000 65535 f\r\n")
+		}
+		for _, run := range xrefRuns(nums) {
+			fmt.Fprintf(&buf, "%d %d\n", run[0], len(run))
+			for _, num := range run {
+				fmt.Fprintf(&buf, "%010d %05d n\r\n", offsets[num], u.gens[num])
+			}
+		}
+		buf.WriteString("trailer\n")
+		writePdfObject(&buf, trailer)
+		buf.WriteByte('\n')
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes()
+}
+
+// xrefRuns groups sorted object numbers into consecutive subsections.
+func xrefRuns(nums []int) [][]int {
+	var runs [][]int
+	for _, num := range nums {
+		if n := len(runs); n > 0 && runs[n-1][len(runs[n-1])-1] == num-1 {
+			runs[n-1] = append(runs[n-1], num)
+			continue
+		}
+		runs = append(runs, []int{num})
+	}
+	return runs
+}
+
+// --- Serialization ---
+
+func writePdfObject(buf *bytes.Buffer, obj pdfObject) {
+	switch v := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case pdfName:
+		writePdfName(buf, v)
+	case pdfRef:
+		fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+	case pdfString:
+		writePdfString(buf, v)
+	case pdfKeyword:
+		buf.WriteString(string(v))
+	case pdfArray:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writePdfObject(buf, item)
+		}
+		buf.WriteByte(']')
+	case pdfDict:
+		writePdfDict(buf, v)
+	case *pdfStream:
+		d := make(pdfDict, len(v.Dict)+1)
+		for k, val := range v.Dict {
+			d[k] = val
+		}
+		d["Length"] = len(v.Raw)
+		writePdfDict(buf, d)
+		buf.WriteString("\nstream\n")
+		buf.Write(v.Raw)
+		buf.WriteString("\nendstream")
+	default:
+		panic(fmt.Sprintf("pdfsign: cannot serialize %T", obj))
+	}
+}
+
+func writePdfDict(buf *bytes.Buffer, d pdfDict) {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, string(k))
+	}
+	// Sorted for reproducible output.
+	sort.Strings(keys)
+	buf.WriteString("<<")
+	for _, k := range keys {
+		writePdfName(buf, pdfName(k))
+		buf.WriteByte(' ')
+		writePdfObject(buf, d[pdfName(k)])
+	}
+	buf.WriteString(">>")
+}
+
+func writePdfName(buf *bytes.Buffer, n pdfName) {
+	buf.WriteByte('/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < '!' || c > '~' || c == '#' || isPdfDelim(c) {
+			fmt.Fprintf(buf, "#%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func writePdfString(buf *bytes.Buffer, s pdfString) {
+	if s.Hex {
+		fmt.Fprintf(buf, "<%X>", s.Value)
+		return
+	}
+	buf.WriteByte('(')
+	for _, c := range s.Value {
+		switch c {
+		case '(', ')', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte(')')
+}
+
+// pdfTextString encodes s as a PDF text string: PDFDocEncoding-compatible
+// ASCII stays literal, anything else becomes UTF-16BE with a BOM.
+func pdfTextString(s string) pdfString {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return pdfString{Value: []byte(s)}
+	}
+	out := []byte{0xFE, 0xFF}
+	for _, r := range s {
+		if r > 0xFFFF {
+			r -= 0x10000
+			hi, lo := 0xD800+(r>>10), 0xDC00+(r&0x3FF)
+			out = append(out, byte(hi>>8), byte(hi), byte(lo>>8), byte(lo))
+			continue
+		}
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return pdfString{Value: out, Hex: true}
+}
+
+// decodeTextString is the inverse of pdfTextString for UTF-16BE (BOM) and
+// plain byte strings.
+func decodeTextString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		var runes []rune
+		for i := 2; i+1 < len(b); i += 2 {
+			r := rune(b[i])<<8 | rune(b[i+1])
+			if r >= 0xD800 && r < 0xDC00 && i+3 < len(b) {
+				lo := rune(b[i+2])<<8 | rune(b[i+3])
+				r = 0x10000 + (r-0xD800)<<10 + (lo - 0xDC00)
+				i += 2
+			}
+			runes = append(runes, r)
+		}
+		return string(runes)
+	}
+	return string(b)
+}
@@ -0,0 +1,421 @@
+package pdfsign
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// --- Stamp Placement ---
+// A Placement decides on which page(s) and where a new signature field goes:
+// an exact rectangle, a corner of the page, or next to an anchor text found
+// in the page content. Corners and anchor offsets are measured on the page
+// as displayed, i.e. after /Rotate, and every rectangle must lie inside the
+// visible area (CropBox clipped to MediaBox).
+
+// Placement describes where to create a signature field.
+type Placement struct {
+	// Page is a 1-based page number, "last", or "every". 0 and negative
+	// numbers count from the end like SignatureFieldSpec.Page. Empty means
+	// page 1, or every page for an anchor search.
+	Page string `json:"page" mapstructure:"page"`
+	// Rect is llx, lly, urx, ury in default user space (unrotated), the
+	// same coordinates as the widget's /Rect.
+	Rect []float64 `json:"rect" mapstructure:"rect"`
+	// Position is top-left, top-center, top-right, bottom-left,
+	// bottom-center or bottom-right, Margin points from the displayed edges.
+	Position string  `json:"position" mapstructure:"position"`
+	Margin   float64 `json:"margin" mapstructure:"margin"`
+	// Anchor is text to search for; the stamp goes to AnchorSide of it
+	// (right, left, above, below). AnchorOccurrence picks the n-th match
+	// (default 1). White space is ignored when matching.
+	Anchor           string `json:"anchor" mapstructure:"anchor"`
+	AnchorSide       string `json:"anchor_side" mapstructure:"anchor_side"`
+	AnchorOccurrence int    `json:"anchor_occurrence" mapstructure:"anchor_occurrence"`
+	// Offset moves a position or anchor placement by dx, dy displayed points.
+	Offset []float64 `json:"offset" mapstructure:"offset"`
+	// Width and Height override the template size.
+	Width  float64 `json:"width" mapstructure:"width"`
+	Height float64 `json:"height" mapstructure:"height"`
+}
+
+// LoadPlacementFromConfig reads the config.json key appearance_placement. It
+// returns nil when the key is absent (the field already exists).
+func LoadPlacementFromConfig(vip *viper.Viper) (*Placement, error) {
+	if !vip.IsSet("appearance_placement") {
+		return nil, nil
+	}
+	p := &Placement{}
+	if err := vip.UnmarshalKey("appearance_placement", p); err != nil {
+		return nil, fmt.Errorf("invalid appearance_placement: %w", err)
+	}
+	return p, nil
+}
+
+// PlaceSignatureField returns data plus an incremental update that creates
+// the unsigned signature field name at the placement. width and height are
+// the displayed stamp size (usually the template's); they are not used for
+// Rect placements. A placement on several pages creates one field with a
+// widget per page.
+func PlaceSignatureField(data []byte, name string, p Placement, width, height float64) ([]byte, error) {
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.encrypted() {
+		return nil, fmt.Errorf("%w: cannot add signature fields", ErrPdfEncrypted)
+	}
+	widgets, err := doc.resolvePlacement(p, width, height)
+	if err != nil {
+		return nil, fmt.Errorf("signature field '%s': %w", name, err)
+	}
+	return doc.addSignatureFields([]fieldPlan{{name: name, widgets: widgets}})
+}
+
+func (doc *pdfDocument) resolvePlacement(p Placement, width, height float64) ([]widgetPlan, error) {
+	modes := 0
+	for _, set := range []bool{len(p.Rect) > 0, p.Position != "", p.Anchor != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, errors.New("placement takes only one of rect, position and anchor")
+	}
+	if p.Width > 0 || p.Height > 0 {
+		width, height = p.Width, p.Height
+	}
+	if len(p.Rect) == 0 && (width <= 0 || height <= 0) {
+		return nil, fmt.Errorf("placement needs a stamp size (got %g x %g)", width, height)
+	}
+	var dx, dy float64
+	switch len(p.Offset) {
+	case 0:
+	case 2:
+		dx, dy = p.Offset[0], p.Offset[1]
+	default:
+		return nil, fmt.Errorf("offset must be [dx, dy], got %v", p.Offset)
+	}
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, errors.New("PDF has no pages")
+	}
+	indexes, err := placementPages(p.Page, p.Anchor != "", len(pages))
+	if err != nil {
+		return nil, err
+	}
+
+	var widgets []widgetPlan
+	occurrence := max(p.AnchorOccurrence, 1)
+	remaining := occurrence
+	for _, i := range indexes {
+		if p.Anchor != "" && p.Page == "" && len(widgets) > 0 {
+			break // first page that has the anchor
+		}
+		page := pages[i]
+		view, err := doc.pageView(page)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		var rect [4]float64
+		switch {
+		case len(p.Rect) > 0:
+			if len(p.Rect) != 4 {
+				return nil, fmt.Errorf("rect must have 4 numbers (llx, lly, urx, ury), got %d", len(p.Rect))
+			}
+			copy(rect[:], p.Rect)
+		case p.Anchor != "":
+			// Occurrences count per page for explicit pages, and across the
+			// document when searching all pages for the first match.
+			n := occurrence
+			if p.Page == "" {
+				n = remaining
+			}
+			box, left := doc.findAnchor(page, p.Anchor, n)
+			if p.Page == "" {
+				remaining = left
+			}
+			if left > 0 {
+				continue
+			}
+			vb := view.toView(box)
+			r, err := besideAnchor(vb, p.AnchorSide, width, height)
+			if err != nil {
+				return nil, err
+			}
+			rect = view.toUser(offsetRect(r, dx, dy))
+		default:
+			r, err := cornerRect(view, p.Position, p.Margin, width, height)
+			if err != nil {
+				return nil, err
+			}
+			rect = view.toUser(offsetRect(r, dx, dy))
+		}
+		if err := view.contains(rect); err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		widgets = append(widgets, widgetPlan{page: page, rect: rect})
+	}
+	if len(widgets) == 0 {
+		return nil, fmt.Errorf("anchor text '%s' (occurrence %d) not found", p.Anchor, occurrence)
+	}
+	return widgets, nil
+}
+
+// placementPages turns a Placement.Page into page indexes.
+func placementPages(spec string, anchor bool, count int) ([]int, error) {
+	all := func() []int {
+		out := make([]int, count)
+		for i := range out {
+			out[i] = i
+		}
+		return out
+	}
+	switch strings.ToLower(strings.TrimSpace(spec)) {
+	case "":
+		if anchor {
+			return all(), nil
+		}
+		return []int{0}, nil
+	case "every", "all":
+		return all(), nil
+	case "last":
+		return []int{count - 1}, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(spec))
+	if err != nil {
+		return nil, fmt.Errorf("page must be a number, 'last' or 'every', got '%s'", spec)
+	}
+	i := n - 1
+	if n <= 0 {
+		i = count - 1 + n
+	}
+	if i < 0 || i >= count {
+		return nil, fmt.Errorf("page %d out of range (document has %d pages)", n, count)
+	}
+	return []int{i}, nil
+}
+
+// pageView maps between default user space and the page as displayed: the
+// visible box rotated by /Rotate, with its origin at the displayed
+// bottom-left corner.
+type pageView struct {
+	box    [4]float64 // visible area in user space
+	rotate int        // 0, 90, 180 or 270, clockwise
+}
+
+func (doc *pdfDocument) pageView(page pdfRef) (pageView, error) {
+	box, err := doc.visibleBox(page)
+	if err != nil {
+		return pageView{}, err
+	}
+	return pageView{box: box, rotate: doc.pageRotation(page)}, nil
+}
+
+// visibleBox returns the page's CropBox clipped to its MediaBox.
+func (doc *pdfDocument) visibleBox(page pdfRef) ([4]float64, error) {
+	media, ok := doc.rectangle(doc.pageAttr(page, "MediaBox"))
+	if !ok {
+		return media, errors.New("page has no valid MediaBox")
+	}
+	crop, ok := doc.rectangle(doc.pageAttr(page, "CropBox"))
+	if !ok {
+		return media, nil
+	}
+	box := [4]float64{max(crop[0], media[0]), max(crop[1], media[1]), min(crop[2], media[2]), min(crop[3], media[3])}
+	if box[2] <= box[0] || box[3] <= box[1] {
+		return box, fmt.Errorf("CropBox %v does not overlap MediaBox %v", crop, media)
+	}
+	return box, nil
+}
+
+// pageRotation returns /Rotate normalised to 0, 90, 180 or 270.
+func (doc *pdfDocument) pageRotation(page pdfRef) int {
+	r, _ := doc.number(doc.pageAttr(page, "Rotate"))
+	return ((int(r)%360 + 360) % 360) / 90 * 90
+}
+
+func (v pageView) size() (w, h float64) {
+	w, h = v.box[2]-v.box[0], v.box[3]-v.box[1]
+	if v.rotate == 90 || v.rotate == 270 {
+		return h, w
+	}
+	return w, h
+}
+
+func (v pageView) pointToView(x, y float64) (float64, float64) {
+	ux, uy := x-v.box[0], y-v.box[1]
+	w, h := v.box[2]-v.box[0], v.box[3]-v.box[1]
+	switch v.rotate {
+	case 90:
+		return uy, w - ux
+	case 180:
+		return w - ux, h - uy
+	case 270:
+		return h - uy, ux
+	}
+	return ux, uy
+}
+
+func (v pageView) pointToUser(vx, vy float64) (float64, float64) {
+	w, h := v.box[2]-v.box[0], v.box[3]-v.box[1]
+	var ux, uy float64
+	switch v.rotate {
+	case 90:
+		ux, uy = w-vy, vx
+	case 180:
+		ux, uy = w-vx, h-vy
+	case 270:
+		ux, uy = vy, h-vx
+	default:
+		ux, uy = vx, vy
+	}
+	return ux + v.box[0], uy + v.box[1]
+}
+
+func (v pageView) toView(r [4]float64) [4]float64 {
+	x0, y0 := v.pointToView(r[0], r[1])
+	x1, y1 := v.pointToView(r[2], r[3])
+	return normRect(x0, y0, x1, y1)
+}
+
+func (v pageView) toUser(r [4]float64) [4]float64 {
+	x0, y0 := v.pointToUser(r[0], r[1])
+	x1, y1 := v.pointToUser(r[2], r[3])
+	return normRect(x0, y0, x1, y1)
+}
+
+// contains checks that r (user space) is non-empty and fully visible.
+func (v pageView) contains(r [4]float64) error {
+	const eps = 0.001
+	if r[2] <= r[0] || r[3] <= r[1] {
+		return fmt.Errorf("rect %v is empty or inverted", r)
+	}
+	if r[0] < v.box[0]-eps || r[1] < v.box[1]-eps || r[2] > v.box[2]+eps || r[3] > v.box[3]+eps {
+		return fmt.Errorf("rect %v lies outside the visible page area %v (CropBox/MediaBox)", roundRect(r), v.box)
+	}
+	return nil
+}
+
+func normRect(x0, y0, x1, y1 float64) [4]float64 {
+	return [4]float64{min(x0, x1), min(y0, y1), max(x0, x1), max(y0, y1)}
+}
+
+func offsetRect(r [4]float64, dx, dy float64) [4]float64 {
+	return [4]float64{r[0] + dx, r[1] + dy, r[2] + dx, r[3] + dy}
+}
+
+func roundRect(r [4]float64) [4]float64 {
+	for i := range r {
+		r[i] = math.Round(r[i]*100) / 100
+	}
+	return r
+}
+
+// cornerRect places a w x h stamp in a corner of the displayed page.
+func cornerRect(v pageView, position string, margin, w, h float64) ([4]float64, error) {
+	if position == "" {
+		position = "top-right"
+	}
+	if margin == 0 {
+		margin = 36
+	}
+	pw, ph := v.size()
+	vertical, horizontal, ok := strings.Cut(position, "-")
+	if !ok {
+		return [4]float64{}, fmt.Errorf("unknown position '%s'", position)
+	}
+	var x, y float64
+	switch horizontal {
+	case "left":
+		x = margin
+	case "center":
+		x = (pw - w) / 2
+	case "right":
+		x = pw - margin - w
+	default:
+		return [4]float64{}, fmt.Errorf("unknown position '%s'", position)
+	}
+	switch vertical {
+	case "top":
+		y = ph - margin - h
+	case "bottom":
+		y = margin
+	default:
+		return [4]float64{}, fmt.Errorf("unknown position '%s'", position)
+	}
+	return [4]float64{x, y, x + w, y + h}, nil
+}
+
+// besideAnchor places a w x h stamp next to an anchor box (both displayed).
+func besideAnchor(a [4]float64, side string, w, h float64) ([4]float64, error) {
+	const gap = 4
+	midY := (a[1] + a[3]) / 2
+	var x, y float64
+	switch side {
+	case "", "right":
+		x, y = a[2]+gap, midY-h/2
+	case "left":
+		x, y = a[0]-gap-w, midY-h/2
+	case "above":
+		x, y = a[0], a[3]+gap
+	case "below":
+		x, y = a[0], a[1]-gap-h
+	default:
+		return [4]float64{}, fmt.Errorf("unknown anchor_side '%s'", side)
+	}
+	return [4]float64{x, y, x + w, y + h}, nil
+}
+
+// findAnchor returns the user space box of the n-th occurrence of text on
+// the page, or how many occurrences are still missing when the page has
+// fewer. White space is ignored on both sides, since PDF producers often
+// drop or add spaces between text runs.
+func (doc *pdfDocument) findAnchor(page pdfRef, text string, n int) ([4]float64, int) {
+	var want []rune
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			want = append(want, r)
+		}
+	}
+	if len(want) == 0 {
+		return [4]float64{}, n
+	}
+	var glyphs []pageGlyph
+	for _, g := range doc.pageGlyphs(page) {
+		if !unicode.IsSpace(g.r) {
+			glyphs = append(glyphs, g)
+		}
+	}
+	for i := 0; i+len(want) <= len(glyphs); i++ {
+		match := true
+		for k, r := range want {
+			if glyphs[i+k].r != r {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if n--; n > 0 {
+			continue
+		}
+		box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, g := range glyphs[i : i+len(want)] {
+			// Descent and ascent approximated as 20% and 80% of the size.
+			box[0] = min(box[0], g.x0, g.x1)
+			box[2] = max(box[2], g.x0, g.x1)
+			box[1] = min(box[1], g.y0-0.2*g.size, g.y1-0.2*g.size)
+			box[3] = max(box[3], g.y0+0.8*g.size, g.y1+0.8*g.size)
+		}
+		return box, 0
+	}
+	return [4]float64{}, n
+}
@@ -0,0 +1,87 @@
+package pdfsign
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPlacementPages(t *testing.T) {
+	tests := []struct {
+		spec   string
+		anchor bool
+		want   []int
+		err    string
+	}{
+		{spec: "", want: []int{0}},
+		{spec: "", anchor: true, want: []int{0, 1, 2}},
+		{spec: "every", want: []int{0, 1, 2}},
+		{spec: " ALL ", want: []int{0, 1, 2}},
+		{spec: "last", want: []int{2}},
+		{spec: "2", want: []int{1}},
+		{spec: "0", want: []int{2}},
+		{spec: "-1", want: []int{1}},
+		{spec: "4", err: "page 4 out of range (document has 3 pages)"},
+		{spec: "-3", err: "page -3 out of range"},
+		{spec: "first", err: "page must be a number"},
+	}
+	for _, tt := range tests {
+		got, err := placementPages(tt.spec, tt.anchor, 3)
+		switch {
+		case tt.err == "" && (err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want)):
+			t.Errorf("placementPages(%q, %v) = %v, %v; want %v", tt.spec, tt.anchor, got, err, tt.want)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("placementPages(%q) = %v, want an error containing %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestResolvePlacement(t *testing.T) {
+	// "Sign:" is drawn at (100, 700) in 10 point Courier-width glyphs, so
+	// its box is x 100..125, y 698..708.
+	page := onePage("BT /F1 10 Tf 1 0 0 1 100 700 Tm (Sign:) Tj ET")
+	rotated := classicPdf([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 612 792] >>",
+		"<< /Type /Page /Parent 2 0 R /Rotate 90 >>",
+	}, "")
+	tests := []struct {
+		name string
+		data []byte
+		p    Placement
+		want [4]float64
+		err  string
+	}{
+		{name: "rect", data: page, p: Placement{Rect: []float64{10, 20, 110, 70}}, want: [4]float64{10, 20, 110, 70}},
+		{name: "default corner", data: page, want: [4]float64{476, 706, 576, 756}},
+		{name: "bottom-left with offset", data: page, p: Placement{Position: "bottom-left", Margin: 10, Offset: []float64{5, 5}}, want: [4]float64{15, 15, 115, 65}},
+		{name: "rotated top-left", data: rotated, p: Placement{Position: "top-left", Margin: 10}, want: [4]float64{10, 10, 60, 110}},
+		{name: "right of anchor", data: page, p: Placement{Anchor: "Sign :", Height: 20, Width: 100}, want: [4]float64{129, 693, 229, 713}},
+		{name: "below anchor", data: page, p: Placement{Anchor: "Sign:", AnchorSide: "below"}, want: [4]float64{100, 644, 200, 694}},
+		{name: "anchor missing", data: page, p: Placement{Anchor: "Date:"}, err: "anchor text 'Date:' (occurrence 1) not found"},
+		{name: "second occurrence missing", data: page, p: Placement{Anchor: "Sign:", AnchorOccurrence: 2}, err: "occurrence 2"},
+		{name: "outside the page", data: page, p: Placement{Rect: []float64{600, 20, 700, 70}}, err: "outside the visible page area"},
+		{name: "two modes", data: page, p: Placement{Rect: []float64{10, 20, 110, 70}, Anchor: "Sign:"}, err: "only one of"},
+		{name: "page out of range", data: page, p: Placement{Page: "2"}, err: "out of range"},
+		{name: "bad position", data: page, p: Placement{Position: "middle"}, err: "unknown position 'middle'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parsePdf(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			widgets, err := doc.resolvePlacement(tt.p, 100, 50)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolvePlacement = %v, want an error containing %q", err, tt.err)
+				}
+			case err != nil:
+				t.Fatalf("resolvePlacement: %v", err)
+			case len(widgets) != 1 || roundRect(widgets[0].rect) != tt.want:
+				t.Fatalf("widgets = %+v, want one at %v", widgets, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkat"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// --- Signature Policy (PAdES-EPES) ---
+// An explicit policy-based signature carries the signature-policy-identifier
+// signed attribute: the OID of the policy the signer signed under, the
+// digest of the policy document and optionally where to get it (SPURI).
+// Some counterparties only accept signatures under their own policy.
+//
+// The digest is computed over the policy document file as published (PDF,
+// XML or DER); a verifier holding a local copy recomputes it, so a signature
+// that names the right OID but a different version of the document is
+// caught. Only CAdES signatures (/ETSI.CAdES.detached) can carry the
+// attribute.
+
+var (
+	oidSigPolicyIDAttr = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 15}
+	oidSpqURI          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 5, 1}
+)
+
+// SignaturePolicy identifies a signature policy and its document.
+type SignaturePolicy struct {
+	OID string `json:"oid" mapstructure:"oid"`
+	// HashAlgorithm is sha256 (default), sha384, sha512 or sha1.
+	HashAlgorithm string `json:"hashAlgorithm" mapstructure:"hash_algorithm"`
+	// Hash is the base64 digest of the policy document as published by the
+	// policy issuer. It may be left empty when Document is set.
+	Hash string `json:"hash" mapstructure:"hash"`
+	// URI is the SPURI qualifier: where the policy document can be fetched.
+	URI string `json:"uri" mapstructure:"uri"`
+	// Document is a local copy of the policy document. When both Hash and
+	// Document are set they must agree.
+	Document string `json:"document" mapstructure:"document"`
+
+	oid    asn1.ObjectIdentifier
+	hash   crypto.Hash
+	digest []byte
+}
+
+// PolicyResult is what the verifier found about a signature's policy.
+type PolicyResult struct {
+	OID           string `json:"oid"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	URI           string `json:"uri,omitempty"`
+	// Verified is set when the digest matches the local policy document.
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+var policyHashes = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// Validate parses the OID and hash algorithm, reads Document and checks it
+// against Hash.
+func (p *SignaturePolicy) Validate() error {
+	oid, err := parseOID(p.OID)
+	if err != nil {
+		return fmt.Errorf("signature policy: %w", err)
+	}
+	alg := strings.ToLower(p.HashAlgorithm)
+	if alg == "" {
+		alg = "sha256"
+	}
+	h, ok := policyHashes[alg]
+	if !ok {
+		return fmt.Errorf("signature policy '%s': unsupported hash algorithm '%s' (use sha256, sha384, sha512 or sha1)", p.OID, p.HashAlgorithm)
+	}
+	p.oid, p.hash, p.HashAlgorithm = oid, h, alg
+
+	var digest []byte
+	if p.Hash != "" {
+		if digest, err = base64.StdEncoding.DecodeString(p.Hash); err != nil {
+			return fmt.Errorf("signature policy '%s': hash is not base64: %w", p.OID, err)
+		}
+		if len(digest) != h.Size() {
+			return fmt.Errorf("signature policy '%s': hash has %d bytes, %s needs %d", p.OID, len(digest), alg, h.Size())
+		}
+	}
+	if p.Document != "" {
+		raw, err := os.ReadFile(p.Document)
+		if err != nil {
+			return fmt.Errorf("failed to read policy document '%s': %w", p.Document, err)
+		}
+		computed := hashBytes(h, raw)
+		if digest != nil && !bytes.Equal(digest, computed) {
+			return fmt.Errorf("signature policy '%s': document '%s' does not match the configured hash", p.OID, p.Document)
+		}
+		digest = computed
+	}
+	if digest == nil {
+		return fmt.Errorf("signature policy '%s' needs a hash or a policy document", p.OID)
+	}
+	p.digest = digest
+	return nil
+}
+
+// LoadSignaturePolicyFromConfig reads and validates the config.json key
+// signature_policy ({oid, hash_algorithm, hash, uri, document}). It returns
+// nil when the key is not set.
+func LoadSignaturePolicyFromConfig(vip *viper.Viper) (*SignaturePolicy, error) {
+	if !vip.IsSet("signature_policy") {
+		return nil, nil
+	}
+	p := &SignaturePolicy{}
+	if err := vip.UnmarshalKey("signature_policy", p); err != nil {
+		return nil, fmt.Errorf("invalid signature_policy: %w", err)
+	}
+	if p.OID == "" {
+		return nil, nil
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ApplySignaturePolicy sets the Chilkat SignPdf options that embed p as the
+// signature-policy-identifier attribute. Since only CAdES signatures can
+// carry it, the subFilter is forced to /ETSI.CAdES.detached.
+func ApplySignaturePolicy(jsonOptions *chilkat.JsonObject, p *SignaturePolicy) error {
+	if p == nil {
+		return nil
+	}
+	if p.digest == nil {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	jsonOptions.UpdateString("subFilter", "/ETSI.CAdES.detached")
+	jsonOptions.UpdateString("policyId", p.oid.String())
+	jsonOptions.UpdateString("policyHash", base64.StdEncoding.EncodeToString(p.digest))
+	jsonOptions.UpdateString("policyHashAlg", p.HashAlgorithm)
+	if p.URI != "" {
+		jsonOptions.UpdateString("policyUri", p.URI)
+	}
+	return nil
+}
+
+// CheckSignaturePolicy verifies that the signature in field (the newest
+// one when field is empty) carries p exactly, like CheckSignatureInfo does
+// for the metadata.
+func CheckSignaturePolicy(data []byte, field string, p *SignaturePolicy) error {
+	if p == nil {
+		return nil
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return err
+	}
+	var target *signedField
+	for _, f := range doc.signedFields() {
+		if field == "" || f.name == field {
+			f := f
+			target = &f
+		}
+	}
+	if target == nil {
+		return errors.New("the signed document contains no signature to check the policy of")
+	}
+	sd, err := parseCMS(target.contents)
+	if err != nil {
+		return err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return fmt.Errorf("signature '%s' has no SignerInfo", target.name)
+	}
+	res := checkPolicy(sd.SignerInfos[0], []*SignaturePolicy{p})
+	switch {
+	case res == nil:
+		return fmt.Errorf("signature '%s' carries no signature policy", target.name)
+	case !res.Verified:
+		return fmt.Errorf("signature '%s': %s", target.name, res.Error)
+	case p.URI != "" && res.URI != p.URI:
+		return fmt.Errorf("signature '%s': policy URI is '%s', expected '%s'", target.name, res.URI, p.URI)
+	}
+	return nil
+}
+
+type sigPolicyID struct {
+	ID   asn1.ObjectIdentifier
+	Hash struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Value     []byte
+	}
+	Qualifiers []sigPolicyQualifier `asn1:"optional"`
+}
+
+type sigPolicyQualifier struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier asn1.RawValue
+}
+
+// checkPolicy decodes the SignerInfo's signature-policy-identifier and
+// compares it with the known policy of the same OID. It returns nil when
+// the signature has no policy.
+func checkPolicy(si cmsSignerInfo, known []*SignaturePolicy) *PolicyResult {
+	v, ok := findAttribute(si.SignedAttrs, oidSigPolicyIDAttr)
+	if !ok {
+		return nil
+	}
+	if v.Tag == asn1.TagNull {
+		return &PolicyResult{Error: "the policy is implied (signaturePolicyImplied), there is nothing to check"}
+	}
+	var id sigPolicyID
+	if _, err := asn1.Unmarshal(v.FullBytes, &id); err != nil {
+		return &PolicyResult{Error: fmt.Sprintf("invalid signature-policy-identifier: %v", err)}
+	}
+	res := &PolicyResult{OID: id.ID.String()}
+	for _, q := range id.Qualifiers {
+		var uri string
+		if q.ID.Equal(oidSpqURI) {
+			if _, err := asn1.UnmarshalWithParams(q.Qualifier.FullBytes, &uri, "ia5"); err == nil {
+				res.URI = uri
+			}
+		}
+	}
+	h, ok := hashFromOID(id.Hash.Algorithm.Algorithm)
+	if !ok {
+		res.Error = fmt.Sprintf("unsupported policy hash algorithm %s", id.Hash.Algorithm.Algorithm)
+		return res
+	}
+	for name, alg := range policyHashes {
+		if alg == h {
+			res.HashAlgorithm = name
+		}
+	}
+
+	var policy *SignaturePolicy
+	for _, p := range known {
+		if p != nil && p.oid.Equal(id.ID) {
+			policy = p
+		}
+	}
+	switch {
+	case policy == nil:
+		res.Error = fmt.Sprintf("no local copy of policy %s", res.OID)
+	case policy.hash == h:
+		if !bytes.Equal(policy.digest, id.Hash.Value) {
+			res.Error = fmt.Sprintf("policy %s digest does not match the local policy document", res.OID)
+		}
+	case policy.Document == "":
+		res.Error = fmt.Sprintf("policy %s is hashed with %s but only a %s hash is configured", res.OID, res.HashAlgorithm, policy.HashAlgorithm)
+	default:
+		raw, err := os.ReadFile(policy.Document)
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to read policy document '%s': %v", policy.Document, err)
+		} else if !bytes.Equal(hashBytes(h, raw), id.Hash.Value) {
+			res.Error = fmt.Sprintf("policy %s digest does not match the local policy document", res.OID)
+		}
+	}
+	res.Verified = res.Error == ""
+	return res
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID '%s'", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n := 0
+		if part == "" {
+			return nil, fmt.Errorf("invalid OID '%s'", s)
+		}
+		for _, c := range part {
+			if c < '0' || c > '9' || n > 1<<27 {
+				return nil, fmt.Errorf("invalid OID '%s'", s)
+			}
+			n = n*10 + int(c-'0')
+		}
+		oid[i] = n
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] > 39) {
+		return nil, fmt.Errorf("invalid OID '%s'", s)
+	}
+	return oid, nil
+}
@@ -0,0 +1,661 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+)
+
+// --- Pre-flight Checks ---
+// Preflight checks, before anything is signed, what SignPdf would otherwise
+// trip over somewhere in a LastErrorText dump: the configuration and its
+// secrets, the PKCS#11 library, slot, token and key, the signing
+// certificate (validity, key usage, chain), the TSA, OCSP and CRL endpoints
+// the signature will need, and the input PDF. Every check yields one row of
+// a pass/fail table; a failing check skips the ones that depend on it.
+//
+// Unlike the verifier, which works offline, the endpoint checks send real
+// requests: a timestamp request for a dummy digest, an OCSP request for the
+// signing certificate and a CRL download.
+
+// CheckStatus is the outcome of one pre-flight check.
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "PASS"
+	CheckWarn CheckStatus = "WARN" // signing works, but something deserves a look
+	CheckFail CheckStatus = "FAIL"
+	CheckSkip CheckStatus = "SKIP" // not applicable, or an earlier check failed
+)
+
+// CheckResult is one row of the pre-flight table.
+type CheckResult struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// PreflightOptions says what Preflight checks. Zero fields skip their
+// checks.
+type PreflightOptions struct {
+	Keys KeySource
+	// TokenLabel is the expected PKCS#11 token label (p11_token-label).
+	TokenLabel string
+	Algorithm  *Algorithm
+	Trust      *TrustStore
+	TsaURL     string
+	// Input is the PDF that is going to be signed; MDP, when set, is
+	// checked against it like PrepareMDP does.
+	Input   string
+	MDP     *MDPOptions
+	Timeout time.Duration // per HTTP request, default 10s
+}
+
+// certExpiryWarning is how close to notAfter a signing certificate is
+// reported as about to expire.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// Document signing extended key usages: id-kp-documentSigning (RFC 9336),
+// Microsoft Document Signing and Adobe Authentic Documents Trust.
+var documentSigningEKUs = map[string]bool{
+	"1.3.6.1.5.5.7.3.36":      true,
+	"1.3.6.1.4.1.311.10.3.12": true,
+	"1.2.840.113583.1.1.5":    true,
+}
+
+// LoadPreflightFromConfig reads the config.json key preflight
+// ({key_source, slot, input, tsa_url, timeout_seconds}) together with the
+// settings it checks. Every setting that fails to load becomes a FAIL row;
+// the returned options hold what did load.
+//
+// key_source is "pfx" (pfx_path, pfx_password) or "pkcs11"
+// (pkcs11_lib_path, hsm_pin, p11_token-label). input defaults to
+// pdf_input_path.
+func LoadPreflightFromConfig(vip *viper.Viper) (PreflightOptions, []CheckResult) {
+	var results []CheckResult
+	add := func(name string, err error, detail string) {
+		if err != nil {
+			results = append(results, CheckResult{Name: name, Status: CheckFail, Detail: err.Error()})
+			return
+		}
+		results = append(results, CheckResult{Name: name, Status: CheckPass, Detail: detail})
+	}
+
+	opts := PreflightOptions{
+		TsaURL:  vip.GetString("preflight.tsa_url"),
+		Input:   vip.GetString("preflight.input"),
+		Timeout: time.Duration(vip.GetInt("preflight.timeout_seconds")) * time.Second,
+	}
+	if opts.Input == "" {
+		opts.Input = vip.GetString("pdf_input_path")
+	}
+
+	if _, err := LoadSignatureInfoFromConfig(vip); err != nil {
+		add("config: signature_info", err, "")
+	} else {
+		add("config: signature_info", nil, "valid")
+	}
+	if p, err := LoadSignaturePolicyFromConfig(vip); err != nil {
+		add("config: signature_policy", err, "")
+	} else if p == nil {
+		results = append(results, CheckResult{Name: "config: signature_policy", Status: CheckSkip, Detail: "not set"})
+	} else {
+		add("config: signature_policy", nil, p.OID)
+	}
+	if a, err := LoadAlgorithmFromConfig(vip); err != nil {
+		add("config: signature_algorithm", err, "")
+	} else {
+		opts.Algorithm = &a
+		add("config: signature_algorithm", nil, a.String())
+	}
+	if mdp, err := LoadMDPOptionsFromConfig(vip); err != nil {
+		add("config: certification", err, "")
+	} else {
+		opts.MDP = &mdp
+		add("config: certification", nil, mdp.String())
+	}
+	if ts, err := LoadTrustStoreFromConfig(vip); err != nil {
+		add("config: trust store", err, "")
+	} else if ts == nil {
+		results = append(results, CheckResult{Name: "config: trust store", Status: CheckWarn, Detail: "no trust anchors configured, the chain cannot be validated"})
+	} else {
+		opts.Trust = ts
+		add("config: trust store", nil, fmt.Sprintf("%d signing anchors, %d TSA anchors", ts.Len(SigningTrust), ts.Len(TsaTrust)))
+	}
+
+	// Secrets are only reported as present or missing, never shown.
+	secret := func(key string) bool {
+		if vip.GetString(key) == "" {
+			add("secret: "+key, fmt.Errorf("'%s' is empty", key), "")
+			return false
+		}
+		add("secret: "+key, nil, "set")
+		return true
+	}
+	switch source := vip.GetString("preflight.key_source"); source {
+	case "pfx":
+		path := vip.GetString("pfx_path")
+		if path == "" {
+			add("config: key source", errors.New("'pfx_path' is empty"), "")
+		} else if _, err := os.Stat(path); err != nil {
+			add("config: key source", fmt.Errorf("PFX file: %w", err), "")
+		} else if secret("pfx_password") {
+			opts.Keys = &PfxKeySource{Path: path, Password: vip.GetString("pfx_password")}
+			add("config: key source", nil, opts.Keys.String())
+		}
+	case "pkcs11":
+		lib := vip.GetString("pkcs11_lib_path")
+		if lib == "" {
+			add("config: key source", errors.New("'pkcs11_lib_path' is empty"), "")
+		} else if secret("hsm_pin") {
+			opts.TokenLabel = vip.GetString("p11_token-label")
+			opts.Keys = &Pkcs11KeySource{LibPath: lib, Slot: vip.GetInt("preflight.slot"), Pin: vip.GetString("hsm_pin"), TokenLabel: opts.TokenLabel}
+			add("config: key source", nil, opts.Keys.String())
+		}
+	default:
+		add("config: key source", fmt.Errorf("invalid preflight.key_source '%s' (use pfx or pkcs11)", source), "")
+	}
+	return opts, results
+}
+
+// Preflight runs the checks opts asks for. It does not stop at the first
+// failure, so one run shows everything that needs fixing.
+func Preflight(opts PreflightOptions) []CheckResult {
+	p := &preflight{opts: opts, client: &http.Client{Timeout: opts.Timeout}}
+	if p.client.Timeout == 0 {
+		p.client.Timeout = 10 * time.Second
+	}
+	leaf, issuers := p.checkKey()
+	chain := p.checkCertificate(leaf, issuers)
+	p.checkTsa()
+	p.checkRevocationEndpoints(chain)
+	p.checkInput()
+	return p.results
+}
+
+// PreflightFailed reports whether any check failed.
+func PreflightFailed(results []CheckResult) bool {
+	for _, r := range results {
+		if r.Status == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatPreflightTable renders results as an aligned text table with a
+// summary line.
+func FormatPreflightTable(results []CheckResult) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tCHECK\tDETAIL")
+	counts := make(map[CheckStatus]int)
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Status, r.Name, firstLine(r.Detail))
+	}
+	w.Flush()
+	fmt.Fprintf(&buf, "%d passed, %d warnings, %d failed, %d skipped\n",
+		counts[CheckPass], counts[CheckWarn], counts[CheckFail], counts[CheckSkip])
+	return buf.String()
+}
+
+type preflight struct {
+	opts    PreflightOptions
+	client  *http.Client
+	results []CheckResult
+}
+
+func (p *preflight) add(name string, status CheckStatus, format string, args ...any) {
+	p.results = append(p.results, CheckResult{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// checkKey opens the key source (after probing the token, for PKCS#11) and
+// checks the algorithm against it. It returns the signing certificate and
+// the issuers the key source holds for it, or nil when the key is not
+// usable.
+func (p *preflight) checkKey() (*x509.Certificate, []*x509.Certificate) {
+	keys := p.opts.Keys
+	if keys == nil {
+		p.add("key: certificate", CheckSkip, "no usable key source configured")
+		return nil, nil
+	}
+	if h, ok := keys.(*Pkcs11KeySource); ok && !p.checkToken(h) {
+		p.add("key: certificate", CheckSkip, "token not usable")
+		return nil, nil
+	}
+	cert, err := keys.Cert()
+	if err != nil {
+		p.add("key: certificate", CheckFail, "%s", firstLine(err.Error()))
+		return nil, nil
+	}
+	if !cert.HasPrivateKey() {
+		p.add("key: certificate", CheckFail, "certificate '%s' has no private key", cert.SubjectCN())
+		return nil, nil
+	}
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		p.add("key: certificate", CheckFail, "%v", err)
+		return nil, nil
+	}
+	p.add("key: certificate", CheckPass, "'%s' with private key (%s)", leaf.Subject.CommonName, keys)
+
+	if p.opts.Algorithm == nil {
+		p.add("key: algorithm", CheckSkip, "no signature_algorithm")
+	} else if a, err := CheckKeySourceAlgorithm(keys, *p.opts.Algorithm); err != nil {
+		p.add("key: algorithm", CheckFail, "%v", err)
+	} else {
+		p.add("key: algorithm", CheckPass, "%s", a)
+	}
+	return leaf, chilkatIssuers(cert)
+}
+
+// checkToken loads the PKCS#11 library on its own and looks at the slot and
+// token before the key source logs in, so a missing token or locked PIN is
+// reported as such. It also refuses the login when the token has a single
+// PIN attempt left, since a readiness check must never lock the token.
+func (p *preflight) checkToken(h *Pkcs11KeySource) bool {
+	ctx := pkcs11.New(h.LibPath)
+	if ctx == nil {
+		p.add("pkcs11: library", CheckFail, "failed to load '%s'", h.LibPath)
+		return false
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		var p11err pkcs11.Error
+		if !errors.As(err, &p11err) || p11err != pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+			p.add("pkcs11: library", CheckFail, "Initialize failed for '%s': %v", h.LibPath, err)
+			return false
+		}
+	} else {
+		defer ctx.Finalize()
+	}
+	if info, err := ctx.GetInfo(); err == nil {
+		p.add("pkcs11: library", CheckPass, "%s %d.%d", strings.TrimSpace(info.ManufacturerID), info.LibraryVersion.Major, info.LibraryVersion.Minor)
+	} else {
+		p.add("pkcs11: library", CheckPass, "%s", h.LibPath)
+	}
+
+	name := fmt.Sprintf("pkcs11: slot %d", h.Slot)
+	slots, err := ctx.GetSlotList(false)
+	if err != nil {
+		p.add(name, CheckFail, "failed to list slots: %v", err)
+		return false
+	}
+	found := false
+	for _, s := range slots {
+		found = found || s == uint(h.Slot)
+	}
+	if !found {
+		p.add(name, CheckFail, "no such slot (the library has %d)", len(slots))
+		return false
+	}
+	slot, err := ctx.GetSlotInfo(uint(h.Slot))
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return false
+	}
+	if slot.Flags&pkcs11.CKF_TOKEN_PRESENT == 0 {
+		p.add(name, CheckFail, "no token present")
+		return false
+	}
+	p.add(name, CheckPass, "%s", strings.TrimSpace(slot.SlotDescription))
+
+	token, err := ctx.GetTokenInfo(uint(h.Slot))
+	if err != nil {
+		p.add("pkcs11: token", CheckFail, "%v", err)
+		return false
+	}
+	label := strings.TrimSpace(token.Label)
+	switch {
+	case token.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0:
+		p.add("pkcs11: token", CheckFail, "token '%s' is not initialised", label)
+		return false
+	case token.Flags&pkcs11.CKF_USER_PIN_LOCKED != 0:
+		p.add("pkcs11: token", CheckFail, "user PIN of token '%s' is locked", label)
+		return false
+	case p.opts.TokenLabel != "" && label != p.opts.TokenLabel:
+		p.add("pkcs11: token", CheckFail, "token is '%s', expected '%s'", label, p.opts.TokenLabel)
+		return false
+	case token.Flags&pkcs11.CKF_USER_PIN_FINAL_TRY != 0:
+		// Logging in with a wrong PIN now would lock the token.
+		p.add("pkcs11: token", CheckFail, "'%s': one PIN attempt left before the PIN locks; not logging in", label)
+		return false
+	case token.Flags&pkcs11.CKF_USER_PIN_COUNT_LOW != 0:
+		p.add("pkcs11: token", CheckWarn, "'%s': a wrong PIN was entered recently", label)
+	default:
+		p.add("pkcs11: token", CheckPass, "'%s'", label)
+	}
+	return true
+}
+
+// checkCertificate checks validity, key usage and the chain of leaf, built
+// with the issuers from the key source, and returns the chain (just leaf
+// when it cannot be built).
+func (p *preflight) checkCertificate(leaf *x509.Certificate, issuers []*x509.Certificate) []*x509.Certificate {
+	if leaf == nil {
+		for _, name := range []string{"cert: validity", "cert: key usage", "cert: chain"} {
+			p.add(name, CheckSkip, "no certificate")
+		}
+		return nil
+	}
+	now := time.Now()
+	switch {
+	case now.Before(leaf.NotBefore):
+		p.add("cert: validity", CheckFail, "not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	case now.After(leaf.NotAfter):
+		p.add("cert: validity", CheckFail, "expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	case leaf.NotAfter.Sub(now) < certExpiryWarning:
+		p.add("cert: validity", CheckWarn, "expires on %s", leaf.NotAfter.Format(time.RFC3339))
+	default:
+		p.add("cert: validity", CheckPass, "until %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	signing := x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment
+	switch {
+	case leaf.KeyUsage != 0 && leaf.KeyUsage&signing == 0:
+		p.add("cert: key usage", CheckFail, "key usage allows neither digitalSignature nor nonRepudiation")
+	case !documentSigningUsage(leaf):
+		p.add("cert: key usage", CheckWarn, "extended key usage does not include document signing; some validators reject it")
+	case leaf.KeyUsage == 0:
+		p.add("cert: key usage", CheckPass, "no key usage restriction")
+	default:
+		p.add("cert: key usage", CheckPass, "digitalSignature/nonRepudiation allowed")
+	}
+
+	if p.opts.Trust == nil {
+		p.add("cert: chain", CheckWarn, "no trust anchors configured, chain not validated")
+		return buildChain(leaf, issuers)
+	}
+	chain, err := p.opts.Trust.VerifyChain(SigningTrust, leaf, issuers, now)
+	if err != nil {
+		p.add("cert: chain", CheckFail, "%v", err)
+		return buildChain(leaf, append(issuers, p.opts.Trust.intermediates...))
+	}
+	p.add("cert: chain", CheckPass, "%d certificates, anchored at '%s'", len(chain), chain[len(chain)-1].Subject.CommonName)
+	return chain
+}
+
+// documentSigningUsage reports whether the extended key usage, if any,
+// admits document signing.
+func documentSigningUsage(c *x509.Certificate) bool {
+	if len(c.ExtKeyUsage) == 0 && len(c.UnknownExtKeyUsage) == 0 {
+		return true
+	}
+	for _, u := range c.ExtKeyUsage {
+		if u == x509.ExtKeyUsageAny || u == x509.ExtKeyUsageEmailProtection {
+			return true
+		}
+	}
+	for _, oid := range c.UnknownExtKeyUsage {
+		if documentSigningEKUs[oid.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTsa requests a timestamp for a dummy digest.
+func (p *preflight) checkTsa() {
+	if p.opts.TsaURL == "" {
+		p.add("tsa", CheckSkip, "no TSA URL")
+		return
+	}
+	name := "tsa: " + p.opts.TsaURL
+	der, err := requestTimestamp(p.client, p.opts.TsaURL, hashBytes(crypto.SHA256, []byte("preflight")))
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	token, err := parseCMS(der)
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	tsa := "unknown TSA"
+	if len(token.SignerInfos) > 0 {
+		if c, err := token.signerCert(token.SignerInfos[0]); err == nil {
+			tsa = "'" + c.Subject.CommonName + "'"
+			if p.opts.Trust != nil && p.opts.Trust.Len(TsaTrust) > 0 {
+				if _, err := p.opts.Trust.VerifyChain(TsaTrust, c, token.Certificates, time.Now()); err != nil {
+					p.add(name, CheckFail, "token from %s does not chain to a TSA anchor: %v", tsa, err)
+					return
+				}
+			}
+		}
+	}
+	p.add(name, CheckPass, "token granted by %s", tsa)
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			ReqCert ocspCertID
+		}
+	}
+}
+
+// checkRevocationEndpoints queries the OCSP responders and downloads the
+// CRLs named by every certificate of chain but its anchor, since an LTV
+// signature has to embed their answers.
+func (p *preflight) checkRevocationEndpoints(chain []*x509.Certificate) {
+	if len(chain) == 0 {
+		p.add("revocation", CheckSkip, "no certificate")
+		return
+	}
+	checked := 0
+	for i, cert := range chain {
+		if i > 0 && (i == len(chain)-1 || isSelfSigned(cert)) {
+			break
+		}
+		var issuer *x509.Certificate
+		if i+1 < len(chain) {
+			issuer = chain[i+1]
+		}
+		for _, url := range cert.OCSPServer {
+			p.checkOcsp(url, cert, issuer)
+			checked++
+		}
+		for _, url := range cert.CRLDistributionPoints {
+			p.checkCrl(url, cert, issuer)
+			checked++
+		}
+	}
+	if checked == 0 {
+		p.add("revocation", CheckWarn, "the certificates name no OCSP responder or CRL, LTV data cannot be embedded")
+	}
+}
+
+func (p *preflight) checkOcsp(url string, cert, issuer *x509.Certificate) {
+	name := "ocsp: " + url
+	if issuer == nil {
+		// Without the issuer no CertID can be built; reachability is all
+		// that can be checked.
+		start := time.Now()
+		_, err := p.get(url, false)
+		observeRemote("ocsp", start, err)
+		if err != nil {
+			p.add(name, CheckFail, "%v", err)
+			return
+		}
+		p.add(name, CheckWarn, "reachable; issuer of '%s' unknown, status not queried", cert.Subject.CommonName)
+		return
+	}
+	keyBits, err := subjectPublicKeyBits(issuer)
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	var req ocspRequest
+	req.TBSRequest.RequestList = make([]struct{ ReqCert ocspCertID }, 1)
+	req.TBSRequest.RequestList[0].ReqCert = ocspCertID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidHashSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: hashBytes(crypto.SHA1, issuer.RawSubject),
+		IssuerKeyHash:  hashBytes(crypto.SHA1, keyBits),
+		SerialNumber:   cert.SerialNumber,
+	}
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	start := time.Now()
+	body, err := p.post(url, "application/ocsp-request", der)
+	observeRemote("ocsp", start, err)
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	resp, err := parseOCSPResponse(body)
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	single, ok := resp.find(cert, issuer)
+	switch {
+	case !ok:
+		p.add(name, CheckFail, "response does not cover '%s'", cert.Subject.CommonName)
+	case single.Status == ocspRevoked:
+		p.add(name, CheckFail, "'%s' is revoked since %s", cert.Subject.CommonName, single.RevocationTime.Format(time.RFC3339))
+	case single.Status == ocspUnknown:
+		p.add(name, CheckWarn, "responder does not know '%s'", cert.Subject.CommonName)
+	default:
+		p.add(name, CheckPass, "'%s' is good", cert.Subject.CommonName)
+	}
+}
+
+func (p *preflight) checkCrl(url string, cert, issuer *x509.Certificate) {
+	name := "crl: " + url
+	start := time.Now()
+	body, err := p.get(url, true)
+	observeRemote("crl", start, err)
+	if err != nil {
+		p.add(name, CheckFail, "%v", err)
+		return
+	}
+	crl, err := x509.ParseRevocationList(body)
+	if err != nil {
+		p.add(name, CheckFail, "invalid CRL: %v", err)
+		return
+	}
+	if issuer != nil {
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			p.add(name, CheckFail, "CRL not signed by '%s': %v", issuer.Subject.CommonName, err)
+			return
+		}
+	}
+	for _, e := range crl.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			p.add(name, CheckFail, "'%s' is revoked since %s", cert.Subject.CommonName, e.RevocationTime.Format(time.RFC3339))
+			return
+		}
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		p.add(name, CheckWarn, "CRL is stale (next update was %s)", crl.NextUpdate.Format(time.RFC3339))
+		return
+	}
+	p.add(name, CheckPass, "'%s' not revoked", cert.Subject.CommonName)
+}
+
+// post sends an HTTP POST and returns the body of a 200 response.
+func (p *preflight) post(url, contentType string, body []byte) ([]byte, error) {
+	resp, err := p.client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+}
+
+// get sends an HTTP GET. Unless needOK is set any HTTP answer counts as
+// reachable.
+func (p *preflight) get(url string, needOK bool) ([]byte, error) {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if needOK && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+}
+
+// checkInput checks that the PDF parses, is not encrypted, is not locked by
+// a no-changes certification and suits the MDP options.
+func (p *preflight) checkInput() {
+	if p.opts.Input == "" {
+		p.add("pdf", CheckSkip, "no input PDF")
+		return
+	}
+	data, err := os.ReadFile(p.opts.Input)
+	if err != nil {
+		p.add("pdf: parse", CheckFail, "%v", err)
+		return
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		p.add("pdf: parse", CheckFail, "%v", err)
+		return
+	}
+	p.add("pdf: parse", CheckPass, "%s, %d pages", p.opts.Input, len(doc.pages()))
+	if doc.encrypted() {
+		p.add("pdf: encryption", CheckFail, "the document is encrypted")
+		return
+	}
+	p.add("pdf: encryption", CheckPass, "not encrypted")
+
+	switch level := doc.certificationLevel(); level {
+	case NotCertified:
+		p.add("pdf: certification", CheckPass, "not certified")
+	case CertifyNoChanges:
+		p.add("pdf: certification", CheckFail, "certified with no changes allowed, any further signature breaks it")
+	default:
+		p.add("pdf: certification", CheckPass, "certified (%s), further signatures allowed", level)
+	}
+
+	if p.opts.MDP == nil {
+		return
+	}
+	if _, err := PrepareMDP(data, *p.opts.MDP); err != nil {
+		p.add("pdf: certification options", CheckFail, "%v", err)
+	} else {
+		p.add("pdf: certification options", CheckPass, "%s", p.opts.MDP)
+	}
+}
+
+// certificationLevel returns the /P of the document's DocMDP signature
+// (/Perms /DocMDP), or NotCertified.
+func (doc *pdfDocument) certificationLevel() CertificationLevel {
+	sig := doc.dict(doc.dict(doc.catalog()["Perms"])["DocMDP"])
+	if sig == nil {
+		return NotCertified
+	}
+	for _, ref := range doc.array(sig["Reference"]) {
+		r := doc.dict(ref)
+		if m, _ := doc.resolve(r["TransformMethod"]).(pdfName); m != "DocMDP" {
+			continue
+		}
+		if n, ok := doc.number(doc.dict(r["TransformParams"])["P"]); ok && n >= 1 && n <= 3 {
+			return CertificationLevel(n)
+		}
+		return CertifyFormFilling // /P defaults to 2
+	}
+	return NotCertified
+}
@@ -0,0 +1,531 @@
+package pdfsign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"sync"
+	"unicode"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// --- Appearance Preview ---
+// Designers iterate on templates and placements without a signing key:
+// PreviewAppearance writes the stamp into an unsigned widget, which any PDF
+// viewer shows, and RenderPreview draws a PNG of each page carrying the
+// field. Neither touches a certificate, a PFX or the HSM.
+//
+// The PNG shows the page as displayed (after /Rotate) with the page text as
+// grey bars, the stamp drawn from the same layout as the PDF appearance, and
+// the field's rectangle outlined in red. Standard Helvetica text is drawn
+// with the Go font at Helvetica widths, so line breaks and alignment match
+// the signed stamp even though the letter shapes differ slightly.
+
+// PreviewAppearance returns data with the field created at p (when p is not
+// nil) and its widget showing the template filled with v. The field stays
+// unsigned.
+func PreviewAppearance(data []byte, field string, p *Placement, t *AppearanceTemplate, v AppearanceValues) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("no appearance template given")
+	}
+	if p != nil {
+		var err error
+		if data, err = PlaceSignatureField(data, field, *p, t.Width, t.Height); err != nil {
+			return nil, err
+		}
+	}
+	return PrepareAppearance(data, field, t, v)
+}
+
+// PreviewPage is the PNG preview of one page carrying the field.
+type PreviewPage struct {
+	Page int // 1-based
+	PNG  []byte
+}
+
+// RenderPreview draws every page that has a widget of field, with the
+// template filled with v in the widget's rectangle, at dpi (72 when 0).
+func RenderPreview(data []byte, field string, t *AppearanceTemplate, v AppearanceValues, dpi float64) ([]PreviewPage, error) {
+	if t == nil {
+		return nil, errors.New("no appearance template given")
+	}
+	if dpi <= 0 {
+		dpi = 72
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := doc.findField(field)
+	if !ok {
+		return nil, fmt.Errorf("signature field '%s' not found", field)
+	}
+	if f.Type != "Sig" {
+		return nil, fmt.Errorf("field '%s' is not a signature field", field)
+	}
+	lines, err := t.expandLines(v)
+	if err != nil {
+		return nil, fmt.Errorf("template '%s': %w", t.Name, err)
+	}
+	imagePath, err := t.imageFor(v)
+	if err != nil {
+		return nil, fmt.Errorf("template '%s': %w", t.Name, err)
+	}
+	fonts, err := t.fontChain()
+	if err != nil {
+		return nil, err
+	}
+
+	pages := doc.pages()
+	var out []PreviewPage
+	for _, ref := range fieldWidgets(doc, f) {
+		widget := doc.dict(ref)
+		index := widgetPage(doc, ref, pages)
+		if index < 0 {
+			return nil, fmt.Errorf("widget of field '%s' is on no page", field)
+		}
+		rect, ok := doc.rectangle(widget["Rect"])
+		if !ok {
+			return nil, fmt.Errorf("signature field '%s' has no valid /Rect", field)
+		}
+		view, err := doc.pageView(pages[index])
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", index+1, err)
+		}
+		r := view.toView(rect)
+		l, err := layoutStamp(t, lines, imagePath, fonts, r[2]-r[0], r[3]-r[1])
+		if err != nil {
+			return nil, fmt.Errorf("template '%s' in field '%s': %w", t.Name, field, err)
+		}
+
+		c := newPreviewCanvas(view, dpi/72)
+		c.drawPageText(doc.pageGlyphs(pages[index]))
+		if err := c.drawStamp(t, l, r); err != nil {
+			return nil, err
+		}
+		c.outline(r, color.RGBA{0xE0, 0x20, 0x20, 0xFF})
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, c.img); err != nil {
+			return nil, err
+		}
+		out = append(out, PreviewPage{Page: index + 1, PNG: buf.Bytes()})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("signature field '%s' has no widget annotation", field)
+	}
+	return out, nil
+}
+
+// widgetPage returns the index of the page showing the widget, from its /P
+// entry or else the pages' /Annots.
+func widgetPage(doc *pdfDocument, widget pdfRef, pages []pdfRef) int {
+	p, _ := doc.dict(widget)["P"].(pdfRef)
+	for i, page := range pages {
+		if page == p {
+			return i
+		}
+	}
+	for i, page := range pages {
+		for _, a := range doc.array(doc.dict(page)["Annots"]) {
+			if a == widget {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// previewCanvas is a page raster in displayed orientation.
+type previewCanvas struct {
+	img   *image.RGBA
+	scale float64 // pixels per point
+	view  pageView
+	h     float64 // displayed page height in points
+}
+
+func newPreviewCanvas(view pageView, scale float64) *previewCanvas {
+	w, h := view.size()
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(w*scale)), int(math.Ceil(h*scale))))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	return &previewCanvas{img: img, scale: scale, view: view, h: h}
+}
+
+// pixel converts a displayed point (origin bottom-left) to image pixels.
+func (c *previewCanvas) pixel(vx, vy float64) [2]float64 {
+	return [2]float64{vx * c.scale, (c.h - vy) * c.scale}
+}
+
+// drawPageText draws every visible character as a grey bar along its
+// baseline, about as high as a capital letter.
+func (c *previewCanvas) drawPageText(glyphs []pageGlyph) {
+	grey := color.RGBA{0xC8, 0xC8, 0xC8, 0xFF}
+	for _, g := range glyphs {
+		dx, dy := g.x1-g.x0, g.y1-g.y0
+		n := math.Hypot(dx, dy)
+		if unicode.IsSpace(g.r) || n == 0 {
+			continue
+		}
+		ux, uy := -dy/n*g.size*0.7, dx/n*g.size*0.7
+		var pts [][2]float64
+		for _, p := range [][2]float64{{g.x0, g.y0}, {g.x1, g.y1}, {g.x1 + ux, g.y1 + uy}, {g.x0 + ux, g.y0 + uy}} {
+			vx, vy := c.view.pointToView(p[0], p[1])
+			pts = append(pts, c.pixel(vx, vy))
+		}
+		fillPaths(c.img, grey, pts)
+	}
+}
+
+// drawStamp draws the layout in r (displayed points), on a layer that is
+// composited with the template opacity.
+func (c *previewCanvas) drawStamp(t *AppearanceTemplate, l *stampLayout, r [4]float64) error {
+	layer := image.NewRGBA(c.img.Bounds())
+	at := func(x, y float64) [2]float64 { return c.pixel(r[0]+x, r[1]+y) }
+	box := func(x0, y0, x1, y1 float64) [][2]float64 {
+		return [][2]float64{at(x0, y0), at(x1, y0), at(x1, y1), at(x0, y1)}
+	}
+	if t.Background != "" {
+		bg, _ := parseColor(t.Background)
+		fillPaths(layer, bg.rgba(), box(0, 0, l.w, l.h))
+	}
+	if b := t.Border; b != nil {
+		bc, _ := parseColor(b.Color)
+		bw := b.Width
+		// The inner rectangle runs the other way round and cuts the hole.
+		inner := box(bw, bw, l.w-bw, l.h-bw)
+		inner[1], inner[3] = inner[3], inner[1]
+		fillPaths(layer, bc.rgba(), box(0, 0, l.w, l.h), inner)
+	}
+	if l.image != nil {
+		c.drawImage(layer, l.image, at(l.imageBox.x, l.imageBox.y+l.imageBox.h),
+			at(l.imageBox.x+l.imageBox.w, l.imageBox.y), t.Image.Opacity)
+	}
+	if len(l.lines) > 0 {
+		fc, _ := parseColor(t.Font.Color)
+		for _, line := range l.lines {
+			x := line.x
+			for _, run := range line.runs {
+				for _, ch := range run.text {
+					if err := c.drawGlyph(layer, run.font, ch, at(x, line.y), l.size, fc.rgba()); err != nil {
+						return err
+					}
+					x += run.font.width(string(ch)) * l.size
+				}
+			}
+		}
+	}
+	mask := image.NewUniform(color.Alpha{uint8(math.Round(t.Opacity * 255))})
+	draw.DrawMask(c.img, c.img.Bounds(), layer, image.Point{}, mask, image.Point{}, draw.Over)
+	return nil
+}
+
+// drawImage scales img into the pixel rectangle from top-left tl to
+// bottom-right br.
+func (c *previewCanvas) drawImage(dst *image.RGBA, img *stampImage, tl, br [2]float64, opacity float64) {
+	rect := image.Rect(int(math.Round(tl[0])), int(math.Round(tl[1])), int(math.Round(br[0])), int(math.Round(br[1])))
+	if rect.Empty() {
+		return
+	}
+	scaled := image.NewRGBA(rect)
+	if img.svg != nil {
+		img.svg.rasterize(scaled, rect)
+	} else {
+		xdraw.CatmullRom.Scale(scaled, rect, img.pixels, img.pixels.Bounds(), xdraw.Over, nil)
+	}
+	mask := image.NewUniform(color.Alpha{uint8(math.Round(opacity * 255))})
+	draw.DrawMask(dst, rect, scaled, rect.Min, mask, image.Point{}, draw.Over)
+}
+
+// rasterize draws the drawing scaled to rect. Strokes are approximated by
+// a quad per flattened segment and a disc per vertex, which is close enough
+// to judge size and placement.
+func (img *svgImage) rasterize(dst *image.RGBA, rect image.Rectangle) {
+	sx, sy := float64(rect.Dx())/img.width, float64(rect.Dy())/img.height
+	at := func(p [2]float64) [2]float64 {
+		return [2]float64{float64(rect.Min.X) + p[0]*sx, float64(rect.Min.Y) + p[1]*sy}
+	}
+	for _, s := range img.shapes {
+		if s.fill != nil {
+			var ops []sfnt.SegmentOp
+			var pts [][2]float64
+			var start [2]float64
+			for _, seg := range s.path {
+				switch seg.op {
+				case 'M':
+					ops, pts, start = append(ops, sfnt.SegmentOpMoveTo), append(pts, at(seg.pts[0])), at(seg.pts[0])
+				case 'L':
+					ops, pts = append(ops, sfnt.SegmentOpLineTo), append(pts, at(seg.pts[0]))
+				case 'C':
+					ops, pts = append(ops, sfnt.SegmentOpCubeTo), append(pts, at(seg.pts[0]), at(seg.pts[1]), at(seg.pts[2]))
+				case 'Z':
+					ops, pts = append(ops, sfnt.SegmentOpLineTo), append(pts, start)
+				}
+			}
+			fillOutline(dst, s.fill.nrgba(s.fillOpacity), ops, pts)
+		}
+		if s.stroke != nil {
+			w := s.strokeWidth * (sx + sy) / 2
+			var polys [][][2]float64
+			for _, line := range flattenPath(s.path) {
+				for i := range line {
+					p := at(line[i])
+					polys = append(polys, oriented(disc(p, w/2)))
+					if i == 0 {
+						continue
+					}
+					q := at(line[i-1])
+					dx, dy := p[0]-q[0], p[1]-q[1]
+					n := math.Hypot(dx, dy)
+					if n == 0 {
+						continue
+					}
+					nx, ny := -dy/n*w/2, dx/n*w/2
+					polys = append(polys, oriented([][2]float64{{q[0] + nx, q[1] + ny}, {p[0] + nx, p[1] + ny}, {p[0] - nx, p[1] - ny}, {q[0] - nx, q[1] - ny}}))
+				}
+			}
+			fillPaths(dst, s.stroke.nrgba(s.strokeOpacity), polys...)
+		}
+	}
+}
+
+// flattenPath turns the subpaths into polylines.
+func flattenPath(path []pathSeg) [][][2]float64 {
+	var lines [][][2]float64
+	var cur [][2]float64
+	for _, seg := range path {
+		switch seg.op {
+		case 'M':
+			if len(cur) > 1 {
+				lines = append(lines, cur)
+			}
+			cur = [][2]float64{seg.pts[0]}
+		case 'L':
+			cur = append(cur, seg.pts[0])
+		case 'C':
+			p0 := cur[len(cur)-1]
+			for i := 1; i <= 16; i++ {
+				t := float64(i) / 16
+				mt := 1 - t
+				var p [2]float64
+				for k := range p {
+					p[k] = mt*mt*mt*p0[k] + 3*mt*mt*t*seg.pts[0][k] + 3*mt*t*t*seg.pts[1][k] + t*t*t*seg.pts[2][k]
+				}
+				cur = append(cur, p)
+			}
+		case 'Z':
+			if len(cur) > 0 {
+				cur = append(cur, cur[0])
+			}
+		}
+	}
+	if len(cur) > 1 {
+		lines = append(lines, cur)
+	}
+	return lines
+}
+
+// disc approximates a circle with an octagon.
+func disc(c [2]float64, r float64) [][2]float64 {
+	pts := make([][2]float64, 8)
+	for i := range pts {
+		a := float64(i) * math.Pi / 4
+		pts[i] = [2]float64{c[0] + r*math.Cos(a), c[1] + r*math.Sin(a)}
+	}
+	return pts
+}
+
+// oriented returns the polygon wound clockwise on screen. The rasterizer
+// adds up signed coverage, so overlapping pieces of one stroke must all
+// turn the same way or they would cancel out.
+func oriented(poly [][2]float64) [][2]float64 {
+	area := 0.0
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		area += p[0]*q[1] - q[0]*p[1]
+	}
+	if area < 0 {
+		for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+			poly[i], poly[j] = poly[j], poly[i]
+		}
+	}
+	return poly
+}
+
+// drawGlyph draws ch with its baseline origin at the pixel o.
+func (c *previewCanvas) drawGlyph(dst *image.RGBA, f appearanceFont, ch rune, o [2]float64, size float64, col color.Color) error {
+	if unicode.IsSpace(ch) {
+		return nil
+	}
+	outline, gid, err := glyphOutline(f, ch)
+	if err != nil {
+		return err
+	}
+	var buf sfnt.Buffer
+	segs, err := outline.LoadGlyph(&buf, gid, fixed.Int26_6(size*c.scale*64), nil)
+	if err != nil {
+		return nil // nothing to draw, e.g. a bitmap-only glyph
+	}
+	var paths [][2]float64
+	var ops []sfnt.SegmentOp
+	for _, s := range segs {
+		ops = append(ops, s.Op)
+		for _, a := range s.Args[:segmentPoints[s.Op]] {
+			paths = append(paths, [2]float64{o[0] + float64(a.X)/64, o[1] + float64(a.Y)/64})
+		}
+	}
+	fillOutline(dst, col, ops, paths)
+	return nil
+}
+
+// outline draws a one pixel frame around r (displayed points).
+func (c *previewCanvas) outline(r [4]float64, col color.Color) {
+	tl, br := c.pixel(r[0], r[3]), c.pixel(r[2], r[1])
+	outer := [][2]float64{tl, {br[0], tl[1]}, br, {tl[0], br[1]}}
+	inner := [][2]float64{{tl[0] + 1, tl[1] + 1}, {tl[0] + 1, br[1] - 1}, {br[0] - 1, br[1] - 1}, {br[0] - 1, tl[1] + 1}}
+	fillPaths(c.img, col, outer, inner)
+}
+
+func (c rgbColor) rgba() color.RGBA {
+	return color.RGBA{uint8(math.Round(c[0] * 255)), uint8(math.Round(c[1] * 255)), uint8(math.Round(c[2] * 255)), 0xFF}
+}
+
+func (c rgbColor) nrgba(opacity float64) color.NRGBA {
+	rgba := c.rgba()
+	return color.NRGBA{rgba.R, rgba.G, rgba.B, uint8(math.Round(opacity * 255))}
+}
+
+// fillPaths fills closed polygons (in pixels) with the nonzero rule.
+func fillPaths(dst *image.RGBA, col color.Color, polys ...[][2]float64) {
+	var ops []sfnt.SegmentOp
+	var pts [][2]float64
+	for _, poly := range polys {
+		for i, p := range poly {
+			op := sfnt.SegmentOpLineTo
+			if i == 0 {
+				op = sfnt.SegmentOpMoveTo
+			}
+			ops = append(ops, op)
+			pts = append(pts, p)
+		}
+	}
+	fillOutline(dst, col, ops, pts)
+}
+
+// segmentPoints is the number of points each segment operator takes.
+var segmentPoints = [...]int{
+	sfnt.SegmentOpMoveTo: 1,
+	sfnt.SegmentOpLineTo: 1,
+	sfnt.SegmentOpQuadTo: 2,
+	sfnt.SegmentOpCubeTo: 3,
+}
+
+// fillOutline rasterizes a path of sfnt segment operators; pts holds the
+// points of all segments in order. Only the bounding box of the path is
+// rasterized, clipped to dst.
+func fillOutline(dst *image.RGBA, col color.Color, ops []sfnt.SegmentOp, pts [][2]float64) {
+	if len(pts) == 0 {
+		return
+	}
+	minX, minY, maxX, maxY := pts[0][0], pts[0][1], pts[0][0], pts[0][1]
+	for _, p := range pts {
+		minX, minY = min(minX, p[0]), min(minY, p[1])
+		maxX, maxY = max(maxX, p[0]), max(maxY, p[1])
+	}
+	bounds := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY))).Intersect(dst.Bounds())
+	if bounds.Empty() {
+		return
+	}
+	z := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	at := func(i int) (float32, float32) {
+		return float32(pts[i][0] - float64(bounds.Min.X)), float32(pts[i][1] - float64(bounds.Min.Y))
+	}
+	i := 0
+	for _, op := range ops {
+		switch op {
+		case sfnt.SegmentOpMoveTo:
+			z.ClosePath()
+			z.MoveTo(at(i))
+			i++
+		case sfnt.SegmentOpLineTo:
+			z.LineTo(at(i))
+			i++
+		case sfnt.SegmentOpQuadTo:
+			bx, by := at(i)
+			cx, cy := at(i + 1)
+			z.QuadTo(bx, by, cx, cy)
+			i += 2
+		case sfnt.SegmentOpCubeTo:
+			bx, by := at(i)
+			cx, cy := at(i + 1)
+			dx, dy := at(i + 2)
+			z.CubeTo(bx, by, cx, cy, dx, dy)
+			i += 3
+		}
+	}
+	z.ClosePath()
+	z.Draw(dst, bounds, image.NewUniform(col), image.Point{})
+}
+
+var (
+	outlineFontsMu sync.Mutex
+	outlineFonts   = make(map[string]*sfnt.Font)
+)
+
+// glyphOutline returns the font and glyph to draw ch of f with: the
+// embedded font's own glyph, or the Go font standing in for Helvetica.
+func glyphOutline(f appearanceFont, ch rune) (*sfnt.Font, sfnt.GlyphIndex, error) {
+	outlineFontsMu.Lock()
+	defer outlineFontsMu.Unlock()
+	key := ""
+	if e, ok := f.(*embeddedFont); ok {
+		key = e.tt.path
+	}
+	font, ok := outlineFonts[key]
+	if !ok {
+		var err error
+		if key == "" {
+			font, err = sfnt.Parse(goregular.TTF)
+		} else {
+			font, err = parseOutlineFont(key)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("font '%s': %w", key, err)
+		}
+		outlineFonts[key] = font
+	}
+	if e, ok := f.(*embeddedFont); ok {
+		return font, sfnt.GlyphIndex(e.tt.cmap[ch]), nil
+	}
+	var buf sfnt.Buffer
+	gid, err := font.GlyphIndex(&buf, ch)
+	return font, gid, err
+}
+
+func parseOutlineFont(path string) (*sfnt.Font, error) {
+	file, index, err := splitFontPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("ttcf")) {
+		c, err := sfnt.ParseCollection(data)
+		if err != nil {
+			return nil, err
+		}
+		return c.Font(index)
+	}
+	return sfnt.Parse(data)
+}
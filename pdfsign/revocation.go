@@ -0,0 +1,319 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- Offline Revocation Checking ---
+// In offline mode nothing is fetched: every certificate of the signer and TSA
+// chains must have its revocation status proven by an OCSP response or CRL
+// that is already embedded in the PDF, either in the Document Security Store
+// (/DSS, added by LTV enabling) or inside the signature CMS (Adobe's
+// revocationInfoArchival attribute, the SignedData crls field, or the
+// timestamp token). Certificates without such proof are flagged.
+
+var oidRevocationInfoArchival = asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8}
+
+// Where a piece of embedded validation material was found.
+const (
+	SourceDSS             = "DSS"
+	SourceRevocationAttr  = "CMS revocationInfoArchival"
+	SourceSignedData      = "CMS SignedData"
+	SourceTimestampToken  = "timestamp token"
+	revocationNotRequired = "not required"
+)
+
+// RevocationCheck reports how the revocation status of one certificate was
+// established.
+type RevocationCheck struct {
+	Subject string     `json:"subject"`
+	Serial  string     `json:"serial"`
+	Status  string     `json:"status"`           // good, revoked, unknown or "not required"
+	Method  string     `json:"method,omitempty"` // OCSP, CRL, trust anchor, self-signed root or ocsp-nocheck
+	Source  string     `json:"source,omitempty"` // DSS, CMS revocationInfoArchival, ...
+	Issued  *time.Time `json:"issued,omitempty"` // thisUpdate of the OCSP response or CRL
+	Proven  bool       `json:"proven"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type embeddedOCSP struct {
+	resp   *ocspResponse
+	source string
+}
+
+type embeddedCRL struct {
+	list   *x509.RevocationList
+	source string
+}
+
+// revocationMaterial is all validation data available without a network.
+type revocationMaterial struct {
+	certs []*x509.Certificate
+	ocsps []embeddedOCSP
+	crls  []embeddedCRL
+	// problems records embedded items that could not be decoded
+	problems []string
+}
+
+func (m *revocationMaterial) addOCSP(der []byte, source string) {
+	resp, err := parseOCSPResponse(der)
+	if err != nil {
+		m.problems = append(m.problems, fmt.Sprintf("%s: %v", source, err))
+		return
+	}
+	m.ocsps = append(m.ocsps, embeddedOCSP{resp: resp, source: source})
+	m.certs = append(m.certs, resp.Certificates...)
+}
+
+func (m *revocationMaterial) addCRL(der []byte, source string) {
+	list, err := x509.ParseRevocationList(der)
+	if err != nil {
+		m.problems = append(m.problems, fmt.Sprintf("%s: invalid CRL: %v", source, err))
+		return
+	}
+	m.crls = append(m.crls, embeddedCRL{list: list, source: source})
+}
+
+// dssMaterial collects the certificates, OCSP responses and CRLs of the
+// document's /DSS dictionary.
+func dssMaterial(doc *pdfDocument) (*revocationMaterial, error) {
+	m := &revocationMaterial{}
+	if doc.encrypted() {
+		return m, fmt.Errorf("%w: its DSS cannot be read", ErrPdfEncrypted)
+	}
+	dss := doc.dict(doc.catalog()["DSS"])
+	if dss == nil {
+		return m, nil
+	}
+	streams := func(key pdfName) [][]byte {
+		var out [][]byte
+		for _, item := range doc.array(dss[key]) {
+			s, ok := doc.resolve(item).(*pdfStream)
+			if !ok {
+				continue
+			}
+			data, err := doc.decodeStream(s)
+			if err != nil {
+				m.problems = append(m.problems, fmt.Sprintf("%s /%s: %v", SourceDSS, key, err))
+				continue
+			}
+			out = append(out, data)
+		}
+		return out
+	}
+	for _, der := range streams("Certs") {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			m.problems = append(m.problems, fmt.Sprintf("%s: invalid certificate: %v", SourceDSS, err))
+			continue
+		}
+		m.certs = append(m.certs, cert)
+	}
+	for _, der := range streams("OCSPs") {
+		m.addOCSP(der, SourceDSS)
+	}
+	for _, der := range streams("CRLs") {
+		m.addCRL(der, SourceDSS)
+	}
+	return m, nil
+}
+
+// addCMS adds the validation material carried by one signature: its
+// certificates and CRLs, the revocationInfoArchival signed attribute, and
+// the same from the signature timestamp token.
+func (m *revocationMaterial) addCMS(sd *cmsSignedData) {
+	m.certs = append(m.certs, sd.Certificates...)
+	for _, der := range sd.CRLs {
+		m.addCRL(der, SourceSignedData)
+	}
+	if len(sd.SignerInfos) > 0 {
+		if v, ok := findAttribute(sd.SignerInfos[0].SignedAttrs, oidRevocationInfoArchival); ok {
+			m.addRevocationInfoArchival(v.FullBytes)
+		}
+	}
+	if tok, err := sd.timestampToken(); err == nil && tok != nil {
+		m.certs = append(m.certs, tok.Certificates...)
+		for _, der := range tok.CRLs {
+			m.addCRL(der, SourceTimestampToken)
+		}
+	}
+}
+
+// addRevocationInfoArchival decodes Adobe's attribute:
+//
+//	RevocationInfoArchival ::= SEQUENCE {
+//	  crl   [0] EXPLICIT SEQUENCE of CRLs OPTIONAL,
+//	  ocsp  [1] EXPLICIT SEQUENCE of OCSPResponse OPTIONAL,
+//	  otherRevInfo [2] EXPLICIT SEQUENCE of OtherRevInfo OPTIONAL }
+func (m *revocationMaterial) addRevocationInfoArchival(der []byte) {
+	var archival struct {
+		CRLs  asn1.RawValue `asn1:"explicit,optional,tag:0"`
+		OCSPs asn1.RawValue `asn1:"explicit,optional,tag:1"`
+		Other asn1.RawValue `asn1:"explicit,optional,tag:2"`
+	}
+	if _, err := asn1.Unmarshal(der, &archival); err != nil {
+		m.problems = append(m.problems, fmt.Sprintf("%s: %v", SourceRevocationAttr, err))
+		return
+	}
+	each := func(seq []byte, add func([]byte, string)) {
+		for rest := seq; len(rest) > 0; {
+			var raw asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+				m.problems = append(m.problems, fmt.Sprintf("%s: %v", SourceRevocationAttr, err))
+				return
+			}
+			add(raw.FullBytes, SourceRevocationAttr)
+		}
+	}
+	each(archival.CRLs.Bytes, m.addCRL)
+	each(archival.OCSPs.Bytes, m.addOCSP)
+}
+
+// buildChain walks from leaf towards a self-signed certificate using only the
+// given certificates. It is used when no trust store chain is available.
+func buildChain(leaf *x509.Certificate, pool []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	for cur := leaf; len(chain) < 10; {
+		if isSelfSigned(cur) {
+			break
+		}
+		var next *x509.Certificate
+		for _, c := range pool {
+			if bytes.Equal(c.RawSubject, cur.RawIssuer) && cur.CheckSignatureFrom(c) == nil {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		chain = append(chain, next)
+		cur = next
+	}
+	return chain
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawSubject, c.RawIssuer) && c.CheckSignatureFrom(c) == nil
+}
+
+// checkChainRevocation proves the status of every certificate in chain at
+// time at. The last certificate needs no proof when it is the trust anchor
+// (anchored) or a self-signed root.
+func (m *revocationMaterial) checkChainRevocation(chain []*x509.Certificate, at time.Time, anchored bool) []RevocationCheck {
+	checks := make([]RevocationCheck, 0, len(chain))
+	for i, cert := range chain {
+		check := RevocationCheck{Subject: cert.Subject.CommonName, Serial: cert.SerialNumber.Text(16)}
+		last := i == len(chain)-1
+		switch {
+		case last && anchored:
+			check.Status, check.Method, check.Proven = revocationNotRequired, "trust anchor", true
+		case last && isSelfSigned(cert):
+			check.Status, check.Method, check.Proven = revocationNotRequired, "self-signed root", true
+		case hasExtension(cert, oidOcspNoCheck):
+			check.Status, check.Method, check.Proven = revocationNotRequired, "ocsp-nocheck", true
+		case last:
+			check.Status = ocspUnknown
+			check.Error = "issuer certificate not available offline"
+		default:
+			m.proveStatus(&check, cert, chain[i+1], at)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// proveStatus looks for an OCSP response, then a CRL, that covers cert at
+// time at and is signed by an authorised party.
+func (m *revocationMaterial) proveStatus(check *RevocationCheck, cert, issuer *x509.Certificate, at time.Time) {
+	var problems []string
+	for _, e := range m.ocsps {
+		single, ok := e.resp.find(cert, issuer)
+		if !ok {
+			continue
+		}
+		responder, err := e.resp.responder(issuer, m.certs)
+		if err == nil {
+			err = e.resp.checkSignature(responder)
+		}
+		if err == nil && !covers(single.ThisUpdate, single.NextUpdate, at) {
+			err = fmt.Errorf("OCSP response of %s does not cover %s", single.ThisUpdate.Format(time.RFC3339), at.Format(time.RFC3339))
+		}
+		if err == nil && single.Status == ocspUnknown {
+			err = errors.New("OCSP responder does not know the certificate")
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s OCSP: %v", e.source, err))
+			continue
+		}
+		check.Method, check.Source, check.Issued, check.Proven = "OCSP", e.source, &single.ThisUpdate, true
+		check.Status = statusAt(single.Status == ocspRevoked, single.RevocationTime, at)
+		return
+	}
+
+	for _, e := range m.crls {
+		if !bytes.Equal(e.list.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		err := e.list.CheckSignatureFrom(issuer)
+		if err == nil && !covers(e.list.ThisUpdate, e.list.NextUpdate, at) {
+			err = fmt.Errorf("CRL of %s does not cover %s", e.list.ThisUpdate.Format(time.RFC3339), at.Format(time.RFC3339))
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s CRL: %v", e.source, err))
+			continue
+		}
+		revoked, revokedAt := false, time.Time{}
+		for _, entry := range e.list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				revoked, revokedAt = true, entry.RevocationTime
+				break
+			}
+		}
+		issued := e.list.ThisUpdate
+		check.Method, check.Source, check.Issued, check.Proven = "CRL", e.source, &issued, true
+		check.Status = statusAt(revoked, revokedAt, at)
+		return
+	}
+
+	check.Status = ocspUnknown
+	if len(problems) > 0 {
+		check.Error = fmt.Sprintf("no usable embedded revocation data: %v", problems)
+	} else {
+		check.Error = "no embedded OCSP response or CRL for this certificate"
+	}
+}
+
+// covers reports whether revocation data issued at thisUpdate speaks for
+// time at: either at falls inside its validity window, or the data was
+// issued afterwards (and so would show any earlier revocation).
+func covers(thisUpdate, nextUpdate, at time.Time) bool {
+	if !thisUpdate.Before(at) {
+		return true
+	}
+	return !nextUpdate.IsZero() && !at.After(nextUpdate)
+}
+
+// statusAt turns a revoked flag into the status at validation time; a
+// revocation after that time does not affect a signature made before it.
+func statusAt(revoked bool, revokedAt, at time.Time) string {
+	if revoked && !revokedAt.After(at) {
+		return ocspRevoked
+	}
+	return ocspGood
+}
+
+func hasExtension(c *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, e := range c.Extensions {
+		if e.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
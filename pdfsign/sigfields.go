@@ -0,0 +1,315 @@
+package pdfsign
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// --- Empty Signature Fields ---
+// AddSignatureFields prepares a document for several signers: it appends one
+// revision holding the new unsigned signature fields (merged field/widget
+// annotations) and, optionally, a seed value dictionary per field that tells
+// the signing application which SubFilter, digest and issuing CA to use.
+// Each party then fills its own field by name (unsignedSignatureField).
+
+// SignatureFieldSpec describes one empty signature field.
+type SignatureFieldSpec struct {
+	Name string `json:"name" mapstructure:"name"`
+	// Page is 1-based; 0 or less counts from the end (0 = last page).
+	Page int `json:"page" mapstructure:"page"`
+	// Rect is llx, lly, urx, ury in default user space of the page.
+	Rect []float64  `json:"rect" mapstructure:"rect"`
+	Seed *SeedValue `json:"seed,omitempty" mapstructure:"seed"`
+}
+
+// SeedValue constrains how a field may be signed. Empty lists impose no
+// constraint. The constraints are marked required (/Ff), so conforming
+// signing applications refuse other values.
+type SeedValue struct {
+	SubFilter    []string `json:"subFilter,omitempty" mapstructure:"subFilter"`       // e.g. ETSI.CAdES.detached
+	DigestMethod []string `json:"digestMethod,omitempty" mapstructure:"digestMethod"` // SHA256, SHA384, SHA512
+	// CertIssuers are files holding the acceptable issuing CA certificates
+	// (PEM, DER or PKCS#7 bundle).
+	CertIssuers []string `json:"certIssuers,omitempty" mapstructure:"certIssuers"`
+}
+
+var seedSubFilters = map[string]bool{
+	"adbe.pkcs7.detached": true,
+	"adbe.pkcs7.sha1":     true,
+	"adbe.x509.rsa_sha1":  true,
+	"ETSI.CAdES.detached": true,
+	"ETSI.RFC3161":        true,
+}
+
+var seedDigests = map[string]bool{"SHA1": true, "SHA256": true, "SHA384": true, "SHA512": true, "RIPEMD160": true}
+
+// Seed value /Ff bits (PDF 32000-1, Table 234 and 235).
+const (
+	svFlagSubFilter    = 1 << 1
+	svFlagDigestMethod = 1 << 6
+	svCertFlagIssuer   = 1 << 1
+)
+
+// LoadSignatureFieldSpecsFromConfig reads the config.json key
+// signature_fields, a list of {name, page, rect, seed} objects.
+func LoadSignatureFieldSpecsFromConfig(vip *viper.Viper) ([]SignatureFieldSpec, error) {
+	var specs []SignatureFieldSpec
+	if err := vip.UnmarshalKey("signature_fields", &specs); err != nil {
+		return nil, fmt.Errorf("invalid signature_fields: %w", err)
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("signature_fields is empty")
+	}
+	return specs, nil
+}
+
+// AddSignatureFields returns data plus an incremental update that adds the
+// given empty signature fields. Names must be new and unique, pages must
+// exist and rectangles must lie on the page.
+func AddSignatureFields(data []byte, specs []SignatureFieldSpec) ([]byte, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no signature fields given")
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.encrypted() {
+		return nil, fmt.Errorf("%w: cannot add signature fields", ErrPdfEncrypted)
+	}
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, errors.New("PDF has no pages")
+	}
+	plans := make([]fieldPlan, 0, len(specs))
+	for _, spec := range specs {
+		name := strings.TrimSpace(spec.Name)
+		pageIndex := spec.Page - 1
+		if spec.Page <= 0 {
+			pageIndex = len(pages) - 1 + spec.Page
+		}
+		if pageIndex < 0 || pageIndex >= len(pages) {
+			return nil, fmt.Errorf("signature field '%s': page %d out of range (document has %d pages)", name, spec.Page, len(pages))
+		}
+		pageRef := pages[pageIndex]
+		rect, err := validateFieldRect(doc, pageRef, spec.Rect)
+		if err != nil {
+			return nil, fmt.Errorf("signature field '%s': %w", name, err)
+		}
+		plans = append(plans, fieldPlan{name: name, seed: spec.Seed, widgets: []widgetPlan{{page: pageRef, rect: rect}}})
+	}
+	return doc.addSignatureFields(plans)
+}
+
+// fieldPlan is a validated signature field waiting to be written.
+type fieldPlan struct {
+	name    string
+	seed    *SeedValue
+	widgets []widgetPlan
+}
+
+type widgetPlan struct {
+	page pdfRef
+	rect [4]float64
+}
+
+// addSignatureFields writes the planned fields as one incremental update.
+// A field with a single widget is merged with it; a field shown on several
+// pages gets one child widget per page.
+func (doc *pdfDocument) addSignatureFields(plans []fieldPlan) ([]byte, error) {
+	names := make(map[string]bool)
+	for _, f := range doc.formFields() {
+		names[f.Name] = true
+	}
+
+	u := doc.newUpdate()
+	catalog := copyDict(doc.catalog())
+	catalogRef, _ := doc.trailer["Root"].(pdfRef)
+
+	// AcroForm may be absent, direct in the catalog, or indirect.
+	acroRef, acroIndirect := catalog["AcroForm"].(pdfRef)
+	acro := copyDict(doc.dict(catalog["AcroForm"]))
+	if acro == nil {
+		acro = pdfDict{}
+	}
+	fieldsRef, fieldsIndirect := acro["Fields"].(pdfRef)
+	fields := append(pdfArray(nil), doc.array(acro["Fields"])...)
+
+	// Pages may gain several widgets; keep one working copy per page.
+	pageDicts := make(map[pdfRef]pdfDict)
+	annotArrays := make(map[pdfRef]pdfArray) // indirect /Annots arrays
+	addAnnot := func(pageRef, widgetRef pdfRef) {
+		page, ok := pageDicts[pageRef]
+		if !ok {
+			page = copyDict(doc.dict(pageRef))
+			pageDicts[pageRef] = page
+		}
+		if annotsRef, ok := page["Annots"].(pdfRef); ok {
+			if _, seen := annotArrays[annotsRef]; !seen {
+				annotArrays[annotsRef] = append(pdfArray(nil), doc.array(annotsRef)...)
+			}
+			annotArrays[annotsRef] = append(annotArrays[annotsRef], widgetRef)
+		} else {
+			page["Annots"] = append(append(pdfArray(nil), doc.array(page["Annots"])...), widgetRef)
+		}
+	}
+
+	for i, plan := range plans {
+		name := plan.name
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("signature field %d has no name", i+1)
+		case strings.Contains(name, "."):
+			return nil, fmt.Errorf("signature field name '%s' must not contain '.'", name)
+		case names[name]:
+			return nil, fmt.Errorf("a field named '%s' already exists", name)
+		case len(plan.widgets) == 0:
+			return nil, fmt.Errorf("signature field '%s' has no widget", name)
+		}
+		names[name] = true
+
+		field := pdfDict{
+			"FT": pdfName("Sig"),
+			"T":  pdfTextString(name),
+		}
+		if plan.seed != nil {
+			sv, err := seedValueDict(plan.seed)
+			if err != nil {
+				return nil, fmt.Errorf("signature field '%s': %w", name, err)
+			}
+			field["SV"] = u.add(sv)
+		}
+		widget := func(w widgetPlan) pdfDict {
+			return pdfDict{
+				"Type":    pdfName("Annot"),
+				"Subtype": pdfName("Widget"),
+				"Rect":    pdfArray{w.rect[0], w.rect[1], w.rect[2], w.rect[3]},
+				"F":       4, // Print
+				"P":       w.page,
+			}
+		}
+
+		if len(plan.widgets) == 1 {
+			for k, v := range widget(plan.widgets[0]) {
+				field[k] = v
+			}
+			fieldRef := u.add(field)
+			fields = append(fields, fieldRef)
+			addAnnot(plan.widgets[0].page, fieldRef)
+			continue
+		}
+		fieldRef := u.add(field)
+		var kids pdfArray
+		for _, w := range plan.widgets {
+			kid := widget(w)
+			kid["Parent"] = fieldRef
+			kidRef := u.add(kid)
+			kids = append(kids, kidRef)
+			addAnnot(w.page, kidRef)
+		}
+		field["Kids"] = kids
+		u.set(fieldRef, field)
+		fields = append(fields, fieldRef)
+	}
+
+	for ref, page := range pageDicts {
+		u.set(ref, page)
+	}
+	for ref, annots := range annotArrays {
+		u.set(ref, annots)
+	}
+	if fieldsIndirect {
+		u.set(fieldsRef, fields)
+	} else {
+		acro["Fields"] = fields
+	}
+	if acroIndirect {
+		u.set(acroRef, acro)
+	} else {
+		catalog["AcroForm"] = acro
+		u.set(catalogRef, catalog)
+	}
+	return u.bytes(), nil
+}
+
+// validateFieldRect checks that r is a non-empty rectangle inside the
+// visible area of the page.
+func validateFieldRect(doc *pdfDocument, page pdfRef, r []float64) ([4]float64, error) {
+	var rect [4]float64
+	if len(r) != 4 {
+		return rect, fmt.Errorf("rect must have 4 numbers (llx, lly, urx, ury), got %d", len(r))
+	}
+	copy(rect[:], r)
+	if rect[2] <= rect[0] || rect[3] <= rect[1] {
+		return rect, fmt.Errorf("rect %v is empty or inverted", r)
+	}
+	view, err := doc.pageView(page)
+	if err != nil {
+		return rect, err
+	}
+	return rect, view.contains(rect)
+}
+
+func seedValueDict(seed *SeedValue) (pdfDict, error) {
+	sv := pdfDict{"Type": pdfName("SV")}
+	flags := 0
+	if len(seed.SubFilter) > 0 {
+		var list pdfArray
+		for _, sf := range seed.SubFilter {
+			if !seedSubFilters[sf] {
+				return nil, fmt.Errorf("unsupported seed subFilter '%s'", sf)
+			}
+			list = append(list, pdfName(sf))
+		}
+		sv["SubFilter"] = list
+		flags |= svFlagSubFilter
+	}
+	if len(seed.DigestMethod) > 0 {
+		var list pdfArray
+		for _, dm := range seed.DigestMethod {
+			dm = strings.ToUpper(strings.ReplaceAll(dm, "-", ""))
+			if !seedDigests[dm] {
+				return nil, fmt.Errorf("unsupported seed digestMethod '%s'", dm)
+			}
+			list = append(list, pdfName(dm))
+		}
+		sv["DigestMethod"] = list
+		flags |= svFlagDigestMethod
+	}
+	if len(seed.CertIssuers) > 0 {
+		var issuers pdfArray
+		for _, path := range seed.CertIssuers {
+			certs, err := loadCertificates(path, SigningTrust)
+			if err != nil {
+				return nil, fmt.Errorf("seed certIssuer: %w", err)
+			}
+			for _, c := range certs {
+				issuers = append(issuers, pdfString{Value: c.Raw, Hex: true})
+			}
+		}
+		sv["Cert"] = pdfDict{
+			"Type":   pdfName("SVCert"),
+			"Issuer": issuers,
+			"Ff":     svCertFlagIssuer,
+		}
+	}
+	if flags != 0 {
+		sv["Ff"] = flags
+	}
+	return sv, nil
+}
+
+func copyDict(d pdfDict) pdfDict {
+	if d == nil {
+		return nil
+	}
+	out := make(pdfDict, len(d)+1)
+	for k, v := range d {
+		out[k] = v
+	}
+	return out
+}
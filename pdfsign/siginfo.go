@@ -0,0 +1,283 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkat"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// --- Signature Metadata ---
+// A signature can say why, where and by whom it was made: the /Reason,
+// /Location, /ContactInfo and /Name entries of the signature dictionary, and
+// the CAdES commitment-type-indication signed attribute, which states the
+// signer's commitment (approval, proof of origin, ...) inside the CMS where
+// it is covered by the signature itself. The dictionary entries are not
+// signed by the CMS but they are inside the signed byte range.
+//
+// SignatureInfo is set per document (batch manifest entry, API request,
+// workflow signer). Chilkat may silently ignore an option it does not know,
+// so CheckSignatureInfo reads the signed output back and fails when any
+// requested value is missing.
+
+// SignatureInfo is the signer-supplied metadata of one signature. Empty
+// fields are left out of the signature.
+type SignatureInfo struct {
+	Reason      string `json:"reason,omitempty" mapstructure:"reason"`
+	Location    string `json:"location,omitempty" mapstructure:"location"`
+	ContactInfo string `json:"contactInfo,omitempty" mapstructure:"contact_info"`
+	Name        string `json:"name,omitempty" mapstructure:"name"`
+	// Commitment is a commitment type name (see commitmentTypes) or, in
+	// reports, the OID of a commitment type this package does not know.
+	Commitment string `json:"commitment,omitempty" mapstructure:"commitment"`
+}
+
+var oidCommitmentTypeAttr = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 16}
+
+// commitmentTypes are the commitment types of RFC 5126 section 5.11.1
+// (id-cti-ets-proofOf*).
+var commitmentTypes = map[string]asn1.ObjectIdentifier{
+	"proof-of-origin":   {1, 2, 840, 113549, 1, 9, 16, 6, 1},
+	"proof-of-receipt":  {1, 2, 840, 113549, 1, 9, 16, 6, 2},
+	"proof-of-delivery": {1, 2, 840, 113549, 1, 9, 16, 6, 3},
+	"proof-of-sender":   {1, 2, 840, 113549, 1, 9, 16, 6, 4},
+	"proof-of-approval": {1, 2, 840, 113549, 1, 9, 16, 6, 5},
+	"proof-of-creation": {1, 2, 840, 113549, 1, 9, 16, 6, 6},
+}
+
+// IsZero reports whether no field is set.
+func (info SignatureInfo) IsZero() bool {
+	return info == SignatureInfo{}
+}
+
+// Merge returns info with its empty fields taken from defaults.
+func (info SignatureInfo) Merge(defaults SignatureInfo) SignatureInfo {
+	pick := func(v, d string) string {
+		if v != "" {
+			return v
+		}
+		return d
+	}
+	return SignatureInfo{
+		Reason:      pick(info.Reason, defaults.Reason),
+		Location:    pick(info.Location, defaults.Location),
+		ContactInfo: pick(info.ContactInfo, defaults.ContactInfo),
+		Name:        pick(info.Name, defaults.Name),
+		Commitment:  pick(info.Commitment, defaults.Commitment),
+	}
+}
+
+// Validate checks the commitment type. PAdES (ETSI EN 319 142-1) forbids
+// /Reason when a commitment type is given, since the two could disagree.
+func (info SignatureInfo) Validate() error {
+	if info.Commitment == "" {
+		return nil
+	}
+	if _, ok := commitmentTypes[info.Commitment]; !ok {
+		names := make([]string, 0, len(commitmentTypes))
+		for name := range commitmentTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("invalid commitment type '%s' (use one of %s)", info.Commitment, strings.Join(names, ", "))
+	}
+	if info.Reason != "" {
+		return fmt.Errorf("reason '%s' and commitment type '%s' are mutually exclusive (PAdES), set only one", info.Reason, info.Commitment)
+	}
+	return nil
+}
+
+// ApplySignatureInfo sets the Chilkat SignPdf options for info on
+// jsonOptions. info must have been validated.
+func ApplySignatureInfo(jsonOptions *chilkat.JsonObject, info SignatureInfo) {
+	if info.Reason != "" {
+		jsonOptions.UpdateString("info.Reason", info.Reason)
+	}
+	if info.Location != "" {
+		jsonOptions.UpdateString("info.Location", info.Location)
+	}
+	if info.ContactInfo != "" {
+		jsonOptions.UpdateString("info.ContactInfo", info.ContactInfo)
+	}
+	if info.Name != "" {
+		jsonOptions.UpdateString("info.Name", info.Name)
+	}
+	if oid, ok := commitmentTypes[info.Commitment]; ok {
+		jsonOptions.UpdateString("commitmentTypeIndication", oid.String())
+	}
+}
+
+// AppearanceValues returns v with the stamp's {reason}, {location} and
+// {contact_info} taken from info where set, so the stamp shows what the
+// signature dictionary says.
+func (info SignatureInfo) AppearanceValues(v AppearanceValues) AppearanceValues {
+	if info.Reason != "" {
+		v.Reason = info.Reason
+	}
+	if info.Location != "" {
+		v.Location = info.Location
+	}
+	if info.ContactInfo != "" {
+		v.ContactInfo = info.ContactInfo
+	}
+	return v
+}
+
+// LoadSignatureInfoFromConfig reads the config.json key signature_info
+// ({reason, location, contact_info, name, commitment}). A missing key gives
+// an empty SignatureInfo.
+func LoadSignatureInfoFromConfig(vip *viper.Viper) (SignatureInfo, error) {
+	var info SignatureInfo
+	if !vip.IsSet("signature_info") {
+		return info, nil
+	}
+	if err := vip.UnmarshalKey("signature_info", &info); err != nil {
+		return info, fmt.Errorf("invalid signature_info: %w", err)
+	}
+	return info, info.Validate()
+}
+
+// CheckSignatureInfo verifies that the signature in field of the signed
+// document carries every value set in want. An empty field means the
+// signature whose /Contents comes last in the file, i.e. the newest one.
+func CheckSignatureInfo(data []byte, field string, want SignatureInfo) error {
+	if want.IsZero() {
+		return nil
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return err
+	}
+	sigs := doc.signedFields()
+	var target *signedField
+	for i := range sigs {
+		if field == "" || sigs[i].name == field {
+			target = &sigs[i]
+		}
+	}
+	if target == nil {
+		if field == "" {
+			return errors.New("the signed document contains no signature")
+		}
+		return fmt.Errorf("signature field '%s' is not signed", field)
+	}
+	got := target.info
+	if sd, err := parseCMS(target.contents); err == nil && len(sd.SignerInfos) > 0 {
+		got.Commitment = commitmentType(sd.SignerInfos[0])
+	}
+
+	var missing []string
+	check := func(key, w, g string) {
+		if w != "" && w != g {
+			missing = append(missing, fmt.Sprintf("%s '%s' (found '%s')", key, w, g))
+		}
+	}
+	check("reason", want.Reason, got.Reason)
+	check("location", want.Location, got.Location)
+	check("contact info", want.ContactInfo, got.ContactInfo)
+	check("name", want.Name, got.Name)
+	check("commitment type", want.Commitment, got.Commitment)
+	if len(missing) > 0 {
+		return fmt.Errorf("signature '%s' does not carry the requested %s", target.name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// signedField is a signed signature field with the metadata of its
+// signature dictionary.
+type signedField struct {
+	name      string
+	info      SignatureInfo // without Commitment, which lives in the CMS
+	contents  []byte        // /Contents, zero padded
+	offset    int           // of /Contents in the file
+	end       int           // end of the signed byte range
+	subFilter string        // without the slash, e.g. ETSI.CAdES.detached
+}
+
+// signedFields returns the signed signature fields in /Contents file order.
+func (doc *pdfDocument) signedFields() []signedField {
+	var out []signedField
+	for _, f := range doc.formFields() {
+		if !f.Signed {
+			continue
+		}
+		v := doc.dict(f.Dict["V"])
+		contents, ok := doc.resolve(v["Contents"]).(pdfString)
+		if !ok {
+			continue
+		}
+		text := func(key pdfName) string {
+			if s, ok := doc.resolve(v[key]).(pdfString); ok {
+				return decodeTextString(s.Value)
+			}
+			return ""
+		}
+		subFilter, _ := doc.resolve(v["SubFilter"]).(pdfName)
+		offset, end := -1, -1
+		if br := doc.array(v["ByteRange"]); len(br) == 4 {
+			if n, ok := doc.number(br[1]); ok {
+				offset = int(n)
+			}
+			c, ok1 := doc.number(br[2])
+			d, ok2 := doc.number(br[3])
+			if ok1 && ok2 {
+				end = int(c + d)
+			}
+		}
+		out = append(out, signedField{
+			name: f.Name,
+			info: SignatureInfo{
+				Reason:      text("Reason"),
+				Location:    text("Location"),
+				ContactInfo: text("ContactInfo"),
+				Name:        text("Name"),
+			},
+			contents:  contents.Value,
+			offset:    offset,
+			end:       end,
+			subFilter: string(subFilter),
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].offset < out[j].offset })
+	return out
+}
+
+// findSignedField returns the field whose /Contents holds cms.
+func findSignedField(fields []signedField, cms []byte) (signedField, bool) {
+	if len(cms) == 0 {
+		return signedField{}, false
+	}
+	for _, f := range fields {
+		if bytes.HasPrefix(f.contents, cms) {
+			return f, true
+		}
+	}
+	return signedField{}, false
+}
+
+// commitmentType returns the name (or OID) of the SignerInfo's
+// commitment-type-indication, or "".
+func commitmentType(si cmsSignerInfo) string {
+	v, ok := findAttribute(si.SignedAttrs, oidCommitmentTypeAttr)
+	if !ok {
+		return ""
+	}
+	var cti struct {
+		ID         asn1.ObjectIdentifier
+		Qualifiers asn1.RawValue `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(v.FullBytes, &cti); err != nil {
+		return ""
+	}
+	for name, oid := range commitmentTypes {
+		if oid.Equal(cti.ID) {
+			return name
+		}
+	}
+	return cti.ID.String()
+}
@@ -0,0 +1,159 @@
+package pdfsign
+
+import (
+	"chilkat"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// --- Single Document Signing ---
+// SignDocument signs one PDF held in memory with one key source, with the
+// PAdES level, field, placement and appearance chosen per call. It is what
+// a service exposes; the programs under making/ configure Chilkat directly.
+//
+// Problems with the document or the options (not a PDF, unknown field,
+// placement outside the page, ...) wrap ErrInvalidInput, so a caller can
+// tell them from failures of the key, the TSA or Chilkat.
+
+// PadesLevel is a PAdES baseline level.
+type PadesLevel string
+
+const (
+	LevelBB   PadesLevel = "B-B"
+	LevelBT   PadesLevel = "B-T"   // with a signature timestamp
+	LevelBLT  PadesLevel = "B-LT"  // and revocation data in the DSS
+	LevelBLTA PadesLevel = "B-LTA" // and a document timestamp
+)
+
+// ParsePadesLevel accepts "B-T", "b-t" or "PAdES-B-T". B-LTA is refused:
+// it needs a document timestamp over the signed file, which Chilkat does
+// not add while signing.
+func ParsePadesLevel(s string) (PadesLevel, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "PADES-")
+	switch level := PadesLevel(s); level {
+	case LevelBB, LevelBT, LevelBLT:
+		return level, nil
+	case LevelBLTA:
+		return "", fmt.Errorf("%w: PAdES level B-LTA is not supported for signing", ErrInvalidInput)
+	}
+	return "", fmt.Errorf("%w: unknown PAdES level '%s' (use B-B, B-T or B-LT)", ErrInvalidInput, s)
+}
+
+// apply sets the Chilkat options that reach the level.
+func (l PadesLevel) apply(jsonOptions *chilkat.JsonObject, tsaURL string) {
+	jsonOptions.UpdateString("subFilter", "/ETSI.CAdES.detached")
+	if l == LevelBB {
+		return
+	}
+	jsonOptions.UpdateBool("timestampToken.enabled", true)
+	jsonOptions.UpdateString("timestampToken.tsaUrl", tsaURL)
+	jsonOptions.UpdateBool("timestampToken.requestTsaCert", true)
+	if l == LevelBLT {
+		jsonOptions.UpdateBool("ltvOcsp", true)
+	}
+}
+
+// SignOptions controls SignDocument.
+type SignOptions struct {
+	// Level is the PAdES level; empty means B-B. B-T and B-LT need TsaURL.
+	Level  PadesLevel
+	TsaURL string
+	// Field is the unsigned signature field to fill. With Placement it is
+	// the name of the field created there. Empty adds a new signature
+	// without a visible field.
+	Field     string
+	Placement *Placement
+	// Appearance, when set, is drawn into Field before signing;
+	// AppearanceValues is completed with Info, the signer's common name and
+	// the signing time.
+	Appearance       *AppearanceTemplate
+	AppearanceValues AppearanceValues
+	Info             SignatureInfo
+	// Policy, when set, makes the signature policy-based (PAdES-EPES).
+	Policy *SignaturePolicy
+	// Algorithm, when set, is checked against the key (and HSM mechanisms).
+	Algorithm *Algorithm
+	// CheckChain lets Chilkat check the signer chain while signing.
+	CheckChain bool
+	// Log receives the signing log lines; nil means the default logger.
+	Log *slog.Logger
+}
+
+// SignDocument signs data with keys and returns the signed document.
+func SignDocument(data []byte, keys KeySource, opts SignOptions) ([]byte, SigSpaceReport, error) {
+	var space SigSpaceReport
+	level := opts.Level
+	if level == "" {
+		level = LevelBB
+	}
+	if _, err := ParsePadesLevel(string(level)); err != nil {
+		return nil, space, err
+	}
+	if level != LevelBB && opts.TsaURL == "" {
+		return nil, space, fmt.Errorf("%w: PAdES level %s needs a TSA URL", ErrInvalidInput, level)
+	}
+	if err := opts.Info.Validate(); err != nil {
+		return nil, space, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if opts.Placement != nil && opts.Field == "" {
+		return nil, space, fmt.Errorf("%w: a placement needs a field name", ErrInvalidInput)
+	}
+	if opts.Appearance != nil && opts.Field == "" {
+		return nil, space, fmt.Errorf("%w: an appearance needs a field name", ErrInvalidInput)
+	}
+
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, space, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if doc.encrypted() {
+		return nil, space, fmt.Errorf("%w: cannot be signed", ErrPdfEncrypted)
+	}
+	switch {
+	case opts.Placement != nil:
+		var width, height float64
+		if opts.Appearance != nil {
+			width, height = opts.Appearance.Width, opts.Appearance.Height
+		}
+		if data, err = PlaceSignatureField(data, opts.Field, *opts.Placement, width, height); err != nil {
+			return nil, space, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+	case opts.Field != "":
+		if err := checkUnsignedField(doc, opts.Field); err != nil {
+			return nil, space, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+	}
+
+	cert, err := keys.Cert()
+	if err != nil {
+		return nil, space, err
+	}
+	var alg *Algorithm
+	if opts.Algorithm != nil {
+		resolved, err := CheckKeySourceAlgorithm(keys, *opts.Algorithm)
+		if err != nil {
+			return nil, space, err
+		}
+		alg = &resolved
+	}
+	if opts.Appearance != nil {
+		v := opts.Info.AppearanceValues(opts.AppearanceValues)
+		v.CertCN = cert.SubjectCN()
+		v.SigningTime = time.Now()
+		if data, err = PrepareAppearance(data, opts.Field, opts.Appearance, v); err != nil {
+			return nil, space, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+	}
+	return signPdfData(data, cert, signParams{
+		field:     opts.Field,
+		trust:     opts.CheckChain,
+		configure: func(jsonOptions *chilkat.JsonObject) { level.apply(jsonOptions, opts.TsaURL) },
+		info:      opts.Info,
+		policy:    opts.Policy,
+		algorithm: alg,
+		log:       opts.Log,
+	})
+}
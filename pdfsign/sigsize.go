@@ -0,0 +1,193 @@
+package pdfsign
+
+import (
+	"chilkat"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// --- Signature Space ---
+// Chilkat reserves SigAllocateSize bytes for the CMS in /Contents before it
+// signs, since the signed byte range has to be fixed first. Too little and
+// SignPdf fails; too much and every signed copy carries zero padding.
+//
+// EstimateSigAllocateSize sizes the reservation from what goes into the
+// CMS: the signing certificate and its chain, the signature value, the
+// timestamp token and, for LTV, the embedded revocation data, one proof
+// per certificate of the chain below the root. SigAllocateSizeFor takes
+// the chain Chilkat builds for the certificate (the one it embeds), or the
+// trust store's when Chilkat finds no issuer. When Chilkat
+// still reports that the signature did not fit, RetrySigAllocate doubles
+// the reservation and signs again. MeasureSigSpace reads back how much of
+// the reservation was used, so the estimate can be tuned.
+
+const (
+	// sigSizeBase covers the SignedData structure and signed attributes.
+	sigSizeBase = 3000
+	// sigSizeIntermediate is assumed per issuer when the chain is unknown.
+	sigSizeIntermediate = 1800
+	// sigSizeTimestamp is a timestamp token with its TSA certificate chain.
+	sigSizeTimestamp = 6000
+	// sigSizeRevocation is one OCSP response or CRL. OCSP responses are
+	// 2-5 KB; a CA's CRL can be far larger, which the retry covers.
+	sigSizeRevocation = 10000
+
+	maxSigAllocateSize  = 1 << 20
+	sigAllocateAttempts = 3
+)
+
+// SigSizeOptions says what the CMS of a signature will carry besides the
+// signing certificate.
+type SigSizeOptions struct {
+	// Chain lists the issuers of the signing certificate that are embedded,
+	// up to the root. Nil assumes two intermediates of typical size and a
+	// root; empty means a self-signed certificate.
+	Chain      []*x509.Certificate
+	Timestamp  bool
+	Revocation bool // OCSP responses / CRLs embedded in the CMS (ltvOcsp)
+}
+
+// EstimateSigAllocateSize returns the SigAllocateSize for a signature by
+// leaf, with a quarter of headroom, rounded up to a KiB.
+func EstimateSigAllocateSize(leaf *x509.Certificate, opts SigSizeOptions) int {
+	n := sigSizeBase + len(leaf.Raw) + signatureValueSize(leaf.PublicKey)
+	// One revocation proof for the leaf and each issuer that is not a
+	// self-signed root.
+	proofs := 0
+	if !isSelfSigned(leaf) {
+		proofs = 1
+	}
+	if opts.Chain == nil {
+		n += 3 * sigSizeIntermediate
+		proofs += 2
+	}
+	for _, c := range opts.Chain {
+		n += len(c.Raw)
+		if !isSelfSigned(c) {
+			proofs++
+		}
+	}
+	if opts.Timestamp {
+		n += sigSizeTimestamp
+	}
+	if opts.Revocation {
+		n += proofs * sigSizeRevocation
+	}
+	n += n / 4
+	return (n + 1023) / 1024 * 1024
+}
+
+// SigAllocateSizeFor estimates the SigAllocateSize for cert signing with
+// jsonOptions, which must be complete (timestampToken.enabled, ltvOcsp).
+// trust may be nil.
+func SigAllocateSizeFor(cert *chilkat.Cert, jsonOptions *chilkat.JsonObject, trust *TrustStore) (int, error) {
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		return 0, err
+	}
+	return EstimateSigAllocateSize(leaf, SigSizeOptions{
+		Chain:      sigSizeChain(cert, leaf, trust),
+		Timestamp:  jsonOptions.BoolOf("timestampToken.enabled"),
+		Revocation: jsonOptions.BoolOf("ltvOcsp"),
+	}), nil
+}
+
+// sigSizeChain returns the issuers of leaf that go into the CMS: the chain
+// Chilkat builds for cert (from the PFX or HSM, the trusted roots and the
+// system store), or the signing chain of trust when Chilkat finds no
+// issuer. It returns nil when neither knows the chain.
+func sigSizeChain(cert *chilkat.Cert, leaf *x509.Certificate, trust *TrustStore) []*x509.Certificate {
+	if isSelfSigned(leaf) {
+		return []*x509.Certificate{}
+	}
+	issuers := chilkatIssuers(cert)
+	if len(issuers) == 0 && trust != nil {
+		if chain, err := trust.VerifyChain(SigningTrust, leaf, nil, time.Now()); err == nil {
+			issuers = chain[1:]
+		}
+	}
+	return issuers
+}
+
+func signatureValueSize(pub any) int {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return k.Size()
+	case *ecdsa.PublicKey:
+		return 2*((k.Curve.Params().BitSize+7)/8) + 9
+	case ed25519.PublicKey:
+		return ed25519.SignatureSize
+	}
+	return 512
+}
+
+// RetrySigAllocate calls sign with size and, while it fails with
+// ErrSigSpaceTooSmall, again with twice the size. sign must start from the
+// unsigned document each time. It returns the number of attempts made.
+func RetrySigAllocate(log *slog.Logger, size int, sign func(sigAllocateSize int) error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := sign(size)
+		if err == nil || !errors.Is(err, ErrSigSpaceTooSmall) {
+			return attempt, err
+		}
+		if attempt == sigAllocateAttempts || size >= maxSigAllocateSize {
+			return attempt, fmt.Errorf("signature still does not fit in %d bytes after %d attempts: %w", size, attempt, err)
+		}
+		size *= 2
+		if size > maxSigAllocateSize {
+			size = maxSigAllocateSize
+		}
+		log.Warn("signature did not fit, retrying", "sig_allocate_size", size, "attempt", attempt+1)
+	}
+}
+
+// SigSpaceReport is how much of the /Contents reservation a signature
+// uses.
+type SigSpaceReport struct {
+	Reserved int `json:"reserved"` // bytes of /Contents
+	Used     int `json:"used"`     // bytes of the CMS
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Wasted is the zero padding left in /Contents.
+func (r SigSpaceReport) Wasted() int { return r.Reserved - r.Used }
+
+func (r SigSpaceReport) String() string {
+	s := fmt.Sprintf("CMS uses %d of %d reserved bytes (%d bytes of padding)", r.Used, r.Reserved, r.Wasted())
+	if r.Attempts > 1 {
+		s += fmt.Sprintf(", after %d attempts", r.Attempts)
+	}
+	return s
+}
+
+// MeasureSigSpace reports the space use of the signature in field, or of
+// the newest signature when field is empty.
+func MeasureSigSpace(data []byte, field string) (SigSpaceReport, error) {
+	doc, err := parsePdf(data)
+	if err != nil {
+		return SigSpaceReport{}, err
+	}
+	var target *signedField
+	for _, f := range doc.signedFields() {
+		if field == "" || f.name == field {
+			f := f
+			target = &f
+		}
+	}
+	if target == nil {
+		return SigSpaceReport{}, errors.New("the document contains no signature to measure")
+	}
+	var cms asn1.RawValue
+	rest, err := asn1.Unmarshal(target.contents, &cms)
+	if err != nil {
+		return SigSpaceReport{}, fmt.Errorf("signature '%s': invalid CMS: %w", target.name, err)
+	}
+	return SigSpaceReport{Reserved: len(target.contents), Used: len(target.contents) - len(rest)}, nil
+}
@@ -0,0 +1,150 @@
+package pdfsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for cn, by parent (self-signed when nil).
+func testCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil || cn != "leaf",
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestEstimateSigAllocateSize(t *testing.T) {
+	root, rootKey := testCert(t, "root", nil, nil)
+	ca, caKey := testCert(t, "ca", root, rootKey)
+	leaf, _ := testCert(t, "leaf", ca, caKey)
+	self, _ := testCert(t, "self", nil, nil)
+
+	// base is the size without chain, timestamp or revocation data.
+	base := func(leaf *x509.Certificate) int {
+		return sigSizeBase + len(leaf.Raw) + signatureValueSize(leaf.PublicKey)
+	}
+	round := func(n int) int {
+		n += n / 4
+		return (n + 1023) / 1024 * 1024
+	}
+	tests := []struct {
+		name string
+		leaf *x509.Certificate
+		opts SigSizeOptions
+		want int
+	}{
+		{
+			name: "unknown chain",
+			leaf: leaf,
+			opts: SigSizeOptions{Revocation: true},
+			want: round(base(leaf) + 3*sigSizeIntermediate + 3*sigSizeRevocation),
+		},
+		{
+			name: "chain to the root",
+			leaf: leaf,
+			opts: SigSizeOptions{Chain: []*x509.Certificate{ca, root}, Revocation: true},
+			want: round(base(leaf) + len(ca.Raw) + len(root.Raw) + 2*sigSizeRevocation),
+		},
+		{
+			name: "chain without the root",
+			leaf: leaf,
+			opts: SigSizeOptions{Chain: []*x509.Certificate{ca}, Revocation: true},
+			want: round(base(leaf) + len(ca.Raw) + 2*sigSizeRevocation),
+		},
+		{
+			name: "no revocation data",
+			leaf: leaf,
+			opts: SigSizeOptions{Chain: []*x509.Certificate{ca, root}, Timestamp: true},
+			want: round(base(leaf) + sigSizeTimestamp + len(ca.Raw) + len(root.Raw)),
+		},
+		{
+			name: "self-signed",
+			leaf: self,
+			opts: SigSizeOptions{Chain: []*x509.Certificate{}, Revocation: true},
+			want: round(base(self)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateSigAllocateSize(tt.leaf, tt.opts); got != tt.want {
+				t.Fatalf("EstimateSigAllocateSize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetrySigAllocate(t *testing.T) {
+	tooSmall := chilkatError("SignPdf", "Not enough space for the signature.", ErrSigSpaceTooSmall)
+	other := errors.New("disk full")
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name     string
+		start    int
+		results  []error // returned by successive attempts, then nil
+		sizes    []int
+		tooSmall bool // the final error is ErrSigSpaceTooSmall
+		err      error
+	}{
+		{name: "fits", start: 8192, sizes: []int{8192}},
+		{name: "fits on the third attempt", start: 8192, results: []error{tooSmall, tooSmall}, sizes: []int{8192, 16384, 32768}},
+		{name: "never fits", start: 8192, results: []error{tooSmall, tooSmall, tooSmall}, sizes: []int{8192, 16384, 32768}, tooSmall: true},
+		{name: "other error", start: 8192, results: []error{other}, sizes: []int{8192}, err: other},
+		{name: "capped", start: 700000, results: []error{tooSmall, tooSmall}, sizes: []int{700000, maxSigAllocateSize}, tooSmall: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sizes []int
+			attempts, err := RetrySigAllocate(log, tt.start, func(size int) error {
+				sizes = append(sizes, size)
+				if len(sizes) <= len(tt.results) {
+					return tt.results[len(sizes)-1]
+				}
+				return nil
+			})
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.sizes) || attempts != len(tt.sizes) {
+				t.Fatalf("%d attempts with sizes %v, want %v", attempts, sizes, tt.sizes)
+			}
+			switch {
+			case tt.tooSmall:
+				if !errors.Is(err, ErrSigSpaceTooSmall) || !strings.Contains(err.Error(), "still does not fit") {
+					t.Fatalf("err = %v, want ErrSigSpaceTooSmall after the retries", err)
+				}
+			case err != tt.err:
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package pdfsign
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- Stamp Rendering ---
+// PrepareAppearance draws a template into the widget of an unsigned
+// signature field (/AP /N form XObject) as an incremental update. Signing
+// the field afterwards with appearance.fillUnsignedSignatureField and no
+// appearance.text options keeps the prepared appearance, so the stamp looks
+// the same whichever main or signer fills the field.
+
+// PrepareAppearance returns data plus an incremental update that sets the
+// appearance of the unsigned signature field to the rendered template.
+func PrepareAppearance(data []byte, field string, t *AppearanceTemplate, v AppearanceValues) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("no appearance template given")
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.encrypted() {
+		return nil, fmt.Errorf("%w: cannot prepare an appearance", ErrPdfEncrypted)
+	}
+	if err := checkUnsignedField(doc, field); err != nil {
+		return nil, err
+	}
+	f, _ := doc.findField(field)
+	widgets := fieldWidgets(doc, f)
+	if len(widgets) == 0 {
+		return nil, fmt.Errorf("signature field '%s' has no widget annotation", field)
+	}
+
+	lines, err := t.expandLines(v)
+	if err != nil {
+		return nil, fmt.Errorf("template '%s': %w", t.Name, err)
+	}
+	imagePath, err := t.imageFor(v)
+	if err != nil {
+		return nil, fmt.Errorf("template '%s': %w", t.Name, err)
+	}
+	u := doc.newUpdate()
+	for _, ref := range widgets {
+		widget := doc.dict(ref)
+		rect, ok := doc.rectangle(widget["Rect"])
+		if !ok {
+			return nil, fmt.Errorf("signature field '%s' has no valid /Rect", field)
+		}
+		w, h := rect[2]-rect[0], rect[3]-rect[1]
+		if w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("signature field '%s' is invisible (zero-sized /Rect)", field)
+		}
+		// On a rotated page the stamp is drawn upright as displayed.
+		rotate := 0
+		if page, ok := widget["P"].(pdfRef); ok {
+			rotate = doc.pageRotation(page)
+		}
+		if rotate == 90 || rotate == 270 {
+			w, h = h, w
+		}
+		ap, err := renderStamp(u, t, lines, imagePath, w, h, rotate)
+		if err != nil {
+			return nil, fmt.Errorf("template '%s' in field '%s': %w", t.Name, field, err)
+		}
+		widget = copyDict(widget)
+		widget["AP"] = pdfDict{"N": ap}
+		widget["F"] = 4 // Print
+		u.set(ref, widget)
+	}
+	return u.bytes(), nil
+}
+
+// fieldWidgets returns the widget annotations of a field: the field itself
+// when merged with its widget, otherwise its kids.
+func fieldWidgets(doc *pdfDocument, f formField) []pdfRef {
+	if _, ok := f.Dict["Rect"]; ok {
+		return []pdfRef{f.Ref}
+	}
+	var out []pdfRef
+	for _, kid := range doc.array(f.Dict["Kids"]) {
+		if ref, ok := kid.(pdfRef); ok && doc.dict(ref)["Rect"] != nil {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// stampCanvas collects the content stream and resources of one appearance.
+type stampCanvas struct {
+	buf      bytes.Buffer
+	fontRes  map[appearanceFont]pdfName
+	used     []appearanceFont // in order of first use
+	xobjects pdfDict
+	gstates  pdfDict
+}
+
+type stampBox struct{ x, y, w, h float64 }
+
+// stampLayout is a template laid out in a w x h (as displayed) appearance.
+// The PDF form and the PNG preview are both drawn from it.
+type stampLayout struct {
+	w, h     float64
+	image    *stampImage
+	imageBox stampBox // the picture itself, aspect ratio kept
+	lines    []stampLine
+	size     float64 // font size
+}
+
+// stampLine is a line of text with its baseline starting at x, y.
+type stampLine struct {
+	x, y float64
+	runs []textRun
+}
+
+// layoutStamp places the image at imagePath and the text of t in a w x h
+// appearance.
+func layoutStamp(t *AppearanceTemplate, lines []string, imagePath string, fonts []appearanceFont, w, h float64) (*stampLayout, error) {
+	l := &stampLayout{w: w, h: h}
+	inset := t.Padding
+	if t.Border != nil {
+		inset += t.Border.Width
+	}
+	inner := stampBox{inset, inset, w - 2*inset, h - 2*inset}
+	if inner.w <= 0 || inner.h <= 0 {
+		return nil, fmt.Errorf("padding and border leave no room in a %gx%g field", w, h)
+	}
+
+	textBox := inner
+	if t.Layout != "text-only" {
+		img, err := loadStampImage(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		gap := t.Padding
+		imgBox := inner
+		r := t.Image.Ratio
+		switch t.Layout {
+		case "image-left":
+			imgBox.w = inner.w * r
+			textBox = stampBox{inner.x + imgBox.w + gap, inner.y, inner.w - imgBox.w - gap, inner.h}
+		case "image-right":
+			imgBox = stampBox{inner.x + inner.w*(1-r), inner.y, inner.w * r, inner.h}
+			textBox.w = inner.w - imgBox.w - gap
+		case "image-top":
+			imgBox = stampBox{inner.x, inner.y + inner.h*(1-r), inner.w, inner.h * r}
+			textBox.h = inner.h - imgBox.h - gap
+		}
+		// Scale the image into its box keeping the aspect ratio, centered.
+		scale := min(imgBox.w/float64(img.width), imgBox.h/float64(img.height))
+		dw, dh := float64(img.width)*scale, float64(img.height)*scale
+		l.image = img
+		l.imageBox = stampBox{imgBox.x + (imgBox.w-dw)/2, imgBox.y + (imgBox.h-dh)/2, dw, dh}
+	}
+	if len(lines) > 0 {
+		if err := l.layoutText(t, lines, fonts, textBox); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// renderStamp writes the form XObject for a w x h (as displayed) appearance
+// on a page rotated clockwise by rotate degrees, and returns its reference.
+func renderStamp(u *pdfUpdate, t *AppearanceTemplate, lines []string, imagePath string, w, h float64, rotate int) (pdfRef, error) {
+	fonts, err := t.fontChain()
+	if err != nil {
+		return pdfRef{}, err
+	}
+	l, err := layoutStamp(t, lines, imagePath, fonts, w, h)
+	if err != nil {
+		return pdfRef{}, err
+	}
+	c := &stampCanvas{
+		fontRes:  make(map[appearanceFont]pdfName),
+		xobjects: pdfDict{},
+		gstates:  pdfDict{},
+	}
+	c.op("q")
+	if t.Opacity < 1 {
+		c.op("%s gs", c.gstate(t.Opacity))
+	}
+	if t.Background != "" {
+		bg, _ := parseColor(t.Background)
+		c.op("%s %s %s rg 0 0 %s %s re f", num(bg[0]), num(bg[1]), num(bg[2]), num(w), num(h))
+	}
+	if b := t.Border; b != nil {
+		bc, _ := parseColor(b.Color)
+		c.op("%s w %s %s %s RG %s %s %s %s re S", num(b.Width), num(bc[0]), num(bc[1]), num(bc[2]),
+			num(b.Width/2), num(b.Width/2), num(w-b.Width), num(h-b.Width))
+	}
+	if l.image != nil {
+		if err := c.drawImage(u, l.image, l.imageBox, t.Image); err != nil {
+			return pdfRef{}, err
+		}
+	}
+	if len(l.lines) > 0 {
+		c.drawText(t, l)
+	}
+	c.op("Q")
+
+	resources := pdfDict{}
+	if len(c.fontRes) > 0 {
+		fonts := pdfDict{}
+		for _, f := range c.used {
+			fonts[c.fontRes[f]] = f.object(u)
+		}
+		resources["Font"] = fonts
+	}
+	if len(c.xobjects) > 0 {
+		resources["XObject"] = c.xobjects
+	}
+	if len(c.gstates) > 0 {
+		resources["ExtGState"] = c.gstates
+	}
+	form := pdfDict{
+		"Type":      pdfName("XObject"),
+		"Subtype":   pdfName("Form"),
+		"BBox":      pdfArray{0, 0, w, h},
+		"Resources": resources,
+		"Filter":    pdfName("FlateDecode"),
+	}
+	// Counter-rotate so that the page rotation turns the stamp upright.
+	switch rotate {
+	case 90:
+		form["Matrix"] = pdfArray{0, 1, -1, 0, 0, 0}
+	case 180:
+		form["Matrix"] = pdfArray{-1, 0, 0, -1, 0, 0}
+	case 270:
+		form["Matrix"] = pdfArray{0, -1, 1, 0, 0, 0}
+	}
+	return u.add(&pdfStream{Dict: form, Raw: deflate(c.buf.Bytes())}), nil
+}
+
+func (c *stampCanvas) op(format string, args ...any) {
+	fmt.Fprintf(&c.buf, format, args...)
+	c.buf.WriteByte('\n')
+}
+
+// gstate returns the resource name of a graphics state with the given
+// fill and stroke opacity.
+func (c *stampCanvas) gstate(opacity float64) string {
+	name := pdfName(fmt.Sprintf("GS%d", len(c.gstates)))
+	c.gstates[name] = pdfDict{"Type": pdfName("ExtGState"), "ca": opacity, "CA": opacity}
+	return "/" + string(name)
+}
+
+// textRun is a piece of a line drawn with one font.
+type textRun struct {
+	font appearanceFont
+	text string
+}
+
+// layoutText lays the lines out top-down in box, shrinking the font from
+// Size towards MinSize until the longest line and all lines fit.
+func (l *stampLayout) layoutText(t *AppearanceTemplate, lines []string, fonts []appearanceFont, box stampBox) error {
+	runs := make([][]textRun, len(lines))
+	widths := make([]float64, len(lines))
+	maxWidth := 0.0
+	for i, line := range lines {
+		var err error
+		runs[i], err = splitRuns(fonts, line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		for _, r := range runs[i] {
+			widths[i] += r.font.width(r.text)
+		}
+		maxWidth = max(maxWidth, widths[i])
+	}
+
+	f := t.Font
+	size := f.Size
+	if maxWidth > 0 {
+		size = min(size, box.w/maxWidth)
+	}
+	size = min(size, box.h/(float64(len(lines))*f.LineSpacing))
+	if size < f.MinSize {
+		return fmt.Errorf("text does not fit in %gx%g points at the minimum font size %g", box.w, box.h, f.MinSize)
+	}
+
+	leading := size * f.LineSpacing
+	top := box.y + box.h - (box.h-leading*float64(len(lines)))/2
+	l.size = size
+	for i, line := range runs {
+		x := box.x
+		switch t.Align {
+		case "center":
+			x += (box.w - widths[i]*size) / 2
+		case "right":
+			x += box.w - widths[i]*size
+		}
+		// Baseline at roughly 80% of the line box, leaving room for descenders.
+		y := top - leading*float64(i) - (leading-size)/2 - size*0.8
+		l.lines = append(l.lines, stampLine{x: x, y: y, runs: line})
+	}
+	return nil
+}
+
+func (c *stampCanvas) drawText(t *AppearanceTemplate, l *stampLayout) {
+	fc, _ := parseColor(t.Font.Color)
+	c.op("BT %s %s %s rg", num(fc[0]), num(fc[1]), num(fc[2]))
+	for _, line := range l.lines {
+		c.op("1 0 0 1 %s %s Tm", num(line.x), num(line.y))
+		for _, r := range line.runs {
+			var s bytes.Buffer
+			writePdfString(&s, pdfString{Value: r.font.encode(r.text), Hex: true})
+			c.op("/%s %s Tf %s Tj", c.fontName(r.font), num(l.size), s.String())
+		}
+	}
+	c.op("ET")
+}
+
+// splitRuns assigns every character of line to the first font of the chain
+// that covers it.
+func splitRuns(fonts []appearanceFont, line string) ([]textRun, error) {
+	var runs []textRun
+	for _, r := range line {
+		var font appearanceFont
+		for _, f := range fonts {
+			if f.covers(r) {
+				font = f
+				break
+			}
+		}
+		if font == nil {
+			return nil, fmt.Errorf("character %q is not covered by any appearance font (add a font with this character to font.files)", r)
+		}
+		if n := len(runs); n > 0 && runs[n-1].font == font {
+			runs[n-1].text += string(r)
+		} else {
+			runs = append(runs, textRun{font: font, text: string(r)})
+		}
+	}
+	return runs, nil
+}
+
+func (c *stampCanvas) fontName(f appearanceFont) pdfName {
+	name, ok := c.fontRes[f]
+	if !ok {
+		name = pdfName(fmt.Sprintf("F%d", len(c.fontRes)+1))
+		c.fontRes[f] = name
+		c.used = append(c.used, f)
+	}
+	return name
+}
+
+// drawImage draws img into box.
+func (c *stampCanvas) drawImage(u *pdfUpdate, img *stampImage, box stampBox, style *ImageStyle) error {
+	ref, err := img.object(u, box, style.DPI)
+	if err != nil {
+		return err
+	}
+	name := pdfName(fmt.Sprintf("Im%d", len(c.xobjects)+1))
+	c.xobjects[name] = ref
+	c.op("q")
+	if style.Opacity < 1 {
+		c.op("%s gs", c.gstate(style.Opacity))
+	}
+	c.op("%s 0 0 %s %s %s cm /%s Do", num(box.w), num(box.h), num(box.x), num(box.y), name)
+	c.op("Q")
+	return nil
+}
+
+// num formats a content stream number with at most 3 decimals.
+func num(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 3, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+func deflate(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,752 @@
+package pdfsign
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// --- SVG Drawings ---
+// Handwritten signatures captured on a tablet are usually exported as SVG.
+// The subset of SVG such a drawing uses is converted to PDF paths, so the
+// signature stays sharp at any zoom level: path, rect, circle, ellipse,
+// line, polyline and polygon in nested groups, with fill, stroke, opacity
+// and transform attributes (or the same properties in a style attribute).
+// Text, embedded images, <use>, gradients and style sheets are rejected
+// instead of being silently dropped from the stamp.
+
+// svgImage is a drawing in viewBox units, y pointing down, with the origin
+// at the top-left corner of the viewBox.
+type svgImage struct {
+	width, height float64
+	shapes        []svgShape
+}
+
+// svgShape is one painted path; points are already transformed.
+type svgShape struct {
+	path          []pathSeg
+	fill          *rgbColor
+	fillOpacity   float64
+	evenOdd       bool
+	stroke        *rgbColor
+	strokeOpacity float64
+	strokeWidth   float64
+	cap, join     int // PDF line cap and join styles
+}
+
+// pathSeg is a moveto (M), lineto (L), curveto (C) or closepath (Z).
+type pathSeg struct {
+	op  byte
+	pts [3][2]float64
+}
+
+// points returns the number of points the segment uses.
+func (s pathSeg) points() int {
+	switch s.op {
+	case 'M', 'L':
+		return 1
+	case 'C':
+		return 3
+	}
+	return 0
+}
+
+// svgStyle is the inherited presentation state.
+type svgStyle struct {
+	ctm                        matrix
+	fill, stroke               *rgbColor
+	fillOpacity, strokeOpacity float64
+	opacity                    float64
+	strokeWidth                float64
+	evenOdd                    bool
+	cap, join                  int
+}
+
+var svgIgnored = map[string]bool{
+	"title": true, "desc": true, "metadata": true, "defs": true,
+	"clipPath": true, "mask": true, "symbol": true, "marker": true,
+	"linearGradient": true, "radialGradient": true, "pattern": true,
+}
+
+var svgUnsupported = map[string]bool{
+	"text": true, "image": true, "use": true, "style": true,
+	"foreignObject": true, "switch": true,
+}
+
+// parseSVG reads the drawing. Errors name the offending element so the
+// designer can fix the export settings.
+func parseSVG(data []byte) (*svgImage, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	img := &svgImage{}
+	black := rgbColor{}
+	stack := []svgStyle{{ctm: identity, fill: &black, fillOpacity: 1, strokeOpacity: 1, opacity: 1, strokeWidth: 1}}
+	skip := 0
+	root := true
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SVG: %w", err)
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || svgIgnored[el.Name.Local] {
+				skip++
+				continue
+			}
+			name := el.Name.Local
+			if svgUnsupported[name] {
+				return nil, fmt.Errorf("SVG element <%s> is not supported; convert it to paths", name)
+			}
+			attrs := svgAttributes(el.Attr)
+			if root {
+				if name != "svg" {
+					return nil, errors.New("not an SVG document")
+				}
+				if err := img.viewport(attrs, &stack[0]); err != nil {
+					return nil, err
+				}
+				root = false
+			}
+			st, err := stack[len(stack)-1].inherit(attrs)
+			if err != nil {
+				return nil, fmt.Errorf("<%s>: %w", name, err)
+			}
+			stack = append(stack, st)
+			d, err := svgShapePath(name, attrs)
+			if err != nil {
+				return nil, fmt.Errorf("<%s>: %w", name, err)
+			}
+			if d != "" {
+				segs, err := parsePathData(d)
+				if err != nil {
+					return nil, fmt.Errorf("<%s>: %w", name, err)
+				}
+				img.add(segs, st)
+			}
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if root {
+		return nil, errors.New("not an SVG document")
+	}
+	if len(img.shapes) == 0 {
+		return nil, errors.New("SVG draws nothing")
+	}
+	return img, nil
+}
+
+// svgAttributes merges the attributes with the declarations of the style
+// attribute, which take precedence.
+func svgAttributes(list []xml.Attr) map[string]string {
+	attrs := make(map[string]string, len(list))
+	for _, a := range list {
+		attrs[a.Name.Local] = strings.TrimSpace(a.Value)
+	}
+	for _, decl := range strings.Split(attrs["style"], ";") {
+		if k, v, ok := strings.Cut(decl, ":"); ok {
+			attrs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return attrs
+}
+
+// viewport sets the drawing size from viewBox (or width and height) and
+// moves the viewBox origin to 0, 0.
+func (img *svgImage) viewport(attrs map[string]string, st *svgStyle) error {
+	if vb := attrs["viewBox"]; vb != "" {
+		f := strings.FieldsFunc(vb, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' })
+		var v [4]float64
+		if len(f) != 4 {
+			return fmt.Errorf("invalid SVG viewBox '%s'", vb)
+		}
+		for i := range v {
+			n, err := strconv.ParseFloat(f[i], 64)
+			if err != nil {
+				return fmt.Errorf("invalid SVG viewBox '%s'", vb)
+			}
+			v[i] = n
+		}
+		img.width, img.height = v[2], v[3]
+		st.ctm = matrix{1, 0, 0, 1, -v[0], -v[1]}
+	} else {
+		var err error
+		if img.width, err = svgLength(attrs["width"]); err != nil {
+			return fmt.Errorf("SVG width: %w", err)
+		}
+		if img.height, err = svgLength(attrs["height"]); err != nil {
+			return fmt.Errorf("SVG height: %w", err)
+		}
+	}
+	if img.width <= 0 || img.height <= 0 {
+		return errors.New("SVG needs a viewBox or a positive width and height")
+	}
+	return nil
+}
+
+func svgLength(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		return 0, fmt.Errorf("percentage '%s' is not supported", s)
+	}
+	return strconv.ParseFloat(strings.TrimSuffix(s, "px"), 64)
+}
+
+// inherit returns the style of a child element with the given attributes.
+func (p svgStyle) inherit(attrs map[string]string) (svgStyle, error) {
+	st := p
+	if t := attrs["transform"]; t != "" {
+		m, err := parseTransform(t)
+		if err != nil {
+			return st, err
+		}
+		st.ctm = m.mul(p.ctm)
+	}
+	for _, key := range []string{"fill", "stroke"} {
+		v, ok := attrs[key]
+		if !ok || v == "inherit" {
+			continue
+		}
+		c, err := parseSvgColor(v)
+		if err != nil {
+			return st, fmt.Errorf("%s: %w", key, err)
+		}
+		if key == "fill" {
+			st.fill = c
+		} else {
+			st.stroke = c
+		}
+	}
+	var err error
+	number := func(key string, dst *float64) {
+		if v, ok := attrs[key]; ok && err == nil {
+			var n float64
+			if n, err = svgLength(v); err != nil {
+				err = fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = n
+		}
+	}
+	opacity := 1.0
+	number("fill-opacity", &st.fillOpacity)
+	number("stroke-opacity", &st.strokeOpacity)
+	number("stroke-width", &st.strokeWidth)
+	number("opacity", &opacity)
+	if err != nil {
+		return st, err
+	}
+	st.opacity = p.opacity * opacity
+	if v, ok := attrs["fill-rule"]; ok {
+		st.evenOdd = v == "evenodd"
+	}
+	switch attrs["stroke-linecap"] {
+	case "butt":
+		st.cap = 0
+	case "round":
+		st.cap = 1
+	case "square":
+		st.cap = 2
+	}
+	switch attrs["stroke-linejoin"] {
+	case "miter":
+		st.join = 0
+	case "round":
+		st.join = 1
+	case "bevel":
+		st.join = 2
+	}
+	return st, nil
+}
+
+// add stores a path painted with st, in viewBox coordinates.
+func (img *svgImage) add(segs []pathSeg, st svgStyle) {
+	if len(segs) == 0 || (st.fill == nil && st.stroke == nil) {
+		return
+	}
+	for i := range segs {
+		for j := 0; j < segs[i].points(); j++ {
+			segs[i].pts[j][0], segs[i].pts[j][1] = st.ctm.apply(segs[i].pts[j][0], segs[i].pts[j][1])
+		}
+	}
+	// A stroke is as wide as the transform scales it on average.
+	scale := math.Sqrt(math.Abs(st.ctm[0]*st.ctm[3] - st.ctm[1]*st.ctm[2]))
+	img.shapes = append(img.shapes, svgShape{
+		path:          segs,
+		fill:          st.fill,
+		fillOpacity:   clamp01(st.fillOpacity * st.opacity),
+		evenOdd:       st.evenOdd,
+		stroke:        st.stroke,
+		strokeOpacity: clamp01(st.strokeOpacity * st.opacity),
+		strokeWidth:   st.strokeWidth * scale,
+		cap:           st.cap,
+		join:          st.join,
+	})
+}
+
+func clamp01(f float64) float64 {
+	return max(0, min(1, f))
+}
+
+// svgShapePath returns the path data of a drawing element, or "" for
+// containers.
+func svgShapePath(name string, a map[string]string) (string, error) {
+	num := func(key string) float64 {
+		v, _ := svgLength(a[key])
+		return v
+	}
+	switch name {
+	case "path":
+		return a["d"], nil
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		rx, ry := num("rx"), num("ry")
+		if _, ok := a["ry"]; !ok {
+			ry = rx
+		}
+		if _, ok := a["rx"]; !ok {
+			rx = ry
+		}
+		rx, ry = min(rx, w/2), min(ry, h/2)
+		if w <= 0 || h <= 0 {
+			return "", nil
+		}
+		if rx <= 0 || ry <= 0 {
+			return fmt.Sprintf("M%g %gh%gv%gh%gZ", x, y, w, h, -w), nil
+		}
+		return fmt.Sprintf("M%g %gh%ga%g %g 0 0 1 %g %gv%ga%g %g 0 0 1 %g %gh%ga%g %g 0 0 1 %g %gv%ga%g %g 0 0 1 %g %gZ",
+			x+rx, y, w-2*rx, rx, ry, rx, ry, h-2*ry, rx, ry, -rx, ry, -(w - 2*rx), rx, ry, -rx, -ry, -(h - 2*ry), rx, ry, rx, -ry), nil
+	case "circle", "ellipse":
+		cx, cy := num("cx"), num("cy")
+		rx, ry := num("r"), num("r")
+		if name == "ellipse" {
+			rx, ry = num("rx"), num("ry")
+		}
+		if rx <= 0 || ry <= 0 {
+			return "", nil
+		}
+		return fmt.Sprintf("M%g %gA%g %g 0 1 0 %g %gA%g %g 0 1 0 %g %gZ", cx-rx, cy, rx, ry, cx+rx, cy, rx, ry, cx-rx, cy), nil
+	case "line":
+		return fmt.Sprintf("M%g %gL%g %g", num("x1"), num("y1"), num("x2"), num("y2")), nil
+	case "polyline", "polygon":
+		d := "M" + a["points"]
+		if name == "polygon" {
+			d += "Z"
+		}
+		return d, nil
+	case "svg", "g", "a":
+		return "", nil
+	}
+	return "", fmt.Errorf("SVG element <%s> is not supported", name)
+}
+
+// parseTransform reads a transform list such as "translate(10 5) scale(2)".
+func parseTransform(s string) (matrix, error) {
+	m := identity
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		open := strings.IndexByte(rest, '(')
+		end := strings.IndexByte(rest, ')')
+		if open < 0 || end < open {
+			return m, fmt.Errorf("invalid transform '%s'", s)
+		}
+		name := strings.TrimSpace(rest[:open])
+		var args []float64
+		for _, f := range strings.FieldsFunc(rest[open+1:end], func(r rune) bool { return r == ',' || r == ' ' }) {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return m, fmt.Errorf("invalid transform '%s'", s)
+			}
+			args = append(args, v)
+		}
+		var t matrix
+		switch {
+		case name == "matrix" && len(args) == 6:
+			t = matrix{args[0], args[1], args[2], args[3], args[4], args[5]}
+		case name == "translate" && (len(args) == 1 || len(args) == 2):
+			t = matrix{1, 0, 0, 1, args[0], 0}
+			if len(args) == 2 {
+				t[5] = args[1]
+			}
+		case name == "scale" && (len(args) == 1 || len(args) == 2):
+			t = matrix{args[0], 0, 0, args[0], 0, 0}
+			if len(args) == 2 {
+				t[3] = args[1]
+			}
+		case name == "rotate" && (len(args) == 1 || len(args) == 3):
+			a := args[0] * math.Pi / 180
+			t = matrix{math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a), 0, 0}
+			if len(args) == 3 {
+				cx, cy := args[1], args[2]
+				t = matrix{1, 0, 0, 1, -cx, -cy}.mul(t).mul(matrix{1, 0, 0, 1, cx, cy})
+			}
+		case name == "skewX" && len(args) == 1:
+			t = matrix{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case name == "skewY" && len(args) == 1:
+			t = matrix{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, fmt.Errorf("invalid transform '%s'", s)
+		}
+		// The rightmost transform of the list applies first.
+		m = t.mul(m)
+		rest = strings.TrimLeft(rest[end+1:], " ,")
+	}
+	return m, nil
+}
+
+func parseSvgColor(s string) (*rgbColor, error) {
+	switch s = strings.ToLower(s); {
+	case s == "none" || s == "transparent":
+		return nil, nil
+	case strings.HasPrefix(s, "url("):
+		return nil, fmt.Errorf("gradients and patterns are not supported (%s)", s)
+	case strings.HasPrefix(s, "#") && len(s) == 4:
+		s = string([]byte{'#', s[1], s[1], s[2], s[2], s[3], s[3]})
+	case strings.HasPrefix(s, "rgb(") && strings.HasSuffix(s, ")"):
+		parts := strings.Split(s[4:len(s)-1], ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid color '%s'", s)
+		}
+		var c rgbColor
+		for i, p := range parts {
+			p = strings.TrimSpace(p)
+			scale := 255.0
+			if strings.HasSuffix(p, "%") {
+				p, scale = p[:len(p)-1], 100
+			}
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid color '%s'", s)
+			}
+			c[i] = clamp01(v / scale)
+		}
+		return &c, nil
+	case svgColorNames[s] != "":
+		s = svgColorNames[s]
+	}
+	c, err := parseColor(strings.ToUpper(s))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported color '%s'", s)
+	}
+	return &c, nil
+}
+
+var svgColorNames = map[string]string{
+	"black": "#000000", "white": "#ffffff", "red": "#ff0000", "green": "#008000",
+	"blue": "#0000ff", "yellow": "#ffff00", "gray": "#808080", "grey": "#808080",
+	"orange": "#ffa500", "purple": "#800080", "navy": "#000080", "darkblue": "#00008b",
+	"currentcolor": "#000000",
+}
+
+// parsePathData converts SVG path data to absolute moveto, lineto,
+// curveto and closepath segments. Quadratic curves and arcs become cubic
+// Béziers.
+func parsePathData(d string) ([]pathSeg, error) {
+	p := &pathScanner{s: d}
+	var segs []pathSeg
+	var cur, start, ctrl [2]float64
+	var prev byte
+	cmd := byte(0)
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		if c := p.s[p.i]; isPathCommand(c) {
+			cmd = c
+			p.i++
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("path data must start with a command: '%s'", truncate(d, 40))
+		} else if cmd == 'Z' || cmd == 'z' {
+			return nil, fmt.Errorf("unexpected number after closepath at offset %d of path data", p.i)
+		} else if cmd == 'M' {
+			// Coordinates after a moveto are implicit linetos.
+			cmd = 'L'
+		} else if cmd == 'm' {
+			cmd = 'l'
+		}
+		rel := cmd >= 'a'
+		abs := func(x, y float64) [2]float64 {
+			if rel {
+				return [2]float64{cur[0] + x, cur[1] + y}
+			}
+			return [2]float64{x, y}
+		}
+		n := pathArgCounts[cmd|0x20]
+		args, err := p.numbers(n, cmd|0x20 == 'a')
+		if err != nil {
+			return nil, err
+		}
+		upper := cmd &^ 0x20
+		switch upper {
+		case 'M':
+			cur = abs(args[0], args[1])
+			start = cur
+			segs = append(segs, pathSeg{op: 'M', pts: [3][2]float64{cur}})
+		case 'L', 'H', 'V':
+			next := cur
+			switch upper {
+			case 'L':
+				next = abs(args[0], args[1])
+			case 'H':
+				next[0] = args[0]
+				if rel {
+					next[0] += cur[0]
+				}
+			case 'V':
+				next[1] = args[0]
+				if rel {
+					next[1] += cur[1]
+				}
+			}
+			segs = append(segs, pathSeg{op: 'L', pts: [3][2]float64{next}})
+			cur = next
+		case 'C', 'S':
+			var c1 [2]float64
+			var c2, end [2]float64
+			if upper == 'C' {
+				c1, c2, end = abs(args[0], args[1]), abs(args[2], args[3]), abs(args[4], args[5])
+			} else {
+				c1 = cur
+				if prev == 'C' || prev == 'S' {
+					c1 = [2]float64{2*cur[0] - ctrl[0], 2*cur[1] - ctrl[1]}
+				}
+				c2, end = abs(args[0], args[1]), abs(args[2], args[3])
+			}
+			segs = append(segs, pathSeg{op: 'C', pts: [3][2]float64{c1, c2, end}})
+			ctrl, cur = c2, end
+		case 'Q', 'T':
+			var q, end [2]float64
+			if upper == 'Q' {
+				q, end = abs(args[0], args[1]), abs(args[2], args[3])
+			} else {
+				q = cur
+				if prev == 'Q' || prev == 'T' {
+					q = [2]float64{2*cur[0] - ctrl[0], 2*cur[1] - ctrl[1]}
+				}
+				end = abs(args[0], args[1])
+			}
+			segs = append(segs, quadToCubic(cur, q, end))
+			ctrl, cur = q, end
+		case 'A':
+			end := abs(args[5], args[6])
+			segs = append(segs, arcToCubics(cur, args[0], args[1], args[2], args[3] != 0, args[4] != 0, end)...)
+			cur = end
+		case 'Z':
+			segs = append(segs, pathSeg{op: 'Z'})
+			cur = start
+		}
+		prev = upper
+	}
+	return segs, nil
+}
+
+var pathArgCounts = map[byte]int{'m': 2, 'l': 2, 'h': 1, 'v': 1, 'c': 6, 's': 4, 'q': 4, 't': 2, 'a': 7, 'z': 0}
+
+func isPathCommand(c byte) bool {
+	_, ok := pathArgCounts[c|0x20]
+	return ok
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func quadToCubic(p0, q, p3 [2]float64) pathSeg {
+	c1 := [2]float64{p0[0] + 2.0/3*(q[0]-p0[0]), p0[1] + 2.0/3*(q[1]-p0[1])}
+	c2 := [2]float64{p3[0] + 2.0/3*(q[0]-p3[0]), p3[1] + 2.0/3*(q[1]-p3[1])}
+	return pathSeg{op: 'C', pts: [3][2]float64{c1, c2, p3}}
+}
+
+// arcToCubics converts an elliptical arc (SVG endpoint parameterization) to
+// cubic Béziers of at most 90 degrees each.
+func arcToCubics(p0 [2]float64, rx, ry, angle float64, large, sweep bool, p1 [2]float64) []pathSeg {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p1 {
+		return []pathSeg{{op: 'L', pts: [3][2]float64{p1}}}
+	}
+	phi := angle * math.Pi / 180
+	cos, sin := math.Cos(phi), math.Sin(phi)
+	dx, dy := (p0[0]-p1[0])/2, (p0[1]-p1[1])/2
+	x1, y1 := cos*dx+sin*dy, -sin*dx+cos*dy
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p0[0]+p1[0])/2
+	cy := sin*cx1 + cos*cy1 + (p0[1]+p1[1])/2
+
+	vecAngle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := vecAngle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := vecAngle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	point := func(t float64) [2]float64 {
+		x, y := rx*math.Cos(t), ry*math.Sin(t)
+		return [2]float64{cos*x - sin*y + cx, sin*x + cos*y + cy}
+	}
+	deriv := func(t float64) [2]float64 {
+		x, y := -rx*math.Sin(t), ry*math.Cos(t)
+		return [2]float64{cos*x - sin*y, sin*x + cos*y}
+	}
+	n := int(math.Ceil(math.Abs(delta) / (math.Pi / 2)))
+	step := delta / float64(n)
+	k := 4.0 / 3 * math.Tan(step/4)
+	var segs []pathSeg
+	for i := 0; i < n; i++ {
+		t0, t1 := theta+step*float64(i), theta+step*float64(i+1)
+		a, b := point(t0), point(t1)
+		da, db := deriv(t0), deriv(t1)
+		segs = append(segs, pathSeg{op: 'C', pts: [3][2]float64{
+			{a[0] + k*da[0], a[1] + k*da[1]},
+			{b[0] - k*db[0], b[1] - k*db[1]},
+			b,
+		}})
+	}
+	segs[len(segs)-1].pts[2] = p1
+	return segs
+}
+
+// pathScanner reads the numbers of SVG path data, which may omit
+// separators ("M1-2.5.5" is M 1 -2.5 0.5).
+type pathScanner struct {
+	s string
+	i int
+}
+
+func (p *pathScanner) done() bool { return p.i >= len(p.s) }
+
+func (p *pathScanner) skipSpace() {
+	for !p.done() && strings.IndexByte(" \t\r\n,", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// numbers reads n numbers; arc flags (arguments 4 and 5) are single digits.
+func (p *pathScanner) numbers(n int, arc bool) ([]float64, error) {
+	out := make([]float64, n)
+	for k := range out {
+		p.skipSpace()
+		if p.done() {
+			return nil, errors.New("path data ends in the middle of a command")
+		}
+		if arc && (k == 3 || k == 4) {
+			switch p.s[p.i] {
+			case '0', '1':
+				out[k] = float64(p.s[p.i] - '0')
+				p.i++
+				continue
+			}
+			return nil, fmt.Errorf("invalid arc flag at offset %d of path data", p.i)
+		}
+		start := p.i
+		if c := p.s[p.i]; c == '+' || c == '-' {
+			p.i++
+		}
+		dot, digits := false, false
+		for !p.done() {
+			c := p.s[p.i]
+			if c >= '0' && c <= '9' {
+				digits = true
+			} else if c == '.' && !dot {
+				dot = true
+			} else {
+				break
+			}
+			p.i++
+		}
+		if !p.done() && digits && (p.s[p.i] == 'e' || p.s[p.i] == 'E') {
+			j := p.i + 1
+			if j < len(p.s) && (p.s[j] == '+' || p.s[j] == '-') {
+				j++
+			}
+			if j < len(p.s) && p.s[j] >= '0' && p.s[j] <= '9' {
+				for p.i = j; !p.done() && p.s[p.i] >= '0' && p.s[p.i] <= '9'; p.i++ {
+				}
+			}
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.i], 64)
+		if !digits || err != nil {
+			return nil, fmt.Errorf("invalid number at offset %d of path data", start)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// content returns the drawing as a content stream for a form XObject with
+// BBox 0 0 width height, together with the graphics states it uses.
+func (img *svgImage) content() ([]byte, pdfDict) {
+	var b bytes.Buffer
+	gstates := pdfDict{}
+	fmt.Fprintf(&b, "1 0 0 -1 0 %s cm\n", num(img.height))
+	for _, s := range img.shapes {
+		b.WriteString("q\n")
+		if s.fillOpacity < 1 || s.strokeOpacity < 1 {
+			name := pdfName(fmt.Sprintf("GS%d", len(gstates)))
+			gstates[name] = pdfDict{"Type": pdfName("ExtGState"), "ca": s.fillOpacity, "CA": s.strokeOpacity}
+			fmt.Fprintf(&b, "/%s gs\n", name)
+		}
+		if c := s.fill; c != nil {
+			fmt.Fprintf(&b, "%s %s %s rg\n", num(c[0]), num(c[1]), num(c[2]))
+		}
+		if c := s.stroke; c != nil {
+			fmt.Fprintf(&b, "%s %s %s RG %s w %d J %d j\n", num(c[0]), num(c[1]), num(c[2]), num(s.strokeWidth), s.cap, s.join)
+		}
+		for _, seg := range s.path {
+			switch seg.op {
+			case 'M':
+				fmt.Fprintf(&b, "%s %s m\n", num(seg.pts[0][0]), num(seg.pts[0][1]))
+			case 'L':
+				fmt.Fprintf(&b, "%s %s l\n", num(seg.pts[0][0]), num(seg.pts[0][1]))
+			case 'C':
+				fmt.Fprintf(&b, "%s %s %s %s %s %s c\n", num(seg.pts[0][0]), num(seg.pts[0][1]),
+					num(seg.pts[1][0]), num(seg.pts[1][1]), num(seg.pts[2][0]), num(seg.pts[2][1]))
+			case 'Z':
+				b.WriteString("h\n")
+			}
+		}
+		op := "n"
+		switch {
+		case s.fill != nil && s.stroke != nil:
+			op = "B"
+		case s.fill != nil:
+			op = "f"
+		case s.stroke != nil:
+			op = "S"
+		}
+		if s.evenOdd && op != "S" {
+			op += "*"
+		}
+		b.WriteString(op + "\nQ\n")
+	}
+	return b.Bytes(), gstates
+}
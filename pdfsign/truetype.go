@@ -0,0 +1,642 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// --- Embedded TrueType Fonts ---
+// Stamp text outside WinAnsi (our signers' names and labels are Traditional
+// Chinese) needs an embedded font. A TrueType or OpenType font with
+// TrueType outlines (.ttf, .ttc collections such as msjh.ttc, or .otf with
+// a glyf table) is embedded as a Type0/CIDFontType2 font with Identity-H
+// encoding. Only the glyphs a stamp uses are kept, so a 20 MB CJK font adds
+// a few KB per signature. CFF-based OpenType fonts cannot be subset here and
+// are rejected when the template is loaded.
+
+// trueTypeFont is a parsed font file; it is read-only and shared.
+type trueTypeFont struct {
+	path        string
+	psName      string
+	tables      map[string][]byte
+	unitsPerEm  float64
+	numGlyphs   int
+	longLoca    bool
+	advances    []uint16 // per glyph
+	cmap        map[rune]uint16
+	bbox        [4]float64 // in 1/1000 em
+	ascent      float64
+	descent     float64
+	capHeight   float64
+	italicAngle float64
+}
+
+var (
+	fontCacheMu sync.Mutex
+	fontCache   = make(map[string]*trueTypeFont)
+)
+
+// loadTrueType returns the font at path, loading it once per process. A
+// collection member is selected with "file.ttc#n" (default 0).
+func loadTrueType(path string) (*trueTypeFont, error) {
+	fontCacheMu.Lock()
+	defer fontCacheMu.Unlock()
+	if f, ok := fontCache[path]; ok {
+		return f, nil
+	}
+	file, index, err := splitFontPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font '%s': %w", file, err)
+	}
+	f, err := parseTrueType(data, index)
+	if err != nil {
+		return nil, fmt.Errorf("font '%s': %w", path, err)
+	}
+	f.path = path
+	if f.psName == "" {
+		f.psName = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	fontCache[path] = f
+	return f, nil
+}
+
+// splitFontPath splits "file.ttc#n" into the file and collection index.
+func splitFontPath(path string) (string, int, error) {
+	i := strings.LastIndex(path, "#")
+	if i <= 0 {
+		return path, 0, nil
+	}
+	n, err := strconv.Atoi(path[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("font '%s': invalid collection index", path)
+	}
+	return path[:i], n, nil
+}
+
+func parseTrueType(data []byte, index int) (*trueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, errors.New("file too short")
+	}
+	offset := 0
+	if string(data[:4]) == "ttcf" {
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if index < 0 || index >= n || 12+4*index+4 > len(data) {
+			return nil, fmt.Errorf("collection has %d fonts, index %d requested", n, index)
+		}
+		offset = int(binary.BigEndian.Uint32(data[12+4*index:]))
+	}
+	if offset+12 > len(data) {
+		return nil, errors.New("truncated font header")
+	}
+	switch string(data[offset : offset+4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, errors.New("CFF-based OpenType fonts cannot be subset; use a font with TrueType outlines")
+	default:
+		return nil, errors.New("not a TrueType/OpenType font")
+	}
+
+	f := &trueTypeFont{tables: make(map[string][]byte)}
+	numTables := int(binary.BigEndian.Uint16(data[offset+4:]))
+	for i := 0; i < numTables; i++ {
+		rec := offset + 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errors.New("truncated table directory")
+		}
+		tag := string(data[rec : rec+4])
+		off := int(binary.BigEndian.Uint32(data[rec+8:]))
+		length := int(binary.BigEndian.Uint32(data[rec+12:]))
+		if off < 0 || length < 0 || off+length > len(data) {
+			return nil, fmt.Errorf("table '%s' lies outside the file", tag)
+		}
+		f.tables[tag] = data[off : off+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "loca", "glyf", "cmap"} {
+		if f.tables[tag] == nil {
+			return nil, fmt.Errorf("missing required table '%s'", tag)
+		}
+	}
+
+	head, hhea, maxp := f.tables["head"], f.tables["hhea"], f.tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errors.New("truncated head/hhea/maxp table")
+	}
+	f.unitsPerEm = float64(binary.BigEndian.Uint16(head[18:]))
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("unitsPerEm is 0")
+	}
+	scale := 1000 / f.unitsPerEm
+	for i := range f.bbox {
+		f.bbox[i] = float64(int16(binary.BigEndian.Uint16(head[36+2*i:]))) * scale
+	}
+	f.longLoca = binary.BigEndian.Uint16(head[50:]) == 1
+	f.ascent = float64(int16(binary.BigEndian.Uint16(hhea[4:]))) * scale
+	f.descent = float64(int16(binary.BigEndian.Uint16(hhea[6:]))) * scale
+	f.capHeight = f.ascent
+	if os2 := f.tables["OS/2"]; len(os2) >= 90 && binary.BigEndian.Uint16(os2) >= 2 {
+		f.capHeight = float64(int16(binary.BigEndian.Uint16(os2[88:]))) * scale
+	}
+	if post := f.tables["post"]; len(post) >= 8 {
+		f.italicAngle = float64(int32(binary.BigEndian.Uint32(post[4:]))) / 65536
+	}
+
+	f.numGlyphs = int(binary.BigEndian.Uint16(maxp[4:]))
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := f.tables["hmtx"]
+	if numHMetrics == 0 || numHMetrics > f.numGlyphs || len(hmtx) < 4*numHMetrics {
+		return nil, errors.New("invalid hmtx table")
+	}
+	f.advances = make([]uint16, f.numGlyphs)
+	for gid := range f.advances {
+		m := min(gid, numHMetrics-1)
+		f.advances[gid] = binary.BigEndian.Uint16(hmtx[4*m:])
+	}
+
+	var err error
+	if f.cmap, err = parseCmap(f.tables["cmap"]); err != nil {
+		return nil, err
+	}
+	f.psName = postScriptName(f.tables["name"])
+	return f, nil
+}
+
+// parseCmap reads the Unicode mapping from a format 12 (full Unicode) or
+// format 4 (BMP) subtable.
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errors.New("truncated cmap table")
+	}
+	best, bestScore := -1, 0
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n && 4+8*i+8 <= len(cmap); i++ {
+		rec := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[2:])
+		off := int(binary.BigEndian.Uint32(rec[4:]))
+		if off+2 > len(cmap) {
+			continue
+		}
+		format := binary.BigEndian.Uint16(cmap[off:])
+		score := 0
+		switch {
+		case format == 12 && (platform == 3 && encoding == 10 || platform == 0):
+			score = 3
+		case format == 4 && (platform == 3 && encoding == 1 || platform == 0):
+			score = 2
+		}
+		if score > bestScore {
+			best, bestScore = off, score
+		}
+	}
+	if best < 0 {
+		return nil, errors.New("no Unicode cmap subtable (format 4 or 12)")
+	}
+	m := make(map[rune]uint16)
+	t := cmap[best:]
+	if binary.BigEndian.Uint16(t) == 12 {
+		if len(t) < 16 {
+			return nil, errors.New("truncated cmap format 12")
+		}
+		groups := int(binary.BigEndian.Uint32(t[12:]))
+		for i := 0; i < groups && 16+12*i+12 <= len(t); i++ {
+			g := t[16+12*i:]
+			start, end, gid := binary.BigEndian.Uint32(g), binary.BigEndian.Uint32(g[4:]), binary.BigEndian.Uint32(g[8:])
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				if g := gid + c - start; g != 0 {
+					m[rune(c)] = uint16(g)
+				}
+			}
+		}
+		return m, nil
+	}
+	if len(t) < 14 {
+		return nil, errors.New("truncated cmap format 4")
+	}
+	segs := int(binary.BigEndian.Uint16(t[6:])) / 2
+	ends, starts := 14, 16+2*segs
+	deltas, rangeOffs := starts+2*segs, starts+4*segs
+	if rangeOffs+2*segs > len(t) {
+		return nil, errors.New("truncated cmap format 4")
+	}
+	for s := 0; s < segs; s++ {
+		end := binary.BigEndian.Uint16(t[ends+2*s:])
+		start := binary.BigEndian.Uint16(t[starts+2*s:])
+		delta := binary.BigEndian.Uint16(t[deltas+2*s:])
+		ro := int(binary.BigEndian.Uint16(t[rangeOffs+2*s:]))
+		for c := uint32(start); c <= uint32(end) && c != 0xFFFF; c++ {
+			var gid uint16
+			if ro == 0 {
+				gid = uint16(c) + delta
+			} else {
+				p := rangeOffs + 2*s + ro + 2*int(c-uint32(start))
+				if p+2 > len(t) {
+					continue
+				}
+				if gid = binary.BigEndian.Uint16(t[p:]); gid != 0 {
+					gid += delta
+				}
+			}
+			if gid != 0 {
+				m[rune(c)] = gid
+			}
+		}
+	}
+	return m, nil
+}
+
+// postScriptName reads name ID 6 from the name table.
+func postScriptName(name []byte) string {
+	if len(name) < 6 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint16(name[2:]))
+	storage := int(binary.BigEndian.Uint16(name[4:]))
+	for i := 0; i < count && 6+12*i+12 <= len(name); i++ {
+		rec := name[6+12*i:]
+		platform := binary.BigEndian.Uint16(rec)
+		if binary.BigEndian.Uint16(rec[6:]) != 6 {
+			continue
+		}
+		length, off := int(binary.BigEndian.Uint16(rec[8:])), int(binary.BigEndian.Uint16(rec[10:]))
+		if storage+off+length > len(name) {
+			continue
+		}
+		raw := name[storage+off : storage+off+length]
+		var s string
+		if platform == 3 || platform == 0 {
+			s = string(utf16BERunes(raw))
+		} else {
+			s = string(raw)
+		}
+		// PDF names allow no spaces; PostScript names should not have any.
+		return strings.Map(func(r rune) rune {
+			if r <= ' ' || r > '~' || isPdfDelim(byte(r)) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	return ""
+}
+
+// glyphData returns the glyf entry of gid.
+func (f *trueTypeFont) glyphData(gid int) []byte {
+	loca, glyf := f.tables["loca"], f.tables["glyf"]
+	var start, end int
+	if f.longLoca {
+		if 4*gid+8 > len(loca) {
+			return nil
+		}
+		start, end = int(binary.BigEndian.Uint32(loca[4*gid:])), int(binary.BigEndian.Uint32(loca[4*gid+4:]))
+	} else {
+		if 2*gid+4 > len(loca) {
+			return nil
+		}
+		start, end = 2*int(binary.BigEndian.Uint16(loca[2*gid:])), 2*int(binary.BigEndian.Uint16(loca[2*gid+2:]))
+	}
+	if start >= end || end > len(glyf) {
+		return nil
+	}
+	return glyf[start:end]
+}
+
+// Composite glyph flags.
+const (
+	glyfArgWords = 0x0001
+	glyfScale    = 0x0008
+	glyfMore     = 0x0020
+	glyfXYScale  = 0x0040
+	glyfTwoByTwo = 0x0080
+)
+
+// components returns the glyphs a composite glyph is built from.
+func components(g []byte) []int {
+	if len(g) < 10 || int16(binary.BigEndian.Uint16(g)) >= 0 {
+		return nil
+	}
+	var out []int
+	for p := 10; p+4 <= len(g); {
+		flags := binary.BigEndian.Uint16(g[p:])
+		out = append(out, int(binary.BigEndian.Uint16(g[p+2:])))
+		p += 4
+		if flags&glyfArgWords != 0 {
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&glyfScale != 0:
+			p += 2
+		case flags&glyfXYScale != 0:
+			p += 4
+		case flags&glyfTwoByTwo != 0:
+			p += 8
+		}
+		if flags&glyfMore == 0 {
+			break
+		}
+	}
+	return out
+}
+
+// subset builds a font file that keeps the glyph IDs of the original (so
+// CIDToGIDMap can stay /Identity) but only the outlines of the used glyphs.
+// Glyphs after the highest used ID are dropped.
+func (f *trueTypeFont) subset(used map[uint16]rune) []byte {
+	keep := map[int]bool{0: true}
+	var queue []int
+	for gid := range used {
+		queue = append(queue, int(gid))
+	}
+	for len(queue) > 0 {
+		gid := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if gid >= f.numGlyphs || keep[gid] {
+			continue
+		}
+		keep[gid] = true
+		queue = append(queue, components(f.glyphData(gid))...)
+	}
+	numGlyphs := 0
+	for gid := range keep {
+		numGlyphs = max(numGlyphs, gid+1)
+	}
+
+	var glyf bytes.Buffer
+	loca := make([]byte, 4*(numGlyphs+1))
+	for gid := 0; gid < numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(loca[4*gid:], uint32(glyf.Len()))
+		if keep[gid] {
+			glyf.Write(f.glyphData(gid))
+			for glyf.Len()%4 != 0 {
+				glyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(loca[4*numGlyphs:], uint32(glyf.Len()))
+
+	hhea := append([]byte(nil), f.tables["hhea"]...)
+	numHMetrics := min(int(binary.BigEndian.Uint16(hhea[34:])), numGlyphs)
+	binary.BigEndian.PutUint16(hhea[34:], uint16(numHMetrics))
+	hmtx := append([]byte(nil), f.tables["hmtx"][:4*numHMetrics]...)
+	old := f.tables["hmtx"]
+	oldHMetrics := int(binary.BigEndian.Uint16(f.tables["hhea"][34:]))
+	for gid := numHMetrics; gid < numGlyphs; gid++ {
+		p := 4*oldHMetrics + 2*(gid-oldHMetrics)
+		if p+2 <= len(old) {
+			hmtx = append(hmtx, old[p:p+2]...)
+		} else {
+			hmtx = append(hmtx, 0, 0)
+		}
+	}
+	maxp := append([]byte(nil), f.tables["maxp"]...)
+	binary.BigEndian.PutUint16(maxp[4:], uint16(numGlyphs))
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment, set below
+	binary.BigEndian.PutUint16(head[50:], 1) // long loca
+
+	tables := map[string][]byte{
+		"head": head, "hhea": hhea, "maxp": maxp, "hmtx": hmtx,
+		"loca": loca, "glyf": glyf.Bytes(), "cmap": subsetCmap(used),
+	}
+	for _, tag := range []string{"cvt ", "fpgm", "prep"} { // hinting
+		if t := f.tables[tag]; t != nil {
+			tables[tag] = t
+		}
+	}
+	return writeSfnt(tables)
+}
+
+// subsetCmap builds a format 4 cmap for the used BMP characters. PDF
+// viewers use the CID (= glyph ID) directly, but some tools expect a cmap.
+func subsetCmap(used map[uint16]rune) []byte {
+	type pair struct {
+		r   uint16
+		gid uint16
+	}
+	var pairs []pair
+	for gid, r := range used {
+		if r < 0xFFFF {
+			pairs = append(pairs, pair{uint16(r), gid})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].r < pairs[j].r })
+	pairs = append(pairs, pair{0xFFFF, 0}) // required final segment
+
+	segs := len(pairs)
+	sub := make([]byte, 16+8*segs)
+	binary.BigEndian.PutUint16(sub[0:], 4)
+	binary.BigEndian.PutUint16(sub[2:], uint16(len(sub)))
+	binary.BigEndian.PutUint16(sub[6:], uint16(2*segs))
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= segs {
+		entrySelector++
+	}
+	binary.BigEndian.PutUint16(sub[8:], uint16(2<<entrySelector))
+	binary.BigEndian.PutUint16(sub[10:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(sub[12:], uint16(2*segs-2<<entrySelector))
+	for i, p := range pairs {
+		binary.BigEndian.PutUint16(sub[14+2*i:], p.r)              // endCode
+		binary.BigEndian.PutUint16(sub[16+2*segs+2*i:], p.r)       // startCode
+		binary.BigEndian.PutUint16(sub[16+4*segs+2*i:], p.gid-p.r) // idDelta
+		binary.BigEndian.PutUint16(sub[16+6*segs+2*i:], 0)         // idRangeOffset
+	}
+	binary.BigEndian.PutUint16(sub[16+4*segs+2*(segs-1):], 1) // 0xFFFF -> glyph 0
+
+	out := make([]byte, 12, 12+len(sub))
+	binary.BigEndian.PutUint16(out[2:], 1)  // one subtable
+	binary.BigEndian.PutUint16(out[4:], 3)  // Windows
+	binary.BigEndian.PutUint16(out[6:], 1)  // Unicode BMP
+	binary.BigEndian.PutUint32(out[8:], 12) // offset
+	return append(out, sub...)
+}
+
+func writeSfnt(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, []uint32{0x00010000})
+	binary.Write(&out, binary.BigEndian, []uint16{uint16(n), uint16(searchRange), uint16(entrySelector), uint16(16*n - searchRange)})
+	offset := 12 + 16*n
+	headOffset := 0
+	for _, tag := range tags {
+		t := tables[tag]
+		out.WriteString(tag)
+		binary.Write(&out, binary.BigEndian, []uint32{sfntChecksum(t), uint32(offset), uint32(len(t))})
+		if tag == "head" {
+			headOffset = offset
+		}
+		offset += (len(t) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	b := out.Bytes()
+	binary.BigEndian.PutUint32(b[headOffset+8:], 0xB1B0AFBA-sfntChecksum(b))
+	return b
+}
+
+func sfntChecksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(b); i += 4 {
+		var word [4]byte
+		copy(word[:], b[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+// embeddedFont is one use of a trueTypeFont in an appearance; it records
+// the glyphs drawn so that object can write the subset.
+type embeddedFont struct {
+	tt   *trueTypeFont
+	used map[uint16]rune
+}
+
+func newEmbeddedFont(tt *trueTypeFont) *embeddedFont {
+	return &embeddedFont{tt: tt, used: make(map[uint16]rune)}
+}
+
+func (f *embeddedFont) covers(r rune) bool {
+	gid, ok := f.tt.cmap[r]
+	return ok && int(gid) < f.tt.numGlyphs
+}
+
+func (f *embeddedFont) encode(s string) []byte {
+	out := make([]byte, 0, 2*len(s))
+	for _, r := range s {
+		gid := f.tt.cmap[r]
+		f.used[gid] = r
+		out = append(out, byte(gid>>8), byte(gid))
+	}
+	return out
+}
+
+func (f *embeddedFont) width(s string) float64 {
+	total := 0.0
+	for _, r := range s {
+		total += f.advance(f.tt.cmap[r])
+	}
+	return total / 1000
+}
+
+func (f *embeddedFont) advance(gid uint16) float64 {
+	return float64(f.tt.advances[gid]) * 1000 / f.tt.unitsPerEm
+}
+
+func (f *embeddedFont) object(u *pdfUpdate) pdfRef {
+	gids := make([]int, 0, len(f.used))
+	for gid := range f.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	// The subset tag only has to differ between different subsets.
+	h := sha1.New()
+	h.Write([]byte(f.tt.psName))
+	for _, gid := range gids {
+		h.Write([]byte{byte(gid >> 8), byte(gid)})
+	}
+	sum := h.Sum(nil)
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + sum[i]%26
+	}
+	baseFont := pdfName(string(tag) + "+" + f.tt.psName)
+
+	fontFile := deflate(f.tt.subset(f.used))
+	descriptor := u.add(pdfDict{
+		"Type":        pdfName("FontDescriptor"),
+		"FontName":    baseFont,
+		"Flags":       4, // symbolic
+		"FontBBox":    pdfArray{f.tt.bbox[0], f.tt.bbox[1], f.tt.bbox[2], f.tt.bbox[3]},
+		"ItalicAngle": f.tt.italicAngle,
+		"Ascent":      f.tt.ascent,
+		"Descent":     f.tt.descent,
+		"CapHeight":   f.tt.capHeight,
+		"StemV":       80,
+		"FontFile2":   u.add(&pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Raw: fontFile}),
+	})
+	var widths pdfArray
+	for _, gid := range gids {
+		widths = append(widths, gid, pdfArray{f.advance(uint16(gid))})
+	}
+	cidFont := u.add(pdfDict{
+		"Type":     pdfName("Font"),
+		"Subtype":  pdfName("CIDFontType2"),
+		"BaseFont": baseFont,
+		"CIDSystemInfo": pdfDict{
+			"Registry":   pdfString{Value: []byte("Adobe")},
+			"Ordering":   pdfString{Value: []byte("Identity")},
+			"Supplement": 0,
+		},
+		"FontDescriptor": descriptor,
+		"W":              widths,
+		"DW":             1000,
+		"CIDToGIDMap":    pdfName("Identity"),
+	})
+	return u.add(pdfDict{
+		"Type":            pdfName("Font"),
+		"Subtype":         pdfName("Type0"),
+		"BaseFont":        baseFont,
+		"Encoding":        pdfName("Identity-H"),
+		"DescendantFonts": pdfArray{cidFont},
+		"ToUnicode":       u.add(&pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Raw: deflate(toUnicodeCMap(f.used))}),
+	})
+}
+
+// toUnicodeCMap maps the used glyph IDs back to text, so the stamp can be
+// searched and copied.
+func toUnicodeCMap(used map[uint16]rune) []byte {
+	gids := make([]int, 0, len(used))
+	for gid := range used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for len(gids) > 0 {
+		chunk := gids[:min(len(gids), 100)]
+		gids = gids[len(chunk):]
+		fmt.Fprintf(&b, "%d beginbfchar\n", len(chunk))
+		for _, gid := range chunk {
+			fmt.Fprintf(&b, "<%04X> <", gid)
+			for _, u := range utf16.Encode([]rune{used[uint16(gid)]}) {
+				fmt.Fprintf(&b, "%04X", u)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
@@ -0,0 +1,380 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkat"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Trust Store ---
+// A TrustStore keeps two independent sets of trust anchors: one for the
+// certificates that sign documents and one for the certificates that sign
+// RFC 3161 timestamp tokens. Keeping them apart means an AATL/EUTL signing
+// root cannot vouch for a TSA and vice versa.
+
+// TrustSet selects one of the anchor sets held by a TrustStore.
+type TrustSet int
+
+const (
+	// SigningTrust anchors validate document signer certificates.
+	SigningTrust TrustSet = iota
+	// TsaTrust anchors validate timestamp authority certificates.
+	TsaTrust
+)
+
+func (s TrustSet) String() string {
+	switch s {
+	case SigningTrust:
+		return "signing"
+	case TsaTrust:
+		return "tsa"
+	}
+	return fmt.Sprintf("TrustSet(%d)", int(s))
+}
+
+// TrustStore holds trust anchors and untrusted intermediates used to build
+// certificate chains.
+type TrustStore struct {
+	anchors       map[TrustSet][]*x509.Certificate
+	intermediates []*x509.Certificate
+}
+
+// NewTrustStore returns an empty trust store.
+func NewTrustStore() *TrustStore {
+	return &TrustStore{anchors: make(map[TrustSet][]*x509.Certificate)}
+}
+
+// LoadTrustStoreFromConfig builds a trust store from the config.json keys
+// trust_signing_anchors, trust_tsa_anchors and trust_intermediates. Each key
+// holds a list of paths; see LoadAnchors for the accepted formats. It returns
+// nil, nil when no anchors are configured so callers can keep the old
+// unvalidated behaviour.
+func LoadTrustStoreFromConfig(vip *viper.Viper) (*TrustStore, error) {
+	return loadTrustStore(
+		vip.GetStringSlice("trust_signing_anchors"),
+		vip.GetStringSlice("trust_tsa_anchors"),
+		vip.GetStringSlice("trust_intermediates"),
+	)
+}
+
+func loadTrustStore(signingPaths, tsaPaths, intermediatePaths []string) (*TrustStore, error) {
+	if len(signingPaths) == 0 && len(tsaPaths) == 0 {
+		return nil, nil
+	}
+
+	ts := NewTrustStore()
+	for _, p := range signingPaths {
+		if _, err := ts.LoadAnchors(SigningTrust, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range tsaPaths {
+		if _, err := ts.LoadAnchors(TsaTrust, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range intermediatePaths {
+		certs, err := loadCertificates(p, SigningTrust)
+		if err != nil {
+			return nil, err
+		}
+		ts.intermediates = append(ts.intermediates, certs...)
+	}
+	slog.Info("trust store loaded", "signing_anchors", ts.Len(SigningTrust), "tsa_anchors", ts.Len(TsaTrust), "intermediates", len(ts.intermediates))
+	return ts, nil
+}
+
+// AddAnchor adds a single trust anchor to the given set.
+func (ts *TrustStore) AddAnchor(set TrustSet, cert *x509.Certificate) {
+	for _, c := range ts.anchors[set] {
+		if c.Equal(cert) {
+			return
+		}
+	}
+	ts.anchors[set] = append(ts.anchors[set], cert)
+}
+
+// AddIntermediate adds an untrusted certificate that may be used to complete
+// a chain but is never itself a trust anchor.
+func (ts *TrustStore) AddIntermediate(cert *x509.Certificate) {
+	ts.intermediates = append(ts.intermediates, cert)
+}
+
+// LoadAnchors adds every certificate found at path to the given set and
+// returns how many were added. path may be:
+//   - a directory of PEM (.pem, .crt, .cer) or DER files,
+//   - a PKCS#7 certs-only bundle (.p7b, .p7c),
+//   - an ETSI TS 119 612 trusted list (.xml); only services in a granted
+//     state whose type matches the set (CA services for SigningTrust, TSA
+//     services for TsaTrust) are taken.
+func (ts *TrustStore) LoadAnchors(set TrustSet, path string) (int, error) {
+	certs, err := loadCertificates(path, set)
+	if err != nil {
+		return 0, err
+	}
+	if len(certs) == 0 {
+		return 0, fmt.Errorf("no certificates found in trust anchor source '%s'", path)
+	}
+	for _, c := range certs {
+		ts.AddAnchor(set, c)
+	}
+	slog.Debug("trust anchors loaded", "set", set.String(), "count", len(certs), "source", path)
+	return len(certs), nil
+}
+
+// Anchors returns the anchors of the given set.
+func (ts *TrustStore) Anchors(set TrustSet) []*x509.Certificate {
+	return ts.anchors[set]
+}
+
+// Len returns the number of anchors in the given set.
+func (ts *TrustStore) Len(set TrustSet) int {
+	return len(ts.anchors[set])
+}
+
+// VerifyChain builds a chain from leaf to an anchor of the given set, valid
+// at time at. extra supplies additional intermediates, typically the
+// certificates embedded in the signature itself.
+func (ts *TrustStore) VerifyChain(set TrustSet, leaf *x509.Certificate, extra []*x509.Certificate, at time.Time) ([]*x509.Certificate, error) {
+	if ts.Len(set) == 0 {
+		return nil, fmt.Errorf("trust store has no %s anchors", set)
+	}
+	roots := x509.NewCertPool()
+	for _, c := range ts.anchors[set] {
+		roots.AddCert(c)
+	}
+	inter := x509.NewCertPool()
+	for _, c := range ts.intermediates {
+		inter.AddCert(c)
+	}
+	for _, c := range extra {
+		inter.AddCert(c)
+	}
+
+	usage := x509.ExtKeyUsageAny
+	if set == TsaTrust {
+		usage = x509.ExtKeyUsageTimeStamping
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s chain for '%s' not trusted: %w", set, leaf.Subject.CommonName, err)
+	}
+	return chains[0], nil
+}
+
+// VerifyChilkatCert is VerifyChain for a certificate held by Chilkat, e.g. the
+// signing certificate found on the HSM or loaded from a PFX. The issuers
+// Chilkat holds for it (from the PFX, the token or its certificate store)
+// are offered as intermediates, so trust_intermediates only needs to list
+// what neither provides.
+func (ts *TrustStore) VerifyChilkatCert(set TrustSet, cert *chilkat.Cert, at time.Time) ([]*x509.Certificate, error) {
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		return nil, err
+	}
+	return ts.VerifyChain(set, leaf, chilkatIssuers(cert), at)
+}
+
+// chilkatIssuers returns the issuers of cert that Chilkat can find, nearest
+// first, without cert itself.
+func chilkatIssuers(cert *chilkat.Cert) []*x509.Certificate {
+	chain := cert.GetCertChain()
+	if chain == nil {
+		return nil
+	}
+	defer chain.DisposeCertChain()
+	defer trackChilkat("CertChain")()
+	var issuers []*x509.Certificate
+	// The chain starts with cert itself.
+	for i := 1; i < chain.NumCerts(); i++ {
+		c := chain.GetCert(i)
+		if c == nil {
+			break
+		}
+		issuer, err := chilkatCertToX509(c)
+		c.DisposeCert()
+		if err != nil {
+			break
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers
+}
+
+// ActivateChilkatRoots installs the anchors of set as Chilkat's process-wide
+// trusted roots, with the operating system store disabled, so that Chilkat's
+// own chain checks during SignPdf and VerifySignature use the same anchors.
+// The caller must Deactivate and Dispose the returned object.
+//
+// Chilkat has a single list of roots for every chain it checks, so only one
+// set is installed: with both, a TSA root would also vouch for signers.
+// Chilkat checks the signer chain, so callers pass SigningTrust; TSA chains
+// are checked by VerifyChain with TsaTrust (VerifySignatures, preflight and
+// the audit log seals).
+func (ts *TrustStore) ActivateChilkatRoots(set TrustSet) (*chilkat.TrustedRoots, error) {
+	tRoots := chilkat.NewTrustedRoots()
+	tRoots.SetTrustSystemCaRoots(false)
+	for _, c := range ts.anchors[set] {
+		ckCert, err := x509ToChilkatCert(c)
+		if err != nil {
+			tRoots.DisposeTrustedRoots()
+			return nil, err
+		}
+		success := tRoots.AddCert(ckCert)
+		ckCert.DisposeCert()
+		if !success {
+			errMsg := tRoots.LastErrorText()
+			tRoots.DisposeTrustedRoots()
+			return nil, NewChilkatError(fmt.Sprintf("adding trust anchor '%s' to Chilkat", c.Subject.CommonName), errMsg)
+		}
+	}
+	if !tRoots.Activate() {
+		errMsg := tRoots.LastErrorText()
+		tRoots.DisposeTrustedRoots()
+		return nil, NewChilkatError("activating Chilkat trusted roots", errMsg)
+	}
+	return tRoots, nil
+}
+
+// --- Certificate Sources ---
+
+func loadCertificates(path string, set TrustSet) ([]*x509.Certificate, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("trust anchor source '%s': %w", path, err)
+	}
+	if info.IsDir() {
+		return loadCertificateDir(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust anchor source '%s': %w", path, err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".p7b", ".p7c", ".p7":
+		return parsePkcs7Bundle(data)
+	case ".xml":
+		return parseTrustedList(data, set)
+	}
+	return parseCertificateFile(data)
+}
+
+func loadCertificateDir(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust anchor directory '%s': %w", dir, err)
+	}
+	var certs []*x509.Certificate
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".pem", ".crt", ".cer", ".der":
+		default:
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", e.Name(), err)
+		}
+		found, err := parseCertificateFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", e.Name(), err)
+		}
+		certs = append(certs, found...)
+	}
+	return certs, nil
+}
+
+// parseCertificateFile accepts one or more PEM certificates, or a single DER
+// certificate.
+func parseCertificateFile(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DER certificate: %w", err)
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse PEM certificate: %w", err)
+			}
+			certs = append(certs, cert)
+		case "PKCS7":
+			found, err := parsePkcs7Bundle(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, found...)
+		}
+	}
+	return certs, nil
+}
+
+// parsePkcs7Bundle extracts the certificates of a certs-only SignedData, in
+// DER or PEM form.
+func parsePkcs7Bundle(data []byte) ([]*x509.Certificate, error) {
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return parseCertificateFile(data)
+	}
+	sd, err := parseCMS(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#7 bundle: %w", err)
+	}
+	return sd.Certificates, nil
+}
+
+// --- Chilkat Conversion ---
+
+func chilkatCertToX509(cert *chilkat.Cert) (*x509.Certificate, error) {
+	if cert == nil {
+		return nil, errors.New("certificate is nil")
+	}
+	// GetEncoded returns the DER certificate as base64, possibly line-wrapped.
+	encoded := cert.GetEncoded()
+	if encoded == nil || *encoded == "" {
+		return nil, NewChilkatError("encoding the certificate", cert.LastErrorText())
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(*encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate from Chilkat: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+func x509ToChilkatCert(c *x509.Certificate) (*chilkat.Cert, error) {
+	ckCert := chilkat.NewCert()
+	if !ckCert.LoadFromBase64(base64.StdEncoding.EncodeToString(c.Raw)) {
+		errMsg := ckCert.LastErrorText()
+		ckCert.DisposeCert()
+		return nil, NewChilkatError(fmt.Sprintf("loading certificate '%s' into Chilkat", c.Subject.CommonName), errMsg)
+	}
+	return ckCert, nil
+}
@@ -0,0 +1,89 @@
+package pdfsign
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// --- ETSI TS 119 612 Trusted Lists ---
+// Only the parts of a TSL needed to pull out service certificates are
+// modelled. The list's own XML signature is not checked here; pin the file
+// you load (it is fetched and reviewed out of band).
+
+type tslList struct {
+	XMLName   xml.Name      `xml:"TrustServiceStatusList"`
+	Providers []tslProvider `xml:"TrustServiceProviderList>TrustServiceProvider"`
+}
+
+type tslProvider struct {
+	Services []tslService `xml:"TSPServices>TSPService"`
+}
+
+type tslService struct {
+	TypeIdentifier string   `xml:"ServiceInformation>ServiceTypeIdentifier"`
+	Status         string   `xml:"ServiceInformation>ServiceStatus"`
+	Certificates   []string `xml:"ServiceInformation>ServiceDigitalIdentity>DigitalId>X509Certificate"`
+}
+
+const (
+	tslServiceTypePrefix = "http://uri.etsi.org/TrstSvc/Svctype/"
+	tslStatusPrefix      = "http://uri.etsi.org/TrstSvc/TrustedList/Svcstatus/"
+)
+
+// tslGrantedStatuses are the current and legacy (pre-eIDAS) statuses under
+// which a service's certificates may be trusted.
+var tslGrantedStatuses = map[string]bool{
+	"granted":                   true,
+	"recognisedatnationallevel": true,
+	"undersupervision":          true,
+	"accredited":                true,
+}
+
+func parseTrustedList(data []byte, set TrustSet) ([]*x509.Certificate, error) {
+	var list tslList
+	if err := xml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse trusted list XML: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for _, p := range list.Providers {
+		for _, s := range p.Services {
+			if !tslServiceMatches(s.TypeIdentifier, set) {
+				continue
+			}
+			status := strings.TrimPrefix(strings.TrimSpace(s.Status), tslStatusPrefix)
+			if !tslGrantedStatuses[status] {
+				continue
+			}
+			for _, b64 := range s.Certificates {
+				der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(b64), ""))
+				if err != nil {
+					return nil, fmt.Errorf("trusted list contains invalid base64 certificate: %w", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("trusted list contains invalid certificate: %w", err)
+				}
+				certs = append(certs, cert)
+			}
+		}
+	}
+	return certs, nil
+}
+
+// tslServiceMatches reports whether a service type belongs to the given set:
+// CA services (CA/QC, CA/PKC) for signing, TSA services (TSA, TSA/QTST, ...)
+// for timestamping.
+func tslServiceMatches(typeID string, set TrustSet) bool {
+	t := strings.TrimPrefix(strings.TrimSpace(typeID), tslServiceTypePrefix)
+	switch set {
+	case SigningTrust:
+		return strings.HasPrefix(t, "CA/")
+	case TsaTrust:
+		return t == "TSA" || strings.HasPrefix(t, "TSA/")
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// --- RFC 3161 Time-Stamp Protocol ---
+// Chilkat requests the timestamps embedded in signatures itself. These are
+// for the tokens we need outside a PDF: the pre-flight TSA check and the
+// seals of the audit log.
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint struct {
+		HashAlgorithm pkix.AlgorithmIdentifier
+		HashedMessage []byte
+	}
+	Nonce   *big.Int `asn1:"optional"`
+	CertReq bool     `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status asn1.RawValue // PKIStatusInfo
+	Token  asn1.RawValue `asn1:"optional"`
+}
+
+// requestTimestamp asks the TSA at url for a token over a SHA-256 digest
+// and returns the DER token (a CMS ContentInfo).
+func requestTimestamp(client *http.Client, url string, digest []byte) (token []byte, err error) {
+	defer func(start time.Time) { observeRemote("tsa", start, err) }(time.Now())
+	var req timeStampReq
+	req.Version = 1
+	req.MessageImprint.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidHashSHA256, Parameters: asn1.NullRawValue}
+	req.MessageImprint.HashedMessage = digest
+	req.Nonce, _ = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	req.CertReq = true
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/timestamp-query", bytes.NewReader(der))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTsaUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: HTTP %s", ErrTsaUnavailable, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTsaUnavailable, err)
+	}
+
+	var tsr timeStampResp
+	var status int
+	if _, err := asn1.Unmarshal(body, &tsr); err != nil {
+		return nil, fmt.Errorf("invalid timestamp response: %w", err)
+	}
+	if _, err := asn1.Unmarshal(tsr.Status.Bytes, &status); err != nil {
+		return nil, fmt.Errorf("invalid timestamp response status: %w", err)
+	}
+	if status > 1 || len(tsr.Token.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp request rejected (PKIStatus %d)", status)
+	}
+	return tsr.Token.FullBytes, nil
+}
+
+// verifyTimestampToken checks that der is a validly signed timestamp token
+// over the SHA-256 digest and, when trust has TSA anchors, that its signer
+// chains to one at the token's time. It returns the TSTInfo and the TSA
+// certificate.
+func verifyTimestampToken(der, digest []byte, trust *TrustStore) (*tstInfo, *x509.Certificate, error) {
+	token, err := parseCMS(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := token.tstInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	if h, ok := hashFromOID(info.MessageImprint.HashAlgorithm.Algorithm); !ok || h != crypto.SHA256 {
+		return nil, nil, fmt.Errorf("timestamp imprint algorithm %s is not SHA-256", info.MessageImprint.HashAlgorithm.Algorithm)
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, nil, errors.New("timestamp token covers a different digest")
+	}
+	if len(token.SignerInfos) == 0 {
+		return nil, nil, errors.New("timestamp token has no SignerInfo")
+	}
+	tsa, err := token.signerCert(token.SignerInfos[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("timestamp token: %w", err)
+	}
+	if err := token.verifySignerInfo(token.SignerInfos[0], tsa); err != nil {
+		return nil, nil, fmt.Errorf("timestamp token: %w", err)
+	}
+	if trust != nil && trust.Len(TsaTrust) > 0 {
+		if _, err := trust.VerifyChain(TsaTrust, tsa, token.Certificates, info.GenTime); err != nil {
+			return nil, nil, fmt.Errorf("timestamp token from '%s' does not chain to a TSA anchor: %w", tsa.Subject.CommonName, err)
+		}
+	}
+	return info, tsa, nil
+}
+
+// timestampClient is the HTTP client for TSA requests outside preflight.
+var timestampClient = &http.Client{Timeout: 30 * time.Second}
@@ -0,0 +1,143 @@
+package pdfsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTsa is a TSA certificate and key issued by its own root.
+type testTsa struct {
+	root *x509.Certificate
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestTsa(t *testing.T) *testTsa {
+	t.Helper()
+	root, rootKey := testCert(t, "tsa root", nil, nil)
+	key, err := ecdsa.GenerateKey(root.PublicKey.(*ecdsa.PublicKey).Curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "tsa"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testTsa{root: root, cert: cert, key: key}
+}
+
+// explicit wraps der in a [0] EXPLICIT tag.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// token returns an RFC 3161 timestamp token over the SHA-256 digest.
+func (tsa *testTsa) token(t *testing.T, digest []byte) []byte {
+	t.Helper()
+	sha256ID := pkix.AlgorithmIdentifier{Algorithm: oidHashSHA256}
+	info := tstInfo{Version: 1, Policy: asn1.ObjectIdentifier{1, 2, 3}, SerialNumber: big.NewInt(1), GenTime: time.Now().UTC().Truncate(time.Second)}
+	info.MessageImprint.HashAlgorithm = sha256ID
+	info.MessageImprint.HashedMessage = digest
+	eContent := mustMarshal(t, mustMarshal(t, info))
+
+	attr := func(oid asn1.ObjectIdentifier, value any) []byte {
+		set := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: mustMarshal(t, value)}
+		return mustMarshal(t, struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue
+		}{oid, set})
+	}
+	contentSum := sha256.Sum256(mustMarshal(t, info))
+	attrs := append(attr(oidContentTypeAttr, oidTSTInfo), attr(oidMessageDigest, contentSum[:])...)
+	signedSum := sha256.Sum256(mustMarshal(t, asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrs}))
+	signature, err := ecdsa.SignASN1(rand.Reader, tsa.key, signedSum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sid := mustMarshal(t, cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: tsa.cert.RawIssuer}, SerialNumber: tsa.cert.SerialNumber})
+	si := mustMarshal(t, cmsSignerInfoASN1{
+		Version:            1,
+		SID:                asn1.RawValue{FullBytes: sid},
+		DigestAlgorithm:    sha256ID,
+		SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidKeyECDSA},
+		Signature:          signature,
+	})
+	sd := mustMarshal(t, cmsSignedDataASN1{
+		Version:          3,
+		DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: mustMarshal(t, sha256ID)},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: oidTSTInfo, EContent: explicit(eContent)},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: tsa.cert.Raw},
+		SignerInfos:      asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: si},
+	})
+	return mustMarshal(t, cmsContentInfo{ContentType: oidSignedData, Content: explicit(sd)})
+}
+
+func TestVerifyTimestampToken(t *testing.T) {
+	tsa := newTestTsa(t)
+	other := newTestTsa(t)
+	digest := sha256.Sum256([]byte("record"))
+	token := tsa.token(t, digest[:])
+
+	anchored := NewTrustStore()
+	anchored.AddAnchor(TsaTrust, tsa.root)
+	wrongAnchor := NewTrustStore()
+	wrongAnchor.AddAnchor(TsaTrust, other.root)
+
+	tests := []struct {
+		name   string
+		token  []byte
+		digest []byte
+		trust  *TrustStore
+		err    string
+	}{
+		{"anchored", token, digest[:], anchored, ""},
+		{"no anchors", token, digest[:], nil, ""},
+		{"other digest", token, make([]byte, 32), anchored, "different digest"},
+		{"other TSA root", token, digest[:], wrongAnchor, "does not chain"},
+		{"garbage", []byte("not a token"), digest[:], anchored, "CMS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cert, err := verifyTimestampToken(tt.token, tt.digest, tt.trust)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("verifyTimestampToken: %v", err)
+			case tt.err == "" && !cert.Equal(tsa.cert):
+				t.Fatalf("TSA certificate = %s", cert.Subject)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("verifyTimestampToken = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,883 @@
+package pdfsign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Modifications After Signing ---
+// A signature covers the document up to the end of its byte range; every
+// incremental update appended after that is outside it. Later signatures,
+// document timestamps and DSS (LTV) data are expected there. Anything else
+// changes what a reader sees without invalidating the signature, so each
+// object rewritten after the signature is sorted into signatures, DSS, form
+// fields or other changes. The sorting follows the newest document
+// structure, e.g. a content stream is "other" unless it is reachable from
+// a signature appearance, a form field or the DSS.
+
+// ModificationReport describes what was appended after a signature.
+type ModificationReport struct {
+	// Revision is the revision the signature closes, counting %%EOF markers
+	// (a linearized file counts its first page section as a revision).
+	Revision  int `json:"revision"`
+	Revisions int `json:"revisions"`
+	// CoversDocument is set when nothing follows the signed byte range.
+	CoversDocument  bool     `json:"coversDocument"`
+	LaterSignatures int      `json:"laterSignatures,omitempty"` // incl. document timestamps
+	DSSUpdates      bool     `json:"dssUpdates,omitempty"`
+	FormChanges     []string `json:"formChanges,omitempty"`  // names of filled or changed fields
+	OtherChanges    []string `json:"otherChanges,omitempty"` // e.g. "object 12 (/Page)"
+}
+
+type objectRole int
+
+const (
+	roleOther objectRole = iota
+	roleStructure
+	roleSignature
+	roleDSS
+	roleForm
+)
+
+type objectInfo struct {
+	role  objectRole
+	label string // field name for roleForm, page content for roleOther
+}
+
+// objectRoles sorts the objects reachable from the catalog's signatures,
+// DSS and form fields. Objects not in the map are roleOther.
+func (doc *pdfDocument) objectRoles() map[int]objectInfo {
+	roles := make(map[int]objectInfo)
+	mark := func(obj pdfObject, info objectInfo) {
+		if ref, ok := obj.(pdfRef); ok {
+			if _, seen := roles[ref.Num]; !seen {
+				roles[ref.Num] = info
+			}
+		}
+	}
+	// The catalog, info dictionary, XMP metadata, AcroForm and page
+	// dictionaries change whenever a field, annotation or DSS is added.
+	structure := objectInfo{role: roleStructure}
+	mark(doc.trailer["Root"], structure)
+	mark(doc.trailer["Info"], structure)
+	catalog := doc.catalog()
+	mark(catalog["Metadata"], structure)
+	mark(catalog["AcroForm"], structure)
+	for _, page := range doc.pages() {
+		mark(page, structure)
+	}
+	if pages, ok := catalog["Pages"].(pdfRef); ok {
+		doc.markTree(pages, structure, roles, make(map[int]bool), 0)
+	}
+
+	dss := objectInfo{role: roleDSS}
+	doc.markReachable(catalog["DSS"], dss, roles, 0)
+	for _, f := range doc.formFields() {
+		info := objectInfo{role: roleForm, label: f.Name}
+		if f.Type == "Sig" {
+			info = objectInfo{role: roleSignature, label: f.Name}
+			doc.markReachable(f.Dict["V"], info, roles, 0)
+		}
+		mark(f.Ref, info)
+		for _, w := range fieldWidgets(doc, f) {
+			mark(w, info)
+			doc.markReachable(doc.dict(w)["AP"], info, roles, 0)
+			doc.markReachable(doc.dict(w)["MK"], info, roles, 0)
+		}
+		if info.role == roleForm {
+			doc.markReachable(f.Dict["V"], info, roles, 0)
+		}
+	}
+	if acro := doc.dict(catalog["AcroForm"]); acro != nil {
+		doc.markReachable(acro["DR"], objectInfo{role: roleForm, label: "AcroForm resources"}, roles, 0)
+	}
+	// Content streams stay roleOther, but are named after their page.
+	for i, page := range doc.pages() {
+		contents := doc.dict(page)["Contents"]
+		if a, ok := doc.resolve(contents).(pdfArray); ok && contents != nil {
+			for _, c := range a {
+				mark(c, objectInfo{role: roleOther, label: fmt.Sprintf("page %d content", i+1)})
+			}
+		} else {
+			mark(contents, objectInfo{role: roleOther, label: fmt.Sprintf("page %d content", i+1)})
+		}
+	}
+	return roles
+}
+
+// markTree marks the intermediate /Pages nodes; seen holds the nodes
+// already walked.
+func (doc *pdfDocument) markTree(node pdfRef, info objectInfo, roles map[int]objectInfo, seen map[int]bool, depth int) {
+	if depth > 32 || seen[node.Num] || len(seen) >= maxTreeNodes {
+		return
+	}
+	seen[node.Num] = true
+	roles[node.Num] = info
+	for _, kid := range doc.array(doc.dict(node)["Kids"]) {
+		if ref, ok := kid.(pdfRef); ok && doc.dict(ref)["Type"] == pdfName("Pages") {
+			doc.markTree(ref, info, roles, seen, depth+1)
+		}
+	}
+}
+
+// markReachable marks every object reachable from obj, without following
+// the back links (/P, /Parent) and signature references (/Data) that lead
+// to the page tree or the catalog.
+func (doc *pdfDocument) markReachable(obj pdfObject, info objectInfo, roles map[int]objectInfo, depth int) {
+	if depth > 64 {
+		return
+	}
+	if ref, ok := obj.(pdfRef); ok {
+		if _, seen := roles[ref.Num]; seen {
+			return
+		}
+		roles[ref.Num] = info
+		obj = doc.resolve(ref)
+	}
+	var d pdfDict
+	switch v := obj.(type) {
+	case pdfDict:
+		d = v
+	case *pdfStream:
+		d = v.Dict
+	case pdfArray:
+		for _, item := range v {
+			doc.markReachable(item, info, roles, depth+1)
+		}
+		return
+	}
+	for key, value := range d {
+		if key == "P" || key == "Parent" || key == "Data" {
+			continue
+		}
+		doc.markReachable(value, info, roles, depth+1)
+	}
+}
+
+// changedAfter returns the numbers of the objects whose newest version
+// starts at or after offset end.
+func (doc *pdfDocument) changedAfter(end int) []int {
+	var nums []int
+	for num, e := range doc.xref {
+		if e.free {
+			continue
+		}
+		offset := e.offset
+		if e.inStream > 0 {
+			offset = doc.xref[e.inStream].offset
+		}
+		if offset >= int64(end) {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+// modifications reports what follows sig in data.
+func (doc *pdfDocument) modifications(fields []signedField, sig signedField, roles map[int]objectInfo) *ModificationReport {
+	if sig.end < 0 || sig.end > len(doc.data) {
+		return nil
+	}
+	eof := []byte("%%EOF")
+	m := &ModificationReport{
+		Revision:       bytes.Count(doc.data[:sig.end], eof),
+		Revisions:      bytes.Count(doc.data, eof),
+		CoversDocument: len(bytes.TrimRight(doc.data[sig.end:], "\r\n\t \x00")) == 0,
+	}
+	for _, f := range fields {
+		if f.end > sig.end {
+			m.LaterSignatures++
+		}
+	}
+	if m.CoversDocument {
+		return m
+	}
+	forms := make(map[string]bool)
+	for _, num := range doc.changedAfter(sig.end) {
+		info := roles[num]
+		switch info.role {
+		case roleStructure, roleSignature:
+		case roleDSS:
+			m.DSSUpdates = true
+		case roleForm:
+			if !forms[info.label] {
+				forms[info.label] = true
+				m.FormChanges = append(m.FormChanges, info.label)
+			}
+		default:
+			d := doc.dict(pdfRef{Num: num})
+			if t := d["Type"]; t == pdfName("XRef") || t == pdfName("ObjStm") {
+				continue
+			}
+			desc := fmt.Sprintf("object %d", num)
+			if info.label != "" {
+				desc += " (" + info.label + ")"
+			} else {
+				for _, key := range []pdfName{"Type", "Subtype"} {
+					if n, ok := d[key].(pdfName); ok {
+						desc += fmt.Sprintf(" (/%s)", n)
+						break
+					}
+				}
+			}
+			m.OtherChanges = append(m.OtherChanges, desc)
+		}
+	}
+	return m
+}
+
+// describeRevisions completes the VerifyDocument reports with the PAdES
+// level and the modifications after each signature.
+func describeRevisions(doc *pdfDocument, fields []signedField, reports []SignatureReport) {
+	roles := doc.objectRoles()
+	for i := range reports {
+		r := &reports[i]
+		for _, f := range fields {
+			if r.Field == "" || f.name != r.Field {
+				continue
+			}
+			r.Level = padesLevel(doc, fields, f, r.Timestamped)
+			r.DocumentTimestamp = f.subFilter == subFilterDocTimeStamp
+			r.Modifications = doc.modifications(fields, f, roles)
+		}
+	}
+}
+
+// --- Trust Policies ---
+// A trust policy is a named set of validation rules: which anchors to
+// trust, whether revocation must be proven from the document alone, and the
+// minimum PAdES level. The API picks one per request, so the same service
+// can validate strictly for contracts and leniently for internal forms.
+//
+// Evaluate turns the verification reports into indications in the spirit
+// of ETSI EN 319 102-1: TOTAL-PASSED, TOTAL-FAILED when something is proven
+// wrong (broken signature, revoked certificate, content changed after
+// signing, level below the policy), INDETERMINATE when something could not
+// be proven (untrusted chain, missing revocation data).
+
+// Indication is the outcome of validating a signature or a document.
+type Indication string
+
+const (
+	TotalPassed   Indication = "TOTAL-PASSED"
+	TotalFailed   Indication = "TOTAL-FAILED"
+	Indeterminate Indication = "INDETERMINATE"
+)
+
+// DefaultTrustPolicy is the name of the policy built from the top-level
+// trust_* and verify_offline keys.
+const DefaultTrustPolicy = "default"
+
+// TrustPolicy is a named set of validation rules.
+type TrustPolicy struct {
+	Name  string
+	Trust *TrustStore // nil checks cryptographic validity only
+	// Offline proves revocation from the document's own DSS and CMS data;
+	// it is implied by RequireRevocation.
+	Offline           bool
+	RequireTrusted    bool
+	RequireRevocation bool
+	MinLevel          PadesLevel // empty accepts any level
+	// AllowFormChanges accepts form fields filled in after a signature.
+	AllowFormChanges bool
+	// SignaturePolicies are passed on to VerifyOptions.Policies.
+	SignaturePolicies []*SignaturePolicy
+}
+
+type trustPolicyConfig struct {
+	SigningAnchors    []string `mapstructure:"signing_anchors"`
+	TsaAnchors        []string `mapstructure:"tsa_anchors"`
+	Intermediates     []string `mapstructure:"intermediates"`
+	Offline           bool     `mapstructure:"offline"`
+	RequireTrusted    bool     `mapstructure:"require_trusted"`
+	RequireRevocation bool     `mapstructure:"require_revocation"`
+	MinLevel          string   `mapstructure:"min_level"`
+	AllowFormChanges  bool     `mapstructure:"allow_form_changes"`
+}
+
+// LoadTrustPoliciesFromConfig reads the config.json key trust_policies, a
+// map from policy name to {signing_anchors, tsa_anchors, intermediates,
+// offline, require_trusted, require_revocation, min_level,
+// allow_form_changes}. Names are lower case (viper folds keys). The
+// "default" policy, unless configured there, is built from
+// trust_signing_anchors, trust_tsa_anchors, trust_intermediates and
+// verify_offline and requires a trusted chain when anchors are set. The
+// signature_policy key, when set, applies to every policy.
+func LoadTrustPoliciesFromConfig(vip *viper.Viper) (map[string]*TrustPolicy, error) {
+	sigPolicy, err := LoadSignaturePolicyFromConfig(vip)
+	if err != nil {
+		return nil, err
+	}
+	var sigPolicies []*SignaturePolicy
+	if sigPolicy != nil {
+		sigPolicies = []*SignaturePolicy{sigPolicy}
+	}
+
+	policies := make(map[string]*TrustPolicy)
+	for name := range vip.GetStringMap("trust_policies") {
+		var cfg trustPolicyConfig
+		if err := vip.UnmarshalKey("trust_policies."+name, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid trust policy '%s': %w", name, err)
+		}
+		p, err := newTrustPolicy(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("trust policy '%s': %w", name, err)
+		}
+		p.SignaturePolicies = sigPolicies
+		policies[name] = p
+	}
+	if _, ok := policies[DefaultTrustPolicy]; !ok {
+		trust, err := LoadTrustStoreFromConfig(vip)
+		if err != nil {
+			return nil, err
+		}
+		policies[DefaultTrustPolicy] = &TrustPolicy{
+			Name:              DefaultTrustPolicy,
+			Trust:             trust,
+			Offline:           vip.GetBool("verify_offline"),
+			RequireTrusted:    trust != nil,
+			SignaturePolicies: sigPolicies,
+		}
+	}
+	return policies, nil
+}
+
+func newTrustPolicy(name string, cfg trustPolicyConfig) (*TrustPolicy, error) {
+	trust, err := loadTrustStore(cfg.SigningAnchors, cfg.TsaAnchors, cfg.Intermediates)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RequireTrusted && trust == nil {
+		return nil, errors.New("require_trusted needs signing_anchors or tsa_anchors")
+	}
+	p := &TrustPolicy{
+		Name:              name,
+		Trust:             trust,
+		Offline:           cfg.Offline || cfg.RequireRevocation,
+		RequireTrusted:    cfg.RequireTrusted,
+		RequireRevocation: cfg.RequireRevocation,
+		AllowFormChanges:  cfg.AllowFormChanges,
+	}
+	if cfg.MinLevel != "" {
+		// B-LTA is a valid minimum for validation even though it cannot
+		// be produced by signing.
+		level := PadesLevel(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(cfg.MinLevel)), "PADES-"))
+		if levelRank(level) < 0 {
+			return nil, fmt.Errorf("unknown min_level '%s' (use B-B, B-T, B-LT or B-LTA)", cfg.MinLevel)
+		}
+		p.MinLevel = level
+	}
+	return p, nil
+}
+
+func levelRank(l PadesLevel) int {
+	switch l {
+	case LevelBB:
+		return 0
+	case LevelBT:
+		return 1
+	case LevelBLT:
+		return 2
+	case LevelBLTA:
+		return 3
+	}
+	return -1
+}
+
+// VerifyOptions returns the options VerifyDocument runs with under p.
+func (p *TrustPolicy) VerifyOptions() VerifyOptions {
+	return VerifyOptions{Trust: p.Trust, Offline: p.Offline, Policies: p.SignaturePolicies}
+}
+
+// Validate verifies data and evaluates the signatures against p. name is
+// the document's file name for the report.
+func (p *TrustPolicy) Validate(data []byte, name string) (*ValidationReport, error) {
+	reports, err := VerifyDocument(data, p.VerifyOptions())
+	if err != nil {
+		return nil, err
+	}
+	report := &ValidationReport{
+		Document:    name,
+		Size:        len(data),
+		SHA256:      sha256Hex(data),
+		Policy:      p.Name,
+		ValidatedAt: time.Now().UTC(),
+	}
+	report.Indication, report.Signatures = p.Evaluate(reports)
+	return report, nil
+}
+
+// SignatureValidation is a signature's report with its indication under a
+// trust policy.
+type SignatureValidation struct {
+	SignatureReport
+	Indication Indication `json:"indication"`
+	Reasons    []string   `json:"reasons,omitempty"`
+}
+
+// ValidationReport is the outcome of validating a document under a trust
+// policy.
+type ValidationReport struct {
+	Document    string                `json:"document,omitempty"`
+	Size        int                   `json:"size"`
+	SHA256      string                `json:"sha256"`
+	Policy      string                `json:"policy"`
+	ValidatedAt time.Time             `json:"validatedAt"`
+	Indication  Indication            `json:"indication"`
+	Signatures  []SignatureValidation `json:"signatures"`
+}
+
+// Evaluate applies p to the reports of VerifyDocument. The document
+// indication is the worst signature indication; a document without
+// signatures is INDETERMINATE.
+func (p *TrustPolicy) Evaluate(reports []SignatureReport) (Indication, []SignatureValidation) {
+	out := make([]SignatureValidation, 0, len(reports))
+	doc := TotalPassed
+	if len(reports) == 0 {
+		doc = Indeterminate
+	}
+	for _, r := range reports {
+		v := p.evaluate(r)
+		switch {
+		case v.Indication == TotalFailed:
+			doc = TotalFailed
+		case v.Indication == Indeterminate && doc == TotalPassed:
+			doc = Indeterminate
+		}
+		out = append(out, v)
+	}
+	return doc, out
+}
+
+func (p *TrustPolicy) evaluate(r SignatureReport) SignatureValidation {
+	v := SignatureValidation{SignatureReport: r}
+	var failed, indeterminate []string
+
+	if !r.Valid {
+		reason := "the signature or the digest of the signed bytes does not verify"
+		if line, _, _ := strings.Cut(strings.TrimSpace(r.Error), "\n"); line != "" {
+			reason += ": " + line
+		}
+		failed = append(failed, reason)
+	}
+	if m := r.Modifications; m != nil {
+		if len(m.OtherChanges) > 0 {
+			failed = append(failed, "the document was changed after signing: "+strings.Join(m.OtherChanges, ", "))
+		}
+		if len(m.FormChanges) > 0 && !p.AllowFormChanges {
+			failed = append(failed, "form fields were changed after signing: "+strings.Join(m.FormChanges, ", "))
+		}
+	} else if r.Field == "" {
+		indeterminate = append(indeterminate, "the signature dictionary could not be read, so changes after signing are unknown")
+	}
+	if p.MinLevel != "" && !r.DocumentTimestamp && levelRank(PadesLevel(r.Level)) < levelRank(p.MinLevel) {
+		level := r.Level
+		if level == "" {
+			level = "not PAdES"
+		}
+		failed = append(failed, fmt.Sprintf("level %s is below the required %s", level, p.MinLevel))
+	}
+	for _, c := range append(append([]RevocationCheck(nil), r.SignerRevocation...), r.TsaRevocation...) {
+		if c.Status == ocspRevoked {
+			failed = append(failed, fmt.Sprintf("certificate '%s' is revoked", c.Subject))
+		}
+	}
+	if p.RequireTrusted {
+		if r.SignerTrust == nil || !r.SignerTrust.Trusted {
+			indeterminate = append(indeterminate, "the signer certificate does not chain to a trusted anchor"+chainError(r.SignerTrust))
+		}
+		if r.Timestamped && (r.TsaTrust == nil || !r.TsaTrust.Trusted) {
+			indeterminate = append(indeterminate, "the timestamp certificate does not chain to a trusted anchor"+chainError(r.TsaTrust))
+		}
+	}
+	if p.RequireRevocation && len(r.Unprovable) > 0 {
+		indeterminate = append(indeterminate, "revocation status cannot be proven from the document for: "+strings.Join(r.Unprovable, ", "))
+	}
+	if r.Policy != nil && r.Policy.Error != "" {
+		indeterminate = append(indeterminate, "signature policy "+r.Policy.OID+": "+r.Policy.Error)
+	}
+
+	switch {
+	case len(failed) > 0:
+		v.Indication = TotalFailed
+	case len(indeterminate) > 0:
+		v.Indication = Indeterminate
+	default:
+		v.Indication = TotalPassed
+	}
+	v.Reasons = append(failed, indeterminate...)
+	return v
+}
+
+func chainError(c *ChainResult) string {
+	if c == nil || c.Error == "" {
+		return ""
+	}
+	return ": " + c.Error
+}
+
+// --- Human-Readable Reports ---
+// Customer support gets the validation report as HTML or PDF. Both are
+// rendered from the same sections, so they always say the same thing.
+
+type reportSection struct {
+	Title      string
+	Indication Indication
+	Rows       [][2]string
+	Reasons    []string
+}
+
+const reportTimeFormat = "2006-01-02 15:04:05 MST"
+
+func (r *ValidationReport) sections() []reportSection {
+	document := r.Document
+	if document == "" {
+		document = "(uploaded document)"
+	}
+	summary := reportSection{
+		Title:      "Document",
+		Indication: r.Indication,
+		Rows: [][2]string{
+			{"File", document},
+			{"Size", fmt.Sprintf("%d bytes", r.Size)},
+			{"SHA-256", r.SHA256},
+			{"Trust policy", r.Policy},
+			{"Validated at", r.ValidatedAt.Format(reportTimeFormat)},
+			{"Signatures", fmt.Sprintf("%d", len(r.Signatures))},
+		},
+	}
+	if len(r.Signatures) == 0 {
+		summary.Reasons = []string{"the document has no signatures"}
+	}
+	sections := []reportSection{summary}
+
+	for _, s := range r.Signatures {
+		sec := reportSection{
+			Title:      fmt.Sprintf("Signature %d", s.Index+1),
+			Indication: s.Indication,
+			Reasons:    s.Reasons,
+		}
+		row := func(k, v string) {
+			if v != "" {
+				sec.Rows = append(sec.Rows, [2]string{k, v})
+			}
+		}
+		row("Field", s.Field)
+		row("Signer", s.SignerCN)
+		if s.SigningTime != nil {
+			t := s.SigningTime.Format(reportTimeFormat)
+			if s.Timestamped {
+				t += " (timestamp by " + s.TsaCN + ")"
+			} else {
+				t += " (claimed by the signer, not timestamped)"
+			}
+			row("Signing time", t)
+		}
+		switch {
+		case s.DocumentTimestamp:
+			row("Level", "document timestamp")
+		case s.Level == "":
+			row("Level", "not PAdES")
+		default:
+			row("Level", "PAdES "+s.Level)
+		}
+		row("Signature intact", yesNo(s.Valid))
+		row("Signer chain", chainText(s.SignerTrust))
+		if s.Timestamped {
+			row("Timestamp chain", chainText(s.TsaTrust))
+		}
+		row("Revocation", revocationText(s.SignatureReport))
+		row("Changes after signing", modificationText(s.Modifications))
+		if s.Info != nil {
+			row("Reason", s.Info.Reason)
+			row("Location", s.Info.Location)
+			row("Contact", s.Info.ContactInfo)
+			row("Commitment", s.Info.Commitment)
+		}
+		if s.Policy != nil {
+			row("Signature policy", s.Policy.OID+" (verified: "+yesNo(s.Policy.Verified)+")")
+		}
+		sections = append(sections, sec)
+	}
+	return sections
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func chainText(c *ChainResult) string {
+	switch {
+	case c == nil:
+		return "not checked"
+	case c.Trusted:
+		return "trusted: " + strings.Join(c.Chain, " > ")
+	}
+	return "not trusted: " + c.Error
+}
+
+func revocationText(r SignatureReport) string {
+	checks := append(append([]RevocationCheck(nil), r.SignerRevocation...), r.TsaRevocation...)
+	if len(checks) == 0 {
+		return "not checked"
+	}
+	var revoked []string
+	for _, c := range checks {
+		if c.Status == ocspRevoked {
+			revoked = append(revoked, c.Subject)
+		}
+	}
+	switch {
+	case len(revoked) > 0:
+		return "revoked: " + strings.Join(revoked, ", ")
+	case len(r.Unprovable) > 0:
+		return "not provable from the document for " + strings.Join(r.Unprovable, ", ")
+	}
+	return fmt.Sprintf("proven good for %d certificates", len(checks))
+}
+
+func modificationText(m *ModificationReport) string {
+	if m == nil {
+		return "unknown"
+	}
+	if m.CoversDocument {
+		return fmt.Sprintf("none, the signature covers the whole document (revision %d of %d)", m.Revision, m.Revisions)
+	}
+	var parts []string
+	if m.LaterSignatures > 0 {
+		parts = append(parts, fmt.Sprintf("%d later signature(s) or timestamp(s)", m.LaterSignatures))
+	}
+	if m.DSSUpdates {
+		parts = append(parts, "validation data (DSS)")
+	}
+	if len(m.FormChanges) > 0 {
+		parts = append(parts, "form fields "+strings.Join(m.FormChanges, ", "))
+	}
+	if len(m.OtherChanges) > 0 {
+		parts = append(parts, "other changes: "+strings.Join(m.OtherChanges, ", "))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "structural updates only")
+	}
+	return fmt.Sprintf("revision %d of %d; later: %s", m.Revision, m.Revisions, strings.Join(parts, "; "))
+}
+
+var validationHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Signature Validation Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; min-width: 40em; }
+th, td { text-align: left; vertical-align: top; padding: 4px 8px; border-bottom: 1px solid #ddd; }
+th { width: 12em; color: #555; font-weight: normal; }
+.TOTAL-PASSED { color: #1a7f37; } .TOTAL-FAILED { color: #cf222e; } .INDETERMINATE { color: #9a6700; }
+</style></head><body>
+<h1>Signature Validation Report</h1>
+{{range .}}<h2>{{.Title}}: <span class="{{.Indication}}">{{.Indication}}</span></h2>
+<table>{{range .Rows}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+{{if .Reasons}}<ul>{{range .Reasons}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{end}}</body></html>
+`))
+
+// FormatValidationHTML renders r as a standalone HTML page.
+func FormatValidationHTML(r *ValidationReport) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := validationHTML.Execute(&buf, r.sections()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FormatValidationPDF renders r as an A4 PDF. fontFiles are TrueType fonts
+// (as in an appearance template's font.files) tried before Helvetica, for
+// names outside WinAnsi; characters no font covers print as '?'.
+func FormatValidationPDF(r *ValidationReport, fontFiles []string) ([]byte, error) {
+	var fonts []appearanceFont
+	for _, file := range fontFiles {
+		tt, err := loadTrueType(file)
+		if err != nil {
+			return nil, err
+		}
+		fonts = append(fonts, newEmbeddedFont(tt))
+	}
+	fonts = append(fonts, helvetica)
+
+	w := newReportWriter(fonts)
+	w.text("Signature Validation Report", 18, 0)
+	w.space(8)
+	for _, sec := range r.sections() {
+		w.space(6)
+		w.text(sec.Title+": "+string(sec.Indication), 13, 0)
+		w.space(2)
+		for _, row := range sec.Rows {
+			w.row(row[0], row[1])
+		}
+		for _, reason := range sec.Reasons {
+			w.text("- "+reason, 10, 12)
+		}
+	}
+	return w.bytes()
+}
+
+// reportWriter lays text out top-down on A4 pages, starting a new page
+// when the current one is full.
+type reportWriter struct {
+	fonts  []appearanceFont
+	canvas *stampCanvas // shared font resources; buf holds the current page
+	pages  [][]byte
+	y      float64
+}
+
+const (
+	reportPageWidth  = 595.0
+	reportPageHeight = 842.0
+	reportMargin     = 50.0
+	reportLabelWidth = 130.0
+)
+
+func newReportWriter(fonts []appearanceFont) *reportWriter {
+	w := &reportWriter{fonts: fonts, canvas: &stampCanvas{fontRes: make(map[appearanceFont]pdfName)}}
+	w.y = reportPageHeight - reportMargin
+	return w
+}
+
+func (w *reportWriter) space(h float64) {
+	w.y -= h
+}
+
+// text writes s wrapped to the page width, indented by indent points.
+func (w *reportWriter) text(s string, size, indent float64) {
+	for _, line := range w.wrap(s, size, reportPageWidth-2*reportMargin-indent) {
+		w.line(reportMargin+indent, line, size)
+	}
+}
+
+// row writes a label and its value in two columns.
+func (w *reportWriter) row(label, value string) {
+	const size = 10
+	lines := w.wrap(value, size, reportPageWidth-2*reportMargin-reportLabelWidth)
+	if w.y-size*1.4 < reportMargin {
+		w.newPage()
+	}
+	top := w.y
+	w.line(reportMargin, label, size)
+	w.y = top
+	for _, line := range lines {
+		w.line(reportMargin+reportLabelWidth, line, size)
+	}
+}
+
+func (w *reportWriter) line(x float64, s string, size float64) {
+	leading := size * 1.4
+	if w.y-leading < reportMargin {
+		w.newPage()
+	}
+	w.y -= leading
+	runs, _ := splitRuns(w.fonts, s)
+	c := w.canvas
+	c.op("BT 1 0 0 1 %s %s Tm", num(x), num(w.y))
+	for _, r := range runs {
+		var str bytes.Buffer
+		writePdfString(&str, pdfString{Value: r.font.encode(r.text), Hex: true})
+		c.op("/%s %s Tf %s Tj", c.fontName(r.font), num(size), str.String())
+	}
+	c.op("ET")
+}
+
+func (w *reportWriter) newPage() {
+	w.pages = append(w.pages, append([]byte(nil), w.canvas.buf.Bytes()...))
+	w.canvas.buf.Reset()
+	w.y = reportPageHeight - reportMargin
+}
+
+// wrap replaces uncovered characters and breaks s into lines of at most
+// width points, at spaces where possible.
+func (w *reportWriter) wrap(s string, size, width float64) []string {
+	var clean []rune
+	for _, r := range s {
+		covered := false
+		for _, f := range w.fonts {
+			if f.covers(r) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			r = '?'
+		}
+		clean = append(clean, r)
+	}
+
+	var lines []string
+	for len(clean) > 0 {
+		n, lastSpace := 0, -1
+		for n < len(clean) && w.width(clean[:n+1])*size <= width {
+			if clean[n] == ' ' {
+				lastSpace = n
+			}
+			n++
+		}
+		if n == len(clean) {
+			lines = append(lines, string(clean))
+			break
+		}
+		if n == 0 {
+			n = 1
+		} else if lastSpace > 0 {
+			n = lastSpace
+		}
+		lines = append(lines, string(clean[:n]))
+		clean = []rune(strings.TrimLeft(string(clean[n:]), " "))
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	return lines
+}
+
+func (w *reportWriter) width(rs []rune) float64 {
+	runs, _ := splitRuns(w.fonts, string(rs))
+	total := 0.0
+	for _, r := range runs {
+		total += r.font.width(r.text)
+	}
+	return total
+}
+
+func (w *reportWriter) bytes() ([]byte, error) {
+	w.newPage()
+	u := newPdfFile()
+	fontDict := pdfDict{}
+	for _, f := range w.canvas.used {
+		fontDict[w.canvas.fontRes[f]] = f.object(u)
+	}
+	pagesRef := u.add(nil)
+	var kids pdfArray
+	for _, content := range w.pages {
+		contents := u.add(&pdfStream{
+			Dict: pdfDict{"Filter": pdfName("FlateDecode")},
+			Raw:  deflate(content),
+		})
+		kids = append(kids, u.add(pdfDict{
+			"Type":      pdfName("Page"),
+			"Parent":    pagesRef,
+			"MediaBox":  pdfArray{0, 0, reportPageWidth, reportPageHeight},
+			"Resources": pdfDict{"Font": fontDict},
+			"Contents":  contents,
+		}))
+	}
+	u.set(pagesRef, pdfDict{"Type": pdfName("Pages"), "Kids": kids, "Count": len(kids)})
+	u.doc.trailer["Root"] = u.add(pdfDict{"Type": pdfName("Catalog"), "Pages": pagesRef})
+	return u.bytes(), nil
+}
@@ -0,0 +1,158 @@
+package pdfsign
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// signedBase builds a one-page document whose signature field Sig1 covers
+// the whole file, with the text field Amount on the same page.
+func signedBase(t *testing.T) []byte {
+	t.Helper()
+	const placeholder = "0000000000"
+	data := classicPdf([]string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [4 0 R 6 0 R] >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 7 0 R /Annots [4 0 R 6 0 R] >>",
+		"<< /FT /Sig /T (Sig1) /V 5 0 R /Type /Annot /Subtype /Widget /Rect [0 0 0 0] /P 3 0 R >>",
+		"<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached /ByteRange [0 10 20 " + placeholder + "] /Contents <3000> >>",
+		"<< /FT /Tx /T (Amount) /V (100) /Type /Annot /Subtype /Widget /Rect [100 100 200 120] /P 3 0 R >>",
+		stream("", "BT /F1 10 Tf (Invoice) Tj ET"),
+	}, "")
+	// Only the end of the byte range (20 + length) matters here.
+	return bytes.Replace(data, []byte(placeholder), []byte(fmt.Sprintf("%010d", len(data)-20)), 1)
+}
+
+func TestModifications(t *testing.T) {
+	update := func(t *testing.T, change func(doc *pdfDocument, u *pdfUpdate)) []byte {
+		doc, err := parsePdf(signedBase(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := doc.newUpdate()
+		change(doc, u)
+		return u.bytes()
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		want   ModificationReport
+		policy TrustPolicy
+		passed bool
+	}{
+		{
+			name:   "nothing appended",
+			data:   signedBase(t),
+			want:   ModificationReport{Revision: 1, Revisions: 1, CoversDocument: true},
+			passed: true,
+		},
+		{
+			name: "form filled",
+			data: update(t, func(doc *pdfDocument, u *pdfUpdate) {
+				field := copyDict(doc.dict(pdfRef{Num: 6}))
+				field["V"] = pdfTextString("200")
+				u.set(pdfRef{Num: 6}, field)
+			}),
+			want: ModificationReport{Revision: 1, Revisions: 2, FormChanges: []string{"Amount"}},
+		},
+		{
+			name: "form filled, allowed",
+			data: update(t, func(doc *pdfDocument, u *pdfUpdate) {
+				field := copyDict(doc.dict(pdfRef{Num: 6}))
+				field["V"] = pdfTextString("200")
+				u.set(pdfRef{Num: 6}, field)
+			}),
+			want:   ModificationReport{Revision: 1, Revisions: 2, FormChanges: []string{"Amount"}},
+			policy: TrustPolicy{AllowFormChanges: true},
+			passed: true,
+		},
+		{
+			name: "page content replaced",
+			data: update(t, func(doc *pdfDocument, u *pdfUpdate) {
+				u.set(pdfRef{Num: 7}, &pdfStream{Dict: pdfDict{}, Raw: []byte("BT /F1 10 Tf (Paid) Tj ET")})
+			}),
+			want: ModificationReport{Revision: 1, Revisions: 2, OtherChanges: []string{"object 7 (page 1 content)"}},
+		},
+		{
+			name: "DSS added",
+			data: update(t, func(doc *pdfDocument, u *pdfUpdate) {
+				catalog := copyDict(doc.catalog())
+				catalog["DSS"] = u.add(pdfDict{"Type": pdfName("DSS"), "OCSPs": pdfArray{u.add(&pdfStream{Dict: pdfDict{}, Raw: []byte{0x30, 0}})}})
+				u.set(doc.trailer["Root"].(pdfRef), catalog)
+			}),
+			want:   ModificationReport{Revision: 1, Revisions: 2, DSSUpdates: true},
+			passed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parsePdf(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields := doc.signedFields()
+			if len(fields) != 1 {
+				t.Fatalf("%d signed fields, want 1", len(fields))
+			}
+			m := doc.modifications(fields, fields[0], doc.objectRoles())
+			if fmt.Sprintf("%+v", *m) != fmt.Sprintf("%+v", tt.want) {
+				t.Fatalf("modifications = %+v, want %+v", *m, tt.want)
+			}
+			v := tt.policy.evaluate(SignatureReport{Field: "Sig1", Valid: true, Modifications: m})
+			if (v.Indication == TotalPassed) != tt.passed {
+				t.Fatalf("indication %s %v", v.Indication, v.Reasons)
+			}
+		})
+	}
+}
+
+func TestTrustPolicyEvaluate(t *testing.T) {
+	untrusted := &ChainResult{Trusted: false, Error: "unknown authority"}
+	trusted := &ChainResult{Trusted: true}
+	tests := []struct {
+		name   string
+		policy TrustPolicy
+		report SignatureReport
+		want   Indication
+		reason string
+	}{
+		{"valid", TrustPolicy{}, SignatureReport{Valid: true}, TotalPassed, ""},
+		{"broken", TrustPolicy{}, SignatureReport{Error: "digest mismatch\ndetails"}, TotalFailed, "does not verify: digest mismatch"},
+		{"revoked signer", TrustPolicy{}, SignatureReport{Valid: true, SignerRevocation: []RevocationCheck{{Subject: "signer", Status: ocspRevoked}}}, TotalFailed, "certificate 'signer' is revoked"},
+		{"revoked TSA", TrustPolicy{}, SignatureReport{Valid: true, TsaRevocation: []RevocationCheck{{Subject: "tsa", Status: ocspRevoked}}}, TotalFailed, "certificate 'tsa' is revoked"},
+		{"good revocation", TrustPolicy{}, SignatureReport{Valid: true, SignerRevocation: []RevocationCheck{{Subject: "signer", Status: ocspGood}}}, TotalPassed, ""},
+		{"untrusted signer", TrustPolicy{RequireTrusted: true}, SignatureReport{Valid: true, SignerTrust: untrusted}, Indeterminate, "does not chain to a trusted anchor: unknown authority"},
+		{"untrusted TSA", TrustPolicy{RequireTrusted: true}, SignatureReport{Valid: true, SignerTrust: trusted, Timestamped: true}, Indeterminate, "timestamp certificate"},
+		{"trusted", TrustPolicy{RequireTrusted: true}, SignatureReport{Valid: true, SignerTrust: trusted, Timestamped: true, TsaTrust: trusted}, TotalPassed, ""},
+		{"unprovable revocation", TrustPolicy{RequireRevocation: true}, SignatureReport{Valid: true, Unprovable: []string{"signer: A"}}, Indeterminate, "cannot be proven from the document for: signer: A"},
+		{"level too low", TrustPolicy{MinLevel: LevelBLT}, SignatureReport{Valid: true, Level: string(LevelBT)}, TotalFailed, "level B-T is below the required B-LT"},
+		{"not PAdES", TrustPolicy{MinLevel: LevelBB}, SignatureReport{Valid: true}, TotalFailed, "level not PAdES"},
+		{"document timestamp has no level", TrustPolicy{MinLevel: LevelBLT}, SignatureReport{Valid: true, DocumentTimestamp: true}, TotalPassed, ""},
+		{"failure wins", TrustPolicy{RequireTrusted: true}, SignatureReport{SignerTrust: untrusted}, TotalFailed, "does not chain"},
+	}
+	// Both signatures cover the document; the first passes every policy.
+	intact := &ModificationReport{Revision: 1, Revisions: 1, CoversDocument: true}
+	good := SignatureReport{Field: "Sig1", Valid: true, Modifications: intact, Level: string(LevelBLTA), SignerTrust: trusted}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.report
+			r.Field, r.Modifications = "Sig2", intact
+			doc, got := tt.policy.Evaluate([]SignatureReport{good, r})
+			if doc != tt.want || got[1].Indication != tt.want {
+				t.Fatalf("indication %s (document %s), want %s: %v", got[1].Indication, doc, tt.want, got[1].Reasons)
+			}
+			if reasons := strings.Join(got[1].Reasons, "; "); !strings.Contains(reasons, tt.reason) {
+				t.Fatalf("reasons %q do not mention %q", reasons, tt.reason)
+			}
+		})
+	}
+	// Without the signature dictionary, changes after signing are unknown.
+	if v := (&TrustPolicy{}).evaluate(SignatureReport{Valid: true}); v.Indication != Indeterminate {
+		t.Fatalf("unread signature dictionary: %s %v, want %s", v.Indication, v.Reasons, Indeterminate)
+	}
+	if doc, _ := (&TrustPolicy{}).Evaluate(nil); doc != Indeterminate {
+		t.Fatalf("document without signatures: %s, want %s", doc, Indeterminate)
+	}
+}
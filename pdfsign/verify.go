@@ -0,0 +1,341 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkat"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- Signature Verification ---
+
+// VerifyOptions controls VerifySignatures.
+type VerifyOptions struct {
+	// Trust evaluates signer and TSA chains. When nil only the cryptographic
+	// validity reported by Chilkat is checked.
+	Trust *TrustStore
+	// Offline proves the revocation status of every signer and TSA chain
+	// certificate using only the OCSP responses and CRLs embedded in the PDF
+	// (DSS and signature CMS). Nothing is fetched; certificates that cannot
+	// be proven are listed in SignatureReport.Unprovable. Use VerifyDocument
+	// so the DSS can be read.
+	Offline bool
+	// Policies are the signature policies (PAdES-EPES) with a local copy
+	// of their document or their published hash. A signature under any
+	// other policy is reported with an error in SignatureReport.Policy.
+	Policies []*SignaturePolicy
+}
+
+// ChainResult is the outcome of building a chain to a trust anchor.
+type ChainResult struct {
+	Trusted bool     `json:"trusted"`
+	Chain   []string `json:"chain,omitempty"` // subject CNs, leaf first
+	Error   string   `json:"error,omitempty"`
+}
+
+// SignatureReport describes one signature in a PDF.
+type SignatureReport struct {
+	Index       int          `json:"index"`
+	Field       string       `json:"field,omitempty"` // VerifyDocument only
+	Valid       bool         `json:"valid"`           // signature and byte range digest check out
+	SignerCN    string       `json:"signerCN,omitempty"`
+	SigningTime *time.Time   `json:"signingTime,omitempty"`
+	Timestamped bool         `json:"timestamped"`
+	SignerTrust *ChainResult `json:"signerTrust,omitempty"`
+	TsaCN       string       `json:"tsaCN,omitempty"`
+	TsaTrust    *ChainResult `json:"tsaTrust,omitempty"`
+	// Level is the PAdES level reached (VerifyDocument only), empty for
+	// signatures that are not CAdES-based.
+	Level             string `json:"level,omitempty"`
+	DocumentTimestamp bool   `json:"documentTimestamp,omitempty"`
+	// Modifications describes the updates after the signature
+	// (VerifyDocument only).
+	Modifications *ModificationReport `json:"modifications,omitempty"`
+	// Info is the reason, location, contact info and name of the signature
+	// dictionary (VerifyDocument only) and the CMS commitment type.
+	Info *SignatureInfo `json:"info,omitempty"`
+	// Policy is set when the signature names a signature policy.
+	Policy *PolicyResult `json:"policy,omitempty"`
+	// Offline mode only
+	SignerRevocation []RevocationCheck `json:"signerRevocation,omitempty"`
+	TsaRevocation    []RevocationCheck `json:"tsaRevocation,omitempty"`
+	Unprovable       []string          `json:"unprovable,omitempty"` // "signer: CN" / "tsa: CN"
+	Details          string            `json:"details,omitempty"`    // Chilkat's sigInfo JSON
+	Error            string            `json:"error,omitempty"`
+}
+
+// VerifyDocument loads the PDF bytes into Chilkat and verifies them like
+// VerifySignatures. Unlike VerifySignatures it also reads the document's DSS,
+// which offline revocation checking depends on, and the signature
+// dictionaries, which hold the field name and most of SignatureInfo, and
+// reports each signature's PAdES level and the changes made after it.
+func VerifyDocument(data []byte, opts VerifyOptions) ([]SignatureReport, error) {
+	bd := chilkat.NewBinData()
+	defer bd.DisposeBinData()
+	defer trackChilkat("BinData")()
+	bd.AppendBinary(data)
+
+	pdf := chilkat.NewPdf()
+	defer pdf.DisposePdf()
+	defer trackChilkat("Pdf")()
+	if !pdf.LoadBd(bd) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, NewChilkatError("loading the PDF", pdf.LastErrorText()))
+	}
+
+	// Chilkat loaded the file, so a structure our reader cannot follow only
+	// costs the signature dictionary metadata, unless the DSS is needed.
+	doc, err := parsePdf(data)
+	if err != nil && opts.Offline {
+		return nil, fmt.Errorf("%w: failed to read PDF structure for DSS: %w", ErrInvalidInput, err)
+	}
+	var dss *revocationMaterial
+	var fields []signedField
+	if doc != nil {
+		fields = doc.signedFields()
+		if opts.Offline {
+			if dss, err = dssMaterial(doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	reports, err := verifySignatures(pdf, dss, fields, opts)
+	if err == nil && doc != nil {
+		describeRevisions(doc, fields, reports)
+	}
+	return reports, err
+}
+
+// VerifySignatures verifies every signature in pdf and, when opts.Trust is
+// set, validates the signer chain against the signing anchors and the
+// timestamp chain against the TSA anchors. Signer chains are evaluated at
+// the timestamp's genTime when the signature carries a verified timestamp
+// from a trusted TSA, otherwise now.
+// In offline mode only the material inside each signature is available; the
+// DSS is not, see VerifyDocument.
+func VerifySignatures(pdf *chilkat.Pdf, opts VerifyOptions) ([]SignatureReport, error) {
+	return verifySignatures(pdf, nil, nil, opts)
+}
+
+func verifySignatures(pdf *chilkat.Pdf, dss *revocationMaterial, fields []signedField, opts VerifyOptions) ([]SignatureReport, error) {
+	for _, p := range opts.Policies {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	numSignatures := pdf.NumSignatures()
+	if numSignatures < 0 {
+		return nil, NewChilkatError("counting signatures", pdf.LastErrorText())
+	}
+
+	sigInfo := chilkat.NewJsonObject()
+	defer sigInfo.DisposeJsonObject()
+	defer trackChilkat("JsonObject")()
+
+	reports := make([]SignatureReport, 0, numSignatures)
+	for i := 0; i < numSignatures; i++ {
+		r := SignatureReport{Index: i}
+		r.Valid = pdf.VerifySignature(i, sigInfo)
+		if !r.Valid {
+			r.Error = pdf.LastErrorText()
+		}
+		if emitted := sigInfo.Emit(); emitted != nil {
+			r.Details = *emitted
+		}
+
+		sd, raw, err := signatureCMS(pdf, i)
+		if f, ok := findSignedField(fields, raw); ok {
+			r.Field = f.name
+			info := f.info
+			r.Info = &info
+		}
+		if err != nil {
+			if r.Error == "" {
+				r.Error = err.Error()
+			}
+			reports = append(reports, r)
+			continue
+		}
+		if c := commitmentType(sd.SignerInfos[0]); c != "" {
+			if r.Info == nil {
+				r.Info = &SignatureInfo{}
+			}
+			r.Info.Commitment = c
+		}
+		if r.Info != nil && r.Info.IsZero() {
+			r.Info = nil
+		}
+		r.Policy = checkPolicy(sd.SignerInfos[0], opts.Policies)
+		evaluateSignature(sd, dss, &r, opts)
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// signatureCMS returns the decoded /Contents of the signature at index
+// together with the raw bytes, which are returned even when decoding fails.
+func signatureCMS(pdf *chilkat.Pdf, index int) (*cmsSignedData, []byte, error) {
+	bd := chilkat.NewBinData()
+	defer bd.DisposeBinData()
+	defer trackChilkat("BinData")()
+	if !pdf.GetSignatureContent(index, bd) {
+		return nil, nil, NewChilkatError(fmt.Sprintf("reading the contents of signature %d", index), pdf.LastErrorText())
+	}
+	raw := bd.GetBinary()
+	sd, err := parseCMS(raw)
+	if err != nil {
+		return nil, raw, fmt.Errorf("signature %d: %w", index, err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, raw, fmt.Errorf("signature %d has no SignerInfo", index)
+	}
+	return sd, raw, nil
+}
+
+func evaluateSignature(sd *cmsSignedData, dss *revocationMaterial, r *SignatureReport, opts VerifyOptions) {
+	signer, err := sd.signerCert(sd.SignerInfos[0])
+	if err != nil {
+		r.SignerTrust = &ChainResult{Error: err.Error()}
+		return
+	}
+	r.SignerCN = signer.Subject.CommonName
+
+	tok, tsaCert, err := signatureTimestamp(sd)
+	switch {
+	case err != nil:
+		r.TsaTrust = &ChainResult{Error: err.Error()}
+	case tok != nil:
+		r.Timestamped = true
+		r.SigningTime = &tok.GenTime
+		r.TsaCN = tsaCert.cert.Subject.CommonName
+	default:
+		if t, ok := claimedSigningTime(sd.SignerInfos[0]); ok {
+			r.SigningTime = &t
+		}
+	}
+
+	var material *revocationMaterial
+	if opts.Offline {
+		material = &revocationMaterial{}
+		if dss != nil {
+			*material = *dss
+			material.certs = append([]*x509.Certificate(nil), dss.certs...)
+		}
+		material.addCMS(sd)
+	}
+
+	// The signer chain is evaluated at the timestamp's genTime only when
+	// the token is verified and its TSA chains to a TSA anchor. Anyone can
+	// make a token, and a backdated one must not make an expired or
+	// revoked signing certificate valid.
+	validationTime := time.Now()
+	var signerChain, tsaChain []*x509.Certificate
+	if opts.Trust != nil {
+		var extra []*x509.Certificate
+		if material != nil {
+			extra = material.certs
+		}
+		if tok != nil {
+			tsaChain, err = opts.Trust.VerifyChain(TsaTrust, tsaCert.cert, append(tsaCert.embedded, extra...), tok.GenTime)
+			r.TsaTrust = chainResult(tsaChain, err)
+			if err == nil {
+				validationTime = tok.GenTime
+			}
+		}
+		signerChain, err = opts.Trust.VerifyChain(SigningTrust, signer, append(sd.Certificates, extra...), validationTime)
+		r.SignerTrust = chainResult(signerChain, err)
+	}
+	if material == nil {
+		return
+	}
+
+	// Without a trusted chain, check whatever chain the embedded
+	// certificates form; its root is then not a trust anchor.
+	anchored := signerChain != nil
+	if signerChain == nil {
+		signerChain = buildChain(signer, material.certs)
+	}
+	r.SignerRevocation = material.checkChainRevocation(signerChain, validationTime, anchored)
+	if tok != nil {
+		anchored = tsaChain != nil
+		if tsaChain == nil {
+			tsaChain = buildChain(tsaCert.cert, append(tsaCert.embedded, material.certs...))
+		}
+		r.TsaRevocation = material.checkChainRevocation(tsaChain, tok.GenTime, anchored)
+	}
+	for _, c := range r.SignerRevocation {
+		if !c.Proven {
+			r.Unprovable = append(r.Unprovable, "signer: "+c.Subject)
+		}
+	}
+	for _, c := range r.TsaRevocation {
+		if !c.Proven {
+			r.Unprovable = append(r.Unprovable, "tsa: "+c.Subject)
+		}
+	}
+}
+
+type tsaSigner struct {
+	cert     *x509.Certificate
+	embedded []*x509.Certificate
+}
+
+// signatureTimestamp decodes the signature timestamp token, if any, and
+// identifies the TSA certificate that signed it. The token's own signature
+// is verified, and its messageImprint must be the hash of the signature
+// value it timestamps; whether the TSA is trusted is up to the caller.
+func signatureTimestamp(sd *cmsSignedData) (*tstInfo, *tsaSigner, error) {
+	tok, err := sd.timestampToken()
+	if err != nil || tok == nil {
+		return nil, nil, err
+	}
+	info, err := tok.tstInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tok.SignerInfos) == 0 {
+		return nil, nil, errors.New("timestamp token has no SignerInfo")
+	}
+	cert, err := tok.signerCert(tok.SignerInfos[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("timestamp token: %w", err)
+	}
+	if err := tok.verifySignerInfo(tok.SignerInfos[0], cert); err != nil {
+		return nil, nil, fmt.Errorf("timestamp token: %w", err)
+	}
+	h, ok := hashFromOID(info.MessageImprint.HashAlgorithm.Algorithm)
+	if !ok {
+		return nil, nil, fmt.Errorf("timestamp token: unsupported messageImprint hash %s", info.MessageImprint.HashAlgorithm.Algorithm)
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, hashBytes(h, sd.SignerInfos[0].signature)) {
+		return nil, nil, errors.New("timestamp token does not cover this signature (messageImprint mismatch)")
+	}
+	return info, &tsaSigner{cert: cert, embedded: tok.Certificates}, nil
+}
+
+// claimedSigningTime returns the signer's own signingTime attribute. It is
+// only a claim and is never used as the validation time.
+func claimedSigningTime(si cmsSignerInfo) (time.Time, bool) {
+	v, ok := findAttribute(si.SignedAttrs, oidSigningTimeAttr)
+	if !ok {
+		return time.Time{}, false
+	}
+	var t time.Time
+	if _, err := asn1.Unmarshal(v.FullBytes, &t); err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func chainResult(chain []*x509.Certificate, err error) *ChainResult {
+	if err != nil {
+		return &ChainResult{Error: err.Error()}
+	}
+	res := &ChainResult{Trusted: true}
+	for _, c := range chain {
+		res.Chain = append(res.Chain, c.Subject.CommonName)
+	}
+	return res
+}
@@ -0,0 +1,488 @@
+package pdfsign
+
+import (
+	"bytes"
+	"chilkat"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- Sequential Multi-Signer Workflow ---
+// SignSequentially lets several parties sign one PDF in a fixed order, each
+// into its own (prepared) signature field. Every step is an incremental
+// update of the previous step's output, and after each step all signatures
+// in the document are verified again, so a step that invalidates an earlier
+// signature stops the workflow instead of producing a broken contract.
+
+// Signer is one party of a workflow.
+type Signer struct {
+	Name  string    // for logs and errors
+	Field string    // existing unsigned signature field to fill
+	Keys  KeySource // not closed by the workflow
+	// SignatureImage, when set, is the signer's own image (handwritten
+	// signature) in place of the appearance template's.
+	SignatureImage string
+	// Info is this signer's reason, location, ... on top of
+	// WorkflowOptions.Info.
+	Info SignatureInfo
+}
+
+// WorkflowOptions controls SignSequentially.
+type WorkflowOptions struct {
+	// Configure sets the signing options shared by every step (subFilter,
+	// timestamp, appearance, ...). Field routing is added by the workflow.
+	Configure func(jsonOptions *chilkat.JsonObject)
+	// Trust, when set, also requires every signer chain to be trusted after
+	// each step and lets Chilkat check chains while signing.
+	Trust *TrustStore
+	// StepDir, when set, receives the output of every step as
+	// step_<n>_<field>.pdf for auditing or resuming.
+	StepDir string
+	// Appearance, when set, is drawn into each signer's field right before
+	// the signer signs it; AppearanceValues is completed with the signer's
+	// common name and the signing time.
+	Appearance       *AppearanceTemplate
+	AppearanceValues AppearanceValues
+	// Info is the signature metadata shared by every signer.
+	Info SignatureInfo
+	// Policy, when set, makes every signature policy-based (PAdES-EPES).
+	Policy *SignaturePolicy
+	// Algorithm, when set, is checked against every signer's key (and HSM
+	// mechanisms) before the first step and overrides the seed value's
+	// digest choice. When nil Chilkat's defaults and the seed value apply.
+	Algorithm *Algorithm
+	// Audit, when set, gets a record for every step's signing, with the
+	// document named Document (e.g. its input path). A record that cannot
+	// be written stops the workflow.
+	Audit    *AuditLog
+	Document string
+}
+
+// StepResult is the state of the document after one signer.
+type StepResult struct {
+	Signer  string
+	Field   string
+	Reports []SignatureReport
+	Space   SigSpaceReport // of this step's signature
+}
+
+// SignSequentially applies the signers in order to data and returns the
+// final document together with the per-step verification results. It stops
+// at the first failure; the results of completed steps are still returned.
+func SignSequentially(data []byte, signers []Signer, opts WorkflowOptions) ([]byte, []StepResult, error) {
+	if len(signers) == 0 {
+		return nil, nil, errors.New("workflow has no signers")
+	}
+	doc, err := parsePdf(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.encrypted() {
+		return nil, nil, fmt.Errorf("%w: not supported by the signing workflow", ErrPdfEncrypted)
+	}
+
+	// Route every signer before signing anything, so a typo in the last
+	// signer's field does not leave a half-signed document behind.
+	seen := make(map[string]string)
+	algorithms := make([]*Algorithm, len(signers))
+	for i, s := range signers {
+		if s.Keys == nil {
+			return nil, nil, fmt.Errorf("signer '%s' has no key source", s.Name)
+		}
+		if prev, ok := seen[s.Field]; ok {
+			return nil, nil, fmt.Errorf("signers '%s' and '%s' both target field '%s'", prev, s.Name, s.Field)
+		}
+		seen[s.Field] = s.Name
+		if err := checkUnsignedField(doc, s.Field); err != nil {
+			return nil, nil, fmt.Errorf("signer '%s': %w", s.Name, err)
+		}
+		if err := s.Info.Merge(opts.Info).Validate(); err != nil {
+			return nil, nil, fmt.Errorf("signer '%s': %w", s.Name, err)
+		}
+		if opts.Algorithm != nil {
+			alg, err := CheckKeySourceAlgorithm(s.Keys, *opts.Algorithm)
+			if err != nil {
+				return nil, nil, fmt.Errorf("signer '%s': %w", s.Name, err)
+			}
+			algorithms[i] = &alg
+		}
+	}
+	var policies []*SignaturePolicy
+	if opts.Policy != nil {
+		if err := opts.Policy.Validate(); err != nil {
+			return nil, nil, err
+		}
+		policies = append(policies, opts.Policy)
+	}
+	existing := countSignatures(doc)
+
+	log, jobID := JobLogger("workflow")
+	docID := NewCorrelationID()
+	log = log.With(LogKeyDoc, docID)
+	log.Info("workflow started", "signers", len(signers), "existing_signatures", existing)
+
+	var results []StepResult
+	current := data
+	for i, s := range signers {
+		step := i + 1
+		stepLog := log.With("step", step, "signer", s.Name, "field", s.Field)
+		stepLog.Info("workflow step started", "steps", len(signers), "key_source", s.Keys.String())
+
+		signed, space, err := signStep(stepLog, current, s, algorithms[i], opts)
+		auditErr := opts.Audit.Append(AuditEntry{
+			JobID: jobID, DocID: docID,
+			Input: opts.Document, Field: s.Field,
+			InputData: current, OutputData: signed,
+			Keys: s.Keys, Err: err,
+		})
+		if err != nil {
+			return current, results, fmt.Errorf("step %d ('%s'): %w", step, s.Name, err)
+		}
+		if auditErr != nil {
+			return current, results, fmt.Errorf("step %d ('%s'): signed, but the audit record failed: %w", step, s.Name, auditErr)
+		}
+		if existing+i > 0 && !bytes.HasPrefix(signed, current) {
+			return current, results, fmt.Errorf("step %d ('%s'): signing rewrote the document instead of appending an incremental update", step, s.Name)
+		}
+
+		reports, err := VerifyDocument(signed, VerifyOptions{Trust: opts.Trust, Policies: policies})
+		if err != nil {
+			return current, results, fmt.Errorf("step %d ('%s'): verification failed: %w", step, s.Name, err)
+		}
+		results = append(results, StepResult{Signer: s.Name, Field: s.Field, Reports: reports, Space: space})
+		if want := existing + step; len(reports) != want {
+			return current, results, fmt.Errorf("step %d ('%s'): expected %d signatures after signing, found %d", step, s.Name, want, len(reports))
+		}
+		if err := checkReports(reports); err != nil {
+			return current, results, fmt.Errorf("step %d ('%s'): %w", step, s.Name, err)
+		}
+
+		if opts.StepDir != "" {
+			name := fmt.Sprintf("step_%d_%s.pdf", step, sanitizeFileName(s.Field))
+			if err := os.MkdirAll(opts.StepDir, 0755); err != nil {
+				return signed, results, err
+			}
+			if err := os.WriteFile(filepath.Join(opts.StepDir, name), signed, 0644); err != nil {
+				return signed, results, fmt.Errorf("failed to save step %d: %w", step, err)
+			}
+		}
+		stepLog.Info("workflow step signed", "signatures", len(reports), "sig_space_used", space.Used, "sig_space_reserved", space.Reserved)
+		current = signed
+	}
+	log.Info("workflow finished", "steps", len(signers))
+	return current, results, nil
+}
+
+func signStep(log *slog.Logger, data []byte, s Signer, alg *Algorithm, opts WorkflowOptions) ([]byte, SigSpaceReport, error) {
+	cert, err := s.Keys.Cert()
+	if err != nil {
+		return nil, SigSpaceReport{}, err
+	}
+	info := s.Info.Merge(opts.Info)
+	if opts.Appearance != nil {
+		v := info.AppearanceValues(opts.AppearanceValues)
+		v.CertCN = cert.SubjectCN()
+		v.SigningTime = time.Now()
+		if s.SignatureImage != "" {
+			v.SignatureImage = s.SignatureImage
+		}
+		if data, err = PrepareAppearance(data, s.Field, opts.Appearance, v); err != nil {
+			return nil, SigSpaceReport{}, err
+		}
+	}
+	return signPdfData(data, cert, signParams{
+		field:     s.Field,
+		trust:     opts.Trust != nil,
+		store:     opts.Trust,
+		configure: opts.Configure,
+		info:      info,
+		policy:    opts.Policy,
+		algorithm: alg,
+		log:       log,
+	})
+}
+
+// signParams are the per-signature inputs of signPdfData.
+type signParams struct {
+	field     string      // unsigned field to fill; empty adds a new signature
+	trust     bool        // let Chilkat check the signer chain
+	store     *TrustStore // completes the chain for sizing; may be nil
+	configure func(*chilkat.JsonObject)
+	info      SignatureInfo
+	policy    *SignaturePolicy
+	algorithm *Algorithm // resolved by CheckAlgorithm
+	log       *slog.Logger
+}
+
+// signPdfData signs data with cert, honouring the target field's seed
+// value, and checks that the requested metadata and policy made it into
+// the signature. The signature space is estimated from the options and
+// grown when Chilkat reports that the signature did not fit.
+func signPdfData(data []byte, cert *chilkat.Cert, sp signParams) ([]byte, SigSpaceReport, error) {
+	var space SigSpaceReport
+	log := sp.log
+	if log == nil {
+		log = slog.Default()
+	}
+	jsonOptions := chilkat.NewJsonObject()
+	defer jsonOptions.DisposeJsonObject()
+	defer trackChilkat("JsonObject")()
+	jsonOptions.UpdateBool("signingCertificateV2", true)
+	jsonOptions.UpdateInt("signingTime", 1)
+	if sp.configure != nil {
+		sp.configure(jsonOptions)
+	}
+	if sp.algorithm != nil {
+		ApplyAlgorithm(jsonOptions, *sp.algorithm)
+	}
+	ApplySignatureInfo(jsonOptions, sp.info)
+	if err := ApplySignaturePolicy(jsonOptions, sp.policy); err != nil {
+		return nil, space, err
+	}
+	if field := sp.field; field != "" {
+		doc, err := parsePdf(data)
+		if err != nil {
+			return nil, space, err
+		}
+		if err := applySeedValue(doc, field, cert, sp.algorithm, jsonOptions); err != nil {
+			return nil, space, err
+		}
+		jsonOptions.UpdateBool("appearance.fillUnsignedSignatureField", true)
+		jsonOptions.UpdateString("unsignedSignatureField", field)
+	}
+
+	size, err := SigAllocateSizeFor(cert, jsonOptions, sp.store)
+	if err != nil {
+		return nil, space, err
+	}
+	var signed []byte
+	space.Attempts, err = RetrySigAllocate(log, size, func(size int) error {
+		signed, err = signPdfAttempt(log, data, cert, jsonOptions, size, sp.trust)
+		return err
+	})
+	if err != nil {
+		return nil, space, err
+	}
+	if err := CheckSignatureInfo(signed, sp.field, sp.info); err != nil {
+		return nil, space, err
+	}
+	if err := CheckSignaturePolicy(signed, sp.field, sp.policy); err != nil {
+		return nil, space, err
+	}
+	measured, err := MeasureSigSpace(signed, sp.field)
+	if err != nil {
+		return nil, space, err
+	}
+	space.Reserved, space.Used = measured.Reserved, measured.Used
+	log.Debug("signature space", "reserved", space.Reserved, "used", space.Used, "attempts", space.Attempts)
+	return signed, space, nil
+}
+
+// signPdfAttempt is one SignPdf call on a freshly loaded copy of data.
+func signPdfAttempt(log *slog.Logger, data []byte, cert *chilkat.Cert, jsonOptions *chilkat.JsonObject, sigAllocateSize int, trust bool) ([]byte, error) {
+	pdf := chilkat.NewPdf()
+	defer pdf.DisposePdf()
+	defer trackChilkat("Pdf")()
+	pdf.SetVerboseLogging(ChilkatVerbose(log))
+	inBd := chilkat.NewBinData()
+	defer inBd.DisposeBinData()
+	defer trackChilkat("BinData")()
+	inBd.AppendBinary(data)
+	if !pdf.LoadBd(inBd) {
+		return nil, NewChilkatError("loading the PDF", pdf.LastErrorText())
+	}
+	if !pdf.SetSigningCert(cert) {
+		return nil, chilkatError("setting the signing certificate", pdf.LastErrorText(), ErrKeyNotFound)
+	}
+	if !trust {
+		pdf.SetUncommonOptions("NO_VERIFY_CERT_SIGNATURES")
+	}
+	pdf.SetSigAllocateSize(sigAllocateSize)
+
+	outBd := chilkat.NewBinData()
+	defer outBd.DisposeBinData()
+	defer trackChilkat("BinData")()
+	if !pdf.SignPdfBd(jsonOptions, outBd) {
+		return nil, NewSigningError("SignPdf", pdf.LastErrorText())
+	}
+	LogChilkat(log, "SignPdf", pdf.LastErrorText())
+	return outBd.GetBinary(), nil
+}
+
+func checkUnsignedField(doc *pdfDocument, name string) error {
+	f, ok := doc.findField(name)
+	switch {
+	case name == "":
+		return errors.New("no target field given")
+	case !ok:
+		return fmt.Errorf("signature field '%s' not found", name)
+	case f.Type != "Sig":
+		return fmt.Errorf("field '%s' is not a signature field", name)
+	case f.Signed:
+		return fmt.Errorf("signature field '%s' is already signed", name)
+	}
+	return nil
+}
+
+func countSignatures(doc *pdfDocument) int {
+	n := 0
+	for _, f := range doc.formFields() {
+		if f.Signed {
+			n++
+		}
+	}
+	return n
+}
+
+// checkReports fails on the first signature that no longer verifies or, when
+// trust was evaluated, is not trusted.
+func checkReports(reports []SignatureReport) error {
+	for _, r := range reports {
+		if !r.Valid {
+			return fmt.Errorf("signature %d (%s) is no longer valid: %s", r.Index, r.SignerCN, firstLine(r.Error))
+		}
+		if r.SignerTrust != nil && !r.SignerTrust.Trusted {
+			return fmt.Errorf("signature %d (%s) is not trusted: %s", r.Index, r.SignerCN, r.SignerTrust.Error)
+		}
+	}
+	return nil
+}
+
+// applySeedValue honours the field's /SV dictionary: the first allowed
+// SubFilter and digest are used, and a required issuer list must contain
+// the signer's issuer. A configured algorithm keeps its digest, which must
+// then be one the seed value allows.
+func applySeedValue(doc *pdfDocument, field string, cert *chilkat.Cert, alg *Algorithm, jsonOptions *chilkat.JsonObject) error {
+	f, ok := doc.findField(field)
+	if !ok {
+		return fmt.Errorf("signature field '%s' not found", field)
+	}
+	sv := doc.dict(f.Dict["SV"])
+	if sv == nil {
+		return nil
+	}
+	if list := doc.array(sv["SubFilter"]); len(list) > 0 {
+		if name, ok := doc.resolve(list[0]).(pdfName); ok {
+			jsonOptions.UpdateString("subFilter", "/"+string(name))
+		}
+	}
+	if list := doc.array(sv["DigestMethod"]); len(list) > 0 {
+		var allowed []string
+		for _, item := range list {
+			if name, ok := doc.resolve(item).(pdfName); ok {
+				allowed = append(allowed, strings.ToLower(strings.ReplaceAll(string(name), "-", "")))
+			}
+		}
+		switch {
+		case alg == nil && len(allowed) > 0:
+			jsonOptions.UpdateString("hashAlgorithm", allowed[0])
+		case alg != nil && !containsString(allowed, alg.Hash):
+			return fmt.Errorf("the seed value of field '%s' allows digests %s, not the configured %s", field, strings.Join(allowed, ", "), alg.Hash)
+		}
+	}
+	svCert := doc.dict(sv["Cert"])
+	issuers := doc.array(svCert["Issuer"])
+	if len(issuers) == 0 {
+		return nil
+	}
+	leaf, err := chilkatCertToX509(cert)
+	if err != nil {
+		return err
+	}
+	for _, item := range issuers {
+		s, ok := doc.resolve(item).(pdfString)
+		if !ok {
+			continue
+		}
+		issuer, err := x509.ParseCertificate(s.Value)
+		if err != nil {
+			continue
+		}
+		if leaf.CheckSignatureFrom(issuer) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signer certificate '%s' is not issued by any CA allowed by the seed value of field '%s'", leaf.Subject.CommonName, field)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' || r == '"' || r == '<' || r == '>' || r == '|' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+type signerConfig struct {
+	Name           string        `mapstructure:"name"`
+	Field          string        `mapstructure:"field"`
+	PfxPath        string        `mapstructure:"pfx_path"`
+	PfxPassword    string        `mapstructure:"pfx_password"`
+	Pkcs11LibPath  string        `mapstructure:"pkcs11_lib_path"`
+	Slot           int           `mapstructure:"slot"`
+	Pin            string        `mapstructure:"pin"`
+	TokenLabel     string        `mapstructure:"token_label"`
+	SignatureImage string        `mapstructure:"signature_image"`
+	SignatureInfo  SignatureInfo `mapstructure:"signature_info"`
+}
+
+// LoadSignersFromConfig reads the config.json key workflow_signers, an
+// ordered list of {name, field} plus either pfx_path/pfx_password or
+// pkcs11_lib_path/slot/pin (and token_label for the audit log), and
+// optionally signature_image and signature_info (see
+// LoadSignatureInfoFromConfig). The caller must Close every returned key
+// source.
+func LoadSignersFromConfig(vip *viper.Viper) ([]Signer, error) {
+	var cfgs []signerConfig
+	if err := vip.UnmarshalKey("workflow_signers", &cfgs); err != nil {
+		return nil, fmt.Errorf("invalid workflow_signers: %w", err)
+	}
+	if len(cfgs) == 0 {
+		return nil, errors.New("workflow_signers is empty")
+	}
+	signers := make([]Signer, 0, len(cfgs))
+	for i, c := range cfgs {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("signer %d", i+1)
+		}
+		s := Signer{Name: name, Field: c.Field, SignatureImage: c.SignatureImage, Info: c.SignatureInfo}
+		switch {
+		case c.PfxPath != "" && c.Pkcs11LibPath != "":
+			return nil, fmt.Errorf("%s: set either pfx_path or pkcs11_lib_path, not both", name)
+		case c.PfxPath != "":
+			s.Keys = &PfxKeySource{Path: c.PfxPath, Password: c.PfxPassword}
+		case c.Pkcs11LibPath != "":
+			s.Keys = &Pkcs11KeySource{LibPath: c.Pkcs11LibPath, Slot: c.Slot, Pin: c.Pin, TokenLabel: c.TokenLabel}
+		default:
+			return nil, fmt.Errorf("%s: no key source (pfx_path or pkcs11_lib_path)", name)
+		}
+		signers = append(signers, s)
+	}
+	return signers, nil
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/pdfsign"
 	"errors"        // <<< Added for custom errors
 	"fmt"           // <<< Added for directory creation
+	"log/slog"      // <<< Added for structured logging
+	"os"            // <<< Added for measuring the signature space
 	"path/filepath" // <<< Added for path joining
 	"time"          // <<< Added for sleep
 
@@ -28,14 +31,13 @@ func loadConfig() (*viper.Viper, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fatal error config file: %w", err)
 	}
-	fmt.Println("Configuration file loaded successfully.")
 	return vip, nil
 }
 
 // --- Chilkat Initialization ---
-func initializeChilkat() error {
+func initializeChilkat(log *slog.Logger) error {
 	glob := chilkat.NewGlobal()
-	glob.SetVerboseLogging(true) // <<< Enable Global Verbose Logging
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // <<< Verbose only at debug level
 	// NOTE: glob is often managed globally, but DisposeGlobal should be called at program end.
 	// We will call DisposeGlobal in main's defer. Let's ensure UnlockBundle runs.
 	// defer glob.DisposeGlobal() // Called in main
@@ -44,26 +46,22 @@ func initializeChilkat() error {
 	if !success {
 		errMsg := glob.LastErrorText() // Capture error before potential DisposeGlobal
 		// glob.DisposeGlobal() // Dispose if unlock fails? Depends on API design. Let main handle it.
-		return fmt.Errorf("failed to unlock Chilkat: %s", errMsg)
+		return pdfsign.NewChilkatError("unlocking Chilkat", errMsg)
 	}
 
 	status := glob.UnlockStatus()
-	if status == 2 {
-		fmt.Println("Chilkat unlocked using purchased unlock code.")
-	} else {
-		fmt.Println("Chilkat unlocked in trial mode.")
-	}
+	log.Info("Chilkat unlocked", "purchased", status == 2, "version", glob.Version())
 	return nil
 }
 
 // --- PKCS11 Initialization ---
-func initializePkcs11(libPath string) (*chilkat.Pkcs11, error) {
+func initializePkcs11(log *slog.Logger, libPath string) (*chilkat.Pkcs11, error) {
 	if libPath == "" {
 		return nil, errors.New("PKCS11 library path is empty")
 	}
 	pkcs11 := chilkat.NewPkcs11()
-	pkcs11.SetVerboseLogging(true) // <<< Enable PKCS11 Verbose Logging
-	fmt.Printf("Using PKCS11 library path: %s\n", libPath)
+	pkcs11.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // <<< Verbose only at debug level
+	log.Info("using PKCS11 library", "path", libPath)
 	pkcs11.SetSharedLibPath(libPath)
 
 	// Initialize the library - Step 1 (replaces part of QuickSession)
@@ -72,21 +70,21 @@ func initializePkcs11(libPath string) (*chilkat.Pkcs11, error) {
 	if !success {
 		errMsg := pkcs11.LastErrorText()
 		pkcs11.DisposePkcs11() // Dispose if init fails
-		return nil, fmt.Errorf("PKCS11 Initialize failed: %s", errMsg)
+		return nil, pdfsign.NewChilkatError("PKCS11 Initialize", errMsg)
 	}
-	fmt.Println("PKCS11 Initialize successful.")
+	log.Info("PKCS11 initialized")
 	return pkcs11, nil
 }
 
 // --- PKCS11 Session Establishment ---
-func establishPkcs11Session(pkcs11 *chilkat.Pkcs11, pin string, userType int) (slotID int, err error) {
+func establishPkcs11Session(log *slog.Logger, pkcs11 *chilkat.Pkcs11, pin string, userType int) (slotID int, err error) {
 	if pin == "" {
 		return -1, errors.New("HSM PIN is empty")
 	}
 
 	// --- Directly use Slot ID 0 ---
 	slotID = 0
-	fmt.Printf("Attempting to use hardcoded Slot ID: %d\n", slotID)
+	log.Debug("using hardcoded slot", "slot", slotID)
 
 	// Remove dynamic slot finding logic
 	/*
@@ -116,24 +114,24 @@ func establishPkcs11Session(pkcs11 *chilkat.Pkcs11, pin string, userType int) (s
 	readWrite := true
 	success := pkcs11.OpenSession(slotID, readWrite)
 	if !success {
-		return -1, fmt.Errorf("PKCS11 OpenSession failed for Slot ID %d: %s", slotID, pkcs11.LastErrorText())
+		return -1, pdfsign.NewChilkatError(fmt.Sprintf("PKCS11 OpenSession for Slot ID %d", slotID), pkcs11.LastErrorText())
 	}
-	fmt.Printf("PKCS11 OpenSession successful for Slot ID: %d\n", slotID)
+	log.Info("PKCS11 session opened", "slot", slotID)
 
 	// Login to the session - Step 4 (replaces part of QuickSession)
 	success = pkcs11.Login(userType, pin)
 	if !success {
 		// Attempt to close session even if login fails
 		pkcs11.CloseSession()
-		return -1, fmt.Errorf("PKCS11 Login failed for Slot ID %d: %s", slotID, pkcs11.LastErrorText())
+		return -1, pdfsign.NewChilkatError(fmt.Sprintf("PKCS11 Login for Slot ID %d", slotID), pkcs11.LastErrorText())
 	}
-	fmt.Printf("PKCS11 Login successful for Slot ID: %d, UserType: %d\n", slotID, userType)
+	log.Info("PKCS11 login successful", "slot", slotID, "user_type", userType)
 
 	return slotID, nil // Return the used slot ID if needed, and nil error
 }
 
 // --- Certificate Finding ---
-func findCertificateWithPrivateKey(pkcs11 *chilkat.Pkcs11) (*chilkat.Cert, error) {
+func findCertificateWithPrivateKey(log *slog.Logger, pkcs11 *chilkat.Pkcs11) (*chilkat.Cert, error) {
 	cert := chilkat.NewCert()
 	// defer cert.DisposeCert() // Dispose in the caller (main)
 
@@ -141,30 +139,28 @@ func findCertificateWithPrivateKey(pkcs11 *chilkat.Pkcs11) (*chilkat.Cert, error
 	if !success {
 		cert.DisposeCert() // Dispose if not found
 		// It's possible no cert with a private key exists, handle this gracefully
-		errMsg := pkcs11.LastErrorText()
-		if errMsg == "No certificates with private keys found." || errMsg == "Did not find cert matching criteria." {
-			fmt.Println("No certificates having a private key were found.")
+		err := pdfsign.NewChilkatError("finding a certificate with a private key", pkcs11.LastErrorText())
+		if errors.Is(err, pdfsign.ErrKeyNotFound) {
+			log.Warn("no certificates having a private key were found")
 			return nil, nil // Return nil, nil to indicate not found but not necessarily an error
 		}
-		return nil, fmt.Errorf("error finding certificate with private key: %s", errMsg)
+		return nil, err
 	}
-	fmt.Println("Found cert with potential private key association: ", cert.SubjectCN())
+	log.Debug("found cert with potential private key association", "subject_cn", cert.SubjectCN())
 
 	// Verify the found certificate object truly has a private key linkage
 	if !cert.HasPrivateKey() {
 		subjectCN := cert.SubjectCN() // Get CN before disposing
 		cert.DisposeCert()
-		errMsg := fmt.Sprintf("Error: The certificate found (CN: %s) via pkcs11.FindCert does NOT have an associated private key according to Chilkat.", subjectCN)
-		fmt.Println(errMsg)
-		return nil, errors.New(errMsg)
+		return nil, fmt.Errorf("certificate '%s' found via pkcs11.FindCert: %w", subjectCN, pdfsign.ErrKeyNotFound)
 	}
-	fmt.Println("Certificate object confirmed to have an associated private key.")
+	log.Debug("certificate has an associated private key", "subject_cn", cert.SubjectCN())
 	return cert, nil
 }
 
 // --- (Optional) Find HSM Handles ---
 // Keeping this separate if direct handle verification is needed later
-func findHsmHandles(pkcs11 *chilkat.Pkcs11) (privKeyHandle uint, certHandle uint, err error) {
+func findHsmHandles(log *slog.Logger, pkcs11 *chilkat.Pkcs11) (privKeyHandle uint, certHandle uint, err error) {
 	privKeyHandle = 0
 	certHandle = 0
 
@@ -174,15 +170,15 @@ func findHsmHandles(pkcs11 *chilkat.Pkcs11) (privKeyHandle uint, certHandle uint
 	jsonTemplateKey.UpdateString("class", "private_key")
 	jsonTemplateKey.UpdateString("key_type", "ecc")
 	jsonTemplateKey.UpdateString("label", "ECC Private Key")
-	fmt.Println("Searching for private key with template:", jsonTemplateKey.Emit())
+	log.Debug("searching for private key", "template", jsonTemplateKey.Emit())
 	privKeyHandle = pkcs11.FindObject(jsonTemplateKey) // FindObject returns uint
 	if privKeyHandle == 0 {
-		fmt.Println("Warning: Failed to find the ECC private key handle:", pkcs11.LastErrorText())
+		log.Warn("failed to find the ECC private key handle", "chilkat_log", pkcs11.LastErrorText())
 		// Decide if this is a fatal error or just a warning
 		// err = errors.New("failed to find ECC private key handle")
 		// return // Return early if it's fatal
 	} else {
-		fmt.Printf("Found ECC Private Key Handle: %d\n", privKeyHandle)
+		log.Debug("found ECC private key handle", "handle", privKeyHandle)
 	}
 
 	// Find Certificate Handle
@@ -190,14 +186,14 @@ func findHsmHandles(pkcs11 *chilkat.Pkcs11) (privKeyHandle uint, certHandle uint
 	defer jsonTemplateCert.DisposeJsonObject()
 	jsonTemplateCert.UpdateString("class", "certificate")
 	jsonTemplateCert.UpdateString("label", "X509 Certificate")
-	fmt.Println("Searching for certificate with template:", jsonTemplateCert.Emit())
+	log.Debug("searching for certificate", "template", jsonTemplateCert.Emit())
 	certHandle = pkcs11.FindObject(jsonTemplateCert) // FindObject returns uint
 	if certHandle == 0 {
-		fmt.Println("Warning: Failed to find the X509 certificate handle:", pkcs11.LastErrorText())
+		log.Warn("failed to find the X509 certificate handle", "chilkat_log", pkcs11.LastErrorText())
 		// Decide if this is a fatal error or just a warning
 		// err = errors.New("failed to find X509 certificate handle") // Combine errors if needed
 	} else {
-		fmt.Printf("Found X509 Certificate Handle: %d\n", certHandle)
+		log.Debug("found X509 certificate handle", "handle", certHandle)
 	}
 
 	// Example: Return error if either handle is missing
@@ -209,27 +205,50 @@ func findHsmHandles(pkcs11 *chilkat.Pkcs11) (privKeyHandle uint, certHandle uint
 }
 
 // --- PDF Loading ---
-func loadPdfDocument(filePath string) (*chilkat.Pdf, error) {
+func loadPdfDocument(log *slog.Logger, filePath string, sigAllocateSize int) (*chilkat.Pdf, error) {
 	if filePath == "" {
 		return nil, errors.New("unsigned PDF input path is empty")
 	}
 	pdf := chilkat.NewPdf()
-	pdf.SetVerboseLogging(true)   // <<< Enable PDF Verbose Logging
-	pdf.SetSigAllocateSize(30000) // <<< Use the setter method
+	pdf.SetVerboseLogging(pdfsign.ChilkatVerbose(log)) // <<< Verbose only at debug level
+	pdf.SetSigAllocateSize(sigAllocateSize)            // estimated by pdfsign.SigAllocateSizeFor
 
 	// defer pdf.DisposePdf() // Dispose in the caller (main)
 	success := pdf.LoadFile(filePath)
 	if !success {
 		errMsg := pdf.LastErrorText()
 		pdf.DisposePdf() // Dispose if load fails
-		return nil, fmt.Errorf("failed to load PDF '%s': %s", filePath, errMsg)
+		return nil, pdfsign.NewChilkatError(fmt.Sprintf("loading PDF '%s'", filePath), errMsg)
 	}
-	fmt.Printf("Loaded unsigned PDF: %s\n", filePath)
+	log.Debug("loaded unsigned PDF", "path", filePath, "sig_allocate_size", sigAllocateSize)
 	return pdf, nil
 }
 
+// --- Signing Algorithm Check ---
+// Resolves the configured signature_algorithm against the HSM certificate's
+// key and the token's mechanism list before the first document is signed.
+func checkSigningAlgorithm(log *slog.Logger, pkcs11 *chilkat.Pkcs11, libPath string, slotID int, alg pdfsign.Algorithm) (pdfsign.Algorithm, error) {
+	cert, err := findCertificateWithPrivateKey(log, pkcs11)
+	if err != nil {
+		return alg, err
+	}
+	if cert == nil {
+		return alg, errors.New("no certificate with a private key on the HSM")
+	}
+	defer cert.DisposeCert()
+	alg, err = pdfsign.CheckAlgorithm(alg, cert)
+	if err != nil {
+		return alg, err
+	}
+	if err := pdfsign.CheckHsmMechanisms(libPath, slotID, alg, cert); err != nil {
+		return alg, err
+	}
+	log.Info("signing algorithm checked", "algorithm", alg.String())
+	return alg, nil
+}
+
 // --- Configure Signing Options ---
-func configureSigningOptions() (*chilkat.JsonObject, error) {
+func configureSigningOptions(log *slog.Logger, alg pdfsign.Algorithm) (*chilkat.JsonObject, error) {
 	json := chilkat.NewJsonObject()
 	// defer json.DisposeJsonObject() // Dispose in the caller (main)
 
@@ -237,8 +256,7 @@ func configureSigningOptions() (*chilkat.JsonObject, error) {
 	json.UpdateBool("signingCertificateV2", true)
 
 	json.UpdateInt("signingTime", 1)
-	json.UpdateString("signingAlgorithm", "pkcs")
-	json.UpdateString("hashAlgorithm", "sha256")
+	pdfsign.ApplyAlgorithm(json, alg) // hashAlgorithm, and signingAlgorithm for RSA
 
 	// // --- TEMPORARILY DISABLE TSA ---
 
@@ -269,12 +287,14 @@ func configureSigningOptions() (*chilkat.JsonObject, error) {
 	json.UpdateString("appearance.text[1]", "current_dt")
 	json.UpdateString("appearance.text[2]", "PAdES B-Level Signature") // 更新顯示文字
 
-	fmt.Println("Signing options configured (SIMPLIFIED FOR TESTING).")
+	log.Debug("signing options configured (SIMPLIFIED FOR TESTING)")
 	return json, nil
 }
 
 // --- PDF Signing ---
-func performPdfSigning(pdf *chilkat.Pdf, cert *chilkat.Cert, jsonOptions *chilkat.JsonObject, outputPath string) error {
+// verifyCertSignatures should only be false when no trust anchors are
+// configured; Chilkat then skips checking the signing cert chain.
+func performPdfSigning(log *slog.Logger, pdf *chilkat.Pdf, cert *chilkat.Cert, jsonOptions *chilkat.JsonObject, outputPath string, verifyCertSignatures bool) error {
 	if outputPath == "" {
 		return errors.New("signed PDF output path is empty")
 	}
@@ -284,42 +304,54 @@ func performPdfSigning(pdf *chilkat.Pdf, cert *chilkat.Cert, jsonOptions *chilka
 
 	// Set the certificate object to use for signing.
 	// Chilkat automatically uses the private key associated within the HSM session.
-	fmt.Println("Setting signing certificate on PDF object...")
 	success := pdf.SetSigningCert(cert)
-	pdf.SetUncommonOptions("NO_VERIFY_CERT_SIGNATURES") // <<< Use the setter method
+	if !verifyCertSignatures {
+		pdf.SetUncommonOptions("NO_VERIFY_CERT_SIGNATURES") // <<< Use the setter method
+	}
 
 	if !success {
-		return fmt.Errorf("failed to set signing certificate on PDF object: %s", pdf.LastErrorText())
+		return pdfsign.NewChilkatError("setting the signing certificate", pdf.LastErrorText())
 	}
-	fmt.Println("PKCS11 signing certificate configured successfully on PDF object.")
+	log.Debug("signing certificate set on PDF object")
 
 	// Sign the PDF
-	fmt.Println("--- Beginning PDF Signing --- (Verbose logs follow if error occurs)") // <<< Added Log
-	fmt.Printf("Attempting to sign PDF and save to: %s\n", outputPath)
 	success = pdf.SignPdf(jsonOptions, outputPath)
 	if !success {
-		errMsg := pdf.LastErrorText()                                        // Capture the potentially long error message
-		fmt.Println("--- PDF Signing Failed --- Verbose LastErrorText: ---") // <<< Added Log
-		fmt.Println(errMsg)                                                  // Print the full verbose error
-		fmt.Println("--- End of Verbose LastErrorText ---")                  // <<< Added Log
-		return fmt.Errorf("failed to sign PDF (see verbose log above)")      // Modify error message slightly
+		// The full Chilkat log is kept in the error and logged with it.
+		return pdfsign.NewSigningError("SignPdf", pdf.LastErrorText())
 	}
-	fmt.Println("PDF signed successfully!")
+	pdfsign.LogChilkat(log, "SignPdf", pdf.LastErrorText())
 	return nil
 }
 
+// --- Signature Space Report ---
+// Logs how much of the reserved /Contents the signature used, for tuning
+// the size estimate.
+func reportSigSpace(log *slog.Logger, path string, attempts int) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn("cannot measure signature space", "error", err)
+		return
+	}
+	space, err := pdfsign.MeasureSigSpace(data, "")
+	if err != nil {
+		log.Warn("cannot measure signature space", "error", err)
+		return
+	}
+	log.Info("PDF signed", "output", path, "sig_space_used", space.Used, "sig_space_reserved", space.Reserved, "attempts", attempts)
+}
+
 // --- PKCS11 Logout ---
-func pkcs11Logout(pkcs11 *chilkat.Pkcs11) error {
+func pkcs11Logout(log *slog.Logger, pkcs11 *chilkat.Pkcs11) error {
 	if pkcs11 == nil {
 		return errors.New("cannot logout, PKCS11 object is nil")
 	}
 	success := pkcs11.Logout()
 	if !success {
-		// Log as a warning, as cleanup should still proceed
-		fmt.Println("Warning: PKCS11 Logout failed (non-critical):", pkcs11.LastErrorText())
-		return fmt.Errorf("PKCS11 logout failed: %s", pkcs11.LastErrorText()) // Return error for info
+		// Returned for info; main logs it as a warning, as cleanup should still proceed
+		return pdfsign.NewChilkatError("PKCS11 Logout", pkcs11.LastErrorText())
 	}
-	fmt.Println("PKCS11 Logout successful.")
+	log.Info("PKCS11 logout successful")
 	return nil
 }
 
@@ -329,17 +361,27 @@ func main() {
 	// Defer global Chilkat cleanup
 	defer chilkat.NewGlobal().DisposeGlobal() // Dispose the global object at the very end
 
-	// 1. Initialize Chilkat Global
-	err := initializeChilkat()
+	// 1. Load Configuration
+	vip, err := loadConfig()
 	if err != nil {
-		fmt.Println("Error during Chilkat initialization:", err)
+		fmt.Println("Error loading configuration:", err)
 		return
 	}
 
-	// 2. Load Configuration
-	vip, err := loadConfig()
+	// Logging is configured by the "logging" key; PINs and passwords from the
+	// config are masked in every line.
+	closeLog, err := pdfsign.SetupLogging(vip)
 	if err != nil {
-		fmt.Println("Error loading configuration:", err)
+		fmt.Println("Error setting up logging:", err)
+		return
+	}
+	defer closeLog()
+	log, jobID := pdfsign.JobLogger("hsm-sign")
+
+	// 2. Initialize Chilkat Global
+	err = initializeChilkat(log)
+	if err != nil {
+		pdfsign.LogFailure(log, "Chilkat initialization failed", err)
 		return
 	}
 
@@ -352,99 +394,177 @@ func main() {
 	baseOutputFilename := "signed_hsm_ecc"                       // <<< Base name for output files
 	userType := 1                                                // Normal user, could also be in config
 
+	// Hash and signature scheme (signature_algorithm), checked against the
+	// HSM key and mechanisms once the session is open.
+	alg, err := pdfsign.LoadAlgorithmFromConfig(vip)
+	if err != nil {
+		log.Error("invalid signature_algorithm", "error", err)
+		return
+	}
+
+	// Load trust anchors (optional). When configured, the signing cert chain is
+	// validated before the loop and Chilkat checks it again during SignPdf.
+	trust, err := pdfsign.LoadTrustStoreFromConfig(vip)
+	if err != nil {
+		log.Error("loading trust store failed", "error", err)
+		return
+	}
+	if trust != nil {
+		tRoots, err := trust.ActivateChilkatRoots(pdfsign.SigningTrust)
+		if err != nil {
+			pdfsign.LogFailure(log, "activating trusted roots failed", err)
+			return
+		}
+		defer tRoots.DisposeTrustedRoots()
+		defer tRoots.Deactivate()
+	} else {
+		log.Warn("no trust anchors configured, signing cert chain will not be validated")
+	}
+
 	// 3. Initialize PKCS11
-	pkcs11, err := initializePkcs11(pkcs11LibPath)
+	pkcs11, err := initializePkcs11(log, pkcs11LibPath)
 	if err != nil {
-		fmt.Println("Error initializing PKCS11:", err)
+		pdfsign.LogFailure(log, "PKCS11 initialization failed", err)
 		return
 	}
 	// Defer PKCS11 object disposal (must happen after session closure)
 	defer pkcs11.DisposePkcs11() // Dispose the object itself
 
 	// 4. Establish PKCS11 Session and Login (includes PIN)
-	_, err = establishPkcs11Session(pkcs11, pin, userType)
+	slotID, err := establishPkcs11Session(log, pkcs11, pin, userType)
 	if err != nil {
-		fmt.Println("Error establishing PKCS11 session:", err)
+		pdfsign.LogFailure(log, "establishing PKCS11 session failed", err)
 		// Cleanup for pkcs11 (Dispose) is handled by defer
 		return
 	}
 	// Defer session closing (must happen before DisposePkcs11)
 	defer pkcs11.CloseSession() // Close the session
 
+	alg, err = checkSigningAlgorithm(log, pkcs11, pkcs11LibPath, slotID, alg)
+	if err != nil {
+		pdfsign.LogFailure(log, "signing algorithm check failed", err)
+		return
+	}
+
+	// Audit log (optional "audit" key): one hash-chained record per signing,
+	// sealed with a timestamp when the program ends.
+	audit, err := pdfsign.LoadAuditLogFromConfig(vip)
+	if err != nil {
+		log.Error("opening audit log failed", "error", err)
+		return
+	}
+	defer func() {
+		if err := audit.Close(); err != nil {
+			log.Warn("closing audit log failed", "audit_log", audit.Path(), "error", err)
+		}
+	}()
+	tokenLabel := vip.GetString("p11_token-label")
+
 	// --- Signing Loop ---
 	numberOfSignatures := 10
-	fmt.Printf("\n--- Starting Signing Loop (%d iterations) ---\n", numberOfSignatures)
+	log.Info("signing loop started", "iterations", numberOfSignatures)
+	signed := 0
 	for i := 1; i <= numberOfSignatures; i++ {
-		fmt.Printf("\n--- Iteration %d of %d ---\n", i, numberOfSignatures)
-
-		// 5. Load PDF Document (Moved up as per request)
-		pdf, err := loadPdfDocument(unsignedPdfPath)
-		if err != nil {
-			fmt.Println("Error loading PDF:", err)
-			// Cleanup handled by defers
-			return
-		}
-		defer pdf.DisposePdf() // Dispose the PDF object
+		// Construct the output path for this iteration; every line about it
+		// carries the same doc_id.
+		outputFilename := fmt.Sprintf("%s_%d.pdf", baseOutputFilename, i)
+		iterationOutputPath := filepath.Join(loopOutputDir, outputFilename)
+		docLog, docID := pdfsign.DocumentLogger(log, iterationOutputPath)
+		docLog = docLog.With("iteration", i)
 
 		// 6. Find Certificate with Private Key from HSM (Moved down, requires active session)
-		cert, err := findCertificateWithPrivateKey(pkcs11)
+		cert, err := findCertificateWithPrivateKey(docLog, pkcs11)
 		if err != nil {
-			fmt.Println("Error finding certificate:", err)
+			pdfsign.LogFailure(docLog, "finding certificate failed", err)
 			// Cleanup handled by defers
 			return
 		}
 		if cert == nil {
-			fmt.Println("Could not find a usable certificate with an associated private key on the HSM.")
+			docLog.Error("could not find a usable certificate with an associated private key on the HSM")
 			// Cleanup handled by defers
 			return
 		}
 		defer cert.DisposeCert() // Dispose the certificate object
 
+		// Refuse to sign with a cert that does not chain to a signing anchor
+		if trust != nil {
+			chain, err := trust.VerifyChilkatCert(pdfsign.SigningTrust, cert, time.Now())
+			if err != nil {
+				docLog.Error("signing certificate chain is not trusted", "error", err)
+				return
+			}
+			docLog.Debug("signing certificate chains to trust anchor", "anchor", chain[len(chain)-1].Subject.CommonName)
+		}
+
 		// 7. (Optional) Find HSM Handles for verification if needed
-		_, _, err = findHsmHandles(pkcs11) // Ignore handles for now, just check error
+		_, _, err = findHsmHandles(docLog, pkcs11) // Ignore handles for now, just check error
 		if err != nil {
-			fmt.Println("Error finding HSM handles:", err)
+			docLog.Warn("finding HSM handles failed", "error", err)
 			// Decide if this is fatal
 			// return
 		}
 
 		// 8. Configure Signing Options (JSON) (Moved down)
-		jsonOptions, err := configureSigningOptions()
+		jsonOptions, err := configureSigningOptions(docLog, alg)
 		if err != nil {
-			fmt.Println("Error configuring signing options:", err)
+			docLog.Error("configuring signing options failed", "error", err)
 			// Cleanup handled by defers
 			return
 		}
 		defer jsonOptions.DisposeJsonObject() // Dispose the JSON object
 
-		// Construct the output path for this iteration
-		outputFilename := fmt.Sprintf("%s_%d.pdf", baseOutputFilename, i)
-		iterationOutputPath := filepath.Join(loopOutputDir, outputFilename)
-
 		// Perform the signing for this iteration
 		// Note: performPdfSigning now checks/creates the directory
-		err = performPdfSigning(pdf, cert, jsonOptions, iterationOutputPath)
+		// 5. Load the PDF and sign it. The signature space is estimated from
+		// the certificate and options; when the signature does not fit, the
+		// PDF is loaded again with a larger reservation.
+		sigAllocateSize, err := pdfsign.SigAllocateSizeFor(cert, jsonOptions, trust)
+		if err != nil {
+			docLog.Error("estimating signature size failed", "error", err)
+			return
+		}
+		attempts, err := pdfsign.RetrySigAllocate(docLog, sigAllocateSize, func(size int) error {
+			pdf, err := loadPdfDocument(docLog, unsignedPdfPath, size)
+			if err != nil {
+				return err
+			}
+			defer pdf.DisposePdf() // Dispose the PDF object
+			return performPdfSigning(docLog, pdf, cert, jsonOptions, iterationOutputPath, trust != nil)
+		})
+		auditErr := audit.AppendFiles(pdfsign.AuditEntry{
+			JobID: jobID, DocID: docID,
+			Input: unsignedPdfPath, Output: iterationOutputPath,
+			KeySource: "pkcs11:" + pkcs11LibPath, Slot: &slotID, TokenLabel: tokenLabel,
+			Cert: cert, Err: err,
+		})
+		if auditErr != nil {
+			// An unaudited signature must not go unnoticed; stop signing
+			pdfsign.LogFailure(docLog, "writing the audit record failed, stopping", auditErr)
+			return
+		}
+		if err == nil {
+			signed++
+			reportSigSpace(docLog, iterationOutputPath, attempts)
+		}
 		if err != nil {
-			fmt.Printf("Error during signing iteration %d: %v\n", i, err)
 			// Decide whether to continue or break on error
 			// break // Uncomment to stop loop on first error
-			fmt.Println("Continuing to next iteration despite error...")
+			pdfsign.LogFailure(docLog, "signing failed, continuing with the next iteration", err)
 		}
 
 		// Pause for 2 seconds between iterations
-		fmt.Println("Sleeping for 2 seconds...")
 		time.Sleep(2 * time.Second)
 	}
-	fmt.Printf("\n--- Finished Signing Loop ---\n\n")
+	log.Info("signing loop finished", "signed", signed, "failed", numberOfSignatures-signed)
 
 	// --- Logout after the loop ---
-	err = pkcs11Logout(pkcs11)
+	err = pkcs11Logout(log, pkcs11)
 	if err != nil {
-		// Logged as warning inside the function, main continues
-		fmt.Println("Note: Logout failed, proceeding with cleanup.")
+		// Main continues with cleanup
+		log.Warn("PKCS11 logout failed (non-critical), proceeding with cleanup", "error", err, "chilkat_log", pdfsign.ErrorDetail(err))
 	}
 
-	fmt.Println("Program finished successfully.")
+	log.Info("program finished")
 	// All deferred cleanup functions will execute now in reverse order:
 	// DisposeJsonObject, DisposePdf, DisposeCert, CloseSession, DisposePkcs11, DisposeGlobal
 }
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"chilkattest/apiserver"
+	"chilkattest/pdfsign"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// --- Signing Jobs ---
+// Every POST /v1/pdf/sign becomes a job: the upload is stored, the job is
+// queued, and one of a fixed number of workers signs it. LTV signing waits
+// on the TSA and OCSP responders for seconds, so clients poll
+// /v1/jobs/{id} instead of holding a connection open (or ask to wait for
+// short jobs).
+//
+// Each worker owns one key source, so with an HSM the workers form a pool
+// of PKCS#11 sessions that are opened once and reused; Chilkat objects are
+// never shared between goroutines. A wrong or locked PIN stops all
+// workers from logging in again, since every further attempt would bring
+// the token closer to locking.
+//
+// /readyz has an idle worker check its key source (see checkKey), so the
+// check uses the sessions that sign and opens none of its own.
+//
+// On shutdown no new jobs are accepted and the workers go on with the
+// queue until the shutdown timeout; jobs still queued then fail as
+// retryable. A job that is being signed is always finished, so no PKCS#11
+// session is closed in the middle of a signature.
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobSucceeded jobStatus = "succeeded"
+	jobFailed    jobStatus = "failed"
+)
+
+const (
+	inputKey  = "input.pdf"
+	resultKey = "signed.pdf"
+)
+
+var (
+	errQueueFull    = errors.New("signing queue is full")
+	errShuttingDown = errors.New("the service is shutting down")
+)
+
+// job is one signing request. Its fields are guarded by jobManager.mu.
+type job struct {
+	id       string
+	caller   string
+	client   string // authenticated client, the only one that may read the job
+	source   string // upload file name or URL, for the audit log
+	opts     pdfsign.SignOptions
+	status   jobStatus
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	space    pdfsign.SigSpaceReport
+	err      error
+	done     chan struct{} // closed when the job has finished
+}
+
+// jobView is the JSON form of a job.
+type jobView struct {
+	ID        string                  `json:"job_id"`
+	Status    jobStatus               `json:"status"`
+	Level     pdfsign.PadesLevel      `json:"level"`
+	Field     string                  `json:"field,omitempty"`
+	Created   time.Time               `json:"created"`
+	Started   *time.Time              `json:"started,omitempty"`
+	Finished  *time.Time              `json:"finished,omitempty"`
+	StatusURL string                  `json:"status_url"`
+	ResultURL string                  `json:"result_url,omitempty"` // once succeeded
+	SigSpace  *pdfsign.SigSpaceReport `json:"sig_space,omitempty"`
+	Error     *apiError               `json:"error,omitempty"`
+}
+
+type jobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	queue   chan *job
+	checks  chan chan error // key checks for an idle worker
+	store   Storage
+	audit   *pdfsign.AuditLog
+	ttl     time.Duration
+	keyName string // for metrics
+	keyErr  error  // a PIN failure that stops all signing
+	closed  bool   // no new jobs; the queue channel is closed
+	abandon bool   // fail the queued jobs instead of signing them
+	workers sync.WaitGroup
+	// for metrics and readiness
+	size      int
+	busy      int
+	lastCheck error
+}
+
+func newJobManager(store Storage, audit *pdfsign.AuditLog, keyName string, queueSize int, ttl time.Duration) *jobManager {
+	return &jobManager{
+		jobs:    make(map[string]*job),
+		queue:   make(chan *job, queueSize),
+		checks:  make(chan chan error),
+		store:   store,
+		audit:   audit,
+		keyName: keyName,
+		ttl:     ttl,
+	}
+}
+
+// start runs n workers, each signing with its own key source from newKeys,
+// and the janitor that forgets finished jobs after the TTL.
+func (m *jobManager) start(n int, newKeys func() pdfsign.KeySource) {
+	m.size = n
+	for i := 0; i < n; i++ {
+		m.workers.Add(1)
+		go m.work(newKeys())
+	}
+	go m.janitor()
+}
+
+// stop lets the workers finish the queued jobs until ctx is done and
+// closes their key sources. Jobs queued after that fail; stop returns once
+// the running ones have finished, however long that takes.
+func (m *jobManager) stop(ctx context.Context) {
+	m.mu.Lock()
+	m.closed = true
+	close(m.queue)
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	m.mu.Lock()
+	m.abandon = true
+	queued := len(m.queue)
+	m.mu.Unlock()
+	slog.Warn("shutdown timeout reached, waiting for the running signing jobs only", "queued_jobs_failed", queued)
+	<-done
+}
+
+// submit stores the input and queues a job for it.
+func (m *jobManager) submit(j *job, input []byte) error {
+	if err := m.store.Put(j.id+"/"+inputKey, input); err != nil {
+		return fmt.Errorf("storing the upload: %w", err)
+	}
+	j.status, j.created, j.done = jobQueued, time.Now().UTC(), make(chan struct{})
+	m.mu.Lock()
+	err := errShuttingDown
+	if !m.closed {
+		m.jobs[j.id] = j
+		select {
+		case m.queue <- j:
+			err = nil
+		default:
+			delete(m.jobs, j.id)
+			err = errQueueFull
+		}
+	}
+	m.mu.Unlock()
+	if err != nil {
+		m.store.DeleteJob(j.id)
+	}
+	return err
+}
+
+// get returns the job's view, or false for an unknown (or expired) job.
+func (m *jobManager) get(id string) (jobView, *job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return jobView{}, nil, false
+	}
+	return m.view(j), j, true
+}
+
+func (m *jobManager) view(j *job) jobView {
+	v := jobView{
+		ID:        j.id,
+		Status:    j.status,
+		Level:     j.opts.Level,
+		Field:     j.opts.Field,
+		Created:   j.created,
+		StatusURL: "/v1/jobs/" + j.id,
+	}
+	if !j.started.IsZero() {
+		v.Started = &j.started
+	}
+	if !j.finished.IsZero() {
+		v.Finished = &j.finished
+	}
+	switch j.status {
+	case jobSucceeded:
+		v.ResultURL = v.StatusURL + "/result"
+		v.SigSpace = &j.space
+	case jobFailed:
+		_, e := apiErrorFor(j.err)
+		v.Error = &e
+	}
+	return v
+}
+
+// result returns the signed document of a succeeded job.
+func (m *jobManager) result(id string) ([]byte, error) {
+	return m.store.Get(id + "/" + resultKey)
+}
+
+func (m *jobManager) work(keys pdfsign.KeySource) {
+	defer m.workers.Done()
+	defer keys.Close()
+	for {
+		select {
+		case j, ok := <-m.queue:
+			if !ok {
+				return
+			}
+			m.run(j, keys)
+		case reply := <-m.checks:
+			reply <- m.checkKeys(keys)
+		}
+	}
+}
+
+// checkKey has an idle worker prove that its key source can sign, for
+// /readyz. When every worker stays busy until ctx is done, the last result
+// stands: the workers are evidently signing.
+func (m *jobManager) checkKey(ctx context.Context) error {
+	m.mu.Lock()
+	keyErr, lastCheck := m.keyErr, m.lastCheck
+	m.mu.Unlock()
+	if keyErr != nil {
+		return keyErr
+	}
+	reply := make(chan error, 1)
+	select {
+	case m.checks <- reply:
+	case <-ctx.Done():
+		return lastCheck
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("checking the signing key: %w", ctx.Err())
+	}
+}
+
+func (m *jobManager) checkKeys(keys pdfsign.KeySource) error {
+	m.mu.Lock()
+	keyErr := m.keyErr
+	m.mu.Unlock()
+	if keyErr != nil {
+		// No further login with a PIN that was refused.
+		return keyErr
+	}
+	err := pdfsign.CheckKeySource(keys)
+	m.mu.Lock()
+	m.lastCheck = err
+	m.mu.Unlock()
+	m.stopOnPinFailure(slog.Default(), err)
+	return err
+}
+
+// stopOnPinFailure stops all signing when err is a refused PIN.
+func (m *jobManager) stopOnPinFailure(log *slog.Logger, err error) {
+	if !errors.Is(err, pdfsign.ErrPinIncorrect) && !errors.Is(err, pdfsign.ErrPinLocked) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keyErr == nil {
+		m.keyErr = err
+		log.Error("key source refused the PIN, signing is stopped until the service is restarted with a fixed configuration", "error", err)
+	}
+}
+
+func (m *jobManager) run(j *job, keys pdfsign.KeySource) {
+	log := slog.Default().With("job", "api-sign", pdfsign.LogKeyJob, j.id, "caller", j.caller)
+	m.mu.Lock()
+	j.status, j.started = jobRunning, time.Now().UTC()
+	keyErr := m.keyErr
+	abandon := m.abandon
+	m.busy++
+	m.mu.Unlock()
+	queueWait.Observe(j.started.Sub(j.created).Seconds())
+	log.Info("job started", "level", j.opts.Level, "field", j.opts.Field, "key_source", keys.String())
+
+	input, err := m.store.Get(j.id + "/" + inputKey)
+	var signed []byte
+	var space pdfsign.SigSpaceReport
+	switch {
+	case err != nil:
+		err = fmt.Errorf("reading the upload: %w", err)
+	case abandon:
+		err = errShuttingDown
+	case keyErr != nil:
+		err = keyErr
+	default:
+		opts := j.opts
+		opts.Log = log
+		signed, space, err = pdfsign.SignDocument(input, keys, opts)
+	}
+	if err == nil {
+		if err = m.store.Put(j.id+"/"+resultKey, signed); err != nil {
+			err = fmt.Errorf("storing the result: %w", err)
+			signed = nil
+		}
+	}
+	auditErr := m.audit.Append(pdfsign.AuditEntry{
+		JobID:      j.id,
+		Caller:     j.caller,
+		Input:      j.source,
+		Output:     "job:" + j.id,
+		Field:      j.opts.Field,
+		InputData:  input,
+		OutputData: signed,
+		Keys:       keys,
+		Err:        err,
+	})
+	if auditErr != nil && err == nil {
+		// An unaudited signature is not handed out.
+		m.store.DeleteJob(j.id)
+		err = fmt.Errorf("signed, but the audit record failed: %w", auditErr)
+	}
+
+	m.stopOnPinFailure(log, err)
+	if err != nil && !errors.Is(err, pdfsign.ErrInvalidInput) {
+		// The session or certificate may be stale; reopen for the next job.
+		keys.Close()
+	}
+
+	m.mu.Lock()
+	j.finished, j.space, j.err = time.Now().UTC(), space, err
+	if err != nil {
+		j.status = jobFailed
+	} else {
+		j.status = jobSucceeded
+	}
+	m.busy--
+	m.mu.Unlock()
+	close(j.done)
+	result := pdfsign.ResultLabel(err)
+	if errors.Is(err, errShuttingDown) {
+		result = "shutting_down"
+	}
+	apiserver.ObserveOperation(opPDFSign, m.keyName, result, j.started)
+
+	if err != nil {
+		pdfsign.LogFailure(log, "job failed", err)
+		return
+	}
+	log.Info("job succeeded", "sig_space_used", space.Used, "sig_space_reserved", space.Reserved, "duration", j.finished.Sub(j.started))
+}
+
+// janitor forgets finished jobs, and deletes their documents, once they
+// are older than the TTL.
+func (m *jobManager) janitor() {
+	for range time.Tick(time.Minute) {
+		cutoff := time.Now().Add(-m.ttl)
+		var expired []string
+		m.mu.Lock()
+		for id, j := range m.jobs {
+			if !j.finished.IsZero() && j.finished.Before(cutoff) {
+				delete(m.jobs, id)
+				expired = append(expired, id)
+			}
+		}
+		m.mu.Unlock()
+		for _, id := range expired {
+			if err := m.store.DeleteJob(id); err != nil {
+				slog.Warn("deleting expired job failed", pdfsign.LogKeyJob, id, "error", err)
+			}
+		}
+	}
+}
@@ -2,10 +2,21 @@ package main
 
 import (
 	"chilkat"
+	"chilkattest/apiauth"
+	"chilkattest/apilimit"
+	"chilkattest/apiserver"
+	"chilkattest/metrics"
+	"chilkattest/pdfsign"
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/viper"
 )
 
 /*
@@ -20,133 +31,219 @@ type SignResponse struct {
 	Error     string `json:"error,omitempty"`
 }
 
-// Handles the /sign request
-func signHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// Helper function to write JSON error responses
+func writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	response := SignResponse{Error: message}
+	json.NewEncoder(w).Encode(response)
+}
 
-	glob := chilkat.NewGlobal()
-	// It's important to dispose of Chilkat objects
-	defer glob.DisposeGlobal()
+// Operations that auth.clients[].operations can allow.
+const (
+	opXMLSign   = "xml.sign"
+	opPDFSign   = "pdf.sign"
+	opPDFVerify = "pdf.verify"
+	opJobsRead  = "jobs.read"
+	opMetrics   = "metrics.read"
+	opReload    = "keys.reload"
+)
 
-	success := glob.UnlockBundle("Anything for 30-day trial")
-	if success != true {
-		log.Println("Chilkat unlock failed:", glob.LastErrorText())
-		writeError(w, "Failed to unlock Chilkat library", http.StatusInternalServerError)
-		return
-	}
+// xmlSignKey names the key /sign uses in auth.clients[].keys.
+const xmlSignKey = "xml-demo"
 
-	zipFile := chilkat.NewBinData()
-	defer zipFile.DisposeBinData()
-	localKeyFile := "private-key.zip"
-
-	// Try to load the key from the local file first.
-	success = zipFile.LoadFile(localKeyFile)
-	if success != true {
-		log.Printf("Local key file '%s' not found or failed to load. Falling back to URL download.\n", localKeyFile)
-
-		// Fallback to downloading from URL
-		httpChilkat := chilkat.NewHttp()
-		defer httpChilkat.DisposeHttp()
-		keyUrl := "https://www.chilkatsoft.com/exampleData/secp256r1-key.zip"
-		log.Printf("Downloading key from %s...\n", keyUrl)
-		success = httpChilkat.QuickGetBd(keyUrl, zipFile)
-		if success != true {
-			errMsg := fmt.Sprintf("Failed to download key from URL: %s", httpChilkat.LastErrorText())
-			log.Println(errMsg)
-			writeError(w, errMsg, http.StatusInternalServerError)
-			return
-		}
-		log.Println("Key downloaded successfully.")
+func main() {
+	mux := http.NewServeMux()
+
+	// The PDF signing API (/v1) is enabled by the pdf_service key of
+	// config.json; without it only /sign is served. Without an auth key
+	// the server only listens on localhost. The listener is configured by
+	// servers.signature_api (see apiserver). Chilkat is unlocked and the
+	// keys are loaded here, once; a failure stops the service before it
+	// listens. Logging is configured by the logging key; the secrets of
+	// config.json are masked in every line.
+	vip := viper.New()
+	vip.SetConfigName("config")
+	vip.SetConfigType("json")
+	vip.AddConfigPath("C:/chilkatPackage/chilkattest")
+	var auth *apiauth.Authenticator
+	var limits *apilimit.Limiter
+	var api *pdfAPI
+	var cleanup func(ctx context.Context)
+	if err := vip.ReadInConfig(); err != nil {
+		slog.Warn("no config.json, serving /sign only", "error", err)
+		vip = nil
 	} else {
-		log.Printf("Loaded key from local file '%s'.\n", localKeyFile)
+		closeLog, err := pdfsign.SetupLogging(vip)
+		if err != nil {
+			pdfsign.Fatal(nil, "invalid logging configuration", err)
+		}
+		defer closeLog()
 	}
-
-	// Proceed with the zip data
-	zip := chilkat.NewZip()
-	defer zip.DisposeZip()
-	success = zip.OpenBd(zipFile)
-	if success != true {
-		errMsg := fmt.Sprintf("Failed to open zip data: %s", zip.LastErrorText())
-		log.Println(errMsg)
-		writeError(w, errMsg, http.StatusInternalServerError)
-		return
+	glob, err := unlockChilkat()
+	if err != nil {
+		pdfsign.Fatal(nil, "unlocking Chilkat failed", err)
 	}
-
-	zipEntry := zip.FirstMatchingEntry("*.pem")
-	if zipEntry == nil {
-		log.Println("No .pem file found inside the zip data.")
-		writeError(w, "No .pem file found inside the zip data.", http.StatusInternalServerError)
-		return
+	xml, err := newXMLSigner(vip)
+	if err != nil {
+		pdfsign.Fatal(nil, "loading the XML signing key failed", err)
+	}
+	if vip != nil {
+		if vip.IsSet("pdf_service") {
+			api, cleanup, err = newPdfAPI(vip, glob)
+			if err != nil {
+				pdfsign.Fatal(nil, "starting the PDF signing API failed", err)
+			}
+		}
+		if auth, err = apiauth.LoadFromConfig(vip); err != nil {
+			pdfsign.Fatal(nil, "invalid auth configuration", err)
+		}
+		if limits, err = apilimit.LoadFromConfig(vip, "signature_api"); err != nil {
+			pdfsign.Fatal(nil, "invalid limits configuration", err)
+		}
+	}
+	if xml != nil {
+		mux.HandleFunc("/sign", requireOperation(auth, apiauth.Request{Operation: opXMLSign, Key: xmlSignKey},
+			rateLimited(limits, opXMLSign, xmlSignKey, apiserver.Instrument(opXMLSign, xmlSignKey, xml.handleSign))))
+		mux.HandleFunc("POST /admin/keys/reload", requireOperation(auth, apiauth.Request{Operation: opReload}, xml.handleReload))
+		reloadOnSIGHUP(xml)
+	}
+	mux.HandleFunc("GET /metrics", requireOperation(auth, apiauth.Request{Operation: opMetrics}, metrics.Handler().ServeHTTP))
+	if api != nil {
+		api.auth = auth
+		api.limits = limits
+		api.register(mux)
 	}
-	defer zipEntry.DisposeZipEntry()
 
-	ecKey := chilkat.NewPrivateKey()
-	defer ecKey.DisposePrivateKey()
-	pemContentPtr := zipEntry.UnzipToString(0, "utf-8")
-	if pemContentPtr == nil {
-		errMsg := fmt.Sprintf("Failed to unzip PEM content: %s", zipEntry.LastErrorText())
-		log.Println(errMsg)
-		writeError(w, errMsg, http.StatusInternalServerError)
-		return
+	cfg, err := apiserver.LoadFromConfig(vip, "signature_api", 8080)
+	if err == nil && api != nil {
+		err = api.checkServerConfig(cfg)
+	}
+	if err != nil {
+		pdfsign.Fatal(nil, "invalid server configuration", err)
+	}
+	server, err := apiserver.New(cfg, auth.Middleware(mux, authFailed), auth != nil)
+	if err != nil {
+		pdfsign.Fatal(nil, "setting up the server failed", err)
+	}
+	if api != nil {
+		server.AddReadinessCheck("signing_key", api.jobs.checkKey)
 	}
-	pemContent := *pemContentPtr
+	if cleanup != nil {
+		// Runs after the requests in progress have finished: the workers
+		// drain the queue, then the audit log is closed.
+		server.OnShutdown(cleanup)
+	}
+	server.OnShutdown(func(ctx context.Context) {
+		if err := limits.Close(); err != nil {
+			slog.Warn("writing the usage file failed", "error", err)
+		}
+		glob.DisposeGlobal()
+	})
+	slog.Info("starting server", "addr", server.Addr(), "url", cfg.URL()+"/sign")
 
-	success = ecKey.LoadPem(pemContent)
-	if success != true {
-		errMsg := fmt.Sprintf("Failed to load PEM key: %s", ecKey.LastErrorText())
-		log.Println(errMsg)
-		writeError(w, errMsg, http.StatusInternalServerError)
-		return
+	if err := server.Run(); err != nil {
+		pdfsign.Fatal(nil, "starting the server failed", err)
 	}
+}
 
-	// XML Signature Generation
-	gen := chilkat.NewXmlDSigGen()
-	defer gen.DisposeXmlDSigGen()
-	gen.SetPrivateKey(ecKey)
+// unlockChilkat unlocks Chilkat for the process. The returned object is
+// disposed at shutdown.
+func unlockChilkat() (*chilkat.Global, error) {
+	glob := chilkat.NewGlobal()
+	if !glob.UnlockBundle("Anything for 30-day trial") {
+		err := pdfsign.NewChilkatError("unlocking Chilkat", glob.LastErrorText())
+		glob.DisposeGlobal()
+		return nil, err
+	}
+	return glob, nil
+}
 
-	sbContent := chilkat.NewStringBuilder()
-	defer sbContent.DisposeStringBuilder()
-	sbContent.Append("This is the content that is signed.")
-	gen.AddEnvelopedRef("abc123", sbContent, "sha256", "C14N", "")
+// reloadOnSIGHUP reloads the XML signing key on every SIGHUP. Windows has
+// no SIGHUP; use POST /admin/keys/reload there.
+func reloadOnSIGHUP(xml *xmlSigner) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := xml.reload(); err != nil {
+				slog.Error("reloading the XML signing key failed, keeping the current key", "error", err)
+			}
+		}
+	}()
+}
 
-	sbXml := chilkat.NewStringBuilder()
-	defer sbXml.DisposeStringBuilder()
-	success = gen.CreateXmlDSigSb(sbXml)
-	if success != true {
-		errMsg := fmt.Sprintf("Failed to generate XML signature: %s", gen.LastErrorText())
-		log.Println(errMsg)
-		writeError(w, errMsg, http.StatusInternalServerError)
-		return
+// requireOperation lets only callers allowed req reach next.
+func requireOperation(auth *apiauth.Authenticator, req apiauth.Request, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := auth.Authorize(r, req); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r)
 	}
+}
 
-	// Prepare successful response
-	response := SignResponse{
-		Signature: *sbXml.GetAsString(),
+// rateLimited counts a request against the rate limits and quotas of
+// operation and key before next; one over a limit gets 429.
+func rateLimited(limits *apilimit.Limiter, operation, key string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := limits.Allow(r, operation, key); err != nil {
+			apilimit.SetRetryAfter(w, err)
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
 	}
+}
 
-	err := json.NewEncoder(w).Encode(response)
-	if err != nil {
-		// Log error if encoding fails, but likely headers are already sent
-		log.Printf("Failed to encode JSON response: %v", err)
+// authFailed answers a request without valid credentials in the error
+// format of the endpoint.
+func authFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeAPIError(w, err)
+		return
 	}
-	log.Println("Successfully generated and returned signature.")
+	w.Header().Set("Content-Type", "application/json")
+	writeError(w, err.Error(), http.StatusUnauthorized)
 }
 
-// Helper function to write JSON error responses
-func writeError(w http.ResponseWriter, message string, statusCode int) {
-	w.WriteHeader(statusCode)
-	response := SignResponse{Error: message}
-	json.NewEncoder(w).Encode(response)
-}
+// newPdfAPI sets up storage, the audit log and the signing workers for the
+// /v1 endpoints; glob is the unlocked Chilkat.
+func newPdfAPI(vip *viper.Viper, glob *chilkat.Global) (*pdfAPI, func(ctx context.Context), error) {
+	cfg, err := loadServiceConfig(vip)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := loadStorageFromConfig(vip)
+	if err != nil {
+		return nil, nil, err
+	}
+	audit, err := pdfsign.LoadAuditLogFromConfig(vip)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func main() {
-	http.HandleFunc("/sign", signHandler)
+	// The workers sign concurrently with their own Chilkat objects.
+	glob.SetVerboseLogging(pdfsign.ChilkatVerbose(nil))
 
-	port := "8080"
-	log.Printf("Starting server on port %s...\n", port)
-	log.Printf("Access the API at: http://localhost:%s/sign", port)
+	jobs := newJobManager(store, audit, cfg.KeyName, cfg.QueueSize, cfg.JobTTL)
+	jobs.start(cfg.Workers, cfg.newKeys)
+	registerJobMetrics(jobs)
+	slog.Info("PDF signing API enabled", "workers", cfg.Workers, "default_level", cfg.DefaultLevel, "audit_log", audit.Path())
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	cleanup := func(ctx context.Context) {
+		jobs.stop(ctx)
+		if err := audit.Close(); err != nil {
+			slog.Warn("closing audit log failed", "audit_log", audit.Path(), "error", err)
+		}
+	}
+	api := &pdfAPI{
+		cfg:         cfg,
+		jobs:        jobs,
+		client:      newFetchClient(cfg.URLAllowHosts),
+		verifySlots: make(chan struct{}, cfg.VerifyConcurrency),
 	}
+	return api, cleanup, nil
 }
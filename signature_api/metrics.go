@@ -0,0 +1,37 @@
+package main
+
+import "chilkattest/metrics"
+
+// --- Metrics ---
+// Besides the operation metrics every service has (see apiserver) and
+// pdfsign's TSA, OCSP, Chilkat and PKCS#11 session metrics, the signing
+// API reports its worker pool: with an HSM, busy workers are PKCS#11
+// sessions in use, so signing_workers_busy close to signing_workers means
+// the pool is too small.
+
+var queueWait = metrics.NewHistogram("signing_queue_wait_seconds",
+	"Time signing jobs waited in the queue for a worker.", nil)
+
+// registerJobMetrics adds the gauges read from m on every scrape.
+func registerJobMetrics(m *jobManager) {
+	locked := func(f func() float64) func() float64 {
+		return func() float64 {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			return f()
+		}
+	}
+	metrics.NewGaugeFunc("signing_workers", "Signing workers, each with its own key source.",
+		locked(func() float64 { return float64(m.size) }))
+	metrics.NewGaugeFunc("signing_workers_busy", "Signing workers busy with a job.",
+		locked(func() float64 { return float64(m.busy) }))
+	metrics.NewGaugeFunc("signing_queue_jobs", "Signing jobs waiting for a worker.",
+		func() float64 { return float64(len(m.queue)) })
+	metrics.NewGaugeFunc("signing_key_unusable", "1 when a refused PIN has stopped all signing.",
+		locked(func() float64 {
+			if m.keyErr != nil {
+				return 1
+			}
+			return 0
+		}))
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"chilkattest/apiauth"
+	"chilkattest/apilimit"
+	"chilkattest/apiserver"
+	"chilkattest/pdfsign"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- PDF Signing API ---
+// POST /v1/pdf/sign takes the PDF either as a multipart upload (part
+// "file", options as JSON in part "options") or as a JSON body naming a
+// URL to fetch it from:
+//
+//	{"url": "https://docs.example.com/a.pdf", "level": "B-LT",
+//	 "field": "Approver", "appearance": "corporate",
+//	 "placement": {"position": "bottom-right", "margin": 36},
+//	 "info": {"reason": "Approved", "location": "Taipei"}}
+//
+// It answers 202 with the job (see jobView). GET /v1/jobs/{id} reports the
+// job and GET /v1/jobs/{id}/result returns the signed PDF. With ?wait=true
+// the request waits up to pdf_service.sync_timeout_seconds and returns the
+// signed PDF directly when the job finishes in time.
+//
+// Clients only speak HTTP; they do not link Chilkat or cgo.
+
+// signRequest is the options of a signing request.
+type signRequest struct {
+	URL        string                 `json:"url,omitempty"` // JSON body only
+	Level      string                 `json:"level,omitempty"`
+	Field      string                 `json:"field,omitempty"`
+	Placement  *pdfsign.Placement     `json:"placement,omitempty"`
+	Appearance string                 `json:"appearance,omitempty"` // template name
+	Info       *pdfsign.SignatureInfo `json:"info,omitempty"`
+}
+
+// apiError is the JSON error of a response or a failed job.
+type apiError struct {
+	Message   string `json:"message"`
+	Kind      string `json:"kind,omitempty"` // pdfsign error kind, e.g. "timestamp authority unavailable"
+	Retryable bool   `json:"retryable,omitempty"`
+}
+
+// serviceConfig is the pdf_service config key.
+type serviceConfig struct {
+	Workers       int
+	QueueSize     int
+	MaxUpload     int64
+	JobTTL        time.Duration
+	SyncTimeout   time.Duration
+	TsaURL        string
+	DefaultLevel  pdfsign.PadesLevel
+	URLAllowHosts map[string]bool
+	Templates     map[string]*pdfsign.AppearanceTemplate
+	Info          pdfsign.SignatureInfo
+	Algorithm     pdfsign.Algorithm
+	KeyName       string // for auth.clients[].keys
+	newKeys       func() pdfsign.KeySource
+	// verification
+	Policies          map[string]*pdfsign.TrustPolicy
+	ReportFonts       []string
+	VerifyConcurrency int
+}
+
+// loadServiceConfig reads the pdf_service key:
+//
+//	"pdf_service": {"key_source": "pkcs11", "key_name": "pkcs11", "slot": 0, "workers": 2,
+//	    "queue_size": 100, "max_upload_mb": 50, "job_ttl_minutes": 60,
+//	    "sync_timeout_seconds": 60, "default_level": "B-T",
+//	    "tsa_url": "http://timestamp.digicert.com", "url_allow_hosts": [],
+//	    "storage": {"type": "local", "dir": "..."},
+//	    "verify_concurrency": 4, "report_fonts": ["C:/Windows/Fonts/msjh.ttc"]}
+//
+// The key comes from pfx_file_path/pfx_password or pkcs11_lib_path/hsm_pin;
+// appearance templates from appearance_templates_dir, signature metadata
+// defaults from signature_info, the algorithm from signature_algorithm and
+// the trust policies of /v1/pdf/verify from trust_policies.
+// URLs are only fetched from the hosts in url_allow_hosts, and redirects
+// only followed to them, so the service cannot be used to reach arbitrary
+// internal addresses.
+func loadServiceConfig(vip *viper.Viper) (*serviceConfig, error) {
+	c := &serviceConfig{
+		Workers:     vip.GetInt("pdf_service.workers"),
+		QueueSize:   vip.GetInt("pdf_service.queue_size"),
+		MaxUpload:   vip.GetInt64("pdf_service.max_upload_mb") << 20,
+		JobTTL:      time.Duration(vip.GetInt("pdf_service.job_ttl_minutes")) * time.Minute,
+		SyncTimeout: time.Duration(vip.GetInt("pdf_service.sync_timeout_seconds")) * time.Second,
+		TsaURL:      vip.GetString("pdf_service.tsa_url"),
+	}
+	if c.Workers <= 0 {
+		c.Workers = 2
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 100
+	}
+	if c.MaxUpload <= 0 {
+		c.MaxUpload = 50 << 20
+	}
+	if c.JobTTL <= 0 {
+		c.JobTTL = time.Hour
+	}
+	if c.SyncTimeout <= 0 {
+		c.SyncTimeout = time.Minute
+	}
+	if c.VerifyConcurrency = vip.GetInt("pdf_service.verify_concurrency"); c.VerifyConcurrency <= 0 {
+		c.VerifyConcurrency = 4
+	}
+	c.ReportFonts = vip.GetStringSlice("pdf_service.report_fonts")
+	c.DefaultLevel = pdfsign.LevelBB
+	if s := vip.GetString("pdf_service.default_level"); s != "" {
+		level, err := pdfsign.ParsePadesLevel(s)
+		if err != nil {
+			return nil, fmt.Errorf("pdf_service.default_level: %w", err)
+		}
+		c.DefaultLevel = level
+	}
+	c.URLAllowHosts = make(map[string]bool)
+	for _, h := range vip.GetStringSlice("pdf_service.url_allow_hosts") {
+		c.URLAllowHosts[strings.ToLower(h)] = true
+	}
+
+	switch source := vip.GetString("pdf_service.key_source"); source {
+	case "pfx":
+		path, password := vip.GetString("pfx_file_path"), vip.GetString("pfx_password")
+		if path == "" || password == "" {
+			return nil, errors.New("pdf_service.key_source pfx needs pfx_file_path and pfx_password")
+		}
+		c.newKeys = func() pdfsign.KeySource { return &pdfsign.PfxKeySource{Path: path, Password: password} }
+	case "pkcs11":
+		lib, pin := vip.GetString("pkcs11_lib_path"), vip.GetString("hsm_pin")
+		if lib == "" || pin == "" {
+			return nil, errors.New("pdf_service.key_source pkcs11 needs pkcs11_lib_path and hsm_pin")
+		}
+		slot, label := vip.GetInt("pdf_service.slot"), vip.GetString("p11_token-label")
+		c.newKeys = func() pdfsign.KeySource {
+			return &pdfsign.Pkcs11KeySource{LibPath: lib, Slot: slot, Pin: pin, TokenLabel: label}
+		}
+	default:
+		return nil, fmt.Errorf("invalid pdf_service.key_source '%s' (use pfx or pkcs11)", source)
+	}
+	if c.KeyName = vip.GetString("pdf_service.key_name"); c.KeyName == "" {
+		c.KeyName = vip.GetString("pdf_service.key_source")
+	}
+
+	if dir := vip.GetString("appearance_templates_dir"); dir != "" {
+		templates, err := pdfsign.LoadAppearanceTemplates(dir)
+		if err != nil {
+			return nil, err
+		}
+		c.Templates = templates
+	}
+	info, err := pdfsign.LoadSignatureInfoFromConfig(vip)
+	if err != nil {
+		return nil, err
+	}
+	c.Info = info
+	if c.Algorithm, err = pdfsign.LoadAlgorithmFromConfig(vip); err != nil {
+		return nil, err
+	}
+	if c.Policies, err = pdfsign.LoadTrustPoliciesFromConfig(vip); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkServerConfig makes sure the listener does not cut off what
+// pdf_service allows: an upload of max_upload_mb, or a response that waits
+// sync_timeout_seconds for its job.
+func (a *pdfAPI) checkServerConfig(cfg *apiserver.Config) error {
+	if limit := a.cfg.MaxUpload + 1<<20; cfg.MaxBodyBytes < limit {
+		return fmt.Errorf("servers.%s.max_body_mb must be at least pdf_service.max_upload_mb + 1 (%d)", cfg.Name, limit>>20)
+	}
+	if cfg.WriteTimeout <= a.cfg.SyncTimeout {
+		return fmt.Errorf("servers.%s.write_timeout_seconds must be longer than pdf_service.sync_timeout_seconds (%s)", cfg.Name, a.cfg.SyncTimeout)
+	}
+	return nil
+}
+
+// pdfAPI serves the /v1 endpoints.
+type pdfAPI struct {
+	cfg         *serviceConfig
+	auth        *apiauth.Authenticator // nil without the auth key
+	limits      *apilimit.Limiter      // nil without the limits key
+	jobs        *jobManager
+	client      *http.Client  // for URL inputs
+	verifySlots chan struct{} // bounds concurrent verifications
+}
+
+func (a *pdfAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v1/pdf/sign", a.handleSign)
+	mux.HandleFunc("GET /v1/jobs/{id}", a.handleJob)
+	mux.HandleFunc("GET /v1/jobs/{id}/result", a.handleResult)
+	mux.HandleFunc("POST /v1/pdf/verify", a.handleVerify)
+}
+
+func (a *pdfAPI) handleSign(w http.ResponseWriter, r *http.Request) {
+	// Checked before reading the request, so a caller without pdf.sign
+	// cannot make the service fetch URLs.
+	if err := a.auth.Authorize(r, apiauth.Request{Operation: opPDFSign}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, a.cfg.MaxUpload+1<<20) // room for the options
+	req, input, source, err := a.readSignRequest(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	opts, err := a.signOptions(req)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	err = a.auth.Authorize(r, apiauth.Request{Operation: opPDFSign, Key: a.cfg.KeyName, Profile: req.Appearance, Level: string(opts.Level)})
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	// Counted once the request is known to be signable, so malformed or
+	// forbidden requests do not use up a quota, and given back when the
+	// job cannot be queued.
+	if err := a.limits.Allow(r, opPDFSign, a.cfg.KeyName); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	j := &job{id: pdfsign.NewCorrelationID(), caller: apiauth.Caller(r), client: apiauth.ClientName(r), source: source, opts: opts}
+	if err := a.jobs.submit(j, input); err != nil {
+		a.limits.Refund(r, opPDFSign, a.cfg.KeyName)
+		writeAPIError(w, err)
+		return
+	}
+	slog.Info("job queued", pdfsign.LogKeyJob, j.id, "caller", j.caller, "source", source, "bytes", len(input))
+
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		select {
+		case <-j.done:
+			view, _, _ := a.jobs.get(j.id)
+			if view.Status == jobSucceeded {
+				a.writeResult(w, j.id)
+				return
+			}
+			writeJSON(w, statusForJob(j), view)
+			return
+		case <-time.After(a.cfg.SyncTimeout):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	view, _, _ := a.jobs.get(j.id)
+	w.Header().Set("Location", view.StatusURL)
+	writeJSON(w, http.StatusAccepted, view)
+}
+
+// readSignRequest returns the options, the PDF and a name for it.
+func (a *pdfAPI) readSignRequest(r *http.Request) (*signRequest, []byte, string, error) {
+	req := &signRequest{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, nil, "", badRequest("invalid multipart upload: %v", err)
+		}
+		if s := r.FormValue("options"); s != "" {
+			if err := decodeStrict([]byte(s), req); err != nil {
+				return nil, nil, "", badRequest("invalid options: %v", err)
+			}
+			if req.URL != "" {
+				return nil, nil, "", badRequest("give either a file or a url, not both")
+			}
+		}
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			return nil, nil, "", badRequest("multipart upload has no part 'file'")
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, a.cfg.MaxUpload+1))
+		if err != nil {
+			return nil, nil, "", badRequest("reading the upload: %v", err)
+		}
+		if int64(len(data)) > a.cfg.MaxUpload {
+			return nil, nil, "", tooLarge(a.cfg.MaxUpload)
+		}
+		return req, data, "upload:" + hdr.Filename, nil
+	case "application/json":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, nil, "", tooLarge(a.cfg.MaxUpload)
+		}
+		if err := decodeStrict(body, req); err != nil {
+			return nil, nil, "", badRequest("invalid JSON request: %v", err)
+		}
+		if req.URL == "" {
+			return nil, nil, "", badRequest("JSON request has no url; upload the PDF as multipart/form-data instead")
+		}
+		data, err := a.fetch(r, req.URL)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return req, data, req.URL, nil
+	default:
+		return nil, nil, "", &httpError{http.StatusUnsupportedMediaType, "use multipart/form-data or application/json"}
+	}
+}
+
+// fetch downloads a URL input from an allowed host.
+func (a *pdfAPI) fetch(r *http.Request, raw string) ([]byte, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, badRequest("invalid url '%s'", raw)
+	}
+	if err := checkFetchHost(u, a.cfg.URLAllowHosts); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, badRequest("invalid url '%s'", raw)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		var he *httpError
+		if errors.As(err, &he) {
+			return nil, he // a redirect to a host that is not allowed
+		}
+		return nil, &httpError{http.StatusBadGateway, fmt.Sprintf("fetching '%s' failed: %v", raw, err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpError{http.StatusBadGateway, fmt.Sprintf("fetching '%s' failed: HTTP %s", raw, resp.Status)}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, a.cfg.MaxUpload+1))
+	if err != nil {
+		return nil, &httpError{http.StatusBadGateway, fmt.Sprintf("fetching '%s' failed: %v", raw, err)}
+	}
+	if int64(len(data)) > a.cfg.MaxUpload {
+		return nil, tooLarge(a.cfg.MaxUpload)
+	}
+	return data, nil
+}
+
+// maxFetchRedirects is how many redirects fetch follows.
+const maxFetchRedirects = 5
+
+// newFetchClient returns the client of fetch. Every redirect must stay on
+// http or https and go to a host in allow too, or the allowlist could be
+// bypassed through an allowed host that redirects elsewhere.
+func newFetchClient(allow map[string]bool) *http.Client {
+	return &http.Client{
+		Timeout: 60 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return &httpError{http.StatusBadGateway, fmt.Sprintf("fetching '%s' failed: more than %d redirects", via[0].URL, maxFetchRedirects)}
+			}
+			if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+				return &httpError{http.StatusForbidden, fmt.Sprintf("'%s' redirects to the unsupported url '%s'", via[0].URL, req.URL)}
+			}
+			return checkFetchHost(req.URL, allow)
+		},
+	}
+}
+
+func checkFetchHost(u *url.URL, allow map[string]bool) error {
+	if !allow[strings.ToLower(u.Hostname())] {
+		return &httpError{http.StatusForbidden, fmt.Sprintf("fetching from host '%s' is not allowed", u.Hostname())}
+	}
+	return nil
+}
+
+// signOptions checks the request against the configuration.
+func (a *pdfAPI) signOptions(req *signRequest) (pdfsign.SignOptions, error) {
+	opts := pdfsign.SignOptions{
+		Level:     a.cfg.DefaultLevel,
+		TsaURL:    a.cfg.TsaURL,
+		Field:     req.Field,
+		Placement: req.Placement,
+		Info:      a.cfg.Info,
+		Algorithm: &a.cfg.Algorithm,
+	}
+	if req.Level != "" {
+		level, err := pdfsign.ParsePadesLevel(req.Level)
+		if err != nil {
+			return opts, badRequest("%v", err)
+		}
+		opts.Level = level
+	}
+	if opts.Level != pdfsign.LevelBB && opts.TsaURL == "" {
+		return opts, badRequest("level %s needs a TSA, and pdf_service.tsa_url is not configured", opts.Level)
+	}
+	if req.Appearance != "" {
+		t, ok := a.cfg.Templates[req.Appearance]
+		if !ok {
+			return opts, badRequest("unknown appearance template '%s'", req.Appearance)
+		}
+		opts.Appearance = t
+	}
+	if req.Info != nil {
+		opts.Info = req.Info.Merge(a.cfg.Info)
+	}
+	if err := opts.Info.Validate(); err != nil {
+		return opts, badRequest("invalid info: %v", err)
+	}
+	return opts, nil
+}
+
+// ownJob returns the job if the caller of r submitted it. Other callers
+// get the same 404 as for an unknown job.
+func (a *pdfAPI) ownJob(r *http.Request, id string) (jobView, *job, bool) {
+	view, j, ok := a.jobs.get(id)
+	if !ok || j.client != apiauth.ClientName(r) {
+		return jobView{}, nil, false
+	}
+	return view, j, true
+}
+
+func (a *pdfAPI) handleJob(w http.ResponseWriter, r *http.Request) {
+	if err := a.auth.Authorize(r, apiauth.Request{Operation: opJobsRead}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	view, _, ok := a.ownJob(r, r.PathValue("id"))
+	if !ok {
+		writeAPIError(w, &httpError{http.StatusNotFound, "no such job (finished jobs expire)"})
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (a *pdfAPI) handleResult(w http.ResponseWriter, r *http.Request) {
+	if err := a.auth.Authorize(r, apiauth.Request{Operation: opJobsRead}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	id := r.PathValue("id")
+	view, j, ok := a.ownJob(r, id)
+	switch {
+	case !ok:
+		writeAPIError(w, &httpError{http.StatusNotFound, "no such job (finished jobs expire)"})
+	case view.Status == jobFailed:
+		writeJSON(w, statusForJob(j), view)
+	case view.Status != jobSucceeded:
+		writeAPIError(w, &httpError{http.StatusConflict, fmt.Sprintf("job is %s", view.Status)})
+	default:
+		a.writeResult(w, id)
+	}
+}
+
+func (a *pdfAPI) writeResult(w http.ResponseWriter, id string) {
+	data, err := a.jobs.result(id)
+	if err != nil {
+		writeAPIError(w, fmt.Errorf("reading the result: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="signed_%s.pdf"`, id))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// --- Errors and Responses ---
+
+// httpError is a request the handler rejects itself.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string { return e.message }
+
+func badRequest(format string, args ...any) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func tooLarge(limit int64) error {
+	return &httpError{http.StatusRequestEntityTooLarge, fmt.Sprintf("PDF is larger than %d MB", limit>>20)}
+}
+
+// apiErrorFor maps an error to a status code and its JSON form: the
+// caller's mistakes and missing permissions are 4xx, an unreachable TSA or
+// OCSP responder 502 (retryable), a full queue, shutdown or a key or PIN
+// problem 503 and anything else 500.
+func apiErrorFor(err error) (int, apiError) {
+	e := apiError{Message: err.Error(), Retryable: pdfsign.Retryable(err)}
+	var ce *pdfsign.ChilkatError
+	if errors.As(err, &ce) && ce.Kind != nil {
+		e.Kind = ce.Kind.Error()
+	}
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		return he.status, e
+	case errors.Is(err, apiauth.ErrUnauthenticated):
+		return http.StatusUnauthorized, e
+	case errors.Is(err, apiauth.ErrForbidden):
+		return http.StatusForbidden, e
+	case errors.Is(err, apilimit.ErrLimited):
+		e.Retryable = true
+		return http.StatusTooManyRequests, e
+	case errors.Is(err, errQueueFull), errors.Is(err, errShuttingDown):
+		e.Retryable = true
+		return http.StatusServiceUnavailable, e
+	case errors.Is(err, pdfsign.ErrInvalidInput), errors.Is(err, pdfsign.ErrPdfEncrypted):
+		if e.Kind == "" {
+			e.Kind = pdfsign.ErrInvalidInput.Error()
+		}
+		return http.StatusUnprocessableEntity, e
+	case errors.Is(err, pdfsign.ErrTsaUnavailable), errors.Is(err, pdfsign.ErrOcspFailed):
+		return http.StatusBadGateway, e
+	case errors.Is(err, pdfsign.ErrPinIncorrect), errors.Is(err, pdfsign.ErrPinLocked), errors.Is(err, pdfsign.ErrKeyNotFound):
+		return http.StatusServiceUnavailable, e
+	}
+	return http.StatusInternalServerError, e
+}
+
+// statusForJob is the status code of a finished job's response.
+func statusForJob(j *job) int {
+	if j.err == nil {
+		return http.StatusOK
+	}
+	status, _ := apiErrorFor(j.err)
+	return status
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status, e := apiErrorFor(err)
+	if status >= 500 {
+		slog.Error("request failed", "status", status, "error", err)
+	}
+	if e.Retryable {
+		w.Header().Set("Retry-After", "30")
+		apilimit.SetRetryAfter(w, err) // the time the limit gives
+	}
+	writeJSON(w, status, struct {
+		Error apiError `json:"error"`
+	}{e})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to encode JSON response", "error", err)
+	}
+}
+
+// decodeStrict rejects unknown keys, so a misspelt option is not silently
+// ignored.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
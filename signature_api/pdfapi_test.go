@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFetchRedirects(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n")
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pdf)
+	}))
+	defer other.Close()
+	// The allowed server answers /doc.pdf and redirects everything else.
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/doc.pdf":
+			w.Write(pdf)
+		case "/local":
+			http.Redirect(w, r, "/doc.pdf", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		default:
+			http.Redirect(w, r, other.URL+"/doc.pdf", http.StatusFound)
+		}
+	}))
+	defer allowed.Close()
+
+	// Both servers are on 127.0.0.1, so the other one is reached as localhost.
+	other.URL = "http://localhost:" + port(t, other.URL)
+	allowHosts := map[string]bool{"127.0.0.1": true}
+	a := &pdfAPI{
+		cfg:    &serviceConfig{MaxUpload: 1 << 20, URLAllowHosts: allowHosts},
+		client: newFetchClient(allowHosts),
+	}
+	tests := []struct {
+		path   string
+		status int // 0 when the fetch must succeed
+	}{
+		{"/doc.pdf", 0},
+		{"/local", 0},
+		{"/elsewhere", http.StatusForbidden},
+		{"/file", http.StatusForbidden},
+		{"/loop", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/pdf/sign", nil)
+			data, err := a.fetch(r, allowed.URL+tt.path)
+			if tt.status == 0 {
+				if err != nil || string(data) != string(pdf) {
+					t.Fatalf("fetch = %q, %v", data, err)
+				}
+				return
+			}
+			var he *httpError
+			if !errors.As(err, &he) || he.status != tt.status {
+				t.Fatalf("fetch error = %v, want HTTP %d", err, tt.status)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/pdf/sign", nil)
+	if _, err := a.fetch(r, other.URL+"/doc.pdf"); err == nil {
+		t.Fatal("fetch from a host that is not allowed succeeded")
+	}
+}
+
+func port(t *testing.T, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Port()
+}
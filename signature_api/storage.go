@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// --- Job Storage ---
+// Uploaded and signed documents are kept in a Storage rather than in
+// memory, so a queue of large PDFs does not live on the heap and a result
+// can be fetched after the request that created the job has gone. Keys are
+// "<job id>/<name>".
+
+var errNotStored = errors.New("not found in storage")
+
+// Storage holds the documents of jobs.
+type Storage interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error) // errNotStored when absent
+	// DeleteJob removes every document of a job.
+	DeleteJob(jobID string) error
+}
+
+// loadStorageFromConfig reads pdf_service.storage: {"type": "local",
+// "dir": "..."}. local is the only backend so far.
+func loadStorageFromConfig(vip *viper.Viper) (Storage, error) {
+	switch typ := vip.GetString("pdf_service.storage.type"); typ {
+	case "", "local":
+		dir := vip.GetString("pdf_service.storage.dir")
+		if dir == "" {
+			return nil, errors.New("pdf_service.storage.dir is empty")
+		}
+		return newLocalStorage(dir)
+	default:
+		return nil, fmt.Errorf("unknown pdf_service.storage.type '%s' (use local)", typ)
+	}
+}
+
+// localStorage keeps each job's documents in a directory of its own.
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory '%s': %w", dir, err)
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	// Keys are built from job IDs and fixed names; refuse anything that
+	// could leave the storage directory.
+	if key == "" || strings.Contains(key, "..") || filepath.IsAbs(key) {
+		return "", fmt.Errorf("invalid storage key '%s'", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes through a temporary file, so a reader never sees half a PDF.
+func (s *localStorage) Put(key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localStorage) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotStored
+	}
+	return data, err
+}
+
+func (s *localStorage) DeleteJob(jobID string) error {
+	p, err := s.path(jobID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
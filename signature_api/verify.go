@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"chilkattest/apiauth"
+	"chilkattest/apiserver"
+	"chilkattest/pdfsign"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- PDF Verification API ---
+// POST /v1/pdf/verify takes a PDF as a multipart upload (part "file") or as
+// an application/pdf body, verifies every signature and evaluates them
+// against a trust policy (?policy=..., default "default"; see
+// pdfsign.LoadTrustPoliciesFromConfig). The answer is the
+// pdfsign.ValidationReport as JSON, or with ?format=html or ?format=pdf a
+// human-readable report for customer support. A document that fails
+// validation is still a 200: the report is the answer.
+//
+// Verification runs in the request. It is bounded by
+// pdf_service.verify_concurrency rather than queued, since it needs no key
+// and, offline, no network.
+
+func (a *pdfAPI) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if err := a.auth.Authorize(r, apiauth.Request{Operation: opPDFVerify}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, a.cfg.MaxUpload+1<<20)
+	q := r.URL.Query()
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "html" && format != "pdf" {
+		writeAPIError(w, badRequest("unknown format '%s' (use json, html or pdf)", format))
+		return
+	}
+
+	data, name, err := a.readVerifyRequest(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	policyName := strings.ToLower(r.FormValue("policy"))
+	if policyName == "" {
+		policyName = pdfsign.DefaultTrustPolicy
+	}
+	policy, ok := a.cfg.Policies[policyName]
+	if !ok {
+		writeAPIError(w, badRequest("unknown trust policy '%s' (configured: %s)", policyName, strings.Join(a.policyNames(), ", ")))
+		return
+	}
+
+	select {
+	case a.verifySlots <- struct{}{}:
+		defer func() { <-a.verifySlots }()
+	case <-r.Context().Done():
+		return
+	}
+	start := time.Now()
+	report, err := policy.Validate(data, name)
+	apiserver.ObserveOperation(opPDFVerify, "", pdfsign.ResultLabel(err), start)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	slog.Info("document verified", "caller", apiauth.Caller(r), "document", name, "sha256", report.SHA256,
+		"policy", policyName, "indication", report.Indication, "signatures", len(report.Signatures))
+
+	switch format {
+	case "html":
+		page, err := pdfsign.FormatValidationHTML(report)
+		if err != nil {
+			writeAPIError(w, fmt.Errorf("rendering the report: %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(page)
+	case "pdf":
+		doc, err := pdfsign.FormatValidationPDF(report, a.cfg.ReportFonts)
+		if err != nil {
+			writeAPIError(w, fmt.Errorf("rendering the report: %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="validation_%s"`, reportFileName(name)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(doc)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(doc)
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// readVerifyRequest returns the PDF and its file name, if any.
+func (a *pdfAPI) readVerifyRequest(r *http.Request) ([]byte, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var data []byte
+	var name string
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, "", badRequest("invalid multipart upload: %v", err)
+		}
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", badRequest("multipart upload has no part 'file'")
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(io.LimitReader(f, a.cfg.MaxUpload+1)); err != nil {
+			return nil, "", badRequest("reading the upload: %v", err)
+		}
+		name = hdr.Filename
+	case "application/pdf":
+		var err error
+		if data, err = io.ReadAll(io.LimitReader(r.Body, a.cfg.MaxUpload+1)); err != nil {
+			return nil, "", tooLarge(a.cfg.MaxUpload)
+		}
+		name = r.URL.Query().Get("name")
+	default:
+		return nil, "", &httpError{http.StatusUnsupportedMediaType, "use multipart/form-data or application/pdf"}
+	}
+	if int64(len(data)) > a.cfg.MaxUpload {
+		return nil, "", tooLarge(a.cfg.MaxUpload)
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\r\n\t "), []byte("%PDF-")) {
+		return nil, "", fmt.Errorf("%w: the upload is not a PDF", pdfsign.ErrInvalidInput)
+	}
+	return data, name, nil
+}
+
+func (a *pdfAPI) policyNames() []string {
+	names := make([]string, 0, len(a.cfg.Policies))
+	for name := range a.cfg.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reportFileName is the uploaded name, stripped of directories and quotes,
+// with a .pdf extension.
+func reportFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		if r == '"' || r < 32 {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		name = "report"
+	}
+	return name + ".pdf"
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"chilkat"
+	"chilkattest/apiauth"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// --- XML Signing Key ---
+// /sign signs with the EC key of xml_sign.key_file: a .pem file, or a .zip
+// holding one (the private-key.zip of the Chilkat example):
+//
+//	"xml_sign": {"key_file": "C:/chilkatPackage/chilkattest/signature_api/private-key.zip", "concurrency": 4}
+//
+// The key is read once at startup, and the service does not start when it
+// cannot be read or cannot sign. It is parsed into concurrency Chilkat key
+// objects, so requests never share one between goroutines; a request waits
+// for a free one. SIGHUP or POST /admin/keys/reload reads the file again:
+// the new keys are checked first and replace the old ones only if they can
+// sign, and requests already signing finish with the old key.
+//
+// The key file is never fetched from anywhere: without it the service
+// does not start (or xml_sign.enabled is false, which turns /sign off).
+
+// xmlKeyInfo describes the loaded key, for the log and the reload answer.
+type xmlKeyInfo struct {
+	Key    string    `json:"key"`
+	File   string    `json:"file"`
+	SHA256 string    `json:"sha256"` // of the key file
+	Loaded time.Time `json:"loaded"`
+}
+
+// xmlKeys is one loaded generation of the key.
+type xmlKeys struct {
+	info  xmlKeyInfo
+	free  chan *chilkat.PrivateKey
+	inUse sync.WaitGroup
+}
+
+// dispose waits until every key is back and disposes them.
+func (k *xmlKeys) dispose() {
+	k.inUse.Wait()
+	for {
+		select {
+		case key := <-k.free:
+			key.DisposePrivateKey()
+		default:
+			return
+		}
+	}
+}
+
+type xmlSigner struct {
+	file        string
+	concurrency int
+	reloading   sync.Mutex // one reload at a time
+	mu          sync.RWMutex
+	keys        *xmlKeys
+}
+
+// newXMLSigner reads xml_sign and loads the key; it returns nil, nil when
+// xml_sign.enabled is false. vip may be nil.
+func newXMLSigner(vip *viper.Viper) (*xmlSigner, error) {
+	if vip == nil {
+		vip = viper.New()
+	}
+	if vip.IsSet("xml_sign.enabled") && !vip.GetBool("xml_sign.enabled") {
+		return nil, nil
+	}
+	s := &xmlSigner{
+		file:        vip.GetString("xml_sign.key_file"),
+		concurrency: vip.GetInt("xml_sign.concurrency"),
+	}
+	if s.file == "" {
+		s.file = "private-key.zip"
+	}
+	if s.concurrency <= 0 {
+		s.concurrency = 4
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload loads the key file again. On failure the current key stays.
+func (s *xmlSigner) reload() error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	keys, err := s.load()
+	if err != nil {
+		return fmt.Errorf("loading xml_sign.key_file '%s': %w", s.file, err)
+	}
+	s.mu.Lock()
+	old := s.keys
+	s.keys = keys
+	s.mu.Unlock()
+	if old != nil {
+		go old.dispose()
+	}
+	slog.Info("XML signing key loaded", "file", keys.info.File, "sha256", keys.info.SHA256, "concurrency", s.concurrency)
+	return nil
+}
+
+func (s *xmlSigner) load() (*xmlKeys, error) {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := pemFromKeyFile(s.file, data)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	keys := &xmlKeys{
+		info: xmlKeyInfo{Key: xmlSignKey, File: s.file, SHA256: hex.EncodeToString(sum[:]), Loaded: time.Now().UTC()},
+		free: make(chan *chilkat.PrivateKey, s.concurrency),
+	}
+	for i := 0; i < s.concurrency; i++ {
+		key := chilkat.NewPrivateKey()
+		if !key.LoadPem(pem) {
+			err := fmt.Errorf("parsing the PEM key: %s", key.LastErrorText())
+			key.DisposePrivateKey()
+			keys.dispose()
+			return nil, err
+		}
+		keys.free <- key
+	}
+	// Prove the key can sign before it replaces a working one.
+	key := <-keys.free
+	_, err = signXML(key)
+	keys.free <- key
+	if err != nil {
+		keys.dispose()
+		return nil, err
+	}
+	return keys, nil
+}
+
+// pemFromKeyFile returns the PEM text of a .pem file, or of the first .pem
+// entry of a .zip.
+func pemFromKeyFile(name string, data []byte) (string, error) {
+	if !strings.EqualFold(path.Ext(name), ".zip") {
+		return string(data), nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("opening the zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if !strings.EqualFold(path.Ext(f.Name), ".pem") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("unzipping %s: %w", f.Name, err)
+		}
+		defer rc.Close()
+		pem, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+		if err != nil {
+			return "", fmt.Errorf("unzipping %s: %w", f.Name, err)
+		}
+		return string(pem), nil
+	}
+	return "", errors.New("no .pem file in the zip")
+}
+
+// acquire waits for a free key; call release when done with it.
+func (s *xmlSigner) acquire(ctx context.Context) (key *chilkat.PrivateKey, release func(), err error) {
+	s.mu.RLock()
+	keys := s.keys
+	keys.inUse.Add(1) // under the lock, so a reload's dispose waits for it
+	s.mu.RUnlock()
+	select {
+	case key = <-keys.free:
+		return key, func() {
+			keys.free <- key
+			keys.inUse.Done()
+		}, nil
+	case <-ctx.Done():
+		keys.inUse.Done()
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (s *xmlSigner) info() xmlKeyInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys.info
+}
+
+// signXML signs the demo content with key and returns the XML signature.
+func signXML(key *chilkat.PrivateKey) (string, error) {
+	gen := chilkat.NewXmlDSigGen()
+	defer gen.DisposeXmlDSigGen()
+	gen.SetPrivateKey(key)
+
+	sbContent := chilkat.NewStringBuilder()
+	defer sbContent.DisposeStringBuilder()
+	sbContent.Append("This is the content that is signed.")
+	gen.AddEnvelopedRef("abc123", sbContent, "sha256", "C14N", "")
+
+	sbXml := chilkat.NewStringBuilder()
+	defer sbXml.DisposeStringBuilder()
+	if !gen.CreateXmlDSigSb(sbXml) {
+		return "", fmt.Errorf("generating the XML signature: %s", gen.LastErrorText())
+	}
+	return *sbXml.GetAsString(), nil
+}
+
+// Handles the /sign request
+func (s *xmlSigner) handleSign(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, release, err := s.acquire(r.Context())
+	if err != nil {
+		return // the client has gone
+	}
+	signature, err := signXML(key)
+	release()
+	if err != nil {
+		slog.Error("signing the XML failed", "error", err)
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Prepare successful response
+	response := SignResponse{
+		Signature: signature,
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		// Log error if encoding fails, but likely headers are already sent
+		slog.Warn("failed to encode JSON response", "error", err)
+	}
+	slog.Info("XML signature returned", "caller", apiauth.Caller(r))
+}
+
+// handleReload answers POST /admin/keys/reload with the new key, or 500
+// and the old key still in use.
+func (s *xmlSigner) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.reload(); err != nil {
+		slog.Error("reloading the XML signing key failed, keeping the current key", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.info())
+}